@@ -0,0 +1,61 @@
+package main
+
+import (
+	"backend/handlers"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+func runCommand(args []string) error {
+	switch args[0] {
+	case "replay":
+		return runReplay(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	username := fs.String("user", "", "username whose positions and history to rebuild")
+	dryRun := fs.Bool("dry-run", false, "show the diff without saving the rebuild")
+	dropGaps := fs.Bool("drop-unledgered", false, "save the rebuild even if it drops positions or history no ledger trade made")
+	fs.Parse(args)
+
+	if *username == "" {
+		return fmt.Errorf("replay: -user is required")
+	}
+
+	var userID int
+	err := db.QueryRow("SELECT id FROM users WHERE username = ?", *username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("replay: no user named %q", *username)
+	}
+	if err != nil {
+		return err
+	}
+
+	diffs, err := handlers.ReplayUser(userID, *dryRun, *dropGaps)
+	if errors.Is(err, handlers.ErrLedgerGap) {
+		return fmt.Errorf("replay: %w; run with -dry-run to list them and -drop-unledgered to drop them", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, diff := range diffs {
+		fmt.Printf("%s: %d added, %d removed, %d unchanged\n", diff.Table, len(diff.Added), len(diff.Removed), diff.Unchanged)
+		for _, line := range diff.Removed {
+			fmt.Println("  -", line)
+		}
+		for _, line := range diff.Added {
+			fmt.Println("  +", line)
+		}
+	}
+	if *dryRun {
+		fmt.Println("dry run: no changes saved")
+	}
+	return nil
+}
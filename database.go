@@ -1,126 +1,17 @@
 package main
 
 import (
+	"backend/handlers"
 	"database/sql"
+	"fmt"
 	"log"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var db *sql.DB
 
-const schemaSQL = `
-CREATE TABLE IF NOT EXISTS users (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    username TEXT UNIQUE NOT NULL,
-    password TEXT NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS stock_trades (
-    id TEXT PRIMARY KEY,
-    user_id INTEGER NOT NULL,
-    ticker TEXT NOT NULL,
-    date TEXT NOT NULL,
-    code TEXT NOT NULL,
-    price REAL NOT NULL,
-    amount REAL NOT NULL,
-    quantity REAL NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-);
-
-CREATE TABLE IF NOT EXISTS option_trades (
-    id TEXT PRIMARY KEY,
-    user_id INTEGER NOT NULL,
-    ticker TEXT NOT NULL,
-    date TEXT NOT NULL,
-    code TEXT NOT NULL,
-    price REAL NOT NULL,
-    amount REAL NOT NULL,
-    quantity REAL NOT NULL,
-    strike REAL NOT NULL,
-    exp_date TEXT NOT NULL,
-    option_type TEXT NOT NULL,
-    premium REAL NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-);
-
-CREATE TABLE IF NOT EXISTS stock_positions (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    user_id INTEGER NOT NULL,
-    open_date TEXT NOT NULL,
-    ticker TEXT NOT NULL,
-    quantity REAL NOT NULL,
-    cost_basis REAL NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-    UNIQUE(user_id, ticker, open_date)
-);
-
-CREATE TABLE IF NOT EXISTS closed_stocks (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    user_id INTEGER NOT NULL,
-    ticker TEXT NOT NULL,
-    open_date TEXT NOT NULL,
-    close_date TEXT NOT NULL,
-    quantity REAL NOT NULL,
-    cost_basis REAL NOT NULL,
-    sell_price REAL NOT NULL,
-    profit_loss REAL NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-);
-
-CREATE TABLE IF NOT EXISTS option_positions (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    user_id INTEGER NOT NULL,
-    ticker TEXT NOT NULL,
-    price REAL NOT NULL,
-    premium REAL NOT NULL,
-    strike REAL NOT NULL,
-    exp_date TEXT NOT NULL,
-    type TEXT NOT NULL,
-    collateral REAL NOT NULL,
-    quantity REAL NOT NULL DEFAULT 1,
-    purchase_date TEXT NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-);
-
-CREATE TABLE IF NOT EXISTS closed_options (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    user_id INTEGER NOT NULL,
-    ticker TEXT NOT NULL,
-    price REAL NOT NULL,
-    premium REAL NOT NULL,
-    strike REAL NOT NULL,
-    exp_date TEXT NOT NULL,
-    type TEXT NOT NULL,
-    collateral REAL NOT NULL,
-    quantity REAL NOT NULL DEFAULT 1,
-    purchase_date TEXT NOT NULL,
-    close_date TEXT NOT NULL,
-    sell_price REAL NOT NULL,
-    profit_loss REAL NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-);
-
-CREATE INDEX IF NOT EXISTS idx_stock_trades_user_id ON stock_trades(user_id);
-CREATE INDEX IF NOT EXISTS idx_stock_trades_ticker ON stock_trades(ticker);
-CREATE INDEX IF NOT EXISTS idx_option_trades_user_id ON option_trades(user_id);
-CREATE INDEX IF NOT EXISTS idx_option_trades_ticker ON option_trades(ticker);
-CREATE INDEX IF NOT EXISTS idx_stock_positions_user_id ON stock_positions(user_id);
-CREATE INDEX IF NOT EXISTS idx_stock_positions_ticker ON stock_positions(ticker);
-CREATE INDEX IF NOT EXISTS idx_closed_stocks_user_id ON closed_stocks(user_id);
-CREATE INDEX IF NOT EXISTS idx_closed_options_user_id ON closed_options(user_id);
-`
-
 func InitDB() {
 	var err error
 	db, err = sql.Open("sqlite3", "./database.db")
@@ -128,29 +19,377 @@ func InitDB() {
 		log.Fatal(err)
 	}
 
-	_, err = db.Exec(schemaSQL)
+	_, err = db.Exec(handlers.SchemaSQL)
 	if err != nil {
 		log.Fatal("Failed to execute database schema:", err)
 	}
 
 	runMigrations()
 
+	_, err = db.Exec(handlers.IndexSQL)
+	if err != nil {
+		log.Fatal("Failed to create indexes:", err)
+	}
+
 	log.Println("Database initialized successfully")
 }
 
 func runMigrations() {
+	addColumn("option_positions", "quantity", "REAL NOT NULL DEFAULT 1")
+	addColumn("closed_options", "quantity", "REAL NOT NULL DEFAULT 1")
+
+	addColumn("stock_trades", "seq", "INTEGER NOT NULL DEFAULT 0")
+	addColumn("stock_trades", "source", "TEXT NOT NULL DEFAULT 'import'")
+	addColumn("option_trades", "seq", "INTEGER NOT NULL DEFAULT 0")
+	addColumn("option_trades", "source", "TEXT NOT NULL DEFAULT 'import'")
+	addColumn("stock_trades", "fingerprint", "TEXT NOT NULL DEFAULT ''")
+	addColumn("option_trades", "fingerprint", "TEXT NOT NULL DEFAULT ''")
+	addColumn("closed_stocks", "close_trade_id", "TEXT NOT NULL DEFAULT ''")
+	addColumn("option_positions", "open_trade_id", "TEXT NOT NULL DEFAULT ''")
+	addColumn("closed_options", "open_trade_id", "TEXT NOT NULL DEFAULT ''")
+	addColumn("closed_options", "close_trade_id", "TEXT NOT NULL DEFAULT ''")
+	addColumn("stock_trades", "lot_method", "TEXT NOT NULL DEFAULT ''")
+	addColumn("stock_trades", "lot_trade_id", "TEXT NOT NULL DEFAULT ''")
+	addColumn("closed_stocks", "lot_id", "INTEGER NOT NULL DEFAULT 0")
+	for _, table := range []string{"stock_lots", "option_positions", "closed_stocks", "closed_options"} {
+		addColumn(table, "wash_adjustment", "REAL NOT NULL DEFAULT 0")
+		addColumn(table, "wash_quantity", "REAL NOT NULL DEFAULT 0")
+	}
+	addColumn("closed_stocks", "wash_disallowed", "REAL NOT NULL DEFAULT 0")
+	for _, table := range []string{"stock_lots", "stock_positions", "closed_stocks"} {
+		addColumn(table, "side", "TEXT NOT NULL DEFAULT 'long'")
+	}
+	addColumn("closed_options", "wash_disallowed", "REAL NOT NULL DEFAULT 0")
+	addColumn("option_trades", "strategy_key", "TEXT NOT NULL DEFAULT ''")
+	addColumn("option_positions", "strategy_id", "INTEGER NOT NULL DEFAULT 0")
+	addColumn("closed_options", "strategy_id", "INTEGER NOT NULL DEFAULT 0")
+	for _, table := range []string{"stock_lots", "closed_stocks", "option_positions", "closed_options"} {
+		addColumn(table, "campaign_id", "INTEGER NOT NULL DEFAULT 0")
+	}
+	addColumn("stock_trades", "option_trade_id", "TEXT NOT NULL DEFAULT ''")
+	addColumn("stock_lots", "premium_adjustment", "REAL NOT NULL DEFAULT 0")
+	addColumn("closed_stocks", "premium_adjustment", "REAL NOT NULL DEFAULT 0")
+	addColumn("closed_options", "premium_to_basis", "REAL NOT NULL DEFAULT 0")
+	addColumn("user_settings", "adjusted_basis", "INTEGER NOT NULL DEFAULT 0")
+	addColumn("option_trades", "roll_key", "TEXT NOT NULL DEFAULT ''")
+	addColumn("option_positions", "roll_id", "INTEGER NOT NULL DEFAULT 0")
+	addColumn("closed_options", "roll_id", "INTEGER NOT NULL DEFAULT 0")
+	addColumn("option_positions", "multiplier", "REAL NOT NULL DEFAULT 100")
+	addColumn("closed_options", "multiplier", "REAL NOT NULL DEFAULT 100")
+	for _, table := range []string{"stock_trades", "option_trades", "cash_flows", "stock_lots", "closed_stocks", "option_positions", "closed_options", "wheel_campaigns"} {
+		addColumn(table, "account_id", "INTEGER NOT NULL DEFAULT 0")
+	}
+	addColumn("user_settings", "account_view", "INTEGER NOT NULL DEFAULT -1")
+	convertMoneyColumns()
+	for _, table := range []string{"stock_trades", "option_trades", "stock_lots", "closed_stocks", "option_positions", "closed_options"} {
+		addColumn(table, "fees", "INTEGER NOT NULL DEFAULT 0")
+	}
+	addColumn("user_settings", "utilization_limit", "INTEGER NOT NULL DEFAULT 100")
+	addColumn("option_trades", "multiplier", "INTEGER NOT NULL DEFAULT 100000000")
+	addColumn("option_trades", "position_trade_id", "TEXT NOT NULL DEFAULT ''")
+	fixPerContractCollateral()
+	rebuildStockPositions()
+
+	backfillStockLots()
+}
+
+// moneyColumns lists the prices, quantities and amounts of money in each
+// table, which are stored as integer millionths.
+var moneyColumns = map[string][]string{
+	"stock_trades":      {"price", "amount", "quantity", "fees"},
+	"option_trades":     {"price", "amount", "quantity", "strike", "premium", "multiplier", "fees"},
+	"stock_positions":   {"quantity", "cost_basis"},
+	"stock_lots":        {"quantity", "cost_basis", "wash_adjustment", "wash_quantity", "premium_adjustment", "fees"},
+	"closed_stocks":     {"quantity", "cost_basis", "sell_price", "profit_loss", "wash_disallowed", "wash_quantity", "wash_adjustment", "premium_adjustment", "fees"},
+	"option_positions":  {"price", "premium", "strike", "collateral", "quantity", "wash_adjustment", "wash_quantity", "multiplier", "fees"},
+	"closed_options":    {"price", "premium", "strike", "collateral", "quantity", "sell_price", "profit_loss", "wash_disallowed", "wash_quantity", "wash_adjustment", "multiplier", "premium_to_basis", "fees"},
+	"corporate_actions": {"old_shares", "new_shares", "cash_per_share"},
+	"cash_flows":        {"amount"},
+}
+
+// convertMoneyColumns rebuilds tables from before money was stored as
+// integer millionths, scaling each value and rounding it to the nearest
+// millionth. Every table converts in one transaction: a database with some
+// tables converted and others not would misread every amount, so a failure
+// stops the server with nothing changed.
+func convertMoneyColumns() {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal("Failed to convert to integer money:", err)
+	}
+	defer tx.Rollback()
+
+	for table, columns := range moneyColumns {
+		if tableColumns(tx, table)[columns[0]] != "REAL" {
+			continue
+		}
+		if err := convertMoneyTable(tx, table, columns); err != nil {
+			log.Fatalf("Failed to convert %s to integer money: %v", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("Failed to convert to integer money:", err)
+	}
+}
+
+func convertMoneyTable(tx *sql.Tx, table string, columns []string) error {
+	oldTable := table + "_old"
+	oldColumns := tableColumns(tx, table)
+
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", table, oldTable)); err != nil {
+		return err
+	}
+
+	// The renamed table keeps its indexes, which would stop the schema
+	// creating them on the new table.
+	rows, err := tx.Query("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", oldTable)
+	if err != nil {
+		return err
+	}
+	var indexes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+	for _, index := range indexes {
+		if _, err := tx.Exec("DROP INDEX " + index); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(handlers.SchemaSQL); err != nil {
+		return err
+	}
+
+	money := map[string]bool{}
+	for _, column := range columns {
+		money[column] = true
+	}
+	newColumns := tableColumns(tx, table)
+	var names, values []string
+	for column := range oldColumns {
+		if _, ok := newColumns[column]; !ok {
+			continue
+		}
+		names = append(names, column)
+		if money[column] {
+			values = append(values, fmt.Sprintf("CAST(ROUND(%s * 1000000) AS INTEGER)", column))
+		} else {
+			values = append(values, column)
+		}
+	}
+
+	for _, statement := range []string{
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", table, strings.Join(names, ", "), strings.Join(values, ", "), oldTable),
+		"DROP TABLE " + oldTable,
+	} {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildStockPositions recreates stock_positions from before accounts,
+// which allowed one row per ticker and open date, so the same ticker can be
+// held in more than one account. The rows are copied into the Default
+// account.
+func rebuildStockPositions() {
+	if columnExists("stock_positions", "account_id") {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Migration note: failed to rebuild stock_positions: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{
+		"ALTER TABLE stock_positions RENAME TO stock_positions_old",
+		"DROP INDEX IF EXISTS idx_stock_positions_user_id",
+		"DROP INDEX IF EXISTS idx_stock_positions_ticker",
+		handlers.SchemaSQL,
+		`INSERT INTO stock_positions (id, user_id, open_date, ticker, quantity, cost_basis, side, created_at, updated_at)
+		 SELECT id, user_id, open_date, ticker, quantity, cost_basis, side, created_at, updated_at FROM stock_positions_old`,
+		"DROP TABLE stock_positions_old",
+	} {
+		if _, err := tx.Exec(statement); err != nil {
+			log.Printf("Migration note: failed to rebuild stock_positions: %v", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Migration note: failed to rebuild stock_positions: %v", err)
+	}
+}
+
+// fixPerContractCollateral scales CSP collateral that was recorded as the
+// strike times 100, or not at all, to cover every contract. Every value is
+// in millionths, so each product is scaled back down by a million. It runs
+// once, so positions saved since are left as they are.
+func fixPerContractCollateral() {
+	runOnce("per_contract_collateral", func() error {
+		for _, table := range []string{"option_positions", "closed_options"} {
+			_, err := db.Exec(fmt.Sprintf(`
+				UPDATE %s SET collateral = strike * quantity / 1000000 * multiplier / 1000000
+				WHERE type = 'CSP' AND (collateral = 0 OR collateral = strike * 100)
+			`, table))
+			if err != nil {
+				return fmt.Errorf("fixing collateral in %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+// backfillStockLots gives stock positions created before tax lots existed a
+// single lot holding their averaged cost basis, on the same side and in
+// the same account.
+func backfillStockLots() {
 	_, err := db.Exec(`
-		ALTER TABLE option_positions ADD COLUMN quantity REAL NOT NULL DEFAULT 1
+		INSERT INTO stock_lots (user_id, ticker, open_date, quantity, cost_basis, side, account_id, open_trade_id)
+		SELECT p.user_id, p.ticker, p.open_date, p.quantity, p.cost_basis, p.side, p.account_id, lower(hex(randomblob(16)))
+		FROM stock_positions p
+		WHERE p.quantity > 0 AND NOT EXISTS (
+			SELECT 1 FROM stock_lots l
+			WHERE l.user_id = p.user_id AND l.ticker = p.ticker AND l.side = p.side AND l.account_id = p.account_id
+		)
 	`)
 	if err != nil {
-		log.Println("Migration note: quantity column may already exist in option_positions")
+		log.Printf("Migration note: failed to backfill stock lots: %v", err)
+	}
+}
+
+// runOnce runs a data migration the first time the database starts with
+// it and records its name so later starts skip it. A migration that fails
+// is tried again on the next start.
+func runOnce(name string, migrate func() error) {
+	var done bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM data_migrations WHERE name = ?)", name).Scan(&done)
+	if err != nil {
+		log.Printf("Migration note: failed to check migration %s: %v", name, err)
+		return
+	}
+	if done {
+		return
+	}
+	if err := migrate(); err != nil {
+		log.Printf("Migration note: %s failed: %v", name, err)
+		return
 	}
+	if _, err := db.Exec("INSERT INTO data_migrations (name) VALUES (?)", name); err != nil {
+		log.Printf("Migration note: failed to record migration %s: %v", name, err)
+	}
+}
+
+// backfillLegacyLedger records ledger trades for the positions and history
+// users had before the ledger existed, so replay starts from a complete
+// ledger. It runs once: rows edited by hand later are not ledger entries.
+func backfillLegacyLedger() {
+	runOnce("legacy_ledger", func() error {
+		rows, err := db.Query("SELECT id FROM users")
+		if err != nil {
+			return err
+		}
+		var userIDs []int
+		for rows.Next() {
+			var userID int
+			if err := rows.Scan(&userID); err != nil {
+				rows.Close()
+				return err
+			}
+			userIDs = append(userIDs, userID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, userID := range userIDs {
+			if err := handlers.BackfillLedger(userID); err != nil {
+				return fmt.Errorf("user %d: %w", userID, err)
+			}
+		}
+		return nil
+	})
+}
 
-	_, err = db.Exec(`
-		ALTER TABLE closed_options ADD COLUMN quantity REAL NOT NULL DEFAULT 1
+// backfillCashTransactions builds the cash history of users whose trades and
+// cash flows were recorded before cash was tracked.
+func backfillCashTransactions() {
+	rows, err := db.Query(`
+		SELECT id FROM users u
+		WHERE NOT EXISTS (SELECT 1 FROM cash_transactions c WHERE c.user_id = u.id)
+		AND (EXISTS (SELECT 1 FROM stock_trades t WHERE t.user_id = u.id)
+			OR EXISTS (SELECT 1 FROM option_trades t WHERE t.user_id = u.id)
+			OR EXISTS (SELECT 1 FROM cash_flows f WHERE f.user_id = u.id))
 	`)
 	if err != nil {
-		log.Println("Migration note: quantity column may already exist in closed_options")
+		log.Printf("Migration note: failed to find users to backfill cash for: %v", err)
+		return
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		if err := handlers.BackfillCash(userID); err != nil {
+			log.Printf("Migration note: failed to backfill cash for user %d: %v", userID, err)
+		}
+	}
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// tableColumns returns the table's column names and their declared types.
+func tableColumns(q querier, table string) map[string]string {
+	columns := map[string]string{}
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return columns
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			continue
+		}
+		columns[name] = colType
+	}
+	return columns
+}
+
+func columnExists(table, column string) bool {
+	_, ok := tableColumns(db, table)[column]
+	return ok
+}
+
+func addColumn(table, column, definition string) {
+	if columnExists(table, column) {
+		return
+	}
+
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		log.Printf("Migration note: failed to add %s column to %s: %v", column, table, err)
 	}
 }
 
@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"database/sql"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// userAccounts lists the accounts the user has set up, by name. The Default
+// account is not stored and is not included.
+func userAccounts(q dbtx, userID int) ([]types.Account, error) {
+	rows, err := q.Query("SELECT id, name FROM accounts WHERE user_id = ? ORDER BY name COLLATE NOCASE", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []types.Account
+	for rows.Next() {
+		var account types.Account
+		if err := rows.Scan(&account.ID, &account.Name); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// validAccount reports whether the account exists for the user.
+func validAccount(q dbtx, userID, accountID int) bool {
+	if accountID == types.DefaultAccount {
+		return true
+	}
+	var count int
+	q.QueryRow("SELECT COUNT(*) FROM accounts WHERE id = ? AND user_id = ?", accountID, userID).Scan(&count)
+	return count > 0
+}
+
+// formAccount reads the account picked on a form, defaulting to the
+// Default account when the form has no account field.
+func formAccount(r *http.Request, userID int) (int, error) {
+	value := r.FormValue("account")
+	if value == "" {
+		return types.DefaultAccount, nil
+	}
+	accountID, err := strconv.Atoi(value)
+	if err != nil || !validAccount(db, userID, accountID) {
+		return 0, fmt.Errorf("unknown account")
+	}
+	return accountID, nil
+}
+
+// formDefaultAccount is the account preselected on forms: the one being
+// viewed, or the Default account when every account is shown.
+func formDefaultAccount(userID int) int {
+	if view := userAccountView(db, userID); view != types.AllAccounts {
+		return view
+	}
+	return types.DefaultAccount
+}
+
+// userAccountView returns the account the user is viewing, or AllAccounts.
+func userAccountView(q dbtx, userID int) int {
+	view := types.AllAccounts
+	q.QueryRow("SELECT account_view FROM user_settings WHERE user_id = ?", userID).Scan(&view)
+	if !validAccount(q, userID, view) {
+		return types.AllAccounts
+	}
+	return view
+}
+
+func setUserAccountView(q dbtx, userID, view int) error {
+	_, err := q.Exec(`
+		INSERT INTO user_settings (user_id, account_view) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET account_view = excluded.account_view, updated_at = CURRENT_TIMESTAMP
+	`, userID, view)
+	return err
+}
+
+// accountScope limits the positions, history and stats a request reads to
+// one account, or to none when every account is consolidated.
+type accountScope struct {
+	View  int
+	names map[int]string
+}
+
+// requestAccountScope scopes a request to the "account" query parameter
+// when given, and to the account the user is viewing otherwise. Viewing
+// every account labels rows with the account they belong to.
+func requestAccountScope(r *http.Request, userID int) accountScope {
+	scope := accountScope{View: userAccountView(db, userID)}
+	if value := r.URL.Query().Get("account"); value != "" {
+		if view, err := strconv.Atoi(value); err == nil && (view == types.AllAccounts || validAccount(db, userID, view)) {
+			scope.View = view
+		}
+	}
+
+	if scope.View == types.AllAccounts {
+		accounts, _ := userAccounts(db, userID)
+		if len(accounts) > 0 {
+			scope.names = map[int]string{types.DefaultAccount: types.DefaultAccountName}
+			for _, account := range accounts {
+				scope.names[account.ID] = account.Name
+			}
+		}
+	}
+	return scope
+}
+
+// filter returns the condition limiting column to the scoped account, to be
+// appended to a WHERE clause, with args supplying its parameter.
+func (s accountScope) filter(column string) string {
+	if s.View == types.AllAccounts {
+		return ""
+	}
+	return " AND " + column + " = ?"
+}
+
+// args appends the scoped account to a query's other arguments.
+func (s accountScope) args(args ...interface{}) []interface{} {
+	if s.View == types.AllAccounts {
+		return args
+	}
+	return append(args, s.View)
+}
+
+// label names the account of a row listed alongside other accounts.
+func (s accountScope) label(accountID int) string {
+	return s.names[accountID]
+}
+
+// accountTagHTML is AccountTag for the tables built with fmt.
+func accountTagHTML(account string) string {
+	if account == "" {
+		return ""
+	}
+	return fmt.Sprintf(` <span class="account-tag">%s</span>`, html.EscapeString(account))
+}
+
+func recordAccountTransfer(q dbtx, userID int, transfer *types.AccountTransfer) error {
+	transfer.Date = NormalizeDateToISO(transfer.Date)
+	transfer.Ticker = strings.ToUpper(strings.TrimSpace(transfer.Ticker))
+
+	result, err := q.Exec(`
+		INSERT INTO account_transfers (user_id, date, ticker, from_account, to_account)
+		VALUES (?, ?, ?, ?, ?)
+	`, userID, transfer.Date, transfer.Ticker, transfer.FromAccount, transfer.ToAccount)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	transfer.ID = int(id)
+	return err
+}
+
+func accountTransfers(q dbtx, userID int) ([]types.AccountTransfer, error) {
+	rows, err := q.Query(`
+		SELECT id, date, ticker, from_account, to_account
+		FROM account_transfers
+		WHERE user_id = ?
+		ORDER BY date, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []types.AccountTransfer
+	for rows.Next() {
+		var t types.AccountTransfer
+		if err := rows.Scan(&t.ID, &t.Date, &t.Ticker, &t.FromAccount, &t.ToAccount); err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+// applyAccountTransfer moves the open lots, option positions and running
+// wheel campaigns of a transfer into the receiving account, as a broker
+// transfer would, keeping their basis and open dates.
+func applyAccountTransfer(q dbtx, userID int, transfer types.AccountTransfer) error {
+	tickerFilter, args := "", []interface{}{transfer.ToAccount, userID, transfer.FromAccount}
+	if transfer.Ticker != "" {
+		tickerFilter = " AND ticker = ?"
+		args = append(args, transfer.Ticker)
+	}
+
+	tickers, err := transferTickers(q, userID, transfer, tickerFilter)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"UPDATE stock_lots SET account_id = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND account_id = ? AND quantity > 0",
+		"UPDATE option_positions SET account_id = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND account_id = ? AND quantity > 0",
+		"UPDATE wheel_campaigns SET account_id = ? WHERE user_id = ? AND account_id = ? AND end_date = ''",
+	} {
+		if _, err := q.Exec(query+tickerFilter, args...); err != nil {
+			return err
+		}
+	}
+
+	for _, ticker := range tickers {
+		if _, err := syncStockPosition(q, userID, transfer.FromAccount, ticker); err != nil {
+			return err
+		}
+		if _, err := syncStockPosition(q, userID, transfer.ToAccount, ticker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferTickers lists the tickers with open lots a transfer moves.
+func transferTickers(q dbtx, userID int, transfer types.AccountTransfer, tickerFilter string) ([]string, error) {
+	args := []interface{}{userID, transfer.FromAccount}
+	if transfer.Ticker != "" {
+		args = append(args, transfer.Ticker)
+	}
+	rows, err := q.Query("SELECT DISTINCT ticker FROM stock_lots WHERE user_id = ? AND account_id = ? AND quantity > 0"+tickerFilter, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickers []string
+	for rows.Next() {
+		var ticker string
+		if err := rows.Scan(&ticker); err != nil {
+			return nil, err
+		}
+		tickers = append(tickers, ticker)
+	}
+	return tickers, rows.Err()
+}
+
+// accountInUse reports whether anything has been recorded in an account.
+func accountInUse(q dbtx, userID, accountID int) (bool, error) {
+	var count int
+	err := q.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM stock_trades WHERE user_id = ? AND account_id = ?)
+		     + (SELECT COUNT(*) FROM option_trades WHERE user_id = ? AND account_id = ?)
+		     + (SELECT COUNT(*) FROM cash_flows WHERE user_id = ? AND account_id = ?)
+		     + (SELECT COUNT(*) FROM account_transfers WHERE user_id = ? AND (from_account = ? OR to_account = ?))
+	`, userID, accountID, userID, accountID, userID, accountID, userID, accountID, accountID).Scan(&count)
+	return count > 0, err
+}
+
+func HandleAccountSelector(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accounts, err := userAccounts(db, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.AccountSelector(accounts, userAccountView(db, userID)).Render(r.Context(), w)
+}
+
+// HandleUpdateAccountView switches the account the user is viewing and
+// reloads the page so every table follows it.
+func HandleUpdateAccountView(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	view, err := strconv.Atoi(r.FormValue("account"))
+	if err != nil || (view != types.AllAccounts && !validAccount(db, userID, view)) {
+		http.Error(w, "Unknown account", http.StatusBadRequest)
+		return
+	}
+	if err := setUserAccountView(db, userID, view); err != nil {
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+}
+
+func renderAccountsModal(w http.ResponseWriter, r *http.Request, userID int, message string) {
+	accounts, err := userAccounts(db, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.AccountsModal(accounts, time.Now().Format("2006-01-02"), message).Render(r.Context(), w)
+}
+
+func HandleModalAccounts(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	renderAccountsModal(w, r, userID, "")
+}
+
+func HandleAddAccount(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || strings.EqualFold(name, types.DefaultAccountName) {
+		renderAccountsModal(w, r, userID, "Pick a name other than "+types.DefaultAccountName+".")
+		return
+	}
+
+	if _, err := db.Exec("INSERT INTO accounts (user_id, name) VALUES (?, ?)", userID, name); err != nil {
+		renderAccountsModal(w, r, userID, "An account named "+name+" already exists.")
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "accountsUpdated")
+	renderAccountsModal(w, r, userID, "")
+}
+
+// HandleDeleteAccount removes an account nothing has been recorded in.
+func HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accountID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	inUse, err := accountInUse(db, userID, accountID)
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	if inUse {
+		renderAccountsModal(w, r, userID, "Trades, cash flows or transfers are recorded in that account, so it can't be deleted.")
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM accounts WHERE id = ? AND user_id = ?", accountID, userID); err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "accountsUpdated")
+	renderAccountsModal(w, r, userID, "")
+}
+
+// HandleMoveToAccountModal asks where to move a ticker's positions. The
+// position id and kind identify the account and ticker being moved.
+func HandleMoveToAccountModal(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	table := "stock_positions"
+	if chi.URLParam(r, "kind") == "option" {
+		table = "option_positions"
+	}
+
+	var ticker string
+	var fromAccount int
+	err := db.QueryRow("SELECT ticker, account_id FROM "+table+" WHERE id = ? AND user_id = ?", chi.URLParam(r, "id"), userID).Scan(&ticker, &fromAccount)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch position", http.StatusInternalServerError)
+		return
+	}
+
+	accounts, err := userAccounts(db, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.MoveToAccountModal(ticker, fromAccount, accounts, time.Now().Format("2006-01-02")).Render(r.Context(), w)
+}
+
+// HandleTransferPositions records a transfer between accounts in the
+// ledger and applies it. Leaving the ticker blank moves every open
+// position in the account.
+func HandleTransferPositions(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	from, err1 := strconv.Atoi(r.FormValue("fromAccount"))
+	to, err2 := strconv.Atoi(r.FormValue("toAccount"))
+	if err1 != nil || err2 != nil || !validAccount(db, userID, from) || !validAccount(db, userID, to) {
+		http.Error(w, "Unknown account", http.StatusBadRequest)
+		return
+	}
+	if from == to {
+		http.Error(w, "Pick a different account to move to", http.StatusBadRequest)
+		return
+	}
+
+	transfer := types.AccountTransfer{
+		Date:        r.FormValue("date"),
+		Ticker:      r.FormValue("ticker"),
+		FromAccount: from,
+		ToAccount:   to,
+	}
+	if transfer.Date == "" {
+		transfer.Date = time.Now().Format("2006-01-02")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = recordAccountTransfer(tx, userID, &transfer)
+	if err == nil {
+		err = applyAccountTransfer(tx, userID, transfer)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Failed to move positions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "positionAdded, positionClosed")
+	components.ModalClose().Render(r.Context(), w)
+}
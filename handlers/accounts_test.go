@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"backend/types"
+	"fmt"
+	"testing"
+)
+
+// addAccount sets up a named account for the user.
+func addAccount(t *testing.T, userID int, name string) int {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO accounts (user_id, name) VALUES (?, ?)", userID, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// accountPositions describes the user's stock positions, one per account.
+func accountPositions(t *testing.T, userID int) string {
+	t.Helper()
+	rows, err := db.Query("SELECT account_id, ticker, quantity, cost_basis FROM stock_positions WHERE user_id = ? ORDER BY account_id", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var positions []string
+	for rows.Next() {
+		var accountID int
+		var ticker string
+		var quantity, costBasis types.Decimal
+		if err := rows.Scan(&accountID, &ticker, &quantity, &costBasis); err != nil {
+			t.Fatal(err)
+		}
+		positions = append(positions, fmt.Sprintf("%d:%s %s @ %s", accountID, ticker, quantity, costBasis))
+	}
+	return fmt.Sprint(positions)
+}
+
+func TestAccountScopeFilter(t *testing.T) {
+	tests := []struct {
+		view   int
+		filter string
+		args   string
+	}{
+		{types.AllAccounts, "", "[7]"},
+		{types.DefaultAccount, " AND account_id = ?", "[7 0]"},
+		{3, " AND account_id = ?", "[7 3]"},
+	}
+	for _, tt := range tests {
+		scope := accountScope{View: tt.view}
+		if got := scope.filter("account_id"); got != tt.filter {
+			t.Errorf("view %d: filter = %q, want %q", tt.view, got, tt.filter)
+		}
+		if got := fmt.Sprint(scope.args(7)); got != tt.args {
+			t.Errorf("view %d: args = %s, want %s", tt.view, got, tt.args)
+		}
+	}
+}
+
+// The same ticker held in two accounts stays two positions: a sale in one
+// account closes only its own lots and moves only its own cash.
+func TestAccountsKeepPositionsApart(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "accounts")
+	ira := addAccount(t, userID, "IRA")
+
+	for _, buy := range []struct {
+		account int
+		price   string
+	}{{types.DefaultAccount, "10"}, {ira, "20"}} {
+		applyStock(t, userID, types.StockTrade{
+			Ticker:    "F",
+			Date:      "2025-01-02",
+			Code:      types.Buy,
+			Price:     decimal(t, buy.price),
+			Quantity:  types.DecimalFromInt(10),
+			AccountID: buy.account,
+		})
+	}
+	applyStock(t, userID, types.StockTrade{
+		Ticker:    "F",
+		Date:      "2025-02-03",
+		Code:      types.Sell,
+		Price:     decimal(t, "25"),
+		Quantity:  types.DecimalFromInt(5),
+		AccountID: ira,
+	})
+
+	if got, want := accountPositions(t, userID), fmt.Sprintf("[0:F 10 @ 10 %d:F 5 @ 20]", ira); got != want {
+		t.Errorf("positions = %s, want %s", got, want)
+	}
+
+	var closedAccount int
+	var profitLoss types.Decimal
+	if err := db.QueryRow("SELECT account_id, profit_loss FROM closed_stocks WHERE user_id = ?", userID).Scan(&closedAccount, &profitLoss); err != nil {
+		t.Fatal(err)
+	}
+	// 5 x ($25 - $20), not the Default account's $10 basis.
+	if closedAccount != ira || profitLoss.String() != "25" {
+		t.Errorf("sale closed %s in account %d, want 25 in %d", profitLoss, closedAccount, ira)
+	}
+
+	for _, tt := range []struct {
+		view int
+		want string
+	}{
+		{types.DefaultAccount, "-100"},
+		{ira, "-75"},
+		{types.AllAccounts, "-175"},
+	} {
+		balance, err := cashBalance(db, userID, accountScope{View: tt.view})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance.String() != tt.want {
+			t.Errorf("cash in view %d = %s, want %s", tt.view, balance, tt.want)
+		}
+	}
+}
+
+// A transfer moves the open lots and options of a ticker into the other
+// account, merging them with what it already holds.
+func TestApplyAccountTransfer(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "transfer")
+	ira := addAccount(t, userID, "IRA")
+
+	for _, trade := range []types.StockTrade{
+		{Ticker: "F", Date: "2025-01-02", Code: types.Buy, Price: decimal(t, "10"), Quantity: types.DecimalFromInt(10)},
+		{Ticker: "F", Date: "2025-01-03", Code: types.Buy, Price: decimal(t, "20"), Quantity: types.DecimalFromInt(10), AccountID: ira},
+		{Ticker: "GM", Date: "2025-01-02", Code: types.Buy, Price: decimal(t, "40"), Quantity: types.DecimalFromInt(5)},
+	} {
+		applyStock(t, userID, trade)
+	}
+	applyOption(t, userID, openingOptionTrade("F", "2025-01-02", types.CC, decimal(t, "12"), decimal(t, "0.3"), types.DecimalFromInt(1), types.StandardMultiplier, "2025-02-21"))
+
+	transfer := types.AccountTransfer{Date: "2025-03-03", Ticker: "F", FromAccount: types.DefaultAccount, ToAccount: ira}
+	if err := applyAccountTransfer(db, userID, transfer); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := accountPositions(t, userID), fmt.Sprintf("[0:GM 5 @ 40 %d:F 20 @ 15]", ira); got != want {
+		t.Errorf("positions = %s, want %s", got, want)
+	}
+	var optionAccount int
+	if err := db.QueryRow("SELECT account_id FROM option_positions WHERE user_id = ?", userID).Scan(&optionAccount); err != nil {
+		t.Fatal(err)
+	}
+	if optionAccount != ira {
+		t.Errorf("covered call is in account %d, want %d", optionAccount, ira)
+	}
+}
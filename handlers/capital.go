@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// capitalInUse totals the capital open positions tie up in scope, by
+// ticker: CSP collateral, the shares covering CCs, the collateral of open
+// strategies as analyzeStrategy works it out, and the rest of the long
+// stock at cost.
+func capitalInUse(q dbtx, userID int, scope accountScope) ([]components.CapitalRow, error) {
+	rows := map[string]*components.CapitalRow{}
+	row := func(ticker string) *components.CapitalRow {
+		if rows[ticker] == nil {
+			rows[ticker] = &components.CapitalRow{Ticker: ticker}
+		}
+		return rows[ticker]
+	}
+
+	optionRows, err := q.Query(`
+		SELECT ticker, type, collateral
+		FROM option_positions
+		WHERE user_id = ? AND quantity > 0 AND strategy_id = 0 AND type IN (?, ?)`+scope.filter("account_id"),
+		scope.args(userID, types.CSP, types.CC)...)
+	if err != nil {
+		return nil, err
+	}
+	defer optionRows.Close()
+
+	for optionRows.Next() {
+		var ticker string
+		var positionType types.OptionType
+		var collateral types.Decimal
+		if err := optionRows.Scan(&ticker, &positionType, &collateral); err != nil {
+			return nil, err
+		}
+		if positionType == types.CSP {
+			row(ticker).CSP = row(ticker).CSP.Add(collateral)
+		} else {
+			row(ticker).CoveredShares = row(ticker).CoveredShares.Add(collateral)
+		}
+	}
+	if err := optionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// A strategy's legs are counted together, so a spread ties up its
+	// width rather than the full collateral of its short leg.
+	strategies, err := openStrategies(q, userID, scope, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, strategy := range strategies {
+		row(strategy.Ticker).Strategies = row(strategy.Ticker).Strategies.Add(strategy.Collateral)
+	}
+
+	stockRows, err := q.Query(`
+		SELECT ticker, quantity, cost_basis
+		FROM stock_positions
+		WHERE user_id = ? AND side = ?`+scope.filter("account_id"),
+		scope.args(userID, types.Long)...)
+	if err != nil {
+		return nil, err
+	}
+	defer stockRows.Close()
+
+	for stockRows.Next() {
+		var ticker string
+		var quantity, costBasis types.Decimal
+		if err := stockRows.Scan(&ticker, &quantity, &costBasis); err != nil {
+			return nil, err
+		}
+		row(ticker).LongStock = row(ticker).LongStock.Add(quantity.Mul(costBasis))
+	}
+	if err := stockRows.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []components.CapitalRow
+	for _, r := range rows {
+		// Shares covering a CC are long stock too; count them once.
+		r.LongStock = types.MaxDecimal(r.LongStock.Sub(r.CoveredShares), types.Decimal{})
+		r.Total = r.CSP.Add(r.CoveredShares).Add(r.Strategies).Add(r.LongStock)
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Total.Cmp(result[j].Total) > 0 })
+	return result, nil
+}
+
+// accountSize is the book value of the accounts in scope: what was
+// deposited plus what has been realized.
+func accountSize(q dbtx, userID int, scope accountScope) (types.Decimal, error) {
+	days, err := performanceDays(q, userID, scope)
+	var size types.Decimal
+	for _, day := range days {
+		size = size.Add(day.Flow).Add(day.Gain)
+	}
+	return size, err
+}
+
+// utilization is capital as a percentage of the account size, or false
+// when the account size isn't known.
+func utilization(capital, size types.Decimal) (float64, bool) {
+	if size.Sign() <= 0 {
+		return 0, false
+	}
+	return capital.Float() / size.Float() * 100, true
+}
+
+func userUtilizationLimit(q dbtx, userID int) int {
+	limit := 100
+	q.QueryRow("SELECT utilization_limit FROM user_settings WHERE user_id = ?", userID).Scan(&limit)
+	return limit
+}
+
+func setUserUtilizationLimit(q dbtx, userID, limit int) error {
+	_, err := q.Exec(`
+		INSERT INTO user_settings (user_id, utilization_limit) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET utilization_limit = excluded.utilization_limit, updated_at = CURRENT_TIMESTAMP
+	`, userID, limit)
+	return err
+}
+
+func HandleCapital(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scope := requestAccountScope(r, userID)
+	tickers, err := capitalInUse(db, userID, scope)
+	if err != nil {
+		http.Error(w, "Failed to load capital in use", http.StatusInternalServerError)
+		return
+	}
+	size, err := accountSize(db, userID, scope)
+	if err != nil {
+		http.Error(w, "Failed to load account size", http.StatusInternalServerError)
+		return
+	}
+
+	data := components.CapitalData{Tickers: tickers, AccountSize: size, Limit: userUtilizationLimit(db, userID)}
+	for _, t := range tickers {
+		data.CSP = data.CSP.Add(t.CSP)
+		data.CoveredShares = data.CoveredShares.Add(t.CoveredShares)
+		data.Strategies = data.Strategies.Add(t.Strategies)
+		data.LongStock = data.LongStock.Add(t.LongStock)
+		data.Total = data.Total.Add(t.Total)
+	}
+	data.Utilization, data.HasUtilization = utilization(data.Total, size)
+
+	w.Header().Set("Content-Type", "text/html")
+	components.CapitalDashboard(data).Render(r.Context(), w)
+}
+
+func HandleUpdateUtilizationLimit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if err := setUserUtilizationLimit(db, userID, limit); err != nil {
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "capitalUpdated")
+	w.Header().Set("Content-Type", "text/html")
+	components.UtilizationLimitSetting(limit).Render(r.Context(), w)
+}
+
+// HandleCapitalCheck previews the utilization of the account a position
+// is being added to, flagging it when the position would take it over the
+// limit. Only stock bought long, CSPs and strategies need new capital; a CC
+// is covered by shares already counted.
+func HandleCapitalCheck(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accountID, err := formAccount(r, userID)
+	if err != nil {
+		http.Error(w, "Unknown account", http.StatusBadRequest)
+		return
+	}
+
+	quantity, _ := types.ParseDecimal(r.FormValue("quantity"))
+	var required types.Decimal
+	switch r.FormValue("positionType") {
+	case "stock":
+		if types.PositionSide(r.FormValue("side")) != types.Short {
+			costBasis, _ := types.ParseDecimal(r.FormValue("costBasis"))
+			required = costBasis.Mul(quantity)
+		}
+	case "option":
+		if types.OptionType(r.FormValue("optionType")) == types.CSP {
+			strike, _ := types.ParseDecimal(r.FormValue("strike"))
+			required = strike.Mul(quantity).Mul(formMultiplier(r))
+		}
+	case "strategy":
+		required, err = strategyCapital(r, userID, accountID, quantity)
+		if err != nil {
+			// Legs still being entered need nothing yet.
+			required = types.Decimal{}
+		}
+	}
+
+	check := components.CapitalCheckData{Required: required, Limit: userUtilizationLimit(db, userID)}
+	if required.Sign() > 0 {
+		scope := accountScope{View: accountID}
+		tickers, err := capitalInUse(db, userID, scope)
+		if err != nil {
+			http.Error(w, "Failed to load capital in use", http.StatusInternalServerError)
+			return
+		}
+		size, err := accountSize(db, userID, scope)
+		if err != nil {
+			http.Error(w, "Failed to load account size", http.StatusInternalServerError)
+			return
+		}
+
+		capital := required
+		for _, t := range tickers {
+			capital = capital.Add(t.Total)
+		}
+		check.Utilization, check.HasUtilization = utilization(capital, size)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.CapitalCheck(check).Render(r.Context(), w)
+}
+
+// strategyCapital is the capital the strategy entered in the Add Position
+// modal would tie up: its maximum loss when that is defined, otherwise the
+// collateral of each leg, as analyzeStrategy works it out for open
+// strategies.
+func strategyCapital(r *http.Request, userID, accountID int, quantity types.Decimal) (types.Decimal, error) {
+	ticker := strings.ToUpper(r.FormValue("ticker"))
+	trades, err := strategyLegTrades(r, ticker, "", quantity)
+	if err != nil {
+		return types.Decimal{}, err
+	}
+
+	var strategy types.OptionStrategy
+	for _, trade := range trades {
+		positionType := optionPositionType(trade)
+		collateral, err := optionCollateral(db, userID, accountID, 0, ticker, positionType, trade.Strike, trade.Quantity, trade.Multiplier)
+		if err != nil {
+			return types.Decimal{}, err
+		}
+		strategy.Legs = append(strategy.Legs, types.OptionPos{
+			Type:       positionType,
+			Strike:     trade.Strike,
+			Premium:    trade.Premium,
+			Quantity:   trade.Quantity,
+			Multiplier: trade.Multiplier,
+			Collateral: collateral,
+		})
+	}
+	analyzeStrategy(&strategy)
+	return strategy.Collateral, nil
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"backend/types"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUtilization(t *testing.T) {
+	tests := []struct {
+		capital, size string
+		want          float64
+		ok            bool
+	}{
+		{"500", "1000", 50, true},
+		{"0", "1000", 0, true},
+		{"1500", "1000", 150, true},
+		{"500", "0", 0, false},
+		{"500", "-100", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := utilization(decimal(t, tt.capital), decimal(t, tt.size))
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("utilization(%s, %s) = %v, %v; want %v, %v", tt.capital, tt.size, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// holdCapital deposits $10,000 and opens F positions tying up capital of
+// every kind.
+func holdCapital(t *testing.T, userID int, h http.Handler) {
+	t.Helper()
+	if err := postCashFlow(db, userID, types.CashFlow{Date: "2025-01-02", Type: types.Deposit, Amount: decimal(t, "10000")}); err != nil {
+		t.Fatal(err)
+	}
+	applyStock(t, userID, types.StockTrade{Ticker: "F", Date: "2025-01-03", Code: types.Buy, Price: decimal(t, "10"), Quantity: types.DecimalFromInt(200)})
+	applyOption(t, userID, openingOptionTrade("F", "2025-01-03", types.CC, decimal(t, "12"), decimal(t, "0.3"), types.DecimalFromInt(1), types.StandardMultiplier, "2025-02-21"))
+	applyOption(t, userID, openingOptionTrade("F", "2025-01-03", types.CSP, decimal(t, "9"), decimal(t, "0.4"), types.DecimalFromInt(2), types.StandardMultiplier, "2025-02-21"))
+	postForm(t, h, "/api/positions/add", url.Values{
+		"positionType": {"strategy"}, "ticker": {"F"}, "quantity": {"1"}, "expDate": {"2025-02-21"}, "openDate": {"2025-01-03"},
+		"legType": {string(types.CSP), string(types.Put)}, "legStrike": {"8", "7"}, "legPremium": {"0.5", "0.2"},
+	})
+}
+
+// Each kind of position is counted once: the covered shares apart from the
+// rest of the stock, and a spread at its maximum loss rather than the
+// collateral of its short put.
+func TestCapitalInUse(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "capital")
+	holdCapital(t, userID, h)
+
+	rows, err := capitalInUse(db, userID, accountScope{View: types.AllAccounts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, fmt.Sprintf("%s csp %s covered %s strategies %s long %s total %s", row.Ticker, row.CSP, row.CoveredShares, row.Strategies, row.LongStock, row.Total))
+	}
+	if want := "[F csp 1800 covered 1000 strategies 70 long 1000 total 3870]"; fmt.Sprint(got) != want {
+		t.Errorf("capital in use = %v, want %s", got, want)
+	}
+
+	size, err := accountSize(db, userID, accountScope{View: types.AllAccounts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.String() != "10000" {
+		t.Errorf("account size = %s, want 10000", size)
+	}
+}
+
+// The check adds what a new position would need to the $3,870 already in
+// use, and warns once that goes over the limit.
+func TestCapitalCheck(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "capital")
+	holdCapital(t, userID, h)
+
+	tests := []struct {
+		name string
+		form url.Values
+		want string
+	}{
+		{"stock bought long", url.Values{"positionType": {"stock"}, "quantity": {"100"}, "costBasis": {"10"}},
+			"needs $1000.00, leaving 48.7% of the account in use"},
+		{"stock sold short", url.Values{"positionType": {"stock"}, "side": {string(types.Short)}, "quantity": {"100"}, "costBasis": {"10"}}, ""},
+		{"cash-secured put", url.Values{"positionType": {"option"}, "optionType": {string(types.CSP)}, "quantity": {"2"}, "strike": {"50"}},
+			"needs $10000.00 and would put 138.7% of the account to work, over the 100% limit"},
+		{"covered call", url.Values{"positionType": {"option"}, "optionType": {string(types.CC)}, "quantity": {"1"}, "strike": {"12"}}, ""},
+		{"put credit spread", url.Values{
+			"positionType": {"strategy"}, "ticker": {"F"}, "quantity": {"3"}, "expDate": {"2025-03-21"},
+			"legType": {string(types.CSP), string(types.Put)}, "legStrike": {"10", "8"}, "legPremium": {"1", "0.4"},
+		}, "needs $420.00, leaving 42.9% of the account in use"},
+		{"strategy missing a leg", url.Values{
+			"positionType": {"strategy"}, "ticker": {"F"}, "quantity": {"3"}, "expDate": {"2025-03-21"},
+			"legType": {string(types.CSP)}, "legStrike": {"10"}, "legPremium": {"1"},
+		}, ""},
+	}
+	for _, tt := range tests {
+		body := postForm(t, h, "/api/capital/check", tt.form)
+		if tt.want == "" {
+			if strings.Contains(body, "needs") {
+				t.Errorf("%s: check = %s, want nothing needed", tt.name, body)
+			}
+		} else if !strings.Contains(body, tt.want) {
+			t.Errorf("%s: check = %s, want %q", tt.name, body, tt.want)
+		}
+	}
+}
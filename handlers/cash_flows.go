@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func recordCashFlow(q dbtx, userID int, flow *types.CashFlow, source string) error {
+	flow.Date = NormalizeDateToISO(flow.Date)
+	flow.Ticker = strings.ToUpper(strings.TrimSpace(flow.Ticker))
+
+	result, err := q.Exec(`
+		INSERT INTO cash_flows (user_id, account_id, date, type, ticker, amount, description, source, fingerprint)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, flow.AccountID, flow.Date, flow.Type, flow.Ticker, flow.Amount, flow.Description, source, flow.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	flow.ID = int(id)
+	return err
+}
+
+func HandleModalAddCashFlow(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accounts, err := userAccounts(db, userID)
+	if err != nil {
+		http.Error(w, "Failed to load accounts", http.StatusInternalServerError)
+		return
+	}
+
+	components.AddCashFlowModal(time.Now().Format("2006-01-02"), accounts, formDefaultAccount(userID)).Render(r.Context(), w)
+}
+
+func HandleAddCashFlow(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	amount, err := types.ParseDecimal(r.FormValue("amount"))
+	if err != nil || amount.IsZero() {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	accountID, err := formAccount(r, userID)
+	if err != nil {
+		http.Error(w, "Unknown account", http.StatusBadRequest)
+		return
+	}
+
+	flow := types.CashFlow{
+		Date:        r.FormValue("date"),
+		Type:        types.CashFlowType(r.FormValue("type")),
+		Ticker:      r.FormValue("ticker"),
+		Description: strings.TrimSpace(r.FormValue("description")),
+		AccountID:   accountID,
+	}
+	if flow.Date == "" {
+		flow.Date = time.Now().Format("2006-01-02")
+	}
+
+	// Amounts are entered as positive numbers; interest and starting
+	// balances keep their sign so margin interest and a margin debit can be
+	// entered as negatives.
+	switch flow.Type {
+	case types.Dividend, types.Deposit:
+		flow.Amount = amount.Abs()
+	case types.Fee, types.Withdrawal:
+		flow.Amount = amount.Abs().Neg()
+	case types.Interest, types.StartingBalance:
+		flow.Amount = amount
+	default:
+		http.Error(w, "Invalid cash flow type", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = recordCashFlow(tx, userID, &flow, SourceManual)
+	if err == nil {
+		err = postCashFlow(tx, userID, flow)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Failed to add cash flow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "cashFlowsUpdated")
+	components.ModalClose().Render(r.Context(), w)
+}
+
+func HandleGetCashFlows(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scope := requestAccountScope(r, userID)
+	rows, err := db.Query(`
+		SELECT id, date, type, ticker, amount, description, account_id
+		FROM cash_flows
+		WHERE user_id = ?`+scope.filter("account_id")+`
+		ORDER BY date DESC, id DESC
+	`, scope.args(userID)...)
+	if err != nil {
+		http.Error(w, "Failed to fetch cash flows", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var flows []types.CashFlow
+	for rows.Next() {
+		var flow types.CashFlow
+		if err := rows.Scan(&flow.ID, &flow.Date, &flow.Type, &flow.Ticker, &flow.Amount, &flow.Description, &flow.AccountID); err != nil {
+			continue
+		}
+		flow.Account = scope.label(flow.AccountID)
+		flows = append(flows, flow)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.CashFlowsTable(flows, FormatDate).Render(r.Context(), w)
+}
+
+func HandleDeleteCashFlow(w http.ResponseWriter, r *http.Request) {
+	flowID := chi.URLParam(r, "id")
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := db.Exec("DELETE FROM cash_flows WHERE id = ? AND user_id = ?", flowID, userID)
+	if err == nil {
+		_, err = db.Exec("DELETE FROM cash_transactions WHERE cash_flow_id = ? AND user_id = ?", flowID, userID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete cash flow", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "cashFlowsUpdated")
+	HandleGetCashFlows(w, r)
+}
+
+// HandleTickerReturns shows the total return of each ticker: realized stock
+// and option P/L plus the dividends and fees attributed to it.
+func HandleTickerReturns(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scope := requestAccountScope(r, userID)
+	filter := scope.filter("account_id")
+	var args []interface{}
+	args = append(args, scope.args(userID)...)
+	args = append(args, scope.args(userID)...)
+	args = append(args, scope.args(userID, types.Dividend)...)
+	args = append(args, scope.args(userID, types.Fee)...)
+
+	rows, err := db.Query(`
+		SELECT ticker, SUM(stock_pl), SUM(option_pl), SUM(dividends), SUM(fees)
+		FROM (
+			SELECT ticker, profit_loss AS stock_pl, 0 AS option_pl, 0 AS dividends, 0 AS fees
+			FROM closed_stocks WHERE user_id = ?`+filter+`
+			UNION ALL
+			SELECT ticker, 0, profit_loss, 0, 0
+			FROM closed_options WHERE user_id = ?`+filter+`
+			UNION ALL
+			SELECT ticker, 0, 0, amount, 0
+			FROM cash_flows WHERE user_id = ? AND type = ? AND ticker != ''`+filter+`
+			UNION ALL
+			SELECT ticker, 0, 0, 0, amount
+			FROM cash_flows WHERE user_id = ? AND type = ? AND ticker != ''`+filter+`
+		)
+		GROUP BY ticker
+		ORDER BY ticker
+	`, args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch returns", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var returns []components.TickerReturn
+	for rows.Next() {
+		var tr components.TickerReturn
+		if err := rows.Scan(&tr.Ticker, &tr.StockPL, &tr.OptionPL, &tr.Dividends, &tr.Fees); err != nil {
+			continue
+		}
+		tr.TotalReturn = tr.StockPL.Add(tr.OptionPL).Add(tr.Dividends).Add(tr.Fees)
+		returns = append(returns, tr)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.TickerReturnsTable(returns).Render(r.Context(), w)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"backend/types"
+	"fmt"
+)
+
+// Cash transactions are derived like positions: every trade, derived share
+// trade and cash flow posts its cash as it is applied, so replay rebuilds
+// them from the ledger. Kinds other than these are cash flow types.
+const (
+	cashStockTrade  = "stock trade"
+	cashOptionTrade = "option trade"
+)
+
+func postCash(q dbtx, userID, accountID int, date, kind, description string, amount types.Decimal, tradeID string, cashFlowID int) error {
+	if amount.IsZero() {
+		return nil
+	}
+	_, err := q.Exec(`
+		INSERT INTO cash_transactions (user_id, account_id, date, kind, description, amount, trade_id, cash_flow_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, accountID, NormalizeDateToISO(date), kind, description, amount, tradeID, cashFlowID)
+	return err
+}
+
+// tradeCash is the cash a trade moves: negative when it pays. Brokers that
+// report amounts unsigned, or not at all, get the sign from the trade code
+// and the amount from the price.
+func tradeCash(pays bool, amount, gross, fees types.Decimal) types.Decimal {
+	if amount.IsZero() {
+		if pays {
+			return gross.Add(fees).Neg()
+		}
+		return gross.Sub(fees)
+	}
+	if pays && amount.Sign() > 0 {
+		return amount.Neg()
+	}
+	return amount
+}
+
+func postStockTradeCash(q dbtx, userID int, trade types.StockTrade) error {
+	pays := trade.Code == types.Buy || trade.Code == types.BuyToCover
+	amount := tradeCash(pays, trade.Amount, trade.Price.Mul(trade.Quantity), trade.Fees)
+	description := fmt.Sprintf("%s %s x%.4g @ $%.2f", trade.Code, trade.Ticker, trade.Quantity, trade.Price)
+	return postCash(q, userID, trade.AccountID, trade.Date, cashStockTrade, description, amount, trade.ID, 0)
+}
+
+func postOptionTradeCash(q dbtx, userID int, trade types.OptionTrade) error {
+	pays := trade.Code == types.BTO || trade.Code == types.BTC
+	amount := tradeCash(pays, trade.Amount, trade.Price.Mul(trade.Quantity).Mul(trade.ContractMultiplier()), trade.Fees)
+	description := fmt.Sprintf("%s %s $%.2f %s %s x%.4g", trade.Code, trade.Ticker, trade.Strike, trade.OptionType, trade.ExpDate, trade.Quantity)
+	return postCash(q, userID, trade.AccountID, trade.Date, cashOptionTrade, description, amount, trade.ID, 0)
+}
+
+func postCashFlow(q dbtx, userID int, flow types.CashFlow) error {
+	description := flow.Description
+	if description == "" {
+		description = flow.Ticker
+	}
+	return postCash(q, userID, flow.AccountID, flow.Date, string(flow.Type), description, flow.Amount, "", flow.ID)
+}
+
+// cashBalance is the cash held in the scoped accounts.
+func cashBalance(q dbtx, userID int, scope accountScope) (types.Decimal, error) {
+	var balance types.Decimal
+	err := q.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM cash_transactions WHERE user_id = ?"+scope.filter("account_id"), scope.args(userID)...).Scan(&balance)
+	return balance, err
+}
+
+// BackfillCash builds the cash history of a user recorded before cash was
+// tracked. The ledger is replayed inside a savepoint that is rolled back, so
+// positions and history, including any edits, are left as they are and only
+// the cash the replay posted is kept.
+func BackfillCash(userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SAVEPOINT backfill_cash"); err != nil {
+		return err
+	}
+	for _, table := range derivedTables {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", table), userID); err != nil {
+			return err
+		}
+	}
+	entries, err := loadLedger(tx, userID)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := applyLedgerEntry(tx, userID, entry); err != nil {
+			return err
+		}
+	}
+
+	type cashRow struct {
+		accountID, cashFlowID   int
+		date, kind, description string
+		amount                  types.Decimal
+		tradeID                 string
+	}
+	rows, err := tx.Query(`
+		SELECT account_id, date, kind, description, amount, trade_id, cash_flow_id
+		FROM cash_transactions
+		WHERE user_id = ?
+		ORDER BY id
+	`, userID)
+	if err != nil {
+		return err
+	}
+	var posted []cashRow
+	for rows.Next() {
+		var c cashRow
+		if err := rows.Scan(&c.accountID, &c.date, &c.kind, &c.description, &c.amount, &c.tradeID, &c.cashFlowID); err != nil {
+			rows.Close()
+			return err
+		}
+		posted = append(posted, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("ROLLBACK TO backfill_cash"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM cash_transactions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, c := range posted {
+		if err := postCash(tx, userID, c.accountID, c.date, c.kind, c.description, c.amount, c.tradeID, c.cashFlowID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
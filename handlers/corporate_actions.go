@@ -0,0 +1,582 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/utils"
+	"backend/views/components"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// corporateActions loads the user's corporate actions by effective date.
+// With appliedOnly set only the actions applied to the user's data are
+// returned.
+func corporateActions(q dbtx, userID int, appliedOnly bool) ([]types.CorporateAction, error) {
+	query := `
+		SELECT id, ticker, type, effective_date, old_shares, new_shares, new_ticker, cash_per_share, applied
+		FROM corporate_actions
+		WHERE user_id = ?`
+	if appliedOnly {
+		query += ` AND applied = 1`
+	}
+	query += ` ORDER BY effective_date DESC, id DESC`
+
+	rows, err := q.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []types.CorporateAction
+	for rows.Next() {
+		var a types.CorporateAction
+		if err := rows.Scan(&a.ID, &a.Ticker, &a.Type, &a.EffectiveDate, &a.OldShares, &a.NewShares, &a.NewTicker, &a.CashPerShare, &a.Applied); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+func recordCorporateAction(q dbtx, userID int, action types.CorporateAction) error {
+	_, err := q.Exec(`
+		INSERT OR IGNORE INTO corporate_actions (user_id, ticker, type, effective_date, old_shares, new_shares, new_ticker, cash_per_share)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, strings.ToUpper(action.Ticker), action.Type, NormalizeDateToISO(action.EffectiveDate),
+		action.OldShares, action.NewShares, strings.ToUpper(action.NewTicker), action.CashPerShare)
+	return err
+}
+
+// auditValue formats an adjusted value for the audit trail.
+func auditValue(value types.Decimal) string {
+	return value.String()
+}
+
+// auditAdjustment records the value a corporate action replaced.
+func auditAdjustment(q dbtx, userID int, action types.CorporateAction, record string, recordID int, ticker, field, oldValue, newValue string) error {
+	_, err := q.Exec(`
+		INSERT INTO corporate_action_adjustments (user_id, action_id, record, record_id, ticker, field, old_value, new_value)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, action.ID, record, recordID, ticker, field, oldValue, newValue)
+	return err
+}
+
+// actionOptions loads the open option positions in a ticker.
+func actionOptions(q dbtx, userID int, ticker string) ([]types.OptionPos, error) {
+	rows, err := q.Query(`
+		SELECT id, ticker, price, premium, strike, exp_date, type, quantity, multiplier, open_trade_id, account_id
+		FROM option_positions
+		WHERE user_id = ? AND ticker = ? AND quantity > 0
+		ORDER BY id
+	`, userID, ticker)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []types.OptionPos
+	for rows.Next() {
+		var pos types.OptionPos
+		if err := rows.Scan(&pos.ID, &pos.Ticker, &pos.Price, &pos.Premium, &pos.Strike, &pos.ExpDate, &pos.Type, &pos.Quantity, &pos.Multiplier, &pos.OpenTradeID, &pos.AccountID); err != nil {
+			return nil, err
+		}
+		positions = append(positions, pos)
+	}
+	return positions, rows.Err()
+}
+
+// actionLots loads the open long and short lots in a ticker across every
+// account.
+func actionLots(q dbtx, userID int, ticker string) ([]types.StockLot, error) {
+	rows, err := q.Query(`
+		SELECT id, ticker, side, open_date, quantity, cost_basis, open_trade_id, wash_adjustment, wash_quantity, campaign_id, premium_adjustment, fees, account_id
+		FROM stock_lots
+		WHERE user_id = ? AND ticker = ? AND quantity > 0
+		ORDER BY account_id, side, open_date ASC, id ASC
+	`, userID, ticker)
+	if err != nil {
+		return nil, err
+	}
+	return scanStockLots(rows)
+}
+
+// applyCorporateAction adjusts the user's open lots and option positions in
+// the action's ticker, in every account. Closed history is left as it was
+// traded.
+func applyCorporateAction(q dbtx, userID int, action types.CorporateAction) error {
+	switch action.Type {
+	case types.Split:
+		return applySplit(q, userID, action)
+	case types.TickerChange:
+		return applyTickerChange(q, userID, action)
+	case types.CashMerger:
+		return applyCashMerger(q, userID, action)
+	}
+	return nil
+}
+
+// applySplit multiplies share quantities by the split ratio and divides
+// their basis by it. Options follow the usual contract adjustment: a whole
+// ratio multiplies the contracts, any other ratio changes the shares each
+// contract delivers. Either way strikes and premiums are divided by the
+// ratio so the value of the position is unchanged.
+func applySplit(q dbtx, userID int, action types.CorporateAction) error {
+	newShares, oldShares := action.NewShares, action.OldShares
+	if newShares.Sign() <= 0 || oldShares.Sign() <= 0 || newShares == oldShares {
+		return nil
+	}
+
+	lots, err := actionLots(q, userID, action.Ticker)
+	if err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		quantity, costBasis := lot.Quantity.MulDiv(newShares, oldShares), lot.CostBasis.MulDiv(oldShares, newShares)
+		_, err := q.Exec(`
+			UPDATE stock_lots SET quantity = ?, cost_basis = ?, wash_quantity = ?
+			WHERE id = ?
+		`, quantity, costBasis, lot.WashQuantity.MulDiv(newShares, oldShares), lot.ID)
+		if err == nil {
+			err = auditAdjustment(q, userID, action, "stock lot", lot.ID, lot.Ticker, "quantity", auditValue(lot.Quantity), auditValue(quantity))
+		}
+		if err == nil {
+			err = auditAdjustment(q, userID, action, "stock lot", lot.ID, lot.Ticker, "cost basis", auditValue(lot.CostBasis), auditValue(costBasis))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(lots) > 0 {
+		if err := syncTickerPositions(q, userID, action.Ticker); err != nil {
+			return err
+		}
+	}
+
+	positions, err := actionOptions(q, userID, action.Ticker)
+	if err != nil {
+		return err
+	}
+	for _, pos := range positions {
+		quantity, multiplier := pos.Quantity, pos.Multiplier
+		if newShares.Micros()%oldShares.Micros() == 0 {
+			quantity = quantity.MulDiv(newShares, oldShares)
+		} else {
+			multiplier = multiplier.MulDiv(newShares, oldShares)
+		}
+		strike, premium := pos.Strike.MulDiv(oldShares, newShares), pos.Premium.MulDiv(oldShares, newShares)
+
+		// The contracts only ever grow by a whole multiple, so the wash
+		// quantity scales exactly.
+		_, err := q.Exec(`
+			UPDATE option_positions
+			SET quantity = ?, multiplier = ?, strike = ?, premium = ?, price = ?, wash_quantity = wash_quantity * ? / ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, quantity, multiplier, strike, premium, pos.Price.MulDiv(oldShares, newShares), quantity.Micros(), pos.Quantity.Micros(), pos.ID)
+		if err != nil {
+			return err
+		}
+
+		changes := [][3]string{
+			{"strike", auditValue(pos.Strike), auditValue(strike)},
+			{"premium", auditValue(pos.Premium), auditValue(premium)},
+		}
+		if quantity != pos.Quantity {
+			changes = append(changes, [3]string{"contracts", auditValue(pos.Quantity), auditValue(quantity)})
+		}
+		if multiplier != pos.Multiplier {
+			changes = append(changes, [3]string{"multiplier", auditValue(pos.Multiplier), auditValue(multiplier)})
+		}
+		for _, change := range changes {
+			if err := auditAdjustment(q, userID, action, "option position", pos.ID, pos.Ticker, change[0], change[1], change[2]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyTickerChange moves open lots, option positions and the strategies,
+// campaigns and roll chains still running under the old ticker to the new
+// one.
+func applyTickerChange(q dbtx, userID int, action types.CorporateAction) error {
+	if action.NewTicker == "" || action.NewTicker == action.Ticker {
+		return nil
+	}
+
+	lots, err := actionLots(q, userID, action.Ticker)
+	if err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		_, err := q.Exec("UPDATE stock_lots SET ticker = ? WHERE id = ?", action.NewTicker, lot.ID)
+		if err == nil {
+			err = auditAdjustment(q, userID, action, "stock lot", lot.ID, action.NewTicker, "ticker", action.Ticker, action.NewTicker)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(lots) > 0 {
+		if err := syncTickerPositions(q, userID, action.Ticker); err != nil {
+			return err
+		}
+		if err := syncTickerPositions(q, userID, action.NewTicker); err != nil {
+			return err
+		}
+	}
+
+	positions, err := actionOptions(q, userID, action.Ticker)
+	if err != nil {
+		return err
+	}
+	for _, pos := range positions {
+		_, err := q.Exec("UPDATE option_positions SET ticker = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", action.NewTicker, pos.ID)
+		if err == nil {
+			err = auditAdjustment(q, userID, action, "option position", pos.ID, action.NewTicker, "ticker", action.Ticker, action.NewTicker)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, query := range []string{
+		"UPDATE option_strategies SET ticker = ? WHERE user_id = ? AND ticker = ?",
+		"UPDATE wheel_campaigns SET ticker = ? WHERE user_id = ? AND ticker = ? AND end_date = ''",
+		"UPDATE option_rolls SET ticker = ? WHERE user_id = ? AND ticker = ?",
+	} {
+		if _, err := q.Exec(query, action.NewTicker, userID, action.Ticker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyCashMerger closes every open lot at the cash paid per share and
+// every open option at its intrinsic value against that price. The closing
+// trades are applied like any other but not recorded, since they belong to
+// the action's own ledger entry and replaying it makes them again.
+func applyCashMerger(q dbtx, userID int, action types.CorporateAction) error {
+	tradeID := fmt.Sprintf("action-%d", action.ID)
+
+	lots, err := actionLots(q, userID, action.Ticker)
+	if err != nil {
+		return err
+	}
+	// Each account is cashed out with its own trades.
+	var trades []types.StockTrade
+	for _, lot := range lots {
+		code := types.Sell
+		if lot.Side == types.Short {
+			code = types.BuyToCover
+		}
+		n := len(trades)
+		if n == 0 || trades[n-1].AccountID != lot.AccountID || trades[n-1].Code != code {
+			trades = append(trades, types.StockTrade{ID: tradeID, Ticker: action.Ticker, Date: action.EffectiveDate, Code: code, Price: action.CashPerShare, AccountID: lot.AccountID})
+			n++
+		}
+		trades[n-1].Quantity = trades[n-1].Quantity.Add(lot.Quantity)
+
+		err := auditAdjustment(q, userID, action, "stock lot", lot.ID, lot.Ticker, "cashed out",
+			auditValue(lot.Quantity)+" shares", fmt.Sprintf("$%.2f per share", action.CashPerShare))
+		if err != nil {
+			return err
+		}
+	}
+	for _, trade := range trades {
+		trade.Amount = trade.Quantity.Mul(action.CashPerShare)
+		if trade.Code == types.BuyToCover {
+			trade.Amount = trade.Amount.Neg()
+		}
+		if _, err := applyLedgerEntry(q, userID, ledgerEntry{Stock: &trade}); err != nil {
+			return err
+		}
+	}
+
+	positions, err := actionOptions(q, userID, action.Ticker)
+	if err != nil {
+		return err
+	}
+	for _, pos := range positions {
+		call := pos.Type == types.Call || pos.Type == types.CC
+		intrinsic := types.MaxDecimal(pos.Strike.Sub(action.CashPerShare), types.Decimal{})
+		if call {
+			intrinsic = types.MaxDecimal(action.CashPerShare.Sub(pos.Strike), types.Decimal{})
+		}
+
+		contract := optionContract{Ticker: pos.Ticker, Strike: pos.Strike, ExpDate: pos.ExpDate, PositionType: pos.Type, Multiplier: pos.Multiplier}
+		closeTrade, _ := outcomeTrades(contract, OutcomeClosed, pos.Quantity, intrinsic, types.Decimal{}, action.EffectiveDate)
+		closeTrade.ID = tradeID
+		closeTrade.PositionTradeID = pos.OpenTradeID
+		closeTrade.AccountID = pos.AccountID
+
+		_, err := applyLedgerEntry(q, userID, ledgerEntry{Option: &closeTrade})
+		if err == nil {
+			err = auditAdjustment(q, userID, action, "option position", pos.ID, pos.Ticker, "cashed out",
+				auditValue(pos.Quantity)+" contracts", fmt.Sprintf("$%.2f per share", intrinsic))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// corporateActionAdjustments lists the values an applied action changed.
+func corporateActionAdjustments(q dbtx, userID, actionID int) ([]types.CorporateAdjustment, error) {
+	rows, err := q.Query(`
+		SELECT record, ticker, field, old_value, new_value
+		FROM corporate_action_adjustments
+		WHERE user_id = ? AND action_id = ?
+		ORDER BY id
+	`, userID, actionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var adjustments []types.CorporateAdjustment
+	for rows.Next() {
+		var a types.CorporateAdjustment
+		if err := rows.Scan(&a.Record, &a.Ticker, &a.Field, &a.OldValue, &a.NewValue); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+	return adjustments, rows.Err()
+}
+
+func HandleCorporateActions(w http.ResponseWriter, r *http.Request) {
+	components.AppLayout("Corporate Actions - DATATRADER", "actions", components.CorporateActionsPage()).Render(r.Context(), w)
+}
+
+func HandleModalAddCorporateAction(w http.ResponseWriter, r *http.Request) {
+	components.AddCorporateActionModal(time.Now().Format("2006-01-02")).Render(r.Context(), w)
+}
+
+func HandleModalImportCorporateActions(w http.ResponseWriter, r *http.Request) {
+	components.ImportCorporateActionsModal().Render(r.Context(), w)
+}
+
+func HandleGetCorporateActions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	actions, err := corporateActions(db, userID, false)
+	if err != nil {
+		http.Error(w, "Failed to fetch corporate actions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.CorporateActionsTable(actions, FormatDate).Render(r.Context(), w)
+}
+
+func HandleAddCorporateAction(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	action := types.CorporateAction{
+		Ticker:        strings.TrimSpace(r.FormValue("ticker")),
+		Type:          types.CorporateActionType(r.FormValue("type")),
+		EffectiveDate: r.FormValue("effectiveDate"),
+		OldShares:     types.DecimalFromInt(1),
+		NewShares:     types.DecimalFromInt(1),
+		NewTicker:     strings.TrimSpace(r.FormValue("newTicker")),
+	}
+	if action.Ticker == "" || action.EffectiveDate == "" {
+		http.Error(w, "Ticker and effective date are required", http.StatusBadRequest)
+		return
+	}
+
+	switch action.Type {
+	case types.Split:
+		newShares, oldShares, err := utils.ParseSplitRatio(r.FormValue("ratio"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		action.NewShares, action.OldShares = newShares, oldShares
+	case types.TickerChange:
+		if action.NewTicker == "" {
+			http.Error(w, "A ticker change needs the new ticker", http.StatusBadRequest)
+			return
+		}
+	case types.CashMerger:
+		action.CashPerShare, _ = types.ParseDecimal(r.FormValue("cashPerShare"))
+		if action.CashPerShare.Sign() <= 0 {
+			http.Error(w, "A cash merger needs the cash paid per share", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Invalid corporate action type", http.StatusBadRequest)
+		return
+	}
+
+	if err := recordCorporateAction(db, userID, action); err != nil {
+		http.Error(w, "Failed to add corporate action: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "corporateActionsUpdated")
+	components.ModalClose().Render(r.Context(), w)
+}
+
+func HandleImportCorporateActions(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	file, _, err := r.FormFile("csvFile")
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read file content", http.StatusInternalServerError)
+		return
+	}
+
+	actions, skipped, err := utils.ParseCorporateActionsCSV(string(content))
+	if err != nil {
+		renderImportError(w, err.Error())
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, action := range actions {
+		if err := recordCorporateAction(tx, userID, action); err != nil {
+			http.Error(w, "Failed to import corporate actions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to import corporate actions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "corporateActionsUpdated")
+	w.Header().Set("Content-Type", "text/html")
+	components.CorporateActionsImported(len(actions), skipped).Render(r.Context(), w)
+}
+
+// setCorporateActionApplied marks an action applied or not and rebuilds
+// the user's positions and history from the ledger in the same
+// transaction, so the action takes effect at its date. Like any rebuild it
+// is previewed before it is saved.
+func setCorporateActionApplied(w http.ResponseWriter, r *http.Request, applied bool) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	actionID := chi.URLParam(r, "id")
+	title, verb := "Apply Corporate Action", "apply"
+	if !applied {
+		title, verb = "Revert Corporate Action", "revert"
+	}
+
+	rebuildWith(w, r, userID, title, fmt.Sprintf("/api/corporate-actions/%s/%s", actionID, verb), "corporateActionsUpdated, positionAdded, historyUpdated", func(q dbtx) error {
+		result, err := q.Exec("UPDATE corporate_actions SET applied = ? WHERE id = ? AND user_id = ? AND applied = ?", applied, actionID, userID, !applied)
+		if err != nil {
+			return err
+		}
+		updated, err := result.RowsAffected()
+		if err == nil && updated == 0 {
+			err = sql.ErrNoRows
+		}
+		return err
+	})
+}
+
+func HandleApplyCorporateAction(w http.ResponseWriter, r *http.Request) {
+	setCorporateActionApplied(w, r, true)
+}
+
+func HandleRevertCorporateAction(w http.ResponseWriter, r *http.Request) {
+	setCorporateActionApplied(w, r, false)
+}
+
+func HandleCorporateActionAdjustments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	actionID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	adjustments, err := corporateActionAdjustments(db, userID, actionID)
+	if err != nil {
+		http.Error(w, "Failed to fetch adjustments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.CorporateAdjustmentsModal(adjustments).Render(r.Context(), w)
+}
+
+func HandleDeleteCorporateAction(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	actionID := chi.URLParam(r, "id")
+	result, err := db.Exec("DELETE FROM corporate_actions WHERE id = ? AND user_id = ? AND applied = 0", actionID, userID)
+	if err != nil {
+		http.Error(w, "Failed to delete corporate action", http.StatusInternalServerError)
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		var applied bool
+		err := db.QueryRow("SELECT applied FROM corporate_actions WHERE id = ? AND user_id = ?", actionID, userID).Scan(&applied)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Corporate action not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete corporate action", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Revert the corporate action before deleting it", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "corporateActionsUpdated")
+	HandleGetCorporateActions(w, r)
+}
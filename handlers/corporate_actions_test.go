@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"backend/types"
+	"strings"
+	"testing"
+)
+
+// holdCorporateActionTicker buys 10 F shares at $100 and sells 2 F puts at
+// the $90 strike for $2.
+func holdCorporateActionTicker(t *testing.T, userID int) {
+	t.Helper()
+	applyStock(t, userID, types.StockTrade{
+		Ticker:   "F",
+		Date:     "2025-01-02",
+		Code:     types.Buy,
+		Price:    decimal(t, "100"),
+		Quantity: types.DecimalFromInt(10),
+	})
+	applyOption(t, userID, openingOptionTrade("F", "2025-01-02", types.CSP, decimal(t, "90"), decimal(t, "2"), types.DecimalFromInt(2), types.StandardMultiplier, "2025-06-20"))
+}
+
+func TestApplySplit(t *testing.T) {
+	tests := []struct {
+		name                 string
+		newShares, oldShares string
+		// The lot as quantity @ basis and the option as contracts x
+		// multiplier @ strike for premium.
+		lot, option string
+	}{
+		{"forward 2 for 1", "2", "1", "20 @ 50", "4 x 100 @ 45 for 1"},
+		{"reverse 1 for 2", "1", "2", "5 @ 200", "2 x 50 @ 180 for 4"},
+		{"uneven 3 for 2", "3", "2", "15 @ 66.666667", "2 x 150 @ 60 for 1.333333"},
+		{"no change", "1", "1", "10 @ 100", "2 x 100 @ 90 for 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDB(t)
+			userID, _ := testUser(t, "split")
+			holdCorporateActionTicker(t, userID)
+
+			action := types.CorporateAction{Ticker: "F", Type: types.Split, EffectiveDate: "2025-03-03", NewShares: decimal(t, tt.newShares), OldShares: decimal(t, tt.oldShares)}
+			if err := applyCorporateAction(db, userID, action); err != nil {
+				t.Fatal(err)
+			}
+
+			var quantity, costBasis, position types.Decimal
+			if err := db.QueryRow("SELECT quantity, cost_basis FROM stock_lots WHERE user_id = ?", userID).Scan(&quantity, &costBasis); err != nil {
+				t.Fatal(err)
+			}
+			if got := quantity.String() + " @ " + costBasis.String(); got != tt.lot {
+				t.Errorf("lot = %s, want %s", got, tt.lot)
+			}
+			if err := db.QueryRow("SELECT quantity FROM stock_positions WHERE user_id = ?", userID).Scan(&position); err != nil {
+				t.Fatal(err)
+			}
+			if position != quantity {
+				t.Errorf("position holds %s shares, lots %s", position, quantity)
+			}
+
+			var contracts, multiplier, strike, premium types.Decimal
+			if err := db.QueryRow("SELECT quantity, multiplier, strike, premium FROM option_positions WHERE user_id = ?", userID).Scan(&contracts, &multiplier, &strike, &premium); err != nil {
+				t.Fatal(err)
+			}
+			if got := contracts.String() + " x " + multiplier.String() + " @ " + strike.String() + " for " + premium.String(); got != tt.option {
+				t.Errorf("option = %s, want %s", got, tt.option)
+			}
+		})
+	}
+}
+
+// A ticker change moves the open lots, options and roll chains to the new
+// ticker and leaves nothing behind under the old one.
+func TestApplyTickerChange(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "renamed")
+	holdCorporateActionTicker(t, userID)
+	if _, err := db.Exec("INSERT INTO option_rolls (user_id, ticker, start_date) VALUES (?, 'F', '2025-01-02')", userID); err != nil {
+		t.Fatal(err)
+	}
+
+	action := types.CorporateAction{Ticker: "F", Type: types.TickerChange, EffectiveDate: "2025-03-03", NewTicker: "FM"}
+	if err := applyCorporateAction(db, userID, action); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"stock_lots", "stock_positions", "option_positions", "option_rolls"} {
+		var old, moved int
+		if err := db.QueryRow("SELECT COUNT(CASE WHEN ticker = 'F' THEN 1 END), COUNT(CASE WHEN ticker = 'FM' THEN 1 END) FROM "+table+" WHERE user_id = ?", userID).Scan(&old, &moved); err != nil {
+			t.Fatal(err)
+		}
+		if old != 0 || moved != 1 {
+			t.Errorf("%s: %d rows under F and %d under FM, want 0 and 1", table, old, moved)
+		}
+	}
+}
+
+// A cash merger closes the shares at the cash price and the puts at their
+// intrinsic value against it.
+func TestApplyCashMerger(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "merged")
+	holdCorporateActionTicker(t, userID)
+
+	action := types.CorporateAction{Ticker: "F", Type: types.CashMerger, EffectiveDate: "2025-03-03", CashPerShare: decimal(t, "80")}
+	if err := applyCorporateAction(db, userID, action); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		// (2 - 10) x 2 x 100
+		"CSP F 2025-03-03 x2 -1600.00",
+		// (80 - 100) x 10
+		"stock F 2025-03-03 x10 -200.00",
+	}
+	if got := closedRows(t, userID); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("closed rows:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var open int
+	if err := db.QueryRow("SELECT (SELECT COUNT(*) FROM stock_lots WHERE user_id = ? AND quantity > 0) + (SELECT COUNT(*) FROM option_positions WHERE user_id = ? AND quantity > 0)", userID, userID).Scan(&open); err != nil {
+		t.Fatal(err)
+	}
+	if open != 0 {
+		t.Errorf("%d positions still open after the merger", open)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"backend/types"
+	"testing"
+)
+
+func TestTradeFees(t *testing.T) {
+	tests := []struct {
+		fees, quantity, total string
+		want                  string
+	}{
+		{"0.10", "5", "10", "0.05"},
+		{"0.10", "10", "10", "0.1"},
+		// A close never charges more than the trade paid.
+		{"0.10", "15", "10", "0.1"},
+		{"0.07", "1", "3", "0.023333"},
+		{"0", "3", "10", "0"},
+	}
+	for _, tt := range tests {
+		got := tradeFees(decimal(t, tt.fees), decimal(t, tt.quantity), decimal(t, tt.total))
+		if got.String() != tt.want {
+			t.Errorf("tradeFees(%s, %s, %s) = %s, want %s", tt.fees, tt.quantity, tt.total, got, tt.want)
+		}
+	}
+}
+
+// A partial sale carries its share of the fees paid to buy the lot and all
+// of its own; the lot keeps the rest of its buying fees.
+func TestStockCloseFees(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "fees")
+
+	applyStock(t, userID, types.StockTrade{Ticker: "F", Date: "2025-01-02", Code: types.Buy, Price: decimal(t, "10"), Quantity: types.DecimalFromInt(10), Fees: decimal(t, "1")})
+	applyStock(t, userID, types.StockTrade{Ticker: "F", Date: "2025-02-03", Code: types.Sell, Price: decimal(t, "12"), Quantity: types.DecimalFromInt(4), Fees: decimal(t, "0.3")})
+
+	var closedFees, profitLoss, lotFees types.Decimal
+	if err := db.QueryRow("SELECT fees, profit_loss FROM closed_stocks WHERE user_id = ?", userID).Scan(&closedFees, &profitLoss); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT fees FROM stock_lots WHERE user_id = ?", userID).Scan(&lotFees); err != nil {
+		t.Fatal(err)
+	}
+	// 4 x $2 less $0.40 of the buy's fees and the sale's $0.30.
+	if closedFees.String() != "0.7" || profitLoss.String() != "7.3" || lotFees.String() != "0.6" {
+		t.Errorf("closed fees %s and P/L %s, lot keeps %s; want 0.7, 7.3 and 0.6", closedFees, profitLoss, lotFees)
+	}
+}
+
+// Buying back contracts opened in two trades splits the closing fees
+// between the positions by contract, and the parts add up to what was paid.
+func TestOptionCloseSplitsFees(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "fees")
+
+	for _, open := range []struct {
+		date, fees string
+		quantity   int64
+	}{{"2025-01-02", "0.65", 1}, {"2025-01-03", "1.30", 2}} {
+		trade := openingOptionTrade("F", open.date, types.CSP, decimal(t, "10"), decimal(t, "0.5"), types.DecimalFromInt(open.quantity), types.StandardMultiplier, "2025-02-21")
+		trade.Fees = decimal(t, open.fees)
+		applyOption(t, userID, trade)
+	}
+	contract := optionContract{Ticker: "F", Strike: decimal(t, "10"), ExpDate: "2025-02-21", PositionType: types.CSP, Multiplier: types.StandardMultiplier}
+	closeTrade, _ := outcomeTrades(contract, OutcomeClosed, types.DecimalFromInt(3), decimal(t, "0.2"), types.Decimal{}, "2025-01-10")
+	closeTrade.Fees = decimal(t, "0.10")
+	applyOption(t, userID, closeTrade)
+
+	rows, err := db.Query("SELECT fees, profit_loss FROM closed_options WHERE user_id = ? ORDER BY purchase_date", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var fees []string
+	var totalFees, totalPL types.Decimal
+	for rows.Next() {
+		var f, profitLoss types.Decimal
+		if err := rows.Scan(&f, &profitLoss); err != nil {
+			t.Fatal(err)
+		}
+		fees = append(fees, f.String())
+		totalFees, totalPL = totalFees.Add(f), totalPL.Add(profitLoss)
+	}
+
+	// $0.65 + 1/3 of $0.10, and $1.30 + the other 2/3.
+	if len(fees) != 2 || fees[0] != "0.683333" || fees[1] != "1.366667" {
+		t.Errorf("closed fees = %v, want [0.683333 1.366667]", fees)
+	}
+	// 3 x ($0.50 - $0.20) x 100 less $2.05 of fees.
+	if totalFees.String() != "2.05" || totalPL.String() != "87.95" {
+		t.Errorf("fees total %s and P/L %s, want 2.05 and 87.95", totalFees, totalPL)
+	}
+}
@@ -1,11 +1,12 @@
 package handlers
 
 import (
+	"backend/pnl"
 	"backend/types"
 	"backend/views/components"
+	"database/sql"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
@@ -22,12 +23,13 @@ func HandleGetClosedStocks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scope := requestAccountScope(r, userID)
 	search := strings.ToUpper(r.URL.Query().Get("search"))
 	dateFromInput := r.URL.Query().Get("dateFrom")
 	dateToInput := r.URL.Query().Get("dateTo")
 
-	query := `SELECT id, ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss FROM closed_stocks WHERE user_id = ?`
-	args := []interface{}{userID}
+	query := `SELECT id, ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss, wash_disallowed, wash_adjustment, side, premium_adjustment, fees, account_id FROM closed_stocks WHERE user_id = ?` + scope.filter("account_id")
+	args := scope.args(userID)
 
 	if search != "" {
 		query += ` AND ticker LIKE ?`
@@ -47,16 +49,18 @@ func HandleGetClosedStocks(w http.ResponseWriter, r *http.Request) {
 	var closedStocks []types.ClosedStock
 	for rows.Next() {
 		var cs types.ClosedStock
-		if err := rows.Scan(&cs.ID, &cs.Ticker, &cs.OpenDate, &cs.CloseDate, &cs.Quantity, &cs.CostBasis, &cs.SellPrice, &cs.ProfitLoss); err != nil {
+		var accountID int
+		if err := rows.Scan(&cs.ID, &cs.Ticker, &cs.OpenDate, &cs.CloseDate, &cs.Quantity, &cs.CostBasis, &cs.SellPrice, &cs.ProfitLoss, &cs.WashDisallowed, &cs.WashAdjustment, &cs.Side, &cs.PremiumAdjustment, &cs.Fees, &accountID); err != nil {
 			continue
 		}
+		cs.Account = scope.label(accountID)
 		if IsDateInRange(cs.CloseDate, dateFromInput, dateToInput) {
 			closedStocks = append(closedStocks, cs)
 		}
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	components.ClosedStocksTable(closedStocks, FormatDate).Render(r.Context(), w)
+	components.ClosedStocksTable(closedStocks, userAdjustedBasis(db, userID), FormatDate).Render(r.Context(), w)
 }
 
 func HandleHistoryFilter(w http.ResponseWriter, r *http.Request) {
@@ -66,6 +70,7 @@ func HandleHistoryFilter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scope := requestAccountScope(r, userID)
 	search := strings.ToUpper(r.URL.Query().Get("search"))
 	optionType := r.URL.Query().Get("type")
 	dateFromInput := r.URL.Query().Get("dateFrom")
@@ -74,8 +79,8 @@ func HandleHistoryFilter(w http.ResponseWriter, r *http.Request) {
 	var closedStocks []types.ClosedStock
 
 	if optionType == "" {
-		stockQuery := `SELECT id, ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss FROM closed_stocks WHERE user_id = ?`
-		stockArgs := []interface{}{userID}
+		stockQuery := `SELECT id, ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss, wash_disallowed, wash_adjustment, side, premium_adjustment, fees, account_id FROM closed_stocks WHERE user_id = ?` + scope.filter("account_id")
+		stockArgs := scope.args(userID)
 
 		if search != "" {
 			stockQuery += ` AND ticker LIKE ?`
@@ -92,17 +97,19 @@ func HandleHistoryFilter(w http.ResponseWriter, r *http.Request) {
 
 		for stockRows.Next() {
 			var cs types.ClosedStock
-			if err := stockRows.Scan(&cs.ID, &cs.Ticker, &cs.OpenDate, &cs.CloseDate, &cs.Quantity, &cs.CostBasis, &cs.SellPrice, &cs.ProfitLoss); err != nil {
+			var accountID int
+			if err := stockRows.Scan(&cs.ID, &cs.Ticker, &cs.OpenDate, &cs.CloseDate, &cs.Quantity, &cs.CostBasis, &cs.SellPrice, &cs.ProfitLoss, &cs.WashDisallowed, &cs.WashAdjustment, &cs.Side, &cs.PremiumAdjustment, &cs.Fees, &accountID); err != nil {
 				continue
 			}
+			cs.Account = scope.label(accountID)
 			if IsDateInRange(cs.CloseDate, dateFromInput, dateToInput) {
 				closedStocks = append(closedStocks, cs)
 			}
 		}
 	}
 
-	optionQuery := `SELECT id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, close_date, sell_price, profit_loss FROM closed_options WHERE user_id = ?`
-	optionArgs := []interface{}{userID}
+	optionQuery := `SELECT id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, close_date, sell_price, profit_loss, wash_disallowed, wash_adjustment, premium_to_basis, fees, multiplier, account_id FROM closed_options WHERE user_id = ?` + scope.filter("account_id")
+	optionArgs := scope.args(userID)
 
 	if search != "" {
 		optionQuery += ` AND ticker LIKE ?`
@@ -124,16 +131,18 @@ func HandleHistoryFilter(w http.ResponseWriter, r *http.Request) {
 	var closedOptions []types.ClosedOption
 	for optionRows.Next() {
 		var co types.ClosedOption
-		if err := optionRows.Scan(&co.ID, &co.Ticker, &co.Price, &co.Premium, &co.Strike, &co.ExpDate, &co.Type, &co.Collateral, &co.Quantity, &co.PurchaseDate, &co.CloseDate, &co.SellPrice, &co.ProfitLoss); err != nil {
+		var accountID int
+		if err := optionRows.Scan(&co.ID, &co.Ticker, &co.Price, &co.Premium, &co.Strike, &co.ExpDate, &co.Type, &co.Collateral, &co.Quantity, &co.PurchaseDate, &co.CloseDate, &co.SellPrice, &co.ProfitLoss, &co.WashDisallowed, &co.WashAdjustment, &co.PremiumToBasis, &co.Fees, &co.Multiplier, &accountID); err != nil {
 			continue
 		}
+		co.Account = scope.label(accountID)
 		if IsDateInRange(co.CloseDate, dateFromInput, dateToInput) {
 			closedOptions = append(closedOptions, co)
 		}
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	components.FilteredHistory(closedStocks, closedOptions, FormatDate).Render(r.Context(), w)
+	components.FilteredHistory(closedStocks, closedOptions, userAdjustedBasis(db, userID), FormatDate).Render(r.Context(), w)
 }
 
 func HandleEditClosedStock(w http.ResponseWriter, r *http.Request) {
@@ -146,13 +155,13 @@ func HandleEditClosedStock(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var ticker, openDate, closeDate string
-	var quantity, costBasis, sellPrice, profitLoss float64
+	var quantity, costBasis, sellPrice, profitLoss, fees types.Decimal
 
 	err := db.QueryRow(`
-		SELECT ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss
+		SELECT ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss, fees
 		FROM closed_stocks
 		WHERE id = ? AND user_id = ?
-	`, positionID, userID).Scan(&ticker, &openDate, &closeDate, &quantity, &costBasis, &sellPrice, &profitLoss)
+	`, positionID, userID).Scan(&ticker, &openDate, &closeDate, &quantity, &costBasis, &sellPrice, &profitLoss, &fees)
 
 	if err != nil {
 		http.Error(w, "Position not found", http.StatusNotFound)
@@ -182,6 +191,10 @@ func HandleEditClosedStock(w http.ResponseWriter, r *http.Request) {
 						<label>Sell Price</label>
 						<input type="number" name="sellPrice" step="0.01" value="%.2f" required />
 					</div>
+					<div class="form-group">
+						<label>Fees &amp; Commissions</label>
+						<input type="number" name="fees" step="0.01" min="0" value="%.2f" />
+					</div>
 					<div class="form-group">
 						<label>Open Date</label>
 						<input type="date" name="openDate" value="%s" required />
@@ -197,7 +210,7 @@ func HandleEditClosedStock(w http.ResponseWriter, r *http.Request) {
 				</form>
 			</div>
 		</div>
-	`, ticker, positionID, ticker, quantity, costBasis, sellPrice, openDate, closeDate)
+	`, ticker, positionID, ticker, quantity, costBasis, sellPrice, fees, openDate, closeDate)
 
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(modalHTML))
@@ -217,19 +230,25 @@ func HandleUpdateClosedStock(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ticker := r.FormValue("ticker")
-	quantity, _ := strconv.ParseFloat(r.FormValue("quantity"), 64)
-	costBasis, _ := strconv.ParseFloat(r.FormValue("costBasis"), 64)
-	sellPrice, _ := strconv.ParseFloat(r.FormValue("sellPrice"), 64)
+	quantity, _ := types.ParseDecimal(r.FormValue("quantity"))
+	costBasis, _ := types.ParseDecimal(r.FormValue("costBasis"))
+	sellPrice, _ := types.ParseDecimal(r.FormValue("sellPrice"))
+	fees := formFees(r)
 	openDate := r.FormValue("openDate")
 	closeDate := r.FormValue("closeDate")
 
-	profitLoss := (sellPrice - costBasis) * quantity
+	var side types.PositionSide
+	if err := db.QueryRow("SELECT side FROM closed_stocks WHERE id = ? AND user_id = ?", positionID, userID).Scan(&side); err != nil {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
+	}
+	profitLoss := pnl.StockSale(side, costBasis, sellPrice, quantity, fees)
 
 	_, err := db.Exec(`
 		UPDATE closed_stocks
-		SET ticker = ?, open_date = ?, close_date = ?, quantity = ?, cost_basis = ?, sell_price = ?, profit_loss = ?
+		SET ticker = ?, open_date = ?, close_date = ?, quantity = ?, cost_basis = ?, sell_price = ?, profit_loss = ?, fees = ?
 		WHERE id = ? AND user_id = ?
-	`, ticker, openDate, closeDate, quantity, costBasis, sellPrice, profitLoss, positionID, userID)
+	`, ticker, openDate, closeDate, quantity, costBasis, sellPrice, profitLoss, fees, positionID, userID)
 
 	if err != nil {
 		http.Error(w, "Failed to update position", http.StatusInternalServerError)
@@ -269,13 +288,13 @@ func HandleEditClosedOption(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var ticker, expDate, purchaseDate, closeDate, optionType string
-	var price, premium, strike, collateral, sellPrice, profitLoss float64
+	var price, premium, strike, collateral, sellPrice, profitLoss, fees, multiplier types.Decimal
 
 	err := db.QueryRow(`
-		SELECT ticker, price, premium, strike, exp_date, type, collateral, purchase_date, close_date, sell_price, profit_loss
+		SELECT ticker, price, premium, strike, exp_date, type, collateral, purchase_date, close_date, sell_price, profit_loss, fees, multiplier
 		FROM closed_options
 		WHERE id = ? AND user_id = ?
-	`, positionID, userID).Scan(&ticker, &price, &premium, &strike, &expDate, &optionType, &collateral, &purchaseDate, &closeDate, &sellPrice, &profitLoss)
+	`, positionID, userID).Scan(&ticker, &price, &premium, &strike, &expDate, &optionType, &collateral, &purchaseDate, &closeDate, &sellPrice, &profitLoss, &fees, &multiplier)
 
 	if err != nil {
 		http.Error(w, "Position not found", http.StatusNotFound)
@@ -296,10 +315,10 @@ func HandleEditClosedOption(w http.ResponseWriter, r *http.Request) {
 					<div class="form-group">
 						<label>Type</label>
 						<select name="optionType" required>
-							<option value="call" %s>Call</option>
-							<option value="put" %s>Put</option>
-							<option value="csp" %s>CSP</option>
-							<option value="cc" %s>CC</option>
+							<option value="Call" %s>Call</option>
+							<option value="Put" %s>Put</option>
+							<option value="CSP" %s>CSP</option>
+							<option value="CC" %s>CC</option>
 						</select>
 					</div>
 					<div class="form-group">
@@ -322,6 +341,14 @@ func HandleEditClosedOption(w http.ResponseWriter, r *http.Request) {
 						<label>Sell Price</label>
 						<input type="number" name="sellPrice" step="0.01" value="%.2f" required />
 					</div>
+					<div class="form-group">
+						<label>Fees &amp; Commissions</label>
+						<input type="number" name="fees" step="0.01" min="0" value="%.2f" />
+					</div>
+					<div class="form-group">
+						<label>Contract Multiplier</label>
+						<input type="number" name="multiplier" step="any" min="0" value="%s" required />
+					</div>
 					<div class="form-group">
 						<label>Expiration Date</label>
 						<input type="date" name="expDate" value="%s" required />
@@ -342,9 +369,9 @@ func HandleEditClosedOption(w http.ResponseWriter, r *http.Request) {
 			</div>
 		</div>
 	`, ticker, positionID, ticker,
-		selected(optionType, "call"), selected(optionType, "put"),
-		selected(optionType, "csp"), selected(optionType, "cc"),
-		strike, premium, price, collateral, sellPrice, expDate, purchaseDate, closeDate)
+		selected(optionType, string(types.Call)), selected(optionType, string(types.Put)),
+		selected(optionType, string(types.CSP)), selected(optionType, string(types.CC)),
+		strike, premium, price, collateral, sellPrice, fees, multiplier, expDate, purchaseDate, closeDate)
 
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(modalHTML))
@@ -365,29 +392,31 @@ func HandleUpdateClosedOption(w http.ResponseWriter, r *http.Request) {
 
 	ticker := r.FormValue("ticker")
 	optionType := r.FormValue("optionType")
-	strike, _ := strconv.ParseFloat(r.FormValue("strike"), 64)
-	premium, _ := strconv.ParseFloat(r.FormValue("premium"), 64)
-	price, _ := strconv.ParseFloat(r.FormValue("price"), 64)
-	collateral, _ := strconv.ParseFloat(r.FormValue("collateral"), 64)
-	sellPrice, _ := strconv.ParseFloat(r.FormValue("sellPrice"), 64)
+	strike, _ := types.ParseDecimal(r.FormValue("strike"))
+	premium, _ := types.ParseDecimal(r.FormValue("premium"))
+	price, _ := types.ParseDecimal(r.FormValue("price"))
+	collateral, _ := types.ParseDecimal(r.FormValue("collateral"))
+	sellPrice, _ := types.ParseDecimal(r.FormValue("sellPrice"))
+	fees := formFees(r)
+	multiplier := formMultiplier(r)
 	expDate := r.FormValue("expDate")
 	purchaseDate := r.FormValue("purchaseDate")
 	closeDate := r.FormValue("closeDate")
 
-	var profitLoss float64
-	switch optionType {
-	case "call", "put":
-		profitLoss = sellPrice - premium
-	case "csp", "cc":
-		profitLoss = premium - sellPrice
+	var quantity types.Decimal
+	err := db.QueryRow("SELECT quantity FROM closed_options WHERE id = ? AND user_id = ?", positionID, userID).Scan(&quantity)
+	if err != nil {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
 	}
+	profitLoss := pnl.OptionClose(types.OptionType(optionType), premium, sellPrice, quantity, multiplier, fees)
 
-	_, err := db.Exec(`
+	_, err = db.Exec(`
 		UPDATE closed_options
 		SET ticker = ?, type = ?, strike = ?, premium = ?, price = ?, collateral = ?, sell_price = ?,
-		    exp_date = ?, purchase_date = ?, close_date = ?, profit_loss = ?
+		    exp_date = ?, purchase_date = ?, close_date = ?, profit_loss = ?, fees = ?, multiplier = ?
 		WHERE id = ? AND user_id = ?
-	`, ticker, optionType, strike, premium, price, collateral, sellPrice, expDate, purchaseDate, closeDate, profitLoss, positionID, userID)
+	`, ticker, optionType, strike, premium, price, collateral, sellPrice, expDate, purchaseDate, closeDate, profitLoss, fees, multiplier, positionID, userID)
 
 	if err != nil {
 		http.Error(w, "Failed to update position", http.StatusInternalServerError)
@@ -424,13 +453,14 @@ func HandleGetClosedOptions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scope := requestAccountScope(r, userID)
 	search := strings.ToUpper(r.URL.Query().Get("search"))
 	optionType := r.URL.Query().Get("type")
 	dateFromInput := r.URL.Query().Get("dateFrom")
 	dateToInput := r.URL.Query().Get("dateTo")
 
-	query := `SELECT id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, close_date, sell_price, profit_loss FROM closed_options WHERE user_id = ?`
-	args := []interface{}{userID}
+	query := `SELECT id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, close_date, sell_price, profit_loss, wash_disallowed, wash_adjustment, premium_to_basis, fees, multiplier, account_id FROM closed_options WHERE user_id = ?` + scope.filter("account_id")
+	args := scope.args(userID)
 
 	if search != "" {
 		query += ` AND ticker LIKE ?`
@@ -454,14 +484,147 @@ func HandleGetClosedOptions(w http.ResponseWriter, r *http.Request) {
 	var closedOptions []types.ClosedOption
 	for rows.Next() {
 		var co types.ClosedOption
-		if err := rows.Scan(&co.ID, &co.Ticker, &co.Price, &co.Premium, &co.Strike, &co.ExpDate, &co.Type, &co.Collateral, &co.Quantity, &co.PurchaseDate, &co.CloseDate, &co.SellPrice, &co.ProfitLoss); err != nil {
+		var accountID int
+		if err := rows.Scan(&co.ID, &co.Ticker, &co.Price, &co.Premium, &co.Strike, &co.ExpDate, &co.Type, &co.Collateral, &co.Quantity, &co.PurchaseDate, &co.CloseDate, &co.SellPrice, &co.ProfitLoss, &co.WashDisallowed, &co.WashAdjustment, &co.PremiumToBasis, &co.Fees, &co.Multiplier, &accountID); err != nil {
 			continue
 		}
+		co.Account = scope.label(accountID)
 		if IsDateInRange(co.CloseDate, dateFromInput, dateToInput) {
 			closedOptions = append(closedOptions, co)
 		}
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	components.ClosedOptionsTable(closedOptions, FormatDate).Render(r.Context(), w)
+	components.ClosedOptionsTable(closedOptions, userAdjustedBasis(db, userID), FormatDate).Render(r.Context(), w)
+}
+
+func HandleClosedStockFills(w http.ResponseWriter, r *http.Request) {
+	positionID := chi.URLParam(r, "id")
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var ticker, openDate, closeDate, closeTradeID string
+	var lotTradeID sql.NullString
+	var accountID int
+	err := db.QueryRow(`
+		SELECT c.ticker, c.open_date, c.close_date, c.close_trade_id, l.open_trade_id, c.account_id
+		FROM closed_stocks c
+		LEFT JOIN stock_lots l ON l.id = c.lot_id
+		WHERE c.id = ? AND c.user_id = ?
+	`, positionID, userID).Scan(&ticker, &openDate, &closeDate, &closeTradeID, &lotTradeID, &accountID)
+	if err != nil {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
+	}
+
+	// A row closed from a lot shows the trade that opened the lot. Older
+	// rows have no lot and used an averaged cost basis, so they show every
+	// buy between the open and the sale.
+	stockQuery := `
+		SELECT id, ticker, date, code, price, amount, quantity, fees
+		FROM stock_trades
+		WHERE user_id = ? AND (id = ? OR (account_id = ? AND ticker = ? AND code = ? AND date >= ? AND date <= ?))
+		ORDER BY date ASC, seq ASC
+	`
+	stockArgs := []interface{}{userID, closeTradeID, accountID, ticker, types.Buy, NormalizeDateToISO(openDate), NormalizeDateToISO(closeDate)}
+	if lotTradeID.Valid {
+		stockQuery = `
+			SELECT id, ticker, date, code, price, amount, quantity, fees
+			FROM stock_trades
+			WHERE user_id = ? AND id IN (?, ?)
+			ORDER BY date ASC, seq ASC
+		`
+		stockArgs = []interface{}{userID, closeTradeID, lotTradeID.String}
+	}
+
+	rows, err := db.Query(stockQuery, stockArgs...)
+	if err != nil {
+		http.Error(w, "Failed to fetch fills", http.StatusInternalServerError)
+		return
+	}
+
+	stockTrades, err := scanStockTrades(rows)
+	if err != nil {
+		http.Error(w, "Failed to fetch fills", http.StatusInternalServerError)
+		return
+	}
+
+	// Assignments and exercises deliver shares without a stock trade of
+	// their own, so include those option events too.
+	optionQuery := `
+		SELECT id, ticker, date, code, price, amount, quantity, strike, exp_date, option_type, premium, multiplier, fees
+		FROM option_trades
+		WHERE user_id = ? AND (id = ? OR (account_id = ? AND ticker = ? AND code IN (?, ?) AND date >= ? AND date <= ?))
+		ORDER BY date ASC, seq ASC
+	`
+	optionArgs := []interface{}{userID, closeTradeID, accountID, ticker, types.OASGN, types.OEXCS, NormalizeDateToISO(openDate), NormalizeDateToISO(closeDate)}
+	if lotTradeID.Valid {
+		optionQuery = `
+			SELECT id, ticker, date, code, price, amount, quantity, strike, exp_date, option_type, premium, multiplier, fees
+			FROM option_trades
+			WHERE user_id = ? AND id IN (?, ?)
+			ORDER BY date ASC, seq ASC
+		`
+		optionArgs = []interface{}{userID, closeTradeID, lotTradeID.String}
+	}
+
+	rows, err = db.Query(optionQuery, optionArgs...)
+	if err != nil {
+		http.Error(w, "Failed to fetch fills", http.StatusInternalServerError)
+		return
+	}
+
+	optionTrades, err := scanOptionTrades(rows)
+	if err != nil {
+		http.Error(w, "Failed to fetch fills", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.TradeFillsModal("Fills: "+ticker, stockTrades, optionTrades, FormatDate).Render(r.Context(), w)
+}
+
+func HandleClosedOptionFills(w http.ResponseWriter, r *http.Request) {
+	positionID := chi.URLParam(r, "id")
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var ticker, openTradeID, closeTradeID string
+	err := db.QueryRow(`
+		SELECT ticker, open_trade_id, close_trade_id
+		FROM closed_options
+		WHERE id = ? AND user_id = ?
+	`, positionID, userID).Scan(&ticker, &openTradeID, &closeTradeID)
+	if err != nil {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, ticker, date, code, price, amount, quantity, strike, exp_date, option_type, premium, multiplier, fees
+		FROM option_trades
+		WHERE user_id = ? AND id IN (?, ?)
+		ORDER BY date ASC, seq ASC
+	`, userID, openTradeID, closeTradeID)
+	if err != nil {
+		http.Error(w, "Failed to fetch fills", http.StatusInternalServerError)
+		return
+	}
+
+	optionTrades, err := scanOptionTrades(rows)
+	if err != nil {
+		http.Error(w, "Failed to fetch fills", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.TradeFillsModal("Fills: "+ticker, nil, optionTrades, FormatDate).Render(r.Context(), w)
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"backend/types"
 	"backend/views/components"
 	"net/http"
 )
@@ -16,31 +17,43 @@ func HandleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Stats cover the account being viewed, or every account consolidated.
+	scope := requestAccountScope(r, userID)
+	filter := scope.filter("account_id")
+
 	var stockCount int
-	db.QueryRow("SELECT COUNT(*) FROM stock_positions WHERE user_id = ?", userID).Scan(&stockCount)
+	db.QueryRow("SELECT COUNT(*) FROM stock_positions WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&stockCount)
 
 	var optionCount int
-	db.QueryRow("SELECT COUNT(*) FROM option_positions WHERE user_id = ?", userID).Scan(&optionCount)
+	db.QueryRow("SELECT COUNT(*) FROM option_positions WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&optionCount)
 
 	var closedStockCount int
-	db.QueryRow("SELECT COUNT(*) FROM closed_stocks WHERE user_id = ?", userID).Scan(&closedStockCount)
+	db.QueryRow("SELECT COUNT(*) FROM closed_stocks WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&closedStockCount)
 
 	var closedOptionCount int
-	db.QueryRow("SELECT COUNT(*) FROM closed_options WHERE user_id = ?", userID).Scan(&closedOptionCount)
+	db.QueryRow("SELECT COUNT(*) FROM closed_options WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&closedOptionCount)
+
+	var totalStockPL, totalOptionPL types.Decimal
+	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_stocks WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&totalStockPL)
+	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_options WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&totalOptionPL)
+
+	totalPL := totalStockPL.Add(totalOptionPL)
 
-	var totalStockPL, totalOptionPL float64
-	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_stocks WHERE user_id = ?", userID).Scan(&totalStockPL)
-	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_options WHERE user_id = ?", userID).Scan(&totalOptionPL)
+	var stockWash, optionWash types.Decimal
+	db.QueryRow("SELECT COALESCE(SUM(wash_disallowed - wash_adjustment), 0) FROM closed_stocks WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&stockWash)
+	db.QueryRow("SELECT COALESCE(SUM(wash_disallowed - wash_adjustment), 0) FROM closed_options WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&optionWash)
 
-	totalPL := totalStockPL + totalOptionPL
+	var stockPremium, optionPremium types.Decimal
+	db.QueryRow("SELECT COALESCE(SUM(premium_adjustment), 0) FROM closed_stocks WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&stockPremium)
+	db.QueryRow("SELECT COALESCE(SUM(premium_to_basis), 0) FROM closed_options WHERE user_id = ?"+filter, scope.args(userID)...).Scan(&optionPremium)
 
 	var winningStocks, winningOptions int
-	db.QueryRow("SELECT COUNT(*) FROM closed_stocks WHERE user_id = ? AND profit_loss > 0", userID).Scan(&winningStocks)
-	db.QueryRow("SELECT COUNT(*) FROM closed_options WHERE user_id = ? AND profit_loss > 0", userID).Scan(&winningOptions)
+	db.QueryRow("SELECT COUNT(*) FROM closed_stocks WHERE user_id = ? AND profit_loss > 0"+filter, scope.args(userID)...).Scan(&winningStocks)
+	db.QueryRow("SELECT COUNT(*) FROM closed_options WHERE user_id = ? AND profit_loss > 0"+filter, scope.args(userID)...).Scan(&winningOptions)
 
 	var losingStocks, losingOptions int
-	db.QueryRow("SELECT COUNT(*) FROM closed_stocks WHERE user_id = ? AND profit_loss < 0", userID).Scan(&losingStocks)
-	db.QueryRow("SELECT COUNT(*) FROM closed_options WHERE user_id = ? AND profit_loss < 0", userID).Scan(&losingOptions)
+	db.QueryRow("SELECT COUNT(*) FROM closed_stocks WHERE user_id = ? AND profit_loss < 0"+filter, scope.args(userID)...).Scan(&losingStocks)
+	db.QueryRow("SELECT COUNT(*) FROM closed_options WHERE user_id = ? AND profit_loss < 0"+filter, scope.args(userID)...).Scan(&losingOptions)
 
 	totalWins := winningStocks + winningOptions
 	totalClosed := closedStockCount + closedOptionCount
@@ -50,31 +63,43 @@ func HandleStats(w http.ResponseWriter, r *http.Request) {
 		winRate = (float64(totalWins) / float64(totalClosed)) * 100
 	}
 
-	var stockGains, optionGains float64
-	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_stocks WHERE user_id = ? AND profit_loss > 0", userID).Scan(&stockGains)
-	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_options WHERE user_id = ? AND profit_loss > 0", userID).Scan(&optionGains)
-	totalGains := stockGains + optionGains
+	var stockGains, optionGains types.Decimal
+	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_stocks WHERE user_id = ? AND profit_loss > 0"+filter, scope.args(userID)...).Scan(&stockGains)
+	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_options WHERE user_id = ? AND profit_loss > 0"+filter, scope.args(userID)...).Scan(&optionGains)
+	totalGains := stockGains.Add(optionGains)
 
-	var stockLosses, optionLosses float64
-	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_stocks WHERE user_id = ? AND profit_loss < 0", userID).Scan(&stockLosses)
-	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_options WHERE user_id = ? AND profit_loss < 0", userID).Scan(&optionLosses)
-	totalLossAmount := stockLosses + optionLosses
+	var stockLosses, optionLosses types.Decimal
+	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_stocks WHERE user_id = ? AND profit_loss < 0"+filter, scope.args(userID)...).Scan(&stockLosses)
+	db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_options WHERE user_id = ? AND profit_loss < 0"+filter, scope.args(userID)...).Scan(&optionLosses)
+	totalLossAmount := stockLosses.Add(optionLosses)
 
 	var profitFactor float64
-	if totalLossAmount != 0 {
-		profitFactor = totalGains / -totalLossAmount
+	if !totalLossAmount.IsZero() {
+		profitFactor = totalGains.Float() / -totalLossAmount.Float()
 	}
 
 	// Calculate average win and average loss
 	totalLosses := losingStocks + losingOptions
-	var avgWin, avgLoss float64
+	var avgWin, avgLoss types.Decimal
 	if totalWins > 0 {
-		avgWin = totalGains / float64(totalWins)
+		avgWin = totalGains.Div(types.DecimalFromInt(int64(totalWins)))
 	}
 	if totalLosses > 0 {
-		avgLoss = totalLossAmount / float64(totalLosses)
+		avgLoss = totalLossAmount.Div(types.DecimalFromInt(int64(totalLosses)))
 	}
 
+	var income, fees types.Decimal
+	db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM cash_flows WHERE user_id = ? AND type IN (?, ?)"+filter, scope.args(userID, types.Dividend, types.Interest)...).Scan(&income)
+	db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM cash_flows WHERE user_id = ? AND type = ?"+filter, scope.args(userID, types.Fee)...).Scan(&fees)
+
+	// Commissions on every execution, including positions still open. The
+	// fees of closed trades are already taken out of their P/L.
+	var tradingFees types.Decimal
+	db.QueryRow(`
+		SELECT (SELECT COALESCE(SUM(fees), 0) FROM stock_trades WHERE user_id = ?`+filter+`)
+		     + (SELECT COALESCE(SUM(fees), 0) FROM option_trades WHERE user_id = ?`+filter+`)
+	`, append(scope.args(userID), scope.args(userID)...)...).Scan(&tradingFees)
+
 	totalPositions := stockCount + optionCount
 
 	stats := components.StatsData{
@@ -83,10 +108,17 @@ func HandleStats(w http.ResponseWriter, r *http.Request) {
 		OptionCount:    optionCount,
 		ClosedCount:    totalClosed,
 		TotalPL:        totalPL,
+		AdjustedPL:     totalPL.Add(stockWash).Add(optionWash),
+		PremiumBasis:   userAdjustedBasis(db, userID),
+		PremiumPL:      totalPL.Add(stockPremium).Sub(optionPremium),
 		AvgWin:         avgWin,
 		AvgLoss:        avgLoss,
 		WinRate:        winRate,
 		ProfitFactor:   profitFactor,
+		Income:         income,
+		Fees:           fees,
+		TradingFees:    tradingFees,
+		TotalReturn:    totalPL.Add(income).Add(fees),
 	}
 
 	w.Header().Set("Content-Type", "text/html")
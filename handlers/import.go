@@ -3,13 +3,91 @@ package handlers
 import (
 	"backend/utils"
 	"backend/views/components"
+	"database/sql"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func HandleModalImportCSV(w http.ResponseWriter, r *http.Request) {
-	components.ImportCSVModal().Render(r.Context(), w)
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	profiles, err := listMappingProfiles(userID)
+	if err != nil {
+		http.Error(w, "Failed to load column mappings", http.StatusInternalServerError)
+		return
+	}
+
+	accounts, err := userAccounts(db, userID)
+	if err != nil {
+		http.Error(w, "Failed to load accounts", http.StatusInternalServerError)
+		return
+	}
+
+	formats := append(utils.BrokerFormatNames(), "OFX/QFX")
+	components.ImportCSVModal(formats, profiles, accounts, formDefaultAccount(userID)).Render(r.Context(), w)
+}
+
+func renderImportError(w http.ResponseWriter, message string) {
+	modalHTML := fmt.Sprintf(`
+		<div class="modal">
+			<div class="modal-content">
+				<div class="modal-header">
+					<h3>Import Failed</h3>
+				</div>
+				<p style="color: var(--danger-color); margin: 1rem 0;">%s</p>
+				<div class="form-actions">
+					<button type="button" class="btn btn-primary" hx-get="/modal/close" hx-target="#modal-container">Close</button>
+				</div>
+			</div>
+		</div>
+	`, message)
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(modalHTML))
+}
+
+// newestFirst reports whether a list of trade dates runs backwards, as
+// brokerage exports usually do.
+func newestFirst(firstDate, lastDate string) bool {
+	first, err := ParseDateToTime(firstDate)
+	if err != nil {
+		return false
+	}
+	last, err := ParseDateToTime(lastDate)
+	if err != nil {
+		return false
+	}
+	return first.After(last)
+}
+
+// chronologicalOrder reverses newest-first exports so that same-day trades
+// get ledger sequence numbers in the order they were executed.
+func chronologicalOrder(trades *utils.ImportedTrades) {
+	if n := len(trades.StockTrades); n > 1 && newestFirst(trades.StockTrades[0].Date, trades.StockTrades[n-1].Date) {
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			trades.StockTrades[i], trades.StockTrades[j] = trades.StockTrades[j], trades.StockTrades[i]
+		}
+	}
+	if n := len(trades.OptionTrades); n > 1 && newestFirst(trades.OptionTrades[0].Date, trades.OptionTrades[n-1].Date) {
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			trades.OptionTrades[i], trades.OptionTrades[j] = trades.OptionTrades[j], trades.OptionTrades[i]
+		}
+	}
+	if n := len(trades.CashFlows); n > 1 && newestFirst(trades.CashFlows[0].Date, trades.CashFlows[n-1].Date) {
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			trades.CashFlows[i], trades.CashFlows[j] = trades.CashFlows[j], trades.CashFlows[i]
+		}
+	}
 }
 
 func HandleImportCSV(w http.ResponseWriter, r *http.Request) {
@@ -24,6 +102,12 @@ func HandleImportCSV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	accountID, err := formAccount(r, userID)
+	if err != nil {
+		renderImportError(w, "Unknown account")
+		return
+	}
+
 	file, _, err := r.FormFile("csvFile")
 	if err != nil {
 		http.Error(w, "Failed to read file", http.StatusBadRequest)
@@ -37,233 +121,366 @@ func HandleImportCSV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	trades, err := utils.ParseBrokerageCSV(string(csvContent))
+	var trades *utils.ImportedTrades
+	if utils.IsOFX(string(csvContent)) {
+		trades, err = utils.ParseOFX(string(csvContent))
+	} else {
+		profiles, profileErr := loadMappingProfiles(userID)
+		if profileErr != nil {
+			renderImportError(w, "Failed to load column mappings: "+profileErr.Error())
+			return
+		}
+
+		trades, err = utils.ParseBrokerageCSV(string(csvContent), profiles...)
+		if errors.Is(err, utils.ErrUnrecognizedFormat) {
+			renderColumnMapping(w, r, userID, accountID, string(csvContent))
+			return
+		}
+	}
 	if err != nil {
-		modalHTML := fmt.Sprintf(`
-			<div class="modal">
-				<div class="modal-content">
-					<div class="modal-header">
-						<h3>Import Failed</h3>
-					</div>
-					<p style="color: var(--danger-color); margin: 1rem 0;">%s</p>
-					<div class="form-actions">
-						<button type="button" class="btn btn-primary" hx-get="/modal/close" hx-target="#modal-container">Close</button>
-					</div>
-				</div>
-			</div>
-		`, err.Error())
-		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte(modalHTML))
+		renderImportError(w, err.Error())
 		return
 	}
 
-	stockCount := 0
-	optionCount := 0
-
-	for _, trade := range trades.StockTrades {
-		normalizedDate := NormalizeDateToMMDDYY(trade.Date)
-
-		var existingID int
-		var existingQuantity, existingCostBasis float64
-		err := db.QueryRow(`
-			SELECT id, quantity, cost_basis
-			FROM stock_positions
-			WHERE user_id = ? AND ticker = ?
-		`, userID, trade.Ticker).Scan(&existingID, &existingQuantity, &existingCostBasis)
-
-		if err == nil {
-			switch trade.Code {
-			case "Buy":
-				totalQuantity := existingQuantity + trade.Quantity
-				totalCost := (existingCostBasis * existingQuantity) + (trade.Price * trade.Quantity)
-				newCostBasis := totalCost / totalQuantity
-
-				_, err = db.Exec(`
-					UPDATE stock_positions
-					SET quantity = ?, cost_basis = ?, updated_at = CURRENT_TIMESTAMP
-					WHERE id = ?
-				`, totalQuantity, newCostBasis, existingID)
-			case "Sell":
-				newQuantity := existingQuantity - trade.Quantity
-				if newQuantity <= 0 {
-					profitLoss := (trade.Price - existingCostBasis) * existingQuantity
-					_, err = db.Exec(`
-						INSERT INTO closed_stocks (user_id, ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss)
-						VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-					`, userID, trade.Ticker, normalizedDate, normalizedDate, existingQuantity, existingCostBasis, trade.Price, profitLoss)
-					_, err = db.Exec(`DELETE FROM stock_positions WHERE id = ?`, existingID)
-				} else {
-					_, err = db.Exec(`
-						UPDATE stock_positions
-						SET quantity = ?, updated_at = CURRENT_TIMESTAMP
-						WHERE id = ?
-					`, newQuantity, existingID)
-				}
-			}
-		} else {
-			if trade.Code == "Buy" {
-				_, err = db.Exec(`
-					INSERT INTO stock_positions (user_id, ticker, quantity, cost_basis, open_date)
-					VALUES (?, ?, ?, ?, ?)
-				`, userID, trade.Ticker, trade.Quantity, trade.Price, normalizedDate)
-			}
+	renderImportPreview(w, r, userID, accountID, trades)
+}
+
+// renderImportPreview stages parsed trades for an account and shows what
+// importing them would do.
+func renderImportPreview(w http.ResponseWriter, r *http.Request, userID, accountID int, trades *utils.ImportedTrades) {
+	for i := range trades.StockTrades {
+		trades.StockTrades[i].AccountID = accountID
+	}
+	for i := range trades.OptionTrades {
+		trades.OptionTrades[i].AccountID = accountID
+	}
+	for i := range trades.CashFlows {
+		trades.CashFlows[i].AccountID = accountID
+	}
+	chronologicalOrder(trades)
+	detectStrategies(trades.OptionTrades)
+	detectRolls(trades.OptionTrades)
+
+	staged := &stagedImport{UserID: userID, AccountID: accountID, Skipped: trades.Skipped}
+	for i := range trades.StockTrades {
+		staged.Entries = append(staged.Entries, ledgerEntry{Seq: len(staged.Entries), Stock: &trades.StockTrades[i]})
+	}
+	for i := range trades.OptionTrades {
+		staged.Entries = append(staged.Entries, ledgerEntry{Seq: len(staged.Entries), Option: &trades.OptionTrades[i]})
+	}
+	for i := range trades.CashFlows {
+		staged.Entries = append(staged.Entries, ledgerEntry{Seq: len(staged.Entries), Cash: &trades.CashFlows[i]})
+	}
+	sortLedgerEntries(staged.Entries)
+
+	selected := map[int]bool{}
+	for i, entry := range staged.Entries {
+		exists, err := fingerprintExists(db, userID, accountID, entry.fingerprint())
+		if err != nil {
+			renderImportError(w, "Failed to check for duplicates: "+err.Error())
+			return
 		}
-		stockCount++
-	}
-
-	for _, trade := range trades.OptionTrades {
-		normalizedDate := NormalizeDateToMMDDYY(trade.Date)
-		normalizedExpDate := NormalizeDateToMMDDYY(trade.ExpDate)
-		switch trade.Code {
-		case "BTO":
-			// Buy to Open: creates a Call or Put position (we're buying the option)
-			_, err = db.Exec(`
-				INSERT INTO option_positions (user_id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-			`, userID, trade.Ticker, trade.Price, trade.Premium, trade.Strike, normalizedExpDate, trade.OptionType, 0, trade.Quantity, normalizedDate)
-			if err == nil {
-				optionCount++
-			}
+		staged.Duplicate = append(staged.Duplicate, exists)
+		selected[i] = !exists
+	}
 
-		case "STO":
-			// Sell to Open: creates CSP (if Put) or CC (if Call) - we're selling/writing the option
-			positionType := trade.OptionType
-			if trade.OptionType == "Put" {
-				positionType = "CSP"
-			} else if trade.OptionType == "Call" {
-				positionType = "CC"
-			}
-			_, err = db.Exec(`
-				INSERT INTO option_positions (user_id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-			`, userID, trade.Ticker, trade.Price, trade.Premium, trade.Strike, normalizedExpDate, positionType, 0, trade.Quantity, normalizedDate)
-			if err == nil {
-				optionCount++
-			}
+	token, err := stageImport(staged)
+	if err != nil {
+		renderImportError(w, "Failed to stage import: "+err.Error())
+		return
+	}
 
-		case "STC":
-			// Sell to Close: closes a Call or Put position (one we bought via BTO)
-			var positionID int
-			var positionQuantity float64
-			var purchaseDate, positionType string
-			var premium, collateral float64
-
-			err := db.QueryRow(`
-				SELECT id, quantity, premium, collateral, purchase_date, type
-				FROM option_positions
-				WHERE user_id = ? AND ticker = ? AND strike = ? AND exp_date = ? AND type = ?
-				ORDER BY purchase_date ASC
-				LIMIT 1
-			`, userID, trade.Ticker, trade.Strike, normalizedExpDate, trade.OptionType).Scan(
-				&positionID, &positionQuantity, &premium, &collateral, &purchaseDate, &positionType)
-
-			if err == nil {
-				quantityToClose := trade.Quantity
-				if quantityToClose > positionQuantity {
-					quantityToClose = positionQuantity
-				}
-
-				sellPrice := trade.Price
-				// P/L for bought options: (sell price - buy price) * quantity * 100 (each contract = 100 shares)
-				profitLoss := (sellPrice - premium) * quantityToClose * 100
-
-				collateralForClosed := (collateral / positionQuantity) * quantityToClose
-
-				_, err = db.Exec(`
-					INSERT INTO closed_options (user_id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, close_date, sell_price, profit_loss)
-					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-				`, userID, trade.Ticker, trade.Price, premium, trade.Strike, normalizedExpDate, positionType, collateralForClosed, quantityToClose, purchaseDate, normalizedDate, sellPrice, profitLoss)
-
-				remainingQuantity := positionQuantity - quantityToClose
-				if remainingQuantity > 0 {
-					remainingCollateral := collateral - collateralForClosed
-					_, err = db.Exec(`
-						UPDATE option_positions
-						SET quantity = ?, collateral = ?, updated_at = CURRENT_TIMESTAMP
-						WHERE id = ?
-					`, remainingQuantity, remainingCollateral, positionID)
-				} else {
-					_, err = db.Exec(`DELETE FROM option_positions WHERE id = ?`, positionID)
-				}
-
-				optionCount++
-			}
+	rows, err := previewStagedImport(userID, staged, selected)
+	if err != nil && rows == nil {
+		renderImportError(w, "Failed to preview import: "+err.Error())
+		return
+	}
 
-		case "BTC":
-			// Buy to Close: closes a CSP or CC position (one we sold via STO)
-			// Need to look for CSP if the trade is a Put, or CC if the trade is a Call
-			searchType := trade.OptionType
-			if trade.OptionType == "Put" {
-				searchType = "CSP"
-			} else if trade.OptionType == "Call" {
-				searchType = "CC"
+	w.Header().Set("Content-Type", "text/html")
+	components.ImportPreviewModal(token, trades.Format, rows, staged.Skipped, FormatDate).Render(r.Context(), w)
+}
+
+func selectedImportRows(r *http.Request) map[int]bool {
+	selected := map[int]bool{}
+	for _, value := range r.Form["rows"] {
+		if index, err := strconv.Atoi(value); err == nil {
+			selected[index] = true
+		}
+	}
+	return selected
+}
+
+func importPreviewRow(index int, entry ledgerEntry) components.ImportPreviewRow {
+	row := components.ImportPreviewRow{Index: index, Row: entry.row()}
+	switch {
+	case entry.Stock != nil:
+		t := entry.Stock
+		row.Date, row.Ticker, row.Code = t.Date, t.Ticker, string(t.Code)
+		row.Quantity, row.Price, row.Amount, row.Fees = t.Quantity, t.Price, t.Amount, t.Fees
+	case entry.Option != nil:
+		t := entry.Option
+		row.Date, row.Ticker, row.Code = t.Date, t.Ticker, string(t.Code)
+		row.Quantity, row.Price, row.Amount, row.Fees = t.Quantity, t.Price, t.Amount, t.Fees
+		row.Contract = fmt.Sprintf("%s $%.2f %s", t.OptionType, t.Strike, FormatDate(NormalizeDateToISO(t.ExpDate)))
+		if t.StrategyKey != "" {
+			row.Contract += " (strategy leg)"
+		}
+		if t.RollKey != "" {
+			row.Contract += " (roll)"
+		}
+	default:
+		t := entry.Cash
+		row.Date, row.Ticker, row.Code = t.Date, t.Ticker, string(t.Type)
+		row.Amount = t.Amount
+	}
+	return row
+}
+
+// runStagedImport records and applies the selected rows of a staged import
+// inside tx, reporting the position change each row causes. Rows saved
+// since staging, such as by confirming another staging of the same file,
+// are skipped as duplicates. It stops at the first row that fails, marking
+// that row as an error.
+func runStagedImport(tx *sql.Tx, userID int, staged *stagedImport, selected map[int]bool) ([]components.ImportPreviewRow, error) {
+	rows := make([]components.ImportPreviewRow, 0, len(staged.Entries))
+	for i, entry := range staged.Entries {
+		row := importPreviewRow(i, entry)
+		row.Duplicate = staged.Duplicate[i]
+		if !row.Duplicate {
+			exists, err := fingerprintExists(tx, userID, staged.AccountID, entry.fingerprint())
+			if err != nil {
+				row.Effect = "error"
+				row.Reason = "failed to check for duplicates: " + err.Error()
+				return append(rows, row), fmt.Errorf("row %d: %w", row.Row, err)
 			}
+			row.Duplicate = exists
+		}
 
-			var positionID int
-			var positionQuantity float64
-			var purchaseDate, positionType string
-			var premium, collateral float64
-
-			err := db.QueryRow(`
-				SELECT id, quantity, premium, collateral, purchase_date, type
-				FROM option_positions
-				WHERE user_id = ? AND ticker = ? AND strike = ? AND exp_date = ? AND type = ?
-				ORDER BY purchase_date ASC
-				LIMIT 1
-			`, userID, trade.Ticker, trade.Strike, normalizedExpDate, searchType).Scan(
-				&positionID, &positionQuantity, &premium, &collateral, &purchaseDate, &positionType)
-
-			if err == nil {
-				quantityToClose := trade.Quantity
-				if quantityToClose > positionQuantity {
-					quantityToClose = positionQuantity
-				}
-
-				sellPrice := trade.Price
-				// P/L for sold options: (premium received - cost to close) * quantity * 100
-				profitLoss := (premium - sellPrice) * quantityToClose * 100
-
-				collateralForClosed := (collateral / positionQuantity) * quantityToClose
-
-				_, err = db.Exec(`
-					INSERT INTO closed_options (user_id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, close_date, sell_price, profit_loss)
-					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-				`, userID, trade.Ticker, trade.Price, premium, trade.Strike, normalizedExpDate, positionType, collateralForClosed, quantityToClose, purchaseDate, normalizedDate, sellPrice, profitLoss)
-
-				remainingQuantity := positionQuantity - quantityToClose
-				if remainingQuantity > 0 {
-					remainingCollateral := collateral - collateralForClosed
-					_, err = db.Exec(`
-						UPDATE option_positions
-						SET quantity = ?, collateral = ?, updated_at = CURRENT_TIMESTAMP
-						WHERE id = ?
-					`, remainingQuantity, remainingCollateral, positionID)
-				} else {
-					_, err = db.Exec(`DELETE FROM option_positions WHERE id = ?`, positionID)
-				}
-
-				optionCount++
+		switch {
+		case row.Duplicate:
+			row.Effect = "duplicate"
+		case !selected[i]:
+			row.Effect = "excluded"
+		default:
+			row.Selected = true
+
+			effect, err := applyStagedEntry(tx, userID, entry)
+			if err != nil {
+				row.Effect = "error"
+				row.Reason = err.Error()
+				return append(rows, row), fmt.Errorf("row %d: %w", row.Row, err)
 			}
+			row.Effect = string(effect)
 		}
+		rows = append(rows, row)
 	}
+	return rows, nil
+}
 
-	modalHTML := fmt.Sprintf(`
-		<div class="modal">
-			<div class="modal-content">
-				<div class="modal-header">
-					<h3>Import Successful</h3>
-				</div>
-				<p style="color: var(--success-color); margin: 1rem 0;">
-					Successfully imported %d stock trades and %d option trades
-				</p>
-				<div class="form-actions">
-					<button type="button" class="btn btn-primary" hx-get="/modal/close" hx-target="#modal-container">Close</button>
-				</div>
-			</div>
-		</div>
-	`, stockCount, optionCount)
+func applyStagedEntry(tx *sql.Tx, userID int, entry ledgerEntry) (tradeEffect, error) {
+	recorded, err := recordLedgerEntry(tx, userID, entry, SourceImport)
+	if err != nil {
+		return "", err
+	}
+	return applyLedgerEntry(tx, userID, recorded)
+}
+
+// buildImportReport turns the outcome of a confirmed import into one report
+// line per source row. When the import failed nothing was written, so rows
+// that were applied before the failure are reported as rolled back.
+func buildImportReport(staged *stagedImport, rows []components.ImportPreviewRow, failed bool) []components.ImportReportRow {
+	rolledBack := "rolled back, the import could not be saved"
+	if failed && len(rows) > 0 && rows[len(rows)-1].Effect == "error" {
+		rolledBack = fmt.Sprintf("rolled back after error in row %d", rows[len(rows)-1].Row)
+	}
+
+	var report []components.ImportReportRow
+	for i, entry := range staged.Entries {
+		line := components.ImportReportRow{Row: entry.row(), Status: components.ImportStatusSkipped}
+		preview := importPreviewRow(i, entry)
+		line.Ticker, line.Code = preview.Ticker, preview.Code
+
+		if i >= len(rows) {
+			line.Reason = "not processed, " + rolledBack
+			report = append(report, line)
+			continue
+		}
+
+		row := rows[i]
+		switch {
+		case row.Duplicate:
+			line.Reason = "already imported"
+		case !row.Selected:
+			line.Reason = "deselected"
+		case row.Effect == "error":
+			line.Status = components.ImportStatusError
+			line.Reason = row.Reason
+		case failed:
+			line.Reason = rolledBack
+		case row.Effect == string(effectUnmatched):
+			line.Status = components.ImportStatusApplied
+			line.Reason = "recorded, no open position to close"
+		default:
+			line.Status = components.ImportStatusApplied
+			line.Reason = row.Effect
+		}
+		report = append(report, line)
+	}
+
+	for _, skipped := range staged.Skipped {
+		report = append(report, components.ImportReportRow{
+			Row:    skipped.Row,
+			Status: components.ImportStatusSkipped,
+			Reason: skipped.Reason,
+		})
+	}
+
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].Row < report[j].Row
+	})
+	return report
+}
+
+// previewStagedImport runs the import against the current positions and
+// rolls it back, so the effects reflect exactly what confirming would do.
+// A failing row is returned as an error row alongside the error.
+func previewStagedImport(userID int, staged *stagedImport, selected map[int]bool) ([]components.ImportPreviewRow, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return runStagedImport(tx, userID, staged, selected)
+}
+
+func HandleImportPreview(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	staged, ok := getStagedImport(r.FormValue("token"), userID)
+	if !ok {
+		http.Error(w, "Import expired, please upload the file again", http.StatusGone)
+		return
+	}
+
+	rows, err := previewStagedImport(userID, staged, selectedImportRows(r))
+	if err != nil && rows == nil {
+		http.Error(w, "Failed to preview import: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	w.Header().Set("HX-Trigger", "positionAdded, historyUpdated")
 	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(modalHTML))
+	components.ImportPreviewTable(rows, FormatDate).Render(r.Context(), w)
+}
+
+func HandleImportConfirm(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	token := r.FormValue("token")
+	staged, ok := getStagedImport(token, userID)
+	if !ok {
+		renderImportError(w, "This import has expired. Please upload the file again.")
+		return
+	}
+
+	if !claimStagedImport(staged) {
+		renderImportError(w, "This import has already been saved.")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		releaseStagedImport(staged, nil)
+		renderImportError(w, "Failed to start import: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := runStagedImport(tx, userID, staged, selectedImportRows(r))
+	if err == nil {
+		err = tx.Commit()
+	}
+	report := buildImportReport(staged, rows, err != nil)
+
+	w.Header().Set("Content-Type", "text/html")
+	if err != nil {
+		releaseStagedImport(staged, nil)
+		components.ImportReportModal(token, "Import Failed", "Nothing was saved: "+err.Error(), report, false).Render(r.Context(), w)
+		return
+	}
+	releaseStagedImport(staged, report)
+
+	applied := 0
+	for _, line := range report {
+		if line.Status == components.ImportStatusApplied {
+			applied++
+		}
+	}
+	message := fmt.Sprintf("Imported %d of %d rows.", applied, len(report))
+
+	w.Header().Set("HX-Trigger", "positionAdded, historyUpdated")
+	components.ImportReportModal(token, "Import Successful", message, report, true).Render(r.Context(), w)
+}
+
+// HandleImportReport downloads the row-level report of a confirmed import.
+func HandleImportReport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	staged, ok := getStagedImport(chi.URLParam(r, "token"), userID)
+	if !ok {
+		http.Error(w, "Import report not found", http.StatusNotFound)
+		return
+	}
+	report := stagedImportReport(staged)
+	if report == nil {
+		http.Error(w, "Import report not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="import-report.csv"`)
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"row", "ticker", "code", "status", "reason"})
+	for _, line := range report {
+		writer.Write([]string{strconv.Itoa(line.Row), line.Ticker, line.Code, line.Status, line.Reason})
+	}
+	writer.Flush()
+}
+
+func HandleImportDiscard(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	if userID, ok := GetOrCreateUserID(r); ok {
+		if _, exists := getStagedImport(r.FormValue("token"), userID); exists {
+			discardStagedImport(r.FormValue("token"))
+		}
+	}
+
+	components.ModalClose().Render(r.Context(), w)
 }
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"backend/utils"
+	"backend/views/components"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func loadMappingProfiles(userID int) ([]utils.BrokerFormat, error) {
+	rows, err := db.Query(`
+		SELECT name, header, columns
+		FROM csv_mapping_profiles
+		WHERE user_id = ?
+		ORDER BY name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []utils.BrokerFormat
+	for rows.Next() {
+		var profile utils.MappedFormat
+		var header, columns string
+		if err := rows.Scan(&profile.ProfileName, &header, &columns); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(header), &profile.Header); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(columns), &profile.Columns); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+func listMappingProfiles(userID int) ([]components.MappingProfile, error) {
+	rows, err := db.Query(`
+		SELECT id, name, header
+		FROM csv_mapping_profiles
+		WHERE user_id = ?
+		ORDER BY name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []components.MappingProfile
+	for rows.Next() {
+		var profile components.MappingProfile
+		var header string
+		if err := rows.Scan(&profile.ID, &profile.Name, &header); err != nil {
+			return nil, err
+		}
+		var columns []string
+		if err := json.Unmarshal([]byte(header), &columns); err != nil {
+			return nil, err
+		}
+		profile.Columns = strings.Join(columns, ", ")
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+// saveMappingProfile stores a mapping under its name, replacing any profile
+// the user already saved with that name.
+func saveMappingProfile(userID int, profile utils.MappedFormat) error {
+	header, err := json.Marshal(profile.Header)
+	if err != nil {
+		return err
+	}
+	columns, err := json.Marshal(profile.Columns)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO csv_mapping_profiles (user_id, name, header, columns)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, name) DO UPDATE
+		SET header = excluded.header, columns = excluded.columns, updated_at = CURRENT_TIMESTAMP
+	`, userID, profile.ProfileName, string(header), string(columns))
+	return err
+}
+
+// csvHeaderRow returns the first non-empty row of a CSV and a few rows
+// after it to help the user recognise the columns.
+func csvHeaderRow(content string) ([]string, [][]string) {
+	rows := utils.Parse(content)
+	for i, row := range rows {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		end := i + 4
+		if end > len(rows) {
+			end = len(rows)
+		}
+		return row, rows[i+1 : end]
+	}
+	return nil, nil
+}
+
+func renderColumnMapping(w http.ResponseWriter, r *http.Request, userID, accountID int, content string) {
+	header, samples := csvHeaderRow(content)
+	if len(header) == 0 {
+		renderImportError(w, "CSV is empty")
+		return
+	}
+
+	token, err := stageImport(&stagedImport{UserID: userID, AccountID: accountID, Content: content})
+	if err != nil {
+		renderImportError(w, "Failed to stage import: "+err.Error())
+		return
+	}
+
+	selected := map[string]int{}
+	columns := utils.NewCSVHeader(header)
+	for field, column := range utils.GuessColumnMapping(header) {
+		selected[field] = columns[column]
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.ColumnMappingModal(token, header, samples, utils.MappingFields, selected, "").Render(r.Context(), w)
+}
+
+func HandleImportMapping(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	token := r.FormValue("token")
+	pending, ok := getStagedImport(token, userID)
+	if !ok || pending.Content == "" {
+		renderImportError(w, "This import has expired. Please upload the file again.")
+		return
+	}
+
+	header, samples := csvHeaderRow(pending.Content)
+
+	profileName := strings.TrimSpace(r.FormValue("profile_name"))
+	format := utils.MappedFormat{ProfileName: profileName, Header: header, Columns: utils.ColumnMapping{}}
+	if format.ProfileName == "" {
+		format.ProfileName = "Custom mapping"
+	}
+
+	selected := map[string]int{}
+	for _, field := range utils.MappingFields {
+		index, err := strconv.Atoi(r.FormValue(field.Key))
+		if err != nil || index < 0 || index >= len(header) {
+			continue
+		}
+		selected[field.Key] = index
+		format.Columns[field.Key] = header[index]
+	}
+
+	if err := format.Validate(); err != nil {
+		w.Header().Set("Content-Type", "text/html")
+		components.ColumnMappingModal(token, header, samples, utils.MappingFields, selected, err.Error()).Render(r.Context(), w)
+		return
+	}
+
+	trades, err := utils.ParseBrokerageCSV(pending.Content, format)
+	if err != nil {
+		renderImportError(w, err.Error())
+		return
+	}
+
+	if profileName != "" {
+		if err := saveMappingProfile(userID, format); err != nil {
+			renderImportError(w, "Failed to save column mapping: "+err.Error())
+			return
+		}
+	}
+	discardStagedImport(token)
+
+	renderImportPreview(w, r, userID, pending.AccountID, trades)
+}
+
+func HandleDeleteMappingProfile(w http.ResponseWriter, r *http.Request) {
+	profileID := chi.URLParam(r, "id")
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := db.Exec("DELETE FROM csv_mapping_profiles WHERE id = ? AND user_id = ?", profileID, userID)
+	if err != nil {
+		http.Error(w, "Failed to delete column mapping", http.StatusInternalServerError)
+		return
+	}
+
+	profiles, err := listMappingProfiles(userID)
+	if err != nil {
+		http.Error(w, "Failed to load column mappings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.MappingProfileList(profiles).Render(r.Context(), w)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/utils"
+	"backend/views/components"
+	"sync"
+	"time"
+)
+
+const stagedImportTTL = 30 * time.Minute
+
+// stagedImport is a parsed upload waiting for the user to confirm it.
+// Entries are in chronological order, recorded in AccountID, and Duplicate
+// marks rows that were already imported in that account. An upload in an
+// unrecognized format waits in Content until its columns are mapped.
+// Report is set once the import has been saved, and saving is set while a
+// confirm request is saving it; both are guarded by the store's mutex.
+type stagedImport struct {
+	UserID    int
+	AccountID int
+	Content   string
+	Entries   []ledgerEntry
+	Duplicate []bool
+	Skipped   []utils.SkippedRow
+	Report    []components.ImportReportRow
+	ExpiresAt time.Time
+	saving    bool
+}
+
+type stagedImportStore struct {
+	imports map[string]*stagedImport
+	mu      sync.Mutex
+}
+
+var stagedImports = &stagedImportStore{
+	imports: make(map[string]*stagedImport),
+}
+
+func stageImport(staged *stagedImport) (string, error) {
+	token, err := middleware.GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	staged.ExpiresAt = time.Now().Add(stagedImportTTL)
+
+	stagedImports.mu.Lock()
+	defer stagedImports.mu.Unlock()
+
+	now := time.Now()
+	for t, s := range stagedImports.imports {
+		if now.After(s.ExpiresAt) {
+			delete(stagedImports.imports, t)
+		}
+	}
+	stagedImports.imports[token] = staged
+
+	return token, nil
+}
+
+func getStagedImport(token string, userID int) (*stagedImport, bool) {
+	stagedImports.mu.Lock()
+	defer stagedImports.mu.Unlock()
+
+	staged, exists := stagedImports.imports[token]
+	if !exists || staged.UserID != userID {
+		return nil, false
+	}
+	if time.Now().After(staged.ExpiresAt) {
+		delete(stagedImports.imports, token)
+		return nil, false
+	}
+	return staged, true
+}
+
+func discardStagedImport(token string) {
+	stagedImports.mu.Lock()
+	delete(stagedImports.imports, token)
+	stagedImports.mu.Unlock()
+}
+
+// claimStagedImport marks a staged import as being saved. It reports false
+// when the import was already saved or another request is saving it, so a
+// double-submitted confirm can't record the rows twice.
+func claimStagedImport(staged *stagedImport) bool {
+	stagedImports.mu.Lock()
+	defer stagedImports.mu.Unlock()
+
+	if staged.saving || staged.Report != nil {
+		return false
+	}
+	staged.saving = true
+	return true
+}
+
+// releaseStagedImport ends a save started by claimStagedImport. A nil
+// report means nothing was saved, so the import can be confirmed again.
+func releaseStagedImport(staged *stagedImport, report []components.ImportReportRow) {
+	stagedImports.mu.Lock()
+	defer stagedImports.mu.Unlock()
+
+	staged.saving = false
+	staged.Report = report
+}
+
+// stagedImportReport returns the report of a saved import, or nil.
+func stagedImportReport(staged *stagedImport) []components.ImportReportRow {
+	stagedImports.mu.Lock()
+	defer stagedImports.mu.Unlock()
+
+	return staged.Report
+}
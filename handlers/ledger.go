@@ -0,0 +1,812 @@
+package handlers
+
+import (
+	"backend/pnl"
+	"backend/types"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx so the ledger can be written
+// and applied inside or outside a transaction.
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+const (
+	SourceImport    = "import"
+	SourceManual    = "manual"
+	SourceMigration = "migration"
+)
+
+// ledgerEntry is a single stored execution or cash flow. Exactly one of
+// Stock, Option, Cash, Action or Transfer is set. Cash flows are kept
+// alongside trades so they import the same way, but they don't change
+// positions. Applied corporate actions are replayed at their effective
+// date, before the trades made that day, and account transfers after them.
+type ledgerEntry struct {
+	Seq      int
+	Stock    *types.StockTrade
+	Option   *types.OptionTrade
+	Cash     *types.CashFlow
+	Action   *types.CorporateAction
+	Transfer *types.AccountTransfer
+}
+
+func (e ledgerEntry) date() string {
+	switch {
+	case e.Stock != nil:
+		return e.Stock.Date
+	case e.Option != nil:
+		return e.Option.Date
+	case e.Action != nil:
+		return e.Action.EffectiveDate
+	case e.Transfer != nil:
+		return e.Transfer.Date
+	}
+	return e.Cash.Date
+}
+
+func (e ledgerEntry) row() int {
+	switch {
+	case e.Stock != nil:
+		return e.Stock.Row
+	case e.Option != nil:
+		return e.Option.Row
+	case e.Action != nil:
+		return e.Action.Row
+	case e.Transfer != nil:
+		return 0
+	}
+	return e.Cash.Row
+}
+
+func (e ledgerEntry) fingerprint() string {
+	switch {
+	case e.Stock != nil:
+		return e.Stock.Fingerprint
+	case e.Option != nil:
+		return e.Option.Fingerprint
+	case e.Action != nil, e.Transfer != nil:
+		return ""
+	}
+	return e.Cash.Fingerprint
+}
+
+func sortLedgerEntries(entries []ledgerEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		di, _ := ParseDateToTime(entries[i].date())
+		dj, _ := ParseDateToTime(entries[j].date())
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		if entries[i].order() != entries[j].order() {
+			return entries[i].order() < entries[j].order()
+		}
+		return entries[i].Seq < entries[j].Seq
+	})
+}
+
+// order ranks entries made on the same date: corporate actions, then
+// trades and cash flows, then account transfers.
+func (e ledgerEntry) order() int {
+	switch {
+	case e.Action != nil:
+		return 0
+	case e.Transfer != nil:
+		return 2
+	}
+	return 1
+}
+
+func newTradeID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func nextLedgerSeq(q dbtx, userID int) (int, error) {
+	var seq int
+	err := q.QueryRow(`
+		SELECT COALESCE(MAX(seq), 0) FROM (
+			SELECT seq FROM stock_trades WHERE user_id = ?
+			UNION ALL
+			SELECT seq FROM option_trades WHERE user_id = ?
+		)
+	`, userID, userID).Scan(&seq)
+	return seq + 1, err
+}
+
+func recordStockTrade(q dbtx, userID int, trade *types.StockTrade, source string) (ledgerEntry, error) {
+	id, err := newTradeID()
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+	seq, err := nextLedgerSeq(q, userID)
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+
+	trade.ID = id
+	trade.Date = NormalizeDateToISO(trade.Date)
+
+	// Trades remember the lot method in force when they were made so a
+	// replay closes the same lots after the user changes their default.
+	if trade.LotMethod == "" {
+		trade.LotMethod, err = userLotMethod(q, userID)
+		if err != nil {
+			return ledgerEntry{}, err
+		}
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO stock_trades (id, user_id, ticker, date, code, price, amount, quantity, fees, seq, source, fingerprint, lot_method, lot_trade_id, option_trade_id, account_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, trade.ID, userID, trade.Ticker, trade.Date, trade.Code, trade.Price, trade.Amount, trade.Quantity, trade.Fees, seq, source, trade.Fingerprint,
+		trade.LotMethod, trade.LotTradeID, trade.OptionTradeID, trade.AccountID)
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+
+	return ledgerEntry{Seq: seq, Stock: trade}, nil
+}
+
+func recordOptionTrade(q dbtx, userID int, trade *types.OptionTrade, source string) (ledgerEntry, error) {
+	id, err := newTradeID()
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+	seq, err := nextLedgerSeq(q, userID)
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+
+	trade.ID = id
+	trade.Date = NormalizeDateToISO(trade.Date)
+	trade.ExpDate = NormalizeDateToISO(trade.ExpDate)
+	trade.Multiplier = trade.ContractMultiplier()
+
+	_, err = q.Exec(`
+		INSERT INTO option_trades (id, user_id, ticker, date, code, price, amount, quantity, strike, exp_date, option_type, premium, multiplier, fees, seq, source, fingerprint, strategy_key, roll_key, position_trade_id, account_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, trade.ID, userID, trade.Ticker, trade.Date, trade.Code, trade.Price, trade.Amount, trade.Quantity,
+		trade.Strike, trade.ExpDate, trade.OptionType, trade.Premium, trade.Multiplier, trade.Fees, seq, source, trade.Fingerprint, trade.StrategyKey, trade.RollKey, trade.PositionTradeID, trade.AccountID)
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+
+	return ledgerEntry{Seq: seq, Option: trade}, nil
+}
+
+// recordLedgerEntry stores a copy of an unrecorded entry, leaving the
+// original untouched so staged imports can be recorded more than once.
+func recordLedgerEntry(q dbtx, userID int, entry ledgerEntry, source string) (ledgerEntry, error) {
+	switch {
+	case entry.Stock != nil:
+		trade := *entry.Stock
+		return recordStockTrade(q, userID, &trade, source)
+	case entry.Option != nil:
+		trade := *entry.Option
+		return recordOptionTrade(q, userID, &trade, source)
+	}
+	flow := *entry.Cash
+	return ledgerEntry{Cash: &flow}, recordCashFlow(q, userID, &flow, source)
+}
+
+// fingerprintExists reports whether a source row with this fingerprint has
+// already been imported into the account. The same row imported into a
+// different account is a different execution.
+func fingerprintExists(q dbtx, userID, accountID int, fingerprint string) (bool, error) {
+	if fingerprint == "" {
+		return false, nil
+	}
+
+	var count int
+	err := q.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM stock_trades WHERE user_id = ? AND account_id = ? AND fingerprint = ?)
+		     + (SELECT COUNT(*) FROM option_trades WHERE user_id = ? AND account_id = ? AND fingerprint = ?)
+		     + (SELECT COUNT(*) FROM cash_flows WHERE user_id = ? AND account_id = ? AND fingerprint = ?)
+	`, userID, accountID, fingerprint, userID, accountID, fingerprint, userID, accountID, fingerprint).Scan(&count)
+	return count > 0, err
+}
+
+// tradeEffect describes what applying a trade did to the user's positions.
+type tradeEffect string
+
+const (
+	effectOpen         tradeEffect = "open"
+	effectAdd          tradeEffect = "add"
+	effectPartialClose tradeEffect = "partial close"
+	effectFullClose    tradeEffect = "full close"
+	effectUnmatched    tradeEffect = "unmatched"
+	effectCashFlow     tradeEffect = "cash flow"
+	effectAction       tradeEffect = "corporate action"
+	effectTransfer     tradeEffect = "transfer"
+)
+
+func applyLedgerEntry(q dbtx, userID int, entry ledgerEntry) (tradeEffect, error) {
+	switch {
+	case entry.Stock != nil:
+		return applyStockTrade(q, userID, *entry.Stock)
+	case entry.Option != nil:
+		return applyOptionTrade(q, userID, *entry.Option)
+	case entry.Action != nil:
+		return effectAction, applyCorporateAction(q, userID, *entry.Action)
+	case entry.Transfer != nil:
+		return effectTransfer, applyAccountTransfer(q, userID, *entry.Transfer)
+	case entry.Cash != nil:
+		return effectCashFlow, postCashFlow(q, userID, *entry.Cash)
+	}
+	return effectCashFlow, nil
+}
+
+// applyStockTrade closes lots on the opposite side first, so a sale closes
+// long lots and a buy covers short lots, and opens a lot for whatever is
+// left: a buy opens long and a sale with nothing to close opens a short.
+// Only lots in the trade's account are closed. The ticker's position in the
+// account is then refreshed from the lots still open.
+func applyStockTrade(q dbtx, userID int, trade types.StockTrade) (tradeEffect, error) {
+	switch trade.Code {
+	case types.Buy, types.BuyToCover, types.Sell, types.SellShort:
+	default:
+		return effectUnmatched, nil
+	}
+	if err := postStockTradeCash(q, userID, trade); err != nil {
+		return "", err
+	}
+
+	opening, closing := types.Long, types.Short
+	if trade.Code.Sells() {
+		opening, closing = types.Short, types.Long
+	}
+
+	lots, err := openStockLots(q, userID, trade.AccountID, trade.Ticker, closing)
+	if err != nil {
+		return "", err
+	}
+
+	remaining := trade.Quantity
+	if len(lots) > 0 {
+		closed, err := closeStockLots(q, userID, trade, lots)
+		if err != nil {
+			return "", err
+		}
+		remaining = remaining.Sub(closed)
+	}
+
+	// Covering more than is short, or selling more than is held, leaves
+	// the rest as a new position on the other side.
+	if remaining.Sign() > 0 && trade.Code != types.BuyToCover {
+		existing, err := openStockLots(q, userID, trade.AccountID, trade.Ticker, opening)
+		if err != nil {
+			return "", err
+		}
+		if err := openStockLot(q, userID, trade, opening, remaining); err != nil {
+			return "", err
+		}
+		if _, err := syncStockPosition(q, userID, trade.AccountID, trade.Ticker); err != nil {
+			return "", err
+		}
+
+		switch {
+		case len(lots) > 0:
+			return effectFullClose, nil
+		case len(existing) > 0:
+			return effectAdd, nil
+		}
+		return effectOpen, nil
+	}
+
+	if len(lots) == 0 {
+		return effectUnmatched, nil
+	}
+	open, err := syncStockPosition(q, userID, trade.AccountID, trade.Ticker)
+	if open {
+		return effectPartialClose, err
+	}
+	return effectFullClose, err
+}
+
+// optionPositionType maps a trade to the position type it opens or closes:
+// written puts and calls are tracked as CSPs and CCs.
+func optionPositionType(trade types.OptionTrade) types.OptionType {
+	if trade.Code == types.STO || trade.Code == types.BTC {
+		switch trade.OptionType {
+		case types.Put:
+			return types.CSP
+		case types.Call:
+			return types.CC
+		}
+	}
+	return trade.OptionType
+}
+
+// optionTradeCodes returns the opening and closing trade codes and the
+// contract type for a position type, e.g. CSP opens with STO on a Put.
+func optionTradeCodes(positionType types.OptionType) (types.TradeCode, types.TradeCode, types.OptionType) {
+	switch positionType {
+	case types.CSP:
+		return types.STO, types.BTC, types.Put
+	case types.CC:
+		return types.STO, types.BTC, types.Call
+	default:
+		return types.BTO, types.STC, positionType
+	}
+}
+
+func applyOptionTrade(q dbtx, userID int, trade types.OptionTrade) (tradeEffect, error) {
+	switch trade.Code {
+	case types.BTO, types.STO, types.STC, types.BTC, types.OEXP, types.OASGN, types.OEXCS:
+		if err := postOptionTradeCash(q, userID, trade); err != nil {
+			return "", err
+		}
+	}
+
+	switch trade.Code {
+	case types.BTO, types.STO:
+		return effectOpen, openOptionPosition(q, userID, trade)
+
+	case types.OEXP, types.OASGN, types.OEXCS:
+		return applyOptionEvent(q, userID, trade)
+
+	case types.STC, types.BTC:
+		return closeOptionTrade(q, userID, trade)
+	}
+
+	return effectUnmatched, nil
+}
+
+// closeOptionTrade closes the trade's contracts from the positions in that
+// contract, oldest first, splitting its fees between them. The position the
+// close was entered against goes first, and closing a strategy leg prefers
+// the legs in that strategy over older positions in the same contract. A
+// trade without a quantity closes the first position.
+func closeOptionTrade(q dbtx, userID int, trade types.OptionTrade) (tradeEffect, error) {
+	strategyID, err := strategyIDForKey(q, userID, trade.StrategyKey)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := q.Query(`
+		SELECT id, quantity
+		FROM option_positions
+		WHERE user_id = ? AND account_id = ? AND ticker = ? AND strike = ? AND exp_date = ? AND type = ? AND quantity > 0
+		ORDER BY open_trade_id = ? AND open_trade_id != '' DESC, strategy_id = ? DESC, purchase_date ASC, id ASC
+	`, userID, trade.AccountID, trade.Ticker, trade.Strike, trade.ExpDate, optionPositionType(trade), trade.PositionTradeID, strategyID)
+	if err != nil {
+		return "", err
+	}
+	var ids []int
+	var quantities []types.Decimal
+	for rows.Next() {
+		var id int
+		var quantity types.Decimal
+		if err := rows.Scan(&id, &quantity); err != nil {
+			rows.Close()
+			return "", err
+		}
+		ids = append(ids, id)
+		quantities = append(quantities, quantity)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return effectUnmatched, nil
+	}
+	if trade.Quantity.Sign() <= 0 {
+		return closeOptionPosition(q, userID, ids[0], trade, OutcomeClosed)
+	}
+
+	// Each position's fees are the difference of the running totals, so
+	// the parts add up to the trade's fees exactly.
+	effect := effectUnmatched
+	var closed, feesSoFar types.Decimal
+	for i, id := range ids {
+		if closed.Cmp(trade.Quantity) >= 0 {
+			break
+		}
+		quantity := types.MinDecimal(quantities[i], trade.Quantity.Sub(closed))
+		closed = closed.Add(quantity)
+		fees := tradeFees(trade.Fees, closed, trade.Quantity)
+
+		part := trade
+		part.Quantity = quantity
+		part.Fees = fees.Sub(feesSoFar)
+		feesSoFar = fees
+
+		effect, err = closeOptionPosition(q, userID, id, part, OutcomeClosed)
+		if err != nil {
+			return "", err
+		}
+	}
+	return effect, nil
+}
+
+// optionCollateral is the capital a written option ties up across all its
+// contracts: the cash to buy the shares for a CSP, and the cost of the
+// shares covering a CC that other open CCs (besides positionID) don't
+// already cover.
+func optionCollateral(q dbtx, userID, accountID, positionID int, ticker string, positionType types.OptionType, strike, quantity, multiplier types.Decimal) (types.Decimal, error) {
+	switch positionType {
+	case types.CSP:
+		return strike.Mul(quantity).Mul(multiplier), nil
+	case types.CC:
+		var stockQuantity, stockCostBasis types.Decimal
+		err := q.QueryRow(`
+			SELECT quantity, cost_basis
+			FROM stock_positions
+			WHERE user_id = ? AND account_id = ? AND ticker = ? AND side = ?
+		`, userID, accountID, ticker, types.Long).Scan(&stockQuantity, &stockCostBasis)
+		if err == sql.ErrNoRows {
+			return types.Decimal{}, nil
+		}
+		if err != nil {
+			return types.Decimal{}, err
+		}
+
+		var coveredShares types.Decimal
+		err = q.QueryRow(`
+			SELECT COALESCE(SUM(quantity * multiplier / 1000000), 0)
+			FROM option_positions
+			WHERE user_id = ? AND account_id = ? AND ticker = ? AND type = ? AND quantity > 0 AND id != ?
+		`, userID, accountID, ticker, types.CC, positionID).Scan(&coveredShares)
+		if err != nil {
+			return types.Decimal{}, err
+		}
+
+		available := types.MaxDecimal(stockQuantity.Sub(coveredShares), types.Decimal{})
+		return stockCostBasis.Mul(types.MinDecimal(quantity.Mul(multiplier), available)), nil
+	}
+	return types.Decimal{}, nil
+}
+
+func openOptionPosition(q dbtx, userID int, trade types.OptionTrade) error {
+	positionType := optionPositionType(trade)
+	multiplier := trade.ContractMultiplier()
+	collateral, err := optionCollateral(q, userID, trade.AccountID, 0, trade.Ticker, positionType, trade.Strike, trade.Quantity, multiplier)
+	if err != nil {
+		return err
+	}
+
+	strategyID, err := openStrategy(q, userID, trade)
+	if err != nil {
+		return err
+	}
+	campaignID, err := wheelCampaignFor(q, userID, trade.AccountID, trade.Ticker, positionType, trade.Date)
+	if err != nil {
+		return err
+	}
+	rollID, err := rollChainFor(q, userID, trade)
+	if err != nil {
+		return err
+	}
+
+	result, err := q.Exec(`
+		INSERT INTO option_positions (user_id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, open_trade_id, strategy_id, campaign_id, roll_id, multiplier, fees, account_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, trade.Ticker, trade.Price, trade.Premium, trade.Strike, trade.ExpDate, positionType, collateral, trade.Quantity, trade.Date, trade.ID, strategyID, campaignID, rollID, multiplier, trade.Fees, trade.AccountID)
+	if err != nil || !optionWashApplies(positionType) {
+		return err
+	}
+
+	positionID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	sec := optionWashSecurity(trade.Ticker, positionType, trade.Strike, NormalizeDateToISO(trade.ExpDate))
+	return washPurchase(q, userID, sec, int(positionID), trade.Date, trade.Quantity)
+}
+
+// closeOptionPosition closes the trade's quantity of a position, or all of
+// it, with the given outcome.
+func closeOptionPosition(q dbtx, userID int, positionID int, trade types.OptionTrade, outcome string) (tradeEffect, error) {
+	var ticker, expDate, purchaseDate, openTradeID string
+	var price, premium, strike, collateral, currentQuantity, washAdjustment, washQuantity, openFees types.Decimal
+	var positionType types.OptionType
+	var strategyID, campaignID, rollID, accountID int
+	var multiplier types.Decimal
+
+	err := q.QueryRow(`
+		SELECT ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, open_trade_id, wash_adjustment, wash_quantity, strategy_id, campaign_id, roll_id, multiplier, fees, account_id
+		FROM option_positions
+		WHERE id = ? AND user_id = ?
+	`, positionID, userID).Scan(&ticker, &price, &premium, &strike, &expDate, &positionType, &collateral, &currentQuantity, &purchaseDate, &openTradeID, &washAdjustment, &washQuantity, &strategyID, &campaignID, &rollID, &multiplier, &openFees, &accountID)
+	if err != nil {
+		return "", err
+	}
+
+	quantityToClose := trade.Quantity
+	if quantityToClose.Sign() <= 0 || quantityToClose.Cmp(currentQuantity) > 0 {
+		quantityToClose = currentQuantity
+	}
+
+	// The closed contracts carry their share of the fees paid to open the
+	// position and of the fees on this trade.
+	openFeesForClosed := openFees.MulDiv(quantityToClose, currentQuantity)
+	fees := openFeesForClosed.Add(tradeFees(trade.Fees, quantityToClose, trade.Quantity))
+	profitLoss := pnl.Option(pnl.Outcome(outcome), positionType, premium, trade.Price, quantityToClose, multiplier, fees)
+
+	collateralForClosed := collateral.MulDiv(quantityToClose, currentQuantity)
+	washForClosed := washAdjustment.MulDiv(quantityToClose, currentQuantity)
+
+	result, err := q.Exec(`
+		INSERT INTO closed_options (user_id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, close_date, sell_price, profit_loss, open_trade_id, close_trade_id, wash_adjustment, strategy_id, campaign_id, roll_id, multiplier, fees, account_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, ticker, price, premium, strike, expDate, positionType, collateralForClosed, quantityToClose, purchaseDate, trade.Date, trade.Price, profitLoss, openTradeID, trade.ID, washForClosed, strategyID, campaignID, rollID, multiplier, fees, accountID)
+	if err != nil {
+		return "", err
+	}
+
+	if positionType == types.CC {
+		closedID, err := result.LastInsertId()
+		if err != nil {
+			return "", err
+		}
+		if err := creditCoveredCall(q, userID, accountID, ticker, int(closedID), profitLoss, quantityToClose.Mul(multiplier)); err != nil {
+			return "", err
+		}
+	}
+
+	if optionWashApplies(positionType) {
+		closedID, err := result.LastInsertId()
+		if err != nil {
+			return "", err
+		}
+		if err := washLoss(q, userID, optionWashSecurity(ticker, positionType, strike, expDate), int(closedID), []int{positionID}); err != nil {
+			return "", err
+		}
+	}
+
+	remainingQuantity := currentQuantity.Sub(quantityToClose)
+	if remainingQuantity.Sign() > 0 {
+		remainingCollateral := collateral.Sub(collateralForClosed)
+		_, err = q.Exec(`
+			UPDATE option_positions
+			SET quantity = ?, collateral = ?, wash_adjustment = ?, wash_quantity = ?, fees = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, remainingQuantity, remainingCollateral, washAdjustment.Sub(washForClosed), washQuantity.MulDiv(remainingQuantity, currentQuantity), openFees.Sub(openFeesForClosed), positionID)
+		return effectPartialClose, err
+	}
+
+	if _, err := q.Exec(`DELETE FROM option_positions WHERE id = ?`, positionID); err != nil {
+		return "", err
+	}
+	return effectFullClose, settleWheelCampaign(q, userID, campaignID, trade.Date)
+}
+
+// tradeFees is the part of a trade's fees charged to quantity of the total
+// it traded, such as the shares closed from one lot.
+func tradeFees(fees, quantity, total types.Decimal) types.Decimal {
+	if quantity.Cmp(total) >= 0 {
+		return fees
+	}
+	return fees.MulDiv(quantity, total)
+}
+
+// Outcomes for closing an option position.
+const (
+	OutcomeClosed     = string(pnl.Closed)
+	OutcomeExpired    = string(pnl.Expired)
+	OutcomeAssigned   = string(pnl.Assigned)
+	OutcomeCalledAway = string(pnl.CalledAway)
+	OutcomeExercised  = string(pnl.Exercised)
+)
+
+type optionContract struct {
+	Ticker       string
+	Strike       types.Decimal
+	ExpDate      string
+	PositionType types.OptionType
+	Multiplier   types.Decimal
+}
+
+// outcomeTrades builds the trades that close quantity contracts of a
+// position with the given outcome. Expired, assigned, called away and
+// exercised contracts close at zero, and all but expiry also move
+// quantity*multiplier shares at sharePrice.
+func outcomeTrades(contract optionContract, outcome string, quantity, closePrice, sharePrice types.Decimal, date string) (types.OptionTrade, *types.StockTrade) {
+	var shareTrade *types.StockTrade
+
+	switch outcome {
+	case OutcomeExpired:
+		closePrice = types.Decimal{}
+		date = contract.ExpDate
+
+	case OutcomeAssigned, OutcomeCalledAway, OutcomeExercised:
+		closePrice = types.Decimal{}
+		shares := quantity.Mul(contract.Multiplier)
+
+		code := types.Buy
+		if outcome == OutcomeCalledAway || (outcome == OutcomeExercised && contract.PositionType == types.Put) {
+			code = types.Sell
+		}
+		amount := sharePrice.Mul(shares)
+		if code == types.Buy {
+			amount = amount.Neg()
+		}
+
+		shareTrade = &types.StockTrade{
+			Ticker:   contract.Ticker,
+			Date:     date,
+			Code:     code,
+			Price:    sharePrice,
+			Amount:   amount,
+			Quantity: shares,
+		}
+	}
+
+	_, closeCode, contractType := optionTradeCodes(contract.PositionType)
+	amount := closePrice.Mul(quantity).Mul(contract.Multiplier)
+	if closeCode == types.BTC {
+		amount = amount.Neg()
+	}
+
+	closeTrade := types.OptionTrade{
+		Ticker:     contract.Ticker,
+		Date:       date,
+		Code:       closeCode,
+		Price:      closePrice,
+		Amount:     amount,
+		Quantity:   quantity,
+		Strike:     contract.Strike,
+		ExpDate:    contract.ExpDate,
+		OptionType: contractType,
+		Premium:    closePrice,
+		Multiplier: contract.Multiplier,
+	}
+	return closeTrade, shareTrade
+}
+
+// eventPositionTypes lists the position types an option event can close,
+// in order of preference: assignments hit written contracts and exercises
+// bought ones, while an expiry can close either.
+func eventPositionTypes(trade types.OptionTrade) []types.OptionType {
+	short, long := types.CC, types.Call
+	if trade.OptionType == types.Put {
+		short, long = types.CSP, types.Put
+	}
+
+	switch trade.Code {
+	case types.OASGN:
+		return []types.OptionType{short}
+	case types.OEXCS:
+		return []types.OptionType{long}
+	default:
+		return []types.OptionType{short, long}
+	}
+}
+
+// applyOptionEvent closes the positions matching an expiry, assignment or
+// exercise, oldest first, until the event's contracts are used up,
+// delivering shares at the strike for the contracts closed where the
+// outcome calls for it. The shares are derived from the event rather than
+// recorded as separate ledger trades, so replay reproduces them.
+func applyOptionEvent(q dbtx, userID int, trade types.OptionTrade) (tradeEffect, error) {
+	if trade.Quantity.Sign() <= 0 {
+		return "", fmt.Errorf("%s of %s needs a quantity of contracts", trade.Code, trade.Ticker)
+	}
+
+	type eventPosition struct {
+		id       int
+		quantity types.Decimal
+		contract optionContract
+	}
+	var positions []eventPosition
+	for _, positionType := range eventPositionTypes(trade) {
+		rows, err := q.Query(`
+			SELECT id, quantity, ticker, strike, exp_date, type, multiplier
+			FROM option_positions
+			WHERE user_id = ? AND account_id = ? AND ticker = ? AND strike = ? AND exp_date = ? AND type = ?
+			ORDER BY purchase_date ASC, id ASC
+		`, userID, trade.AccountID, trade.Ticker, trade.Strike, trade.ExpDate, positionType)
+		if err != nil {
+			return "", err
+		}
+		for rows.Next() {
+			var p eventPosition
+			if err := rows.Scan(&p.id, &p.quantity, &p.contract.Ticker, &p.contract.Strike, &p.contract.ExpDate, &p.contract.PositionType, &p.contract.Multiplier); err != nil {
+				rows.Close()
+				return "", err
+			}
+			positions = append(positions, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+	}
+	if len(positions) == 0 {
+		return effectUnmatched, nil
+	}
+
+	var effect tradeEffect
+	var shares *types.StockTrade
+	remaining := trade.Quantity
+	for _, position := range positions {
+		if remaining.Sign() <= 0 {
+			break
+		}
+		quantity := types.MinDecimal(position.quantity, remaining)
+		remaining = remaining.Sub(quantity)
+
+		outcome := OutcomeExpired
+		switch {
+		case trade.Code == types.OEXCS:
+			outcome = OutcomeExercised
+		case trade.Code == types.OASGN && position.contract.PositionType == types.CC:
+			outcome = OutcomeCalledAway
+		case trade.Code == types.OASGN:
+			outcome = OutcomeAssigned
+		}
+
+		closeTrade, shareTrade := outcomeTrades(position.contract, outcome, quantity, types.Decimal{}, position.contract.Strike, trade.Date)
+		closeTrade.ID = trade.ID
+
+		var err error
+		effect, err = closeOptionPosition(q, userID, position.id, closeTrade, outcome)
+		if err != nil {
+			return "", err
+		}
+
+		// Contracts of one event all deliver shares the same way, so the
+		// shares go through as a single trade.
+		if shareTrade == nil {
+			continue
+		}
+		if shares == nil {
+			shares = shareTrade
+			shares.ID = trade.ID
+			shares.AccountID = trade.AccountID
+			if outcome == OutcomeAssigned {
+				shares.OptionTradeID = trade.ID
+			}
+			continue
+		}
+		shares.Quantity = shares.Quantity.Add(shareTrade.Quantity)
+		shares.Amount = shares.Amount.Add(shareTrade.Amount)
+	}
+
+	if shares != nil {
+		if _, err := applyStockTrade(q, userID, *shares); err != nil {
+			return "", err
+		}
+	}
+	return effect, nil
+}
+
+func scanStockTrades(rows *sql.Rows) ([]types.StockTrade, error) {
+	defer rows.Close()
+
+	var trades []types.StockTrade
+	for rows.Next() {
+		var t types.StockTrade
+		if err := rows.Scan(&t.ID, &t.Ticker, &t.Date, &t.Code, &t.Price, &t.Amount, &t.Quantity, &t.Fees); err != nil {
+			return nil, err
+		}
+		trades = append(trades, t)
+	}
+	return trades, rows.Err()
+}
+
+func scanOptionTrades(rows *sql.Rows) ([]types.OptionTrade, error) {
+	defer rows.Close()
+
+	var trades []types.OptionTrade
+	for rows.Next() {
+		var t types.OptionTrade
+		if err := rows.Scan(&t.ID, &t.Ticker, &t.Date, &t.Code, &t.Price, &t.Amount, &t.Quantity, &t.Strike, &t.ExpDate, &t.OptionType, &t.Premium, &t.Multiplier, &t.Fees); err != nil {
+			return nil, err
+		}
+		trades = append(trades, t)
+	}
+	return trades, rows.Err()
+}
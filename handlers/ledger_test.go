@@ -0,0 +1,500 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/types"
+	"bytes"
+	"context"
+	"database/sql"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDB points the handlers at a fresh database in a temporary
+// directory.
+func newTestDB(t *testing.T) {
+	t.Helper()
+	var err error
+	db, err = sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(SchemaSQL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(IndexSQL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testUser creates a user and returns a router that serves the handlers the
+// tests use as that user.
+func testUser(t *testing.T, username string) (int, http.Handler) {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO users (username, password) VALUES (?, '')", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	userID := int(id)
+
+	router := chi.NewMux()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), middleware.UserIDContextKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	router.Post("/api/positions/add", HandleAddPosition)
+	router.Post("/api/positions/close-stock/{id}", HandleCloseStockPosition)
+	router.Post("/api/positions/close-option/{id}", HandleCloseOptionPosition)
+	router.Post("/api/positions/roll-option/{id}", HandleRollOption)
+	router.Post("/api/import-csv", HandleImportCSV)
+	router.Post("/api/import-csv/confirm", HandleImportConfirm)
+	router.Post("/api/capital/check", HandleCapitalCheck)
+	return userID, router
+}
+
+func postForm(t *testing.T, h http.Handler, path string, form url.Values) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code >= 400 {
+		t.Fatalf("POST %s: %d %s", path, w.Code, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+var (
+	tokenInput   = regexp.MustCompile(`name="token" value="([^"]+)"`)
+	selectedRows = regexp.MustCompile(`name="rows" value="(\d+)" checked`)
+)
+
+// importCSV uploads a broker export and confirms every row the preview
+// selects.
+func importCSV(t *testing.T, h http.Handler, content string) {
+	t.Helper()
+	if report := postForm(t, h, "/api/import-csv/confirm", stageCSV(t, h, content)); !strings.Contains(report, "Import Successful") {
+		t.Fatalf("import failed: %s", report)
+	}
+}
+
+// stageCSV uploads a broker export and returns the form that confirms every
+// row the preview selects.
+func stageCSV(t *testing.T, h http.Handler, content string) url.Values {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("csvFile", "trades.csv")
+	part.Write([]byte(content))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/import-csv", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	preview := w.Body.String()
+
+	token := tokenInput.FindStringSubmatch(preview)
+	if token == nil {
+		t.Fatalf("no import preview: %s", preview)
+	}
+	form := url.Values{"token": {token[1]}}
+	for _, row := range selectedRows.FindAllStringSubmatch(preview, -1) {
+		form.Add("rows", row[1])
+	}
+	return form
+}
+
+// closedRows describes a user's closed stocks and options in close order,
+// one line per row, with the P/L to the cent.
+func closedRows(t *testing.T, userID int) []string {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT 'stock ' || ticker, close_date, quantity, profit_loss FROM closed_stocks WHERE user_id = ?
+		UNION ALL
+		SELECT type || ' ' || ticker, close_date, quantity, profit_loss FROM closed_options WHERE user_id = ?
+		ORDER BY 2, 1, 3
+	`, userID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var label, date string
+		var quantity, profitLoss types.Decimal
+		if err := rows.Scan(&label, &date, &quantity, &profitLoss); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, label+" "+date+" x"+quantity.String()+" "+profitLoss.StringFixed(2))
+	}
+	return lines
+}
+
+func firstPositionID(t *testing.T, table string, userID int) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRow("SELECT id FROM "+table+" WHERE user_id = ? AND quantity > 0 ORDER BY id LIMIT 1", userID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// The same trades entered by hand and imported from a broker export close
+// with the same P/L, fees included.
+func TestManualAndImportedClosesMatch(t *testing.T) {
+	newTestDB(t)
+
+	manualID, manual := testUser(t, "manual")
+	postForm(t, manual, "/api/positions/add", url.Values{
+		"positionType": {"stock"}, "ticker": {"AAPL"}, "quantity": {"10"}, "costBasis": {"200"}, "fees": {"0.65"}, "openDate": {"2025-10-01"},
+	})
+	postForm(t, manual, "/api/positions/add", url.Values{
+		"positionType": {"option"}, "ticker": {"AAPL"}, "optionType": {string(types.CSP)}, "strike": {"240"}, "premium": {"2"},
+		"quantity": {"2"}, "fees": {"0.08"}, "expDate": {"2025-10-24"}, "openDate": {"2025-10-10"},
+	})
+	optionID := firstPositionID(t, "option_positions", manualID)
+	postForm(t, manual, "/api/positions/close-option/"+optionID, url.Values{
+		"outcome": {"closed"}, "quantity": {"1"}, "sellPrice": {"0.5"}, "fees": {"0.04"}, "closeDate": {"2025-10-20"},
+	})
+	postForm(t, manual, "/api/positions/close-option/"+optionID, url.Values{
+		"outcome": {"assigned"}, "quantity": {"1"}, "closeDate": {"2025-10-24"},
+	})
+	postForm(t, manual, "/api/positions/close-stock/"+firstPositionID(t, "stock_positions", manualID), url.Values{
+		"quantity": {"50"}, "sellPrice": {"250"}, "fees": {"0.10"}, "closeDate": {"2025-10-27"}, "lot": {string(types.FIFO)},
+	})
+
+	importedID, imported := testUser(t, "imported")
+	importCSV(t, imported, `"Activity Date","Process Date","Settle Date","Instrument","Description","Trans Code","Quantity","Price","Amount"
+"10/27/2025","10/27/2025","10/28/2025","AAPL","Apple","Sell","50","$250.00","$12,499.90"
+"10/24/2025","10/24/2025","10/27/2025","AAPL","AAPL 10/24/2025 Put $240.00","OASGN","1","",""
+"10/20/2025","10/20/2025","10/21/2025","AAPL","AAPL 10/24/2025 Put $240.00","BTC","1","$0.50","($50.04)"
+"10/10/2025","10/10/2025","10/11/2025","AAPL","AAPL 10/24/2025 Put $240.00","STO","2","$2.00","$399.92"
+"10/01/2025","10/01/2025","10/02/2025","AAPL","Apple","Buy","10","$200.00","($2,000.65)"
+`)
+
+	want := closedRows(t, manualID)
+	got := closedRows(t, importedID)
+	if len(want) != 4 {
+		t.Fatalf("manual trades closed %d rows, want 4: %v", len(want), want)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("imported closes differ from manual ones\nimported:\n%s\nmanual:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// Hundreds of partial sells across lots with awkward prices and fees add up
+// to exactly the P/L of selling everything at once: each lot's cost and fees
+// are handed out to its closes without losing or inventing a micro-dollar.
+func TestPartialClosesStayExact(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "partial")
+
+	lots := []struct{ quantity, costBasis, fees, openDate string }{
+		{"600", "33.333333", "9.99", "2025-01-02"},
+		{"400", "12.345678", "4.01", "2025-02-03"},
+	}
+	want := types.Decimal{}
+	for _, lot := range lots {
+		postForm(t, h, "/api/positions/add", url.Values{
+			"positionType": {"stock"}, "ticker": {"XYZ"}, "quantity": {lot.quantity}, "costBasis": {lot.costBasis},
+			"fees": {lot.fees}, "openDate": {lot.openDate},
+		})
+		want = want.Sub(decimal(t, lot.quantity).Mul(decimal(t, lot.costBasis))).Sub(decimal(t, lot.fees))
+	}
+
+	fees := decimal(t, "0.07")
+	remaining := decimal(t, "1000")
+	for i := 0; remaining.Sign() > 0; i++ {
+		quantity := types.MinDecimal(decimal(t, "3.3"), remaining)
+		price := decimal(t, "40").Add(decimal(t, "0.01").Mul(types.DecimalFromInt(int64(i % 50))))
+		postForm(t, h, "/api/positions/close-stock/"+firstPositionID(t, "stock_positions", userID), url.Values{
+			"quantity": {quantity.String()}, "sellPrice": {price.String()}, "fees": {fees.String()},
+			"closeDate": {"2025-03-01"}, "lot": {string(types.FIFO)},
+		})
+		want = want.Add(price.Mul(quantity)).Sub(fees)
+		remaining = remaining.Sub(quantity)
+	}
+
+	var closes int
+	var quantity, profitLoss types.Decimal
+	if err := db.QueryRow(`
+		SELECT COUNT(*), SUM(quantity), SUM(profit_loss) FROM closed_stocks WHERE user_id = ?
+	`, userID).Scan(&closes, &quantity, &profitLoss); err != nil {
+		t.Fatal(err)
+	}
+	if closes < 300 {
+		t.Fatalf("recorded %d closes, want one or two per sell", closes)
+	}
+	if quantity != types.DecimalFromInt(1000) {
+		t.Errorf("closed %s shares, want 1000", quantity)
+	}
+	if profitLoss != want {
+		t.Errorf("P/L of the partial closes = %s, want %s", profitLoss, want)
+	}
+
+	var leftQuantity, leftFees types.Decimal
+	if err := db.QueryRow(`
+		SELECT COALESCE(SUM(quantity), 0), COALESCE(SUM(fees), 0) FROM stock_lots WHERE user_id = ?
+	`, userID).Scan(&leftQuantity, &leftFees); err != nil {
+		t.Fatal(err)
+	}
+	if !leftQuantity.IsZero() || !leftFees.IsZero() {
+		t.Errorf("lots still hold %s shares and %s fees after closing everything", leftQuantity, leftFees)
+	}
+}
+
+func decimal(t *testing.T, s string) types.Decimal {
+	t.Helper()
+	d, err := types.ParseDecimal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// An assignment covering contracts opened in separate trades closes all of
+// them and buys the shares for every contract, not just the oldest.
+func TestAssignmentAcrossPositions(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "assigned")
+	importCSV(t, h, `"Activity Date","Process Date","Settle Date","Instrument","Description","Trans Code","Quantity","Price","Amount"
+"10/24/2025","10/24/2025","10/27/2025","AAPL","AAPL 10/24/2025 Put $240.00","OASGN","3","",""
+"10/10/2025","10/10/2025","10/11/2025","AAPL","AAPL 10/24/2025 Put $240.00","STO","2","$2.00","$400.00"
+"10/01/2025","10/01/2025","10/02/2025","AAPL","AAPL 10/24/2025 Put $240.00","STO","1","$3.00","$300.00"
+`)
+
+	if got := closedRows(t, userID); strings.Join(got, "\n") != strings.Join([]string{
+		"CSP AAPL 2025-10-24 x1 300.00",
+		"CSP AAPL 2025-10-24 x2 400.00",
+	}, "\n") {
+		t.Errorf("closed options:\n%s", strings.Join(got, "\n"))
+	}
+
+	var positions int
+	var shares types.Decimal
+	if err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM option_positions WHERE user_id = ?), COALESCE(SUM(quantity), 0)
+		FROM stock_lots WHERE user_id = ?
+	`, userID, userID).Scan(&positions, &shares); err != nil {
+		t.Fatal(err)
+	}
+	if positions != 0 || shares != types.DecimalFromInt(300) {
+		t.Errorf("%d option positions and %s shares left, want none and 300", positions, shares)
+	}
+}
+
+// Confirming the same staged import from several requests at once saves it
+// once; the rest are told it was already saved.
+func TestConfirmImportOnce(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "twice")
+	form := stageCSV(t, h, `"Activity Date","Process Date","Settle Date","Instrument","Description","Trans Code","Quantity","Price","Amount"
+"10/01/2025","10/01/2025","10/02/2025","AAPL","Apple","Buy","10","$200.00","($2,000.00)"
+`)
+
+	reports := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range reports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/import-csv/confirm", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			reports[i] = w.Body.String()
+		}()
+	}
+	wg.Wait()
+
+	saved := 0
+	for _, report := range reports {
+		switch {
+		case strings.Contains(report, "Import Successful"):
+			saved++
+		case !strings.Contains(report, "already been saved"):
+			t.Errorf("unexpected response: %s", report)
+		}
+	}
+	if saved != 1 {
+		t.Errorf("%d confirms saved the import, want 1", saved)
+	}
+
+	var trades int
+	if err := db.QueryRow("SELECT COUNT(*) FROM stock_trades WHERE user_id = ?", userID).Scan(&trades); err != nil {
+		t.Fatal(err)
+	}
+	if trades != 1 {
+		t.Errorf("recorded %d trades, want 1", trades)
+	}
+}
+
+// A sale's loss is only washed against shares still held after it: lots
+// the same sale closes are not replacements.
+func TestWashSaleIgnoresLotsSoldTogether(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "wash")
+	buy := func(quantity, costBasis, date string) {
+		postForm(t, h, "/api/positions/add", url.Values{
+			"positionType": {"stock"}, "ticker": {"XYZ"}, "quantity": {quantity}, "costBasis": {costBasis}, "openDate": {date},
+		})
+	}
+	sell := func(quantity, date string) {
+		postForm(t, h, "/api/positions/close-stock/"+firstPositionID(t, "stock_positions", userID), url.Values{
+			"quantity": {quantity}, "sellPrice": {"40"}, "closeDate": {date}, "lot": {string(types.FIFO)},
+		})
+	}
+	disallowed := func() types.Decimal {
+		t.Helper()
+		var total types.Decimal
+		if err := db.QueryRow("SELECT COALESCE(SUM(wash_disallowed), 0) FROM closed_stocks WHERE user_id = ?", userID).Scan(&total); err != nil {
+			t.Fatal(err)
+		}
+		return total
+	}
+
+	buy("10", "100", "2025-03-01")
+	buy("10", "50", "2025-03-10")
+	sell("20", "2025-03-15")
+	if got := disallowed(); !got.IsZero() {
+		t.Errorf("selling both lots disallowed %s, want nothing", got)
+	}
+
+	// Half the shares of a losing lot are replaced by a purchase that
+	// stays open.
+	buy("10", "100", "2025-06-01")
+	buy("5", "50", "2025-06-10")
+	sell("10", "2025-06-15")
+	if got, want := disallowed(), types.DecimalFromInt(300); got != want {
+		t.Errorf("disallowed %s, want %s", got, want)
+	}
+}
+
+// A covered call's P/L goes to the shares it covered, oldest lots first,
+// rather than across every share held.
+func TestCoveredCallCreditsCoveredShares(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "covered")
+	for _, lot := range []struct{ quantity, costBasis, openDate string }{
+		{"100", "50", "2025-01-02"},
+		{"200", "60", "2025-02-03"},
+	} {
+		postForm(t, h, "/api/positions/add", url.Values{
+			"positionType": {"stock"}, "ticker": {"XYZ"}, "quantity": {lot.quantity}, "costBasis": {lot.costBasis}, "openDate": {lot.openDate},
+		})
+	}
+	postForm(t, h, "/api/positions/add", url.Values{
+		"positionType": {"option"}, "ticker": {"XYZ"}, "optionType": {string(types.CC)}, "strike": {"70"}, "premium": {"2"},
+		"quantity": {"1.5"}, "expDate": {"2025-04-17"}, "openDate": {"2025-03-02"},
+	})
+	postForm(t, h, "/api/positions/close-option/"+firstPositionID(t, "option_positions", userID), url.Values{
+		"outcome": {"closed"}, "quantity": {"1.5"}, "sellPrice": {"0.5"}, "closeDate": {"2025-03-20"},
+	})
+
+	rows, err := db.Query("SELECT premium_adjustment FROM stock_lots WHERE user_id = ? ORDER BY open_date", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var adjustment types.Decimal
+		if err := rows.Scan(&adjustment); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, adjustment.String())
+	}
+	// 150 shares at $1.50 each: all 100 of the first lot and 50 of the
+	// second.
+	if want := []string{"150", "75"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("lot premium adjustments = %v, want %v", got, want)
+	}
+}
+
+// Two stagings of the same file both see the rows as new; confirming the
+// second must skip what the first saved rather than fail on the
+// fingerprint index.
+func TestConfirmStagedTwice(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "staged")
+	content := `"Activity Date","Process Date","Settle Date","Instrument","Description","Trans Code","Quantity","Price","Amount"
+"10/01/2025","10/01/2025","10/02/2025","AAPL","Apple","Buy","10","$200.00","($2,000.00)"
+"10/02/2025","10/02/2025","10/03/2025","MSFT","Microsoft","Buy","5","$400.00","($2,000.00)"
+`
+	first := stageCSV(t, h, content)
+	second := stageCSV(t, h, content)
+
+	if report := postForm(t, h, "/api/import-csv/confirm", first); !strings.Contains(report, "Import Successful") {
+		t.Fatalf("first import failed: %s", report)
+	}
+	report := postForm(t, h, "/api/import-csv/confirm", second)
+	if !strings.Contains(report, "Import Successful") {
+		t.Fatalf("second import failed: %s", report)
+	}
+	if n := strings.Count(report, "already imported"); n != 2 {
+		t.Errorf("second import reported %d rows as already imported, want 2", n)
+	}
+
+	var trades int
+	if err := db.QueryRow("SELECT COUNT(*) FROM stock_trades WHERE user_id = ?", userID).Scan(&trades); err != nil {
+		t.Fatal(err)
+	}
+	if trades != 2 {
+		t.Errorf("recorded %d trades, want 2", trades)
+	}
+}
+
+// applyStock records a stock trade and applies it, as the handlers do, and
+// returns it with the id it was given.
+func applyStock(t *testing.T, userID int, trade types.StockTrade) types.StockTrade {
+	t.Helper()
+	entry, err := recordStockTrade(db, userID, &trade, SourceManual)
+	if err == nil {
+		_, err = applyLedgerEntry(db, userID, entry)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trade
+}
+
+// applyOption records an option trade and applies it, as the handlers do,
+// and returns it with the id it was given.
+func applyOption(t *testing.T, userID int, trade types.OptionTrade) types.OptionTrade {
+	t.Helper()
+	entry, err := recordOptionTrade(db, userID, &trade, SourceManual)
+	if err == nil {
+		_, err = applyLedgerEntry(db, userID, entry)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trade
+}
+
+// realizedPL totals the P/L of a user's closed stocks.
+func realizedPL(t *testing.T, userID int) types.Decimal {
+	t.Helper()
+	var total types.Decimal
+	if err := db.QueryRow("SELECT COALESCE(SUM(profit_loss), 0) FROM closed_stocks WHERE user_id = ?", userID).Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"backend/types"
+)
+
+// BackfillLedger records ledger trades for positions and history written
+// before the ledger existed. Nothing in the ledger stands behind those rows,
+// so a replay would drop them. The trades are linked to the rows they
+// describe but are not applied, since the rows exist. Users whose cash is
+// already tracked get the cash the trades moved; for the rest BackfillCash
+// builds it from the complete ledger.
+func BackfillLedger(userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var tracksCash bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM cash_transactions WHERE user_id = ?)", userID).Scan(&tracksCash)
+	if err != nil {
+		return err
+	}
+
+	stockTrades, err := backfillLegacyStocks(tx, userID)
+	if err != nil {
+		return err
+	}
+	optionTrades, err := backfillLegacyOptions(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	if tracksCash {
+		for _, trade := range stockTrades {
+			if err := postStockTradeCash(tx, userID, trade); err != nil {
+				return err
+			}
+		}
+		for _, trade := range optionTrades {
+			if err := postOptionTradeCash(tx, userID, trade); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+type legacyStockRow struct {
+	id, accountID               int
+	ticker, openDate, closeDate string
+	side                        types.PositionSide
+	quantity, costBasis, price  types.Decimal
+	fees                        types.Decimal
+	openTradeID                 string
+}
+
+// backfillLegacyStocks records an opening trade for every lot the ledger
+// didn't open, sized to cover the shares ledger trades later sold from it,
+// and an opening and a closing trade for every sale the ledger didn't make.
+// It returns the trades it recorded.
+func backfillLegacyStocks(q dbtx, userID int) ([]types.StockTrade, error) {
+	rows, err := q.Query(`
+		SELECT l.id, l.ticker, l.side, l.open_date, l.quantity + COALESCE((
+			SELECT SUM(c.quantity) FROM closed_stocks c
+			WHERE c.lot_id = l.id AND c.close_trade_id IN (`+ledgerTradeIDs+`)
+		), 0), l.cost_basis, l.fees, l.account_id, l.open_trade_id
+		FROM stock_lots l
+		WHERE l.user_id = ? AND l.open_trade_id NOT IN (`+ledgerTradeIDs+`)
+		ORDER BY l.open_date, l.id
+	`, userID, userID, userID, userID, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var recorded []types.StockTrade
+	var lots []legacyStockRow
+	for rows.Next() {
+		var r legacyStockRow
+		if err := rows.Scan(&r.id, &r.ticker, &r.side, &r.openDate, &r.quantity, &r.costBasis, &r.fees, &r.accountID, &r.openTradeID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		lots = append(lots, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, lot := range lots {
+		if lot.quantity.Sign() <= 0 {
+			continue
+		}
+		open, err := recordLegacyStockOpen(q, userID, lot)
+		if err != nil {
+			return nil, err
+		}
+		recorded = append(recorded, open)
+		if _, err := q.Exec("UPDATE stock_lots SET open_trade_id = ? WHERE id = ?", open.ID, lot.id); err != nil {
+			return nil, err
+		}
+		// Sales that named the lot follow it to its new trade.
+		if lot.openTradeID != "" {
+			_, err := q.Exec("UPDATE stock_trades SET lot_trade_id = ? WHERE user_id = ? AND lot_trade_id = ?", open.ID, userID, lot.openTradeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows, err = q.Query(`
+		SELECT id, ticker, side, open_date, close_date, quantity, cost_basis, sell_price, fees, account_id
+		FROM closed_stocks
+		WHERE user_id = ? AND close_trade_id NOT IN (`+ledgerTradeIDs+`)
+		ORDER BY close_date, id
+	`, userID, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var sales []legacyStockRow
+	for rows.Next() {
+		var r legacyStockRow
+		if err := rows.Scan(&r.id, &r.ticker, &r.side, &r.openDate, &r.closeDate, &r.quantity, &r.costBasis, &r.price, &r.fees, &r.accountID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sales = append(sales, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, sale := range sales {
+		open, err := recordLegacyStockOpen(q, userID, sale)
+		if err != nil {
+			return nil, err
+		}
+		recorded = append(recorded, open)
+
+		code := types.Sell
+		amount := sale.price.Mul(sale.quantity)
+		if sale.side == types.Short {
+			code = types.BuyToCover
+			amount = amount.Neg()
+		}
+		closeTrade := types.StockTrade{
+			Ticker:     sale.ticker,
+			Date:       sale.closeDate,
+			Code:       code,
+			Price:      sale.price,
+			Amount:     amount,
+			Quantity:   sale.quantity,
+			LotMethod:  types.SpecificLot,
+			LotTradeID: open.ID,
+			AccountID:  sale.accountID,
+		}
+		if _, err := recordStockTrade(q, userID, &closeTrade, SourceMigration); err != nil {
+			return nil, err
+		}
+		recorded = append(recorded, closeTrade)
+		if _, err := q.Exec("UPDATE closed_stocks SET close_trade_id = ? WHERE id = ?", closeTrade.ID, sale.id); err != nil {
+			return nil, err
+		}
+	}
+	return recorded, nil
+}
+
+// recordLegacyStockOpen records the trade that opened a legacy lot or sale.
+// The row's fees all go on the opening, which passes them on to the sale.
+func recordLegacyStockOpen(q dbtx, userID int, r legacyStockRow) (types.StockTrade, error) {
+	code := types.Buy
+	amount := r.costBasis.Mul(r.quantity).Neg()
+	if r.side == types.Short {
+		code = types.SellShort
+		amount = amount.Neg()
+	}
+	trade := types.StockTrade{
+		Ticker:    r.ticker,
+		Date:      r.openDate,
+		Code:      code,
+		Price:     r.costBasis,
+		Amount:    amount.Sub(r.fees),
+		Quantity:  r.quantity,
+		Fees:      r.fees,
+		AccountID: r.accountID,
+	}
+	_, err := recordStockTrade(q, userID, &trade, SourceMigration)
+	return trade, err
+}
+
+type legacyOptionRow struct {
+	id, accountID                    int
+	ticker, expDate, openDate        string
+	closeDate                        string
+	positionType                     types.OptionType
+	strike, premium, quantity, price types.Decimal
+	multiplier, fees                 types.Decimal
+	closed                           bool
+	openTradeID, closeTradeID        string
+}
+
+// backfillLegacyOptions records an opening trade for every open or closed
+// option row the ledger didn't open, and a closing trade at the recorded
+// price for every closed row it didn't close. Closed rows are opened first
+// so ledger closes of a partly closed legacy position still find the
+// contracts they closed. It returns the trades it recorded.
+func backfillLegacyOptions(q dbtx, userID int) ([]types.OptionTrade, error) {
+	rows, err := q.Query(`
+		SELECT id, ticker, strike, exp_date, type, premium, quantity, purchase_date, multiplier, fees, account_id, close_date, sell_price, open_trade_id, close_trade_id, 1
+		FROM closed_options
+		WHERE user_id = ? AND (open_trade_id NOT IN (`+ledgerTradeIDs+`) OR close_trade_id NOT IN (`+ledgerTradeIDs+`))
+		UNION ALL
+		SELECT id, ticker, strike, exp_date, type, premium, quantity, purchase_date, multiplier, fees, account_id, '', 0, open_trade_id, '', 0
+		FROM option_positions
+		WHERE user_id = ? AND quantity > 0 AND open_trade_id NOT IN (`+ledgerTradeIDs+`)
+		ORDER BY 16 DESC, 8, 1
+	`, userID, userID, userID, userID, userID, userID, userID, userID, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var recorded []types.OptionTrade
+	var legacy []legacyOptionRow
+	for rows.Next() {
+		var r legacyOptionRow
+		err := rows.Scan(&r.id, &r.ticker, &r.strike, &r.expDate, &r.positionType, &r.premium, &r.quantity, &r.openDate,
+			&r.multiplier, &r.fees, &r.accountID, &r.closeDate, &r.price, &r.openTradeID, &r.closeTradeID, &r.closed)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		legacy = append(legacy, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ledgerIDs := map[string]bool{}
+	rows, err = q.Query(ledgerTradeIDs, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ledgerIDs[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range legacy {
+		table := "option_positions"
+		if r.closed {
+			table = "closed_options"
+		}
+
+		if !ledgerIDs[r.openTradeID] {
+			open := openingOptionTrade(r.ticker, r.openDate, r.positionType, r.strike, r.premium, r.quantity, r.multiplier, r.expDate)
+			open.Amount = open.Amount.Sub(r.fees)
+			open.Fees = r.fees
+			open.AccountID = r.accountID
+			if _, err := recordOptionTrade(q, userID, &open, SourceMigration); err != nil {
+				return nil, err
+			}
+			recorded = append(recorded, open)
+			if _, err := q.Exec("UPDATE "+table+" SET open_trade_id = ? WHERE id = ?", open.ID, r.id); err != nil {
+				return nil, err
+			}
+			// Closes entered against the position follow it to its new trade.
+			if r.openTradeID != "" {
+				_, err := q.Exec("UPDATE option_trades SET position_trade_id = ? WHERE user_id = ? AND position_trade_id = ?", open.ID, userID, r.openTradeID)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if !r.closed || ledgerIDs[r.closeTradeID] {
+			continue
+		}
+		contract := optionContract{
+			Ticker:       r.ticker,
+			Strike:       r.strike,
+			ExpDate:      r.expDate,
+			PositionType: r.positionType,
+			Multiplier:   r.multiplier,
+		}
+		closeTrade, _ := outcomeTrades(contract, OutcomeClosed, r.quantity, r.price, types.Decimal{}, r.closeDate)
+		closeTrade.AccountID = r.accountID
+		if _, err := recordOptionTrade(q, userID, &closeTrade, SourceMigration); err != nil {
+			return nil, err
+		}
+		recorded = append(recorded, closeTrade)
+		if _, err := q.Exec("UPDATE closed_options SET close_trade_id = ? WHERE id = ?", closeTrade.ID, r.id); err != nil {
+			return nil, err
+		}
+	}
+	return recorded, nil
+}
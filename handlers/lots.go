@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"backend/pnl"
+	"backend/types"
+	"database/sql"
+	"sort"
+)
+
+var lotMethods = []types.LotMethod{types.FIFO, types.LIFO, types.HIFO, types.AverageCost}
+
+func lotMethodLabel(method types.LotMethod) string {
+	switch method {
+	case types.LIFO:
+		return "LIFO (last in, first out)"
+	case types.HIFO:
+		return "HIFO (highest cost first)"
+	case types.AverageCost:
+		return "Average cost"
+	case types.SpecificLot:
+		return "Specific lot"
+	}
+	return "FIFO (first in, first out)"
+}
+
+func validLotMethod(method types.LotMethod) bool {
+	for _, m := range lotMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// userLotMethod returns the lot method the user sells with by default.
+func userLotMethod(q dbtx, userID int) (types.LotMethod, error) {
+	var method types.LotMethod
+	err := q.QueryRow("SELECT lot_method FROM user_settings WHERE user_id = ?", userID).Scan(&method)
+	if err == sql.ErrNoRows || (err == nil && !validLotMethod(method)) {
+		return types.FIFO, nil
+	}
+	return method, err
+}
+
+func setUserLotMethod(q dbtx, userID int, method types.LotMethod) error {
+	_, err := q.Exec(`
+		INSERT INTO user_settings (user_id, lot_method) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET lot_method = excluded.lot_method, updated_at = CURRENT_TIMESTAMP
+	`, userID, method)
+	return err
+}
+
+func openStockLots(q dbtx, userID, accountID int, ticker string, side types.PositionSide) ([]types.StockLot, error) {
+	rows, err := q.Query(`
+		SELECT id, ticker, side, open_date, quantity, cost_basis, open_trade_id, wash_adjustment, wash_quantity, campaign_id, premium_adjustment, fees, account_id
+		FROM stock_lots
+		WHERE user_id = ? AND account_id = ? AND ticker = ? AND side = ? AND quantity > 0
+		ORDER BY open_date ASC, id ASC
+	`, userID, accountID, ticker, side)
+	if err != nil {
+		return nil, err
+	}
+	return scanStockLots(rows)
+}
+
+func scanStockLots(rows *sql.Rows) ([]types.StockLot, error) {
+	defer rows.Close()
+
+	var lots []types.StockLot
+	for rows.Next() {
+		var lot types.StockLot
+		if err := rows.Scan(&lot.ID, &lot.Ticker, &lot.Side, &lot.OpenDate, &lot.Quantity, &lot.CostBasis, &lot.OpenTradeID, &lot.WashAdjustment, &lot.WashQuantity, &lot.CampaignID, &lot.PremiumAdjustment, &lot.Fees, &lot.AccountID); err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+	return lots, rows.Err()
+}
+
+func openStockLot(q dbtx, userID int, trade types.StockTrade, side types.PositionSide, quantity types.Decimal) error {
+	// Shares bought while a wheel is running, usually by assignment, are
+	// part of the wheel.
+	var campaignID int
+	if side == types.Long {
+		var err error
+		campaignID, err = openWheelCampaign(q, userID, trade.AccountID, trade.Ticker)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := q.Exec(`
+		INSERT INTO stock_lots (user_id, ticker, side, open_date, quantity, cost_basis, open_trade_id, campaign_id, fees, account_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, trade.Ticker, side, trade.Date, quantity, trade.Price, trade.ID, campaignID, tradeFees(trade.Fees, quantity, trade.Quantity), trade.AccountID)
+	if err != nil || side != types.Long {
+		return err
+	}
+
+	lotID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := creditAssignedPut(q, userID, trade, int(lotID), quantity); err != nil {
+		return err
+	}
+	return washPurchase(q, userID, stockWashSecurity(trade.Ticker), int(lotID), trade.Date, quantity)
+}
+
+// orderLots sorts open lots, oldest first, into the order a sale consumes
+// them. A specific lot goes first and the rest follow FIFO; average cost
+// also sells FIFO so open dates stay meaningful.
+func orderLots(lots []types.StockLot, method types.LotMethod, lotTradeID string) {
+	switch method {
+	case types.LIFO:
+		for i, j := 0, len(lots)-1; i < j; i, j = i+1, j-1 {
+			lots[i], lots[j] = lots[j], lots[i]
+		}
+	case types.HIFO:
+		sort.SliceStable(lots, func(i, j int) bool { return lots[i].CostBasis.Cmp(lots[j].CostBasis) > 0 })
+	case types.SpecificLot:
+		sort.SliceStable(lots, func(i, j int) bool {
+			return lots[i].OpenTradeID == lotTradeID && lots[j].OpenTradeID != lotTradeID
+		})
+	}
+}
+
+// closeStockLots closes up to trade.Quantity shares across lots, which are
+// all on one side of the ticker in the trade's account, writing one
+// closed_stocks row per lot touched, and returns the quantity closed. Trades
+// without a lot method, such as shares called away by an option, use FIFO.
+func closeStockLots(q dbtx, userID int, trade types.StockTrade, lots []types.StockLot) (types.Decimal, error) {
+	orderLots(lots, trade.LotMethod, trade.LotTradeID)
+	side := lots[0].Side
+
+	if trade.LotMethod == types.AverageCost {
+		var quantity, cost types.Decimal
+		for _, lot := range lots {
+			quantity = quantity.Add(lot.Quantity)
+			cost = cost.Add(lot.Quantity.Mul(lot.CostBasis))
+		}
+		average := cost.Div(quantity)
+		for i := range lots {
+			lots[i].CostBasis = average
+		}
+		_, err := q.Exec(`
+			UPDATE stock_lots
+			SET cost_basis = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND account_id = ? AND ticker = ? AND side = ? AND quantity > 0
+		`, average, userID, trade.AccountID, trade.Ticker, side)
+		if err != nil {
+			return types.Decimal{}, err
+		}
+	}
+
+	remaining := trade.Quantity
+	var closed types.Decimal
+	var closedIDs, soldIDs []int
+	for _, lot := range lots {
+		if remaining.Sign() <= 0 {
+			break
+		}
+
+		quantity := types.MinDecimal(lot.Quantity, remaining)
+		remaining = remaining.Sub(quantity)
+
+		// Wash sale adjustments leave the lot with the shares they cover.
+		washAdjustment := lot.WashAdjustment.MulDiv(quantity, lot.Quantity)
+		premiumAdjustment := lot.PremiumAdjustment.MulDiv(quantity, lot.Quantity)
+		openFees := lot.Fees.MulDiv(quantity, lot.Quantity)
+		left := lot.Quantity.Sub(quantity)
+
+		// Cost, proceeds and the trade's fees are split as the difference
+		// between the amounts before and after this lot, so however many
+		// partial closes a lot or a trade is cut into, they add up exactly.
+		cost := lot.CostBasis.Mul(lot.Quantity).Sub(lot.CostBasis.Mul(left))
+		proceeds := trade.Price.Mul(closed.Add(quantity)).Sub(trade.Price.Mul(closed))
+		closeFees := tradeFees(trade.Fees, closed.Add(quantity), trade.Quantity).Sub(tradeFees(trade.Fees, closed, trade.Quantity))
+		closed = closed.Add(quantity)
+
+		// The sale pays the fees of opening the shares and its own share
+		// of the trade's fees.
+		fees := openFees.Add(closeFees)
+		profitLoss := pnl.StockSaleTotal(side, cost, proceeds, fees)
+		result, err := q.Exec(`
+			INSERT INTO closed_stocks (user_id, ticker, side, open_date, close_date, quantity, cost_basis, sell_price, profit_loss, close_trade_id, lot_id, wash_adjustment, campaign_id, premium_adjustment, fees, account_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, userID, trade.Ticker, side, lot.OpenDate, trade.Date, quantity, lot.CostBasis, trade.Price, profitLoss, trade.ID, lot.ID, washAdjustment, lot.CampaignID, premiumAdjustment, fees, lot.AccountID)
+		if err != nil {
+			return types.Decimal{}, err
+		}
+
+		_, err = q.Exec(`
+			UPDATE stock_lots
+			SET quantity = ?, wash_adjustment = ?, wash_quantity = ?, premium_adjustment = ?, fees = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, left, lot.WashAdjustment.Sub(washAdjustment), lot.WashQuantity.MulDiv(left, lot.Quantity), lot.PremiumAdjustment.Sub(premiumAdjustment), lot.Fees.Sub(openFees), lot.ID)
+		if err != nil {
+			return types.Decimal{}, err
+		}
+
+		if err := settleWheelCampaign(q, userID, lot.CampaignID, trade.Date); err != nil {
+			return types.Decimal{}, err
+		}
+
+		closedID, err := result.LastInsertId()
+		if err != nil {
+			return types.Decimal{}, err
+		}
+		closedIDs = append(closedIDs, int(closedID))
+		soldIDs = append(soldIDs, lot.ID)
+	}
+
+	// Losses are matched once every lot the sale touches is closed, so
+	// none of them counts as a replacement for another.
+	if side == types.Long {
+		for _, closedID := range closedIDs {
+			if err := washLoss(q, userID, stockWashSecurity(trade.Ticker), closedID, soldIDs); err != nil {
+				return types.Decimal{}, err
+			}
+		}
+	}
+	return trade.Quantity.Sub(types.MaxDecimal(remaining, types.Decimal{})), nil
+}
+
+// syncStockPosition rewrites the ticker's stock_positions row in an account
+// from its open lots: side, total quantity, weighted cost basis and the
+// oldest open date. It reports whether the ticker is still open.
+func syncStockPosition(q dbtx, userID, accountID int, ticker string) (bool, error) {
+	var side types.PositionSide
+	var quantity, cost types.Decimal
+	var openDate string
+	for _, s := range []types.PositionSide{types.Long, types.Short} {
+		lots, err := openStockLots(q, userID, accountID, ticker, s)
+		if err != nil {
+			return false, err
+		}
+		var sideQuantity, sideCost types.Decimal
+		for _, lot := range lots {
+			sideQuantity = sideQuantity.Add(lot.Quantity)
+			sideCost = sideCost.Add(lot.Quantity.Mul(lot.CostBasis))
+		}
+		if len(lots) > 0 && sideQuantity.Cmp(quantity) > 0 {
+			side, quantity, cost, openDate = s, sideQuantity, sideCost, lots[0].OpenDate
+		}
+	}
+	if quantity.IsZero() {
+		_, err := q.Exec("DELETE FROM stock_positions WHERE user_id = ? AND account_id = ? AND ticker = ?", userID, accountID, ticker)
+		return false, err
+	}
+
+	result, err := q.Exec(`
+		UPDATE stock_positions
+		SET side = ?, quantity = ?, cost_basis = ?, open_date = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND account_id = ? AND ticker = ?
+	`, side, quantity, cost.Div(quantity), openDate, userID, accountID, ticker)
+	if err != nil {
+		return false, err
+	}
+	if updated, _ := result.RowsAffected(); updated > 0 {
+		return true, nil
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO stock_positions (user_id, account_id, ticker, side, quantity, cost_basis, open_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, accountID, ticker, side, quantity, cost.Div(quantity), openDate)
+	return true, err
+}
+
+// syncTickerPositions refreshes the ticker's position in every account
+// that holds it or has a position row for it.
+func syncTickerPositions(q dbtx, userID int, ticker string) error {
+	rows, err := q.Query(`
+		SELECT account_id FROM stock_lots WHERE user_id = ? AND ticker = ?
+		UNION
+		SELECT account_id FROM stock_positions WHERE user_id = ? AND ticker = ?
+	`, userID, ticker, userID, ticker)
+	if err != nil {
+		return err
+	}
+	var accounts []int
+	for rows.Next() {
+		var accountID int
+		if err := rows.Scan(&accountID); err != nil {
+			rows.Close()
+			return err
+		}
+		accounts = append(accounts, accountID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, accountID := range accounts {
+		if _, err := syncStockPosition(q, userID, accountID, ticker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"backend/types"
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestOrderLots(t *testing.T) {
+	tests := []struct {
+		method     types.LotMethod
+		lotTradeID string
+		want       []int
+	}{
+		{types.FIFO, "", []int{1, 2, 3, 4}},
+		{"", "", []int{1, 2, 3, 4}},
+		{types.LIFO, "", []int{4, 3, 2, 1}},
+		// Equal costs keep their FIFO order.
+		{types.HIFO, "", []int{2, 4, 3, 1}},
+		{types.AverageCost, "", []int{1, 2, 3, 4}},
+		{types.SpecificLot, "t3", []int{3, 1, 2, 4}},
+		{types.SpecificLot, "missing", []int{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		lots := []types.StockLot{
+			{ID: 1, CostBasis: types.DecimalFromInt(100), OpenTradeID: "t1"},
+			{ID: 2, CostBasis: types.DecimalFromInt(120), OpenTradeID: "t2"},
+			{ID: 3, CostBasis: types.DecimalFromInt(110), OpenTradeID: "t3"},
+			{ID: 4, CostBasis: types.DecimalFromInt(120), OpenTradeID: "t4"},
+		}
+		orderLots(lots, tt.method, tt.lotTradeID)
+
+		var got []int
+		for _, lot := range lots {
+			got = append(got, lot.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("orderLots(%q, %q) = %v, want %v", tt.method, tt.lotTradeID, got, tt.want)
+		}
+	}
+}
+
+// Selling 15 of 30 shares bought at $100, $120 and $110 realizes a
+// different P/L under each lot method.
+func TestSellWithLotMethods(t *testing.T) {
+	tests := []struct {
+		method   types.LotMethod
+		specific int
+		want     string
+		left     string
+	}{
+		// 10 x $30 + 5 x $10
+		{types.FIFO, -1, "350", "113.333333"},
+		// 10 x $20 + 5 x $10
+		{types.LIFO, -1, "250", "106.666667"},
+		// 10 x $10 + 5 x $20
+		{types.HIFO, -1, "200", "103.333333"},
+		// 15 x $20 at the $110 average
+		{types.AverageCost, -1, "300", "110"},
+		// The March lot, then FIFO: 10 x $20 + 5 x $30
+		{types.SpecificLot, 2, "350", "113.333333"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			newTestDB(t)
+			userID, _ := testUser(t, "lots")
+
+			var buys []types.StockTrade
+			for i, price := range []string{"100", "120", "110"} {
+				buys = append(buys, applyStock(t, userID, types.StockTrade{
+					Ticker:   "AAPL",
+					Date:     fmt.Sprintf("2025-0%d-02", i+1),
+					Code:     types.Buy,
+					Price:    decimal(t, price),
+					Quantity: types.DecimalFromInt(10),
+				}))
+			}
+			sale := types.StockTrade{
+				Ticker:    "AAPL",
+				Date:      "2025-06-02",
+				Code:      types.Sell,
+				Price:     types.DecimalFromInt(130),
+				Quantity:  types.DecimalFromInt(15),
+				LotMethod: tt.method,
+			}
+			if tt.specific >= 0 {
+				sale.LotTradeID = buys[tt.specific].ID
+			}
+			applyStock(t, userID, sale)
+
+			if got := realizedPL(t, userID); got != decimal(t, tt.want) {
+				t.Errorf("realized %s, want %s", got, tt.want)
+			}
+
+			var quantity, basis types.Decimal
+			if err := db.QueryRow("SELECT quantity, cost_basis FROM stock_positions WHERE user_id = ?", userID).Scan(&quantity, &basis); err != nil {
+				t.Fatal(err)
+			}
+			if quantity != types.DecimalFromInt(15) || basis != decimal(t, tt.left) {
+				t.Errorf("left %s shares at $%s, want 15 at $%s", quantity, basis, tt.left)
+			}
+		})
+	}
+}
+
+// Selling more than is held goes short, and buying more than is short goes
+// long, closing the other side first.
+func TestShortSales(t *testing.T) {
+	type trade struct {
+		code            types.TradeCode
+		quantity, price string
+	}
+	tests := []struct {
+		name     string
+		trades   []trade
+		realized string
+		side     types.PositionSide
+		quantity string
+		basis    string
+	}{
+		{"partial cover", []trade{{types.SellShort, "10", "50"}, {types.BuyToCover, "4", "40"}}, "40", types.Short, "6", "50"},
+		{"cover at a loss", []trade{{types.SellShort, "10", "50"}, {types.BuyToCover, "10", "55.5"}}, "-55", "", "0", "0"},
+		{"sale past a long", []trade{{types.Buy, "5", "20"}, {types.Sell, "8", "25"}}, "25", types.Short, "3", "25"},
+		{"buy past a short", []trade{{types.SellShort, "10", "50"}, {types.Buy, "12", "45"}}, "50", types.Long, "2", "45"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDB(t)
+			userID, _ := testUser(t, "short")
+			for i, tr := range tt.trades {
+				applyStock(t, userID, types.StockTrade{
+					Ticker:   "TSLA",
+					Date:     fmt.Sprintf("2025-03-0%d", i+1),
+					Code:     tr.code,
+					Price:    decimal(t, tr.price),
+					Quantity: decimal(t, tr.quantity),
+				})
+			}
+
+			if got := realizedPL(t, userID); got != decimal(t, tt.realized) {
+				t.Errorf("realized %s, want %s", got, tt.realized)
+			}
+
+			var side types.PositionSide
+			var quantity, basis types.Decimal
+			err := db.QueryRow("SELECT side, quantity, cost_basis FROM stock_positions WHERE user_id = ?", userID).Scan(&side, &quantity, &basis)
+			if err == sql.ErrNoRows {
+				err = nil
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if side != tt.side || quantity != decimal(t, tt.quantity) || basis != decimal(t, tt.basis) {
+				t.Errorf("left %s %s at $%s, want %s %s at $%s", side, quantity, basis, tt.side, tt.quantity, tt.basis)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// performanceDay is what happened to an account's book value on one day:
+// money deposited or withdrawn, and money gained or lost.
+type performanceDay struct {
+	Date string
+	Flow types.Decimal
+	Gain types.Decimal
+}
+
+// performanceDays collects the external cash flows and the realized gains,
+// dividends, interest and fees in scope by day, oldest first. Open positions
+// count at cost until they close, since there is no market price to value
+// them at.
+func performanceDays(q dbtx, userID int, scope accountScope) ([]performanceDay, error) {
+	days := map[string]*performanceDay{}
+	add := func(query string, args []interface{}, external bool) error {
+		rows, err := q.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var date string
+			var amount types.Decimal
+			if err := rows.Scan(&date, &amount); err != nil {
+				return err
+			}
+			date = NormalizeDateToISO(date)
+			day := days[date]
+			if day == nil {
+				day = &performanceDay{Date: date}
+				days[date] = day
+			}
+			if external {
+				day.Flow = day.Flow.Add(amount)
+			} else {
+				day.Gain = day.Gain.Add(amount)
+			}
+		}
+		return rows.Err()
+	}
+
+	filter := scope.filter("account_id")
+	queries := []struct {
+		query    string
+		args     []interface{}
+		external bool
+	}{
+		{"SELECT date, amount FROM cash_transactions WHERE user_id = ?" + filter + " AND kind IN (?, ?, ?)",
+			append(scope.args(userID), types.Deposit, types.Withdrawal, types.StartingBalance), true},
+		{"SELECT date, amount FROM cash_transactions WHERE user_id = ?" + filter + " AND kind IN (?, ?, ?)",
+			append(scope.args(userID), types.Dividend, types.Interest, types.Fee), false},
+		{"SELECT close_date, profit_loss FROM closed_stocks WHERE user_id = ?" + filter, scope.args(userID), false},
+		{"SELECT close_date, profit_loss FROM closed_options WHERE user_id = ?" + filter, scope.args(userID), false},
+	}
+	for _, query := range queries {
+		if err := add(query.query, query.args, query.external); err != nil {
+			return nil, err
+		}
+	}
+
+	var ordered []performanceDay
+	for _, day := range days {
+		ordered = append(ordered, *day)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Date < ordered[j].Date })
+	return ordered, nil
+}
+
+// timeWeightedReturn chains the returns between external flows, so the
+// result doesn't depend on when money was added or taken out. Flows count
+// at the start of their day. Periods that begin with nothing invested are
+// skipped; ok is false when no period had money in it.
+func timeWeightedReturn(days []performanceDay) (float64, bool) {
+	growth, ok := 1.0, false
+	var value, periodStart types.Decimal
+	closePeriod := func() {
+		if periodStart.Sign() > 0 {
+			growth *= value.Float() / periodStart.Float()
+			ok = true
+		}
+	}
+
+	for _, day := range days {
+		if !day.Flow.IsZero() {
+			closePeriod()
+			value = value.Add(day.Flow)
+			periodStart = value
+		}
+		value = value.Add(day.Gain)
+	}
+	closePeriod()
+	return growth - 1, ok
+}
+
+// moneyWeightedReturn is the annualized internal rate of return of the
+// external flows, with the ending value treated as withdrawn on the end
+// date. It is found by bisection; ok is false when there is no rate at
+// which the flows balance.
+func moneyWeightedReturn(days []performanceDay, endValue types.Decimal, endDate string) (float64, bool) {
+	type flow struct {
+		years  float64
+		amount float64
+	}
+
+	var flows []flow
+	var start time.Time
+	for _, day := range days {
+		if day.Flow.IsZero() {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", day.Date)
+		if err != nil {
+			continue
+		}
+		if len(flows) == 0 {
+			start = date
+		}
+		// From the investor's side a deposit is money paid out.
+		flows = append(flows, flow{date.Sub(start).Hours() / 24 / 365, -day.Flow.Float()})
+	}
+	end, err := time.Parse("2006-01-02", endDate)
+	if len(flows) == 0 || err != nil || !end.After(start) {
+		return 0, false
+	}
+	flows = append(flows, flow{end.Sub(start).Hours() / 24 / 365, endValue.Float()})
+
+	npv := func(rate float64) float64 {
+		var total float64
+		for _, f := range flows {
+			total += f.amount / math.Pow(1+rate, f.years)
+		}
+		return total
+	}
+
+	low, high := -0.9999, 100.0
+	if npv(low)*npv(high) > 0 {
+		return 0, false
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		if npv(low)*npv(mid) <= 0 {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+	return (low + high) / 2, true
+}
+
+// equityCurve plots the book value after each day as SVG polyline points,
+// spaced by date.
+func equityCurve(days []performanceDay, width, height float64) (string, types.Decimal, types.Decimal) {
+	var values []types.Decimal
+	var dates []time.Time
+	var value types.Decimal
+	for _, day := range days {
+		date, err := time.Parse("2006-01-02", day.Date)
+		if err != nil {
+			continue
+		}
+		value = value.Add(day.Flow).Add(day.Gain)
+		values = append(values, value)
+		dates = append(dates, date)
+	}
+	if len(values) == 0 {
+		return "", types.Decimal{}, types.Decimal{}
+	}
+
+	low, high := values[0], values[0]
+	for _, v := range values {
+		low, high = types.MinDecimal(low, v), types.MaxDecimal(high, v)
+	}
+	span := dates[len(dates)-1].Sub(dates[0]).Hours()
+	valueRange := high.Sub(low).Float()
+
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := 0.0
+		if span > 0 {
+			x = dates[i].Sub(dates[0]).Hours() / span * width
+		}
+		y := height / 2
+		if valueRange > 0 {
+			y = height - v.Sub(low).Float()/valueRange*height
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	// A single day still draws as a flat line across the chart.
+	if len(points) == 1 {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", width, height/2))
+	}
+	return strings.Join(points, " "), low, high
+}
+
+func HandlePerformance(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scope := requestAccountScope(r, userID)
+	cash, err := cashBalance(db, userID, scope)
+	if err != nil {
+		http.Error(w, "Failed to load cash balance", http.StatusInternalServerError)
+		return
+	}
+	days, err := performanceDays(db, userID, scope)
+	if err != nil {
+		http.Error(w, "Failed to load performance", http.StatusInternalServerError)
+		return
+	}
+
+	data := components.PerformanceData{Cash: cash}
+	for _, day := range days {
+		data.NetDeposits = data.NetDeposits.Add(day.Flow)
+		data.BookValue = data.BookValue.Add(day.Flow).Add(day.Gain)
+	}
+	if len(days) > 0 {
+		today := time.Now().Format("2006-01-02")
+		data.TWR, data.HasTWR = timeWeightedReturn(days)
+		data.MWR, data.HasMWR = moneyWeightedReturn(days, data.BookValue, today)
+		data.Curve, data.CurveLow, data.CurveHigh = equityCurve(days, components.CurveWidth, components.CurveHeight)
+		data.CurveStart = FormatDate(days[0].Date)
+		data.CurveEnd = FormatDate(days[len(days)-1].Date)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.PerformanceCards(data).Render(r.Context(), w)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"backend/types"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// bookDay is one performanceDay, with its flow and gain in dollars.
+func bookDay(t *testing.T, date, flow, gain string) performanceDay {
+	t.Helper()
+	return performanceDay{Date: date, Flow: decimal(t, flow), Gain: decimal(t, gain)}
+}
+
+func TestTimeWeightedReturn(t *testing.T) {
+	tests := []struct {
+		name string
+		days []performanceDay
+		want float64
+		ok   bool
+	}{
+		{"no activity", nil, 0, false},
+		{"gains without money in", []performanceDay{bookDay(t, "2025-01-02", "0", "50")}, 0, false},
+		{"one deposit", []performanceDay{bookDay(t, "2025-01-02", "1000", "0"), bookDay(t, "2025-02-03", "0", "100")}, 0.10, true},
+		// The deposit counts at the start of its day.
+		{"gain on the deposit day", []performanceDay{bookDay(t, "2025-01-02", "1000", "50")}, 0.05, true},
+		// +10% then -10%, whatever was added in between.
+		{"deposit between periods", []performanceDay{
+			bookDay(t, "2025-01-02", "1000", "0"),
+			bookDay(t, "2025-02-03", "0", "100"),
+			bookDay(t, "2025-03-03", "1100", "0"),
+			bookDay(t, "2025-04-01", "0", "-220"),
+		}, -0.01, true},
+		// The empty stretch after withdrawing everything is skipped.
+		{"withdrawn and redeposited", []performanceDay{
+			bookDay(t, "2025-01-02", "1000", "0"),
+			bookDay(t, "2025-02-03", "0", "100"),
+			bookDay(t, "2025-03-03", "-1100", "0"),
+			bookDay(t, "2025-04-01", "500", "0"),
+			bookDay(t, "2025-05-01", "0", "50"),
+		}, 0.21, true},
+	}
+	for _, tt := range tests {
+		got, ok := timeWeightedReturn(tt.days)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: timeWeightedReturn = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMoneyWeightedReturn(t *testing.T) {
+	tests := []struct {
+		name     string
+		days     []performanceDay
+		endValue string
+		endDate  string
+		want     float64
+		ok       bool
+	}{
+		{"one deposit for a year", []performanceDay{bookDay(t, "2023-01-01", "1000", "0")}, "1100", "2024-01-01", 0.10, true},
+		// Gains don't move money in or out, so they only show in the end
+		// value.
+		{"gains ignored", []performanceDay{bookDay(t, "2023-01-01", "1000", "0"), bookDay(t, "2023-06-01", "0", "100")}, "1100", "2024-01-01", 0.10, true},
+		{"second deposit mid-year", []performanceDay{bookDay(t, "2023-01-01", "1000", "0"), bookDay(t, "2023-07-02", "1000", "0")}, "2200", "2024-01-01", 0.134627, true},
+		{"no flows", []performanceDay{bookDay(t, "2023-01-01", "0", "100")}, "100", "2024-01-01", 0, false},
+		{"ends before it starts", []performanceDay{bookDay(t, "2023-01-01", "1000", "0")}, "1100", "2023-01-01", 0, false},
+		{"everything lost", []performanceDay{bookDay(t, "2023-01-01", "1000", "0")}, "0", "2024-01-01", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := moneyWeightedReturn(tt.days, decimal(t, tt.endValue), tt.endDate)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s: moneyWeightedReturn = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// Deposits count as flows and closed trades as gains, on the days they
+// happened, within the scoped account.
+func TestPerformanceDays(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "performance")
+	ira := addAccount(t, userID, "IRA")
+
+	for _, flow := range []types.CashFlow{
+		{Date: "2025-01-02", Type: types.Deposit, Amount: decimal(t, "1000")},
+		{Date: "2025-01-02", Type: types.Deposit, Amount: decimal(t, "5000"), AccountID: ira},
+	} {
+		if err := postCashFlow(db, userID, flow); err != nil {
+			t.Fatal(err)
+		}
+	}
+	applyStock(t, userID, types.StockTrade{Ticker: "F", Date: "2025-01-03", Code: types.Buy, Price: decimal(t, "10"), Quantity: types.DecimalFromInt(50)})
+	applyStock(t, userID, types.StockTrade{Ticker: "F", Date: "2025-02-03", Code: types.Sell, Price: decimal(t, "12"), Quantity: types.DecimalFromInt(50)})
+
+	days, err := performanceDays(db, userID, accountScope{View: types.DefaultAccount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range days {
+		got = append(got, d.Date+" "+d.Flow.String()+" "+d.Gain.String())
+	}
+	if want := "[2025-01-02 1000 0 2025-02-03 0 100]"; fmt.Sprint(got) != want {
+		t.Errorf("days = %v, want %s", got, want)
+	}
+	if twr, ok := timeWeightedReturn(days); !ok || math.Abs(twr-0.10) > 1e-9 {
+		t.Errorf("time-weighted return = %v, %v; want 0.1", twr, ok)
+	}
+}
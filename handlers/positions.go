@@ -19,7 +19,19 @@ func HandlePositions(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleModalAddPosition(w http.ResponseWriter, r *http.Request) {
-	components.AddPositionModal().Render(r.Context(), w)
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accounts, err := userAccounts(db, userID)
+	if err != nil {
+		http.Error(w, "Failed to load accounts", http.StatusInternalServerError)
+		return
+	}
+
+	components.AddPositionModal(accounts, formDefaultAccount(userID)).Render(r.Context(), w)
 }
 
 func HandleModalAddPositionFields(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +39,8 @@ func HandleModalAddPositionFields(w http.ResponseWriter, r *http.Request) {
 
 	if positionType == "option" {
 		components.AddPositionOptionFields().Render(r.Context(), w)
+	} else if positionType == "strategy" {
+		components.AddPositionStrategyFields().Render(r.Context(), w)
 	} else if positionType == "stock" {
 		components.AddPositionStockFields().Render(r.Context(), w)
 	} else {
@@ -52,89 +66,162 @@ func HandleAddPosition(w http.ResponseWriter, r *http.Request) {
 
 	positionType := r.FormValue("positionType")
 	ticker := strings.ToUpper(r.FormValue("ticker"))
-	quantity, _ := strconv.ParseFloat(r.FormValue("quantity"), 64)
-	costBasis, _ := strconv.ParseFloat(r.FormValue("costBasis"), 64)
+	quantity, err := types.ParseDecimal(r.FormValue("quantity"))
+	if err != nil || quantity.Sign() <= 0 {
+		http.Error(w, "Quantity must be a positive number", http.StatusBadRequest)
+		return
+	}
+	var costBasis types.Decimal
+	if positionType == "stock" {
+		costBasis, err = types.ParseDecimal(r.FormValue("costBasis"))
+		if err != nil || costBasis.Sign() < 0 {
+			http.Error(w, "Invalid cost basis", http.StatusBadRequest)
+			return
+		}
+	}
+	fees := formFees(r)
 	openDate := r.FormValue("openDate")
 	if openDate == "" {
 		openDate = time.Now().Format("2006-01-02")
 	}
 
-	switch positionType {
-	case "stock":
-		var existingID int
-		var existingQuantity, existingCostBasis float64
-		err := db.QueryRow(`
-			SELECT id, quantity, cost_basis
-			FROM stock_positions
-			WHERE user_id = ? AND ticker = ?
-		`, userID, ticker).Scan(&existingID, &existingQuantity, &existingCostBasis)
-
-		if err == nil {
-			totalQuantity := existingQuantity + quantity
-			totalCost := (existingCostBasis * existingQuantity) + (costBasis * quantity)
-			newCostBasis := totalCost / totalQuantity
+	accountID, err := formAccount(r, userID)
+	if err != nil {
+		http.Error(w, "Unknown account", http.StatusBadRequest)
+		return
+	}
 
-			_, err = db.Exec(`
-				UPDATE stock_positions
-				SET quantity = ?, cost_basis = ?, updated_at = CURRENT_TIMESTAMP
-				WHERE id = ?
-			`, totalQuantity, newCostBasis, existingID)
+	var legs []types.OptionTrade
+	if positionType == "strategy" {
+		legs, err = strategyLegTrades(r, ticker, openDate, quantity)
+		if err != nil {
+			http.Error(w, "Invalid strategy: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i := range legs {
+			legs[i].AccountID = accountID
+		}
+	}
 
-			if err != nil {
-				http.Error(w, "Failed to update stock position: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-		} else {
-			_, err = db.Exec(`
-				INSERT INTO stock_positions (user_id, ticker, quantity, cost_basis, open_date)
-				VALUES (?, ?, ?, ?, ?)
-			`, userID, ticker, quantity, costBasis, openDate)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
-			if err != nil {
-				http.Error(w, "Failed to add stock position: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
+	var entries []ledgerEntry
+	var entry ledgerEntry
+	switch positionType {
+	case "stock":
+		trade := types.StockTrade{
+			Ticker:    ticker,
+			Date:      openDate,
+			Code:      types.Buy,
+			Price:     costBasis,
+			Amount:    costBasis.Mul(quantity).Neg().Sub(fees),
+			Quantity:  quantity,
+			Fees:      fees,
+			AccountID: accountID,
 		}
+		if types.PositionSide(r.FormValue("side")) == types.Short {
+			trade.Code = types.SellShort
+			trade.Amount = costBasis.Mul(quantity).Sub(fees)
+		}
+		entry, err = recordStockTrade(tx, userID, &trade, SourceManual)
+		entries = append(entries, entry)
 	case "option":
-		optionType := r.FormValue("optionType")
-		strike, _ := strconv.ParseFloat(r.FormValue("strike"), 64)
-		premium, _ := strconv.ParseFloat(r.FormValue("premium"), 64)
-		expDate := r.FormValue("expDate")
-
-		price := premium
-		collateral := 0.0
-
-		switch optionType {
-		case "CSP":
-			collateral = strike * 100
-		case "CC":
-			var stockQuantity, stockCostBasis float64
-			err := db.QueryRow(`
-				SELECT quantity, cost_basis
-				FROM stock_positions
-				WHERE user_id = ? AND ticker = ?
-			`, userID, ticker).Scan(&stockQuantity, &stockCostBasis)
-
-			if err == nil && stockQuantity >= 100 {
-				collateral = stockCostBasis * 100
-			}
+		optionType := types.OptionType(r.FormValue("optionType"))
+		var strike, premium types.Decimal
+		strike, err = types.ParseDecimal(r.FormValue("strike"))
+		if err != nil || strike.Sign() <= 0 {
+			http.Error(w, "Invalid strike", http.StatusBadRequest)
+			return
+		}
+		premium, err = types.ParseDecimal(r.FormValue("premium"))
+		if err != nil || premium.Sign() < 0 {
+			http.Error(w, "Invalid premium", http.StatusBadRequest)
+			return
 		}
 
-		_, err := db.Exec(`
-			INSERT INTO option_positions (user_id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, userID, ticker, price, premium, strike, expDate, optionType, collateral, quantity, openDate)
+		trade := openingOptionTrade(ticker, openDate, optionType, strike, premium, quantity, formMultiplier(r), r.FormValue("expDate"))
+		trade.Fees = fees
+		trade.Amount = trade.Amount.Sub(fees)
+		trade.AccountID = accountID
+		entry, err = recordOptionTrade(tx, userID, &trade, SourceManual)
+		entries = append(entries, entry)
+	case "strategy":
+		for i := range legs {
+			if err != nil {
+				break
+			}
+			entry, err = recordOptionTrade(tx, userID, &legs[i], SourceManual)
+			entries = append(entries, entry)
+		}
+	default:
+		http.Error(w, "Unknown position type", http.StatusBadRequest)
+		return
+	}
 
+	for _, entry := range entries {
 		if err != nil {
-			http.Error(w, "Failed to add option position: "+err.Error(), http.StatusInternalServerError)
-			return
+			break
 		}
+		_, err = applyLedgerEntry(tx, userID, entry)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Failed to add "+positionType+" position: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("HX-Trigger", "positionAdded")
 	components.ModalClose().Render(r.Context(), w)
 }
 
+// formFees reads the fees entered with a manual trade, which are always a
+// cost.
+func formFees(r *http.Request) types.Decimal {
+	fees, _ := types.ParseDecimal(r.FormValue("fees"))
+	return fees.Abs()
+}
+
+// formMultiplier reads the contract multiplier entered with a manual option
+// trade, falling back to the standard 100 when it's blank or invalid.
+func formMultiplier(r *http.Request) types.Decimal {
+	multiplier, err := types.ParseDecimal(r.FormValue("multiplier"))
+	if err != nil || multiplier.Sign() <= 0 {
+		return types.StandardMultiplier
+	}
+	return multiplier
+}
+
+// openingOptionTrade builds the trade that opens a position of the given
+// type, e.g. an STO on a Put for a CSP.
+func openingOptionTrade(ticker, date string, positionType types.OptionType, strike, premium, quantity, multiplier types.Decimal, expDate string) types.OptionTrade {
+	openCode, _, contractType := optionTradeCodes(positionType)
+	amount := premium.Mul(quantity).Mul(multiplier)
+	if openCode == types.BTO {
+		amount = amount.Neg()
+	}
+
+	return types.OptionTrade{
+		Ticker:     ticker,
+		Date:       date,
+		Code:       openCode,
+		Price:      premium,
+		Amount:     amount,
+		Quantity:   quantity,
+		Strike:     strike,
+		ExpDate:    expDate,
+		OptionType: contractType,
+		Premium:    premium,
+		Multiplier: multiplier,
+	}
+}
+
 func HandleGetStockPositions(w http.ResponseWriter, r *http.Request) {
 	userID, ok := GetOrCreateUserID(r)
 	if !ok {
@@ -142,12 +229,15 @@ func HandleGetStockPositions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scope := requestAccountScope(r, userID)
 	search := strings.ToUpper(r.URL.Query().Get("search"))
 	dateFromInput := r.URL.Query().Get("dateFrom")
 	dateToInput := r.URL.Query().Get("dateTo")
 
-	query := `SELECT id, ticker, quantity, cost_basis, open_date FROM stock_positions WHERE user_id = ? AND quantity > 0`
-	args := []interface{}{userID}
+	query := `SELECT id, ticker, quantity, cost_basis, open_date, side,
+		COALESCE((SELECT SUM(l.premium_adjustment) FROM stock_lots l WHERE l.user_id = stock_positions.user_id AND l.account_id = stock_positions.account_id AND l.ticker = stock_positions.ticker AND l.quantity > 0), 0), account_id
+		FROM stock_positions WHERE user_id = ? AND quantity > 0` + scope.filter("account_id")
+	args := scope.args(userID)
 
 	if search != "" {
 		query += ` AND ticker LIKE ?`
@@ -167,16 +257,20 @@ func HandleGetStockPositions(w http.ResponseWriter, r *http.Request) {
 	var positions []types.StockPos
 	for rows.Next() {
 		var pos types.StockPos
-		if err := rows.Scan(&pos.ID, &pos.Ticker, &pos.Quantity, &pos.CostBasis, &pos.OpenDate); err != nil {
+		var premium types.Decimal
+		var accountID int
+		if err := rows.Scan(&pos.ID, &pos.Ticker, &pos.Quantity, &pos.CostBasis, &pos.OpenDate, &pos.Side, &premium, &accountID); err != nil {
 			continue
 		}
+		pos.Account = scope.label(accountID)
+		pos.AdjustedBasis = pos.CostBasis.Sub(premium.Div(pos.Quantity))
 		if IsDateInRange(pos.OpenDate, dateFromInput, dateToInput) {
 			positions = append(positions, pos)
 		}
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	components.StockPositionsTable(positions, FormatDate).Render(r.Context(), w)
+	components.StockPositionsTable(positions, userAdjustedBasis(db, userID), FormatDate).Render(r.Context(), w)
 }
 
 func HandleGetOptionPositions(w http.ResponseWriter, r *http.Request) {
@@ -186,13 +280,14 @@ func HandleGetOptionPositions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scope := requestAccountScope(r, userID)
 	search := strings.ToUpper(r.URL.Query().Get("search"))
 	optionType := r.URL.Query().Get("type")
 	dateFromInput := r.URL.Query().Get("dateFrom")
 	dateToInput := r.URL.Query().Get("dateTo")
 
-	query := `SELECT id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date FROM option_positions WHERE user_id = ? AND quantity > 0`
-	args := []interface{}{userID}
+	query := `SELECT id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, roll_id, multiplier, account_id FROM option_positions WHERE user_id = ? AND quantity > 0 AND strategy_id = 0` + scope.filter("account_id")
+	args := scope.args(userID)
 
 	if search != "" {
 		query += ` AND ticker LIKE ?`
@@ -216,14 +311,20 @@ func HandleGetOptionPositions(w http.ResponseWriter, r *http.Request) {
 	var positions []types.OptionPos
 	for rows.Next() {
 		var pos types.OptionPos
-		if err := rows.Scan(&pos.ID, &pos.Ticker, &pos.Price, &pos.Premium, &pos.Strike, &pos.ExpDate, &pos.Type, &pos.Collateral, &pos.Quantity, &pos.PurchaseDate); err != nil {
+		if err := rows.Scan(&pos.ID, &pos.Ticker, &pos.Price, &pos.Premium, &pos.Strike, &pos.ExpDate, &pos.Type, &pos.Collateral, &pos.Quantity, &pos.PurchaseDate, &pos.RollID, &pos.Multiplier, &pos.AccountID); err != nil {
 			continue
 		}
+		pos.Account = scope.label(pos.AccountID)
 		if IsDateInRange(pos.PurchaseDate, dateFromInput, dateToInput) {
 			positions = append(positions, pos)
 		}
 	}
 
+	if err := annotateRolls(db, userID, positions); err != nil {
+		http.Error(w, "Failed to fetch roll chains", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 	components.OptionPositionsTable(positions, FormatDate).Render(r.Context(), w)
 }
@@ -235,6 +336,7 @@ func HandlePositionsFilter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scope := requestAccountScope(r, userID)
 	search := strings.ToUpper(r.URL.Query().Get("search"))
 	optionType := r.URL.Query().Get("type")
 	dateFromInput := r.URL.Query().Get("dateFrom")
@@ -243,8 +345,10 @@ func HandlePositionsFilter(w http.ResponseWriter, r *http.Request) {
 	var stockPositions []types.StockPos
 
 	if optionType == "" {
-		stockQuery := `SELECT id, ticker, quantity, cost_basis, open_date FROM stock_positions WHERE user_id = ? AND quantity > 0`
-		stockArgs := []interface{}{userID}
+		stockQuery := `SELECT id, ticker, quantity, cost_basis, open_date, side,
+		COALESCE((SELECT SUM(l.premium_adjustment) FROM stock_lots l WHERE l.user_id = stock_positions.user_id AND l.account_id = stock_positions.account_id AND l.ticker = stock_positions.ticker AND l.quantity > 0), 0), account_id
+		FROM stock_positions WHERE user_id = ? AND quantity > 0` + scope.filter("account_id")
+		stockArgs := scope.args(userID)
 
 		if search != "" {
 			stockQuery += ` AND ticker LIKE ?`
@@ -261,17 +365,21 @@ func HandlePositionsFilter(w http.ResponseWriter, r *http.Request) {
 
 		for stockRows.Next() {
 			var pos types.StockPos
-			if err := stockRows.Scan(&pos.ID, &pos.Ticker, &pos.Quantity, &pos.CostBasis, &pos.OpenDate); err != nil {
+			var premium types.Decimal
+			var accountID int
+			if err := stockRows.Scan(&pos.ID, &pos.Ticker, &pos.Quantity, &pos.CostBasis, &pos.OpenDate, &pos.Side, &premium, &accountID); err != nil {
 				continue
 			}
+			pos.Account = scope.label(accountID)
+			pos.AdjustedBasis = pos.CostBasis.Sub(premium.Div(pos.Quantity))
 			if IsDateInRange(pos.OpenDate, dateFromInput, dateToInput) {
 				stockPositions = append(stockPositions, pos)
 			}
 		}
 	}
 
-	optionQuery := `SELECT id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date FROM option_positions WHERE user_id = ? AND quantity > 0`
-	optionArgs := []interface{}{userID}
+	optionQuery := `SELECT id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, roll_id, multiplier, account_id FROM option_positions WHERE user_id = ? AND quantity > 0 AND strategy_id = 0` + scope.filter("account_id")
+	optionArgs := scope.args(userID)
 
 	if search != "" {
 		optionQuery += ` AND ticker LIKE ?`
@@ -293,27 +401,37 @@ func HandlePositionsFilter(w http.ResponseWriter, r *http.Request) {
 	var optionPositions []types.OptionPos
 	for optionRows.Next() {
 		var pos types.OptionPos
-		if err := optionRows.Scan(&pos.ID, &pos.Ticker, &pos.Price, &pos.Premium, &pos.Strike, &pos.ExpDate, &pos.Type, &pos.Collateral, &pos.Quantity, &pos.PurchaseDate); err != nil {
+		if err := optionRows.Scan(&pos.ID, &pos.Ticker, &pos.Price, &pos.Premium, &pos.Strike, &pos.ExpDate, &pos.Type, &pos.Collateral, &pos.Quantity, &pos.PurchaseDate, &pos.RollID, &pos.Multiplier, &pos.AccountID); err != nil {
 			continue
 		}
+		pos.Account = scope.label(pos.AccountID)
 		if IsDateInRange(pos.PurchaseDate, dateFromInput, dateToInput) {
 			optionPositions = append(optionPositions, pos)
 		}
 	}
+	if err := annotateRolls(db, userID, optionPositions); err != nil {
+		http.Error(w, "Failed to fetch roll chains", http.StatusInternalServerError)
+		return
+	}
 
 	htmlContent := `<div class="positions-section">
 		<h3>Stock Positions</h3>
 		<div id="stock-positions-list" hx-get="/api/positions/stocks" hx-trigger="positionAdded from:body, positionClosed from:body" hx-swap="innerHTML">`
 
+	adjustedBasis := userAdjustedBasis(db, userID)
 	if len(stockPositions) == 0 {
 		htmlContent += `<p>No stock positions found.</p>`
 	} else {
+		adjustedHeader := ""
+		if adjustedBasis {
+			adjustedHeader = `<th>Adjusted Basis</th>`
+		}
 		htmlContent += `<table class="positions-table">
 			<thead>
 				<tr>
 					<th>Ticker</th>
 					<th>Quantity</th>
-					<th>Cost Basis</th>
+					<th>Cost Basis</th>` + adjustedHeader + `
 					<th>Open Date</th>
 					<th>Actions</th>
 				</tr>
@@ -321,18 +439,23 @@ func HandlePositionsFilter(w http.ResponseWriter, r *http.Request) {
 			<tbody>`
 
 		for _, pos := range stockPositions {
+			adjustedCell := ""
+			if adjustedBasis {
+				adjustedCell = fmt.Sprintf(`<td>$%.2f</td>`, pos.AdjustedBasis)
+			}
 			htmlContent += fmt.Sprintf(`
 				<tr>
-					<td>%s</td>
+					<td>%s%s</td>
 					<td>%.2f</td>
-					<td>$%.2f</td>
+					<td>$%.2f</td>%s
 					<td>%s</td>
 					<td>
 						<button class="btn btn-sm btn-primary" hx-get="/api/positions/edit/%d" hx-target="#modal-container" hx-swap="innerHTML">Edit</button>
 						<button class="btn btn-sm btn-danger" hx-delete="/api/positions/%d" hx-target="#stock-positions-list" hx-swap="outerHTML" hx-confirm="Delete this position?">Delete</button>
-						<button class="btn btn-sm btn-warning" hx-post="/api/positions/close/%d" hx-target="#modal-container" hx-swap="innerHTML">Close</button>
+						<button class="btn btn-sm btn-warning" hx-post="/api/positions/close/%d" hx-target="#modal-container" hx-swap="innerHTML">%s</button>
+						<button class="btn btn-sm btn-secondary" hx-get="/api/accounts/move-modal/stock/%d" hx-target="#modal-container" hx-swap="innerHTML">Move</button>
 					</td>
-				</tr>`, html.EscapeString(pos.Ticker), pos.Quantity, pos.CostBasis, FormatDate(pos.OpenDate), pos.ID, pos.ID, pos.ID)
+				</tr>`, html.EscapeString(pos.Ticker), accountTagHTML(pos.Account), pos.SignedQuantity(), pos.CostBasis, adjustedCell, FormatDate(pos.OpenDate), pos.ID, pos.ID, pos.ID, closeLabel(pos.Side), pos.ID)
 		}
 
 		htmlContent += `</tbody></table>`
@@ -356,33 +479,46 @@ func HandlePositionsFilter(w http.ResponseWriter, r *http.Request) {
 					<th>Premium</th>
 					<th>Exp Date</th>
 					<th>Purchase Date</th>
+					<th>Roll Chain</th>
 					<th>Actions</th>
 				</tr>
 			</thead>
 			<tbody>`
 
 		for _, pos := range optionPositions {
+			rollCell := ""
+			if pos.RollID != 0 {
+				rollCell = fmt.Sprintf(`<button class="btn btn-sm btn-secondary" hx-get="/api/positions/roll-chain/%d" hx-target="#modal-container" hx-swap="innerHTML">%s</button>`,
+					pos.RollID, html.EscapeString(components.RollSummary(pos)))
+			}
 			htmlContent += fmt.Sprintf(`
 				<tr>
-					<td>%s</td>
+					<td>%s%s</td>
 					<td>%s</td>
 					<td>%.0f</td>
 					<td>$%.2f</td>
 					<td>$%.2f</td>
 					<td>%s</td>
 					<td>%s</td>
+					<td>%s</td>
 					<td>
 						<button class="btn btn-sm btn-primary" hx-get="/api/positions/edit-option/%d" hx-target="#modal-container" hx-swap="innerHTML">Edit</button>
 						<button class="btn btn-sm btn-danger" hx-delete="/api/positions/option/%d" hx-target="#option-positions-list" hx-swap="outerHTML" hx-confirm="Delete this position?">Delete</button>
 						<button class="btn btn-sm btn-warning" hx-post="/api/positions/close-option-modal/%d" hx-target="#modal-container" hx-swap="innerHTML">Close</button>
+						<button class="btn btn-sm btn-secondary" hx-get="/api/positions/roll-option-modal/%d" hx-target="#modal-container" hx-swap="innerHTML">Roll</button>
+						<button class="btn btn-sm btn-secondary" hx-get="/api/accounts/move-modal/option/%d" hx-target="#modal-container" hx-swap="innerHTML">Move</button>
 					</td>
-				</tr>`, html.EscapeString(pos.Ticker), pos.Type, pos.Quantity, pos.Strike, pos.Premium, FormatDate(pos.ExpDate), FormatDate(pos.PurchaseDate), pos.ID, pos.ID, pos.ID)
+				</tr>`, html.EscapeString(pos.Ticker), accountTagHTML(pos.Account), pos.Type, pos.Quantity, pos.Strike, pos.Premium, FormatDate(pos.ExpDate), FormatDate(pos.PurchaseDate), rollCell, pos.ID, pos.ID, pos.ID, pos.ID, pos.ID)
 		}
 
 		htmlContent += `</tbody></table>`
 	}
 
-	htmlContent += `</div></div>`
+	htmlContent += `</div></div>
+	<div class="positions-section">
+		<h3>Option Strategies</h3>
+		<div id="strategies-list" hx-get="/api/positions/strategies" hx-trigger="load" hx-swap="outerHTML"></div>
+	</div>`
 
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(htmlContent))
@@ -403,43 +539,64 @@ func HandleClosePosition(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var ticker string
-	var quantity, costBasis float64
+	var quantity, costBasis types.Decimal
 	var openDate string
+	var side types.PositionSide
+	var accountID int
 
 	err := db.QueryRow(`
-		SELECT ticker, quantity, cost_basis, open_date
+		SELECT ticker, quantity, cost_basis, open_date, side, account_id
 		FROM stock_positions
 		WHERE id = ? AND user_id = ?
-	`, positionID, userID).Scan(&ticker, &quantity, &costBasis, &openDate)
+	`, positionID, userID).Scan(&ticker, &quantity, &costBasis, &openDate, &side, &accountID)
 
 	if err == nil {
+		lotOptions, err := closeLotOptions(userID, accountID, ticker, side)
+		if err != nil {
+			http.Error(w, "Failed to load lots: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		title, verb, priceLabel := "Close Stock Position", "Close", "Sell Price"
+		if side == types.Short {
+			title, verb, priceLabel = "Cover Short Position", "Cover", "Cover Price"
+		}
+
 		html := fmt.Sprintf(`
 			<div class="modal">
 				<div class="modal-content">
 					<div class="modal-header">
-						<h3>Close Stock Position: %s</h3>
+						<h3>%s: %s</h3>
 					</div>
 					<form hx-post="/api/positions/close-stock/%s" hx-target="#modal-container" hx-swap="innerHTML">
 						<div class="form-group">
-							<label>Quantity to Close (Available: %.2f)</label>
+							<label>Quantity to %s (Available: %.2f)</label>
 							<input type="number" name="quantity" step="0.01" required placeholder="%.2f" value="%.2f" max="%.2f" />
 						</div>
 						<div class="form-group">
-							<label>Sell Price</label>
+							<label>%s</label>
 							<input type="number" name="sellPrice" step="0.01" required placeholder="%.2f" />
 						</div>
+						<div class="form-group">
+							<label>Fees &amp; Commissions</label>
+							<input type="number" name="fees" step="0.01" min="0" placeholder="0.00" />
+						</div>
+						<div class="form-group">
+							<label>Lots to %s</label>
+							<select name="lot">%s</select>
+						</div>
 						<div class="form-group">
 							<label>Close Date (defaults to today)</label>
 							<input type="date" name="closeDate" />
 						</div>
 						<div class="form-actions">
-							<button type="submit" class="btn btn-primary">Close Position</button>
+							<button type="submit" class="btn btn-primary">%s Position</button>
 							<button type="button" class="btn btn-secondary" hx-get="/modal/close" hx-target="#modal-container">Cancel</button>
 						</div>
 					</form>
 				</div>
 			</div>
-		`, ticker, positionID, quantity, quantity, quantity, quantity, costBasis)
+		`, title, ticker, positionID, verb, quantity, quantity, quantity, quantity, priceLabel, costBasis, verb, lotOptions, verb)
 
 		w.Header().Set("Content-Type", "text/html")
 		w.Header().Set("HX-Retarget", "#modal-container")
@@ -448,7 +605,7 @@ func HandleClosePosition(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var price, premium, strike, collateral float64
+	var price, premium, strike, collateral types.Decimal
 	var expDate, purchaseDate string
 	var optionType types.OptionType
 
@@ -515,6 +672,10 @@ func HandleClosePosition(w http.ResponseWriter, r *http.Request) {
 								<label>Sell Price</label>
 								<input type="number" name="sellPrice" step="0.01" required placeholder="%.2f" />
 							</div>
+							<div class="form-group">
+								<label>Fees &amp; Commissions</label>
+								<input type="number" name="fees" step="0.01" min="0" placeholder="0.00" />
+							</div>
 							<div class="form-group">
 								<label>Close Date (defaults to today)</label>
 								<input type="date" name="closeDate" />
@@ -554,7 +715,7 @@ func HandleCloseOptionModal(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var ticker string
-	var price, premium, strike, collateral, quantity float64
+	var price, premium, strike, collateral, quantity types.Decimal
 	var expDate, purchaseDate string
 	var optionType types.OptionType
 
@@ -633,6 +794,10 @@ func HandleCloseOptionModal(w http.ResponseWriter, r *http.Request) {
 							<label>Sell Price</label>
 							<input type="number" name="sellPrice" step="0.01" required placeholder="%.2f" />
 						</div>
+						<div class="form-group">
+							<label>Fees &amp; Commissions</label>
+							<input type="number" name="fees" step="0.01" min="0" placeholder="0.00" />
+						</div>
 						<div class="form-group">
 							<label>Close Date (defaults to today)</label>
 							<input type="date" name="closeDate" />
@@ -668,7 +833,7 @@ func HandleOutcomeFields(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var premium, strike float64
+	var premium, strike types.Decimal
 	var expDate string
 
 	err := db.QueryRow(`
@@ -710,6 +875,10 @@ func HandleOutcomeFields(w http.ResponseWriter, r *http.Request) {
 				<label>Buy to Close Price</label>
 				<input type="number" name="sellPrice" step="0.01" required placeholder="%.2f" />
 			</div>
+			<div class="form-group">
+				<label>Fees &amp; Commissions</label>
+				<input type="number" name="fees" step="0.01" min="0" placeholder="0.00" />
+			</div>
 			<div class="form-group">
 				<label>Close Date (defaults to today)</label>
 				<input type="date" name="closeDate" />
@@ -737,14 +906,16 @@ func HandleCloseStockPosition(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var ticker string
-	var currentQuantity, costBasis float64
+	var currentQuantity, costBasis types.Decimal
 	var openDate string
+	var side types.PositionSide
+	var accountID int
 
 	err := db.QueryRow(`
-		SELECT ticker, quantity, cost_basis, open_date
+		SELECT ticker, quantity, cost_basis, open_date, side, account_id
 		FROM stock_positions
 		WHERE id = ? AND user_id = ?
-	`, positionID, userID).Scan(&ticker, &currentQuantity, &costBasis, &openDate)
+	`, positionID, userID).Scan(&ticker, &currentQuantity, &costBasis, &openDate, &side, &accountID)
 
 	if err != nil {
 		http.Error(w, "Position not found", http.StatusNotFound)
@@ -753,78 +924,75 @@ func HandleCloseStockPosition(w http.ResponseWriter, r *http.Request) {
 
 	// Parse quantity - if not provided or invalid, default to full position
 	quantityStr := r.FormValue("quantity")
-	var quantityToClose float64
+	var quantityToClose types.Decimal
 	if quantityStr == "" {
 		quantityToClose = currentQuantity
 	} else {
-		quantityToClose, _ = strconv.ParseFloat(quantityStr, 64)
+		quantityToClose, _ = types.ParseDecimal(quantityStr)
 	}
 
-	sellPrice, _ := strconv.ParseFloat(r.FormValue("sellPrice"), 64)
+	sellPrice, _ := types.ParseDecimal(r.FormValue("sellPrice"))
+	fees := formFees(r)
 	closeDate := r.FormValue("closeDate")
 	if closeDate == "" {
 		closeDate = time.Now().Format("2006-01-02")
 	}
 
 	// Validate quantity
-	if quantityToClose <= 0 {
+	if quantityToClose.Sign() <= 0 {
 		quantityToClose = currentQuantity
 	}
-	if quantityToClose > currentQuantity {
+	if quantityToClose.Cmp(currentQuantity) > 0 {
 		quantityToClose = currentQuantity
 	}
 
-	profitLoss := (sellPrice - costBasis) * quantityToClose
-
-	var existingID int
-	var existingQuantity, existingCostBasis, existingSellPrice, existingProfitLoss float64
-	err = db.QueryRow(`
-		SELECT id, quantity, cost_basis, sell_price, profit_loss
-		FROM closed_stocks
-		WHERE user_id = ? AND ticker = ? AND open_date = ?
-	`, userID, ticker, openDate).Scan(&existingID, &existingQuantity, &existingCostBasis, &existingSellPrice, &existingProfitLoss)
-
-	if err == nil {
-		totalQuantity := existingQuantity + quantityToClose
-		totalCostBasis := ((existingCostBasis * existingQuantity) + (costBasis * quantityToClose)) / totalQuantity
-		totalSellPrice := ((existingSellPrice * existingQuantity) + (sellPrice * quantityToClose)) / totalQuantity
-		totalProfitLoss := existingProfitLoss + profitLoss
-
-		_, err = db.Exec(`
-			UPDATE closed_stocks
-			SET quantity = ?, cost_basis = ?, sell_price = ?, profit_loss = ?, close_date = ?
-			WHERE id = ?
-		`, totalQuantity, totalCostBasis, totalSellPrice, totalProfitLoss, closeDate, existingID)
-
-		if err != nil {
-			http.Error(w, "Failed to update closed position: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		_, err = db.Exec(`
-			INSERT INTO closed_stocks (user_id, ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-		`, userID, ticker, openDate, closeDate, quantityToClose, costBasis, sellPrice, profitLoss)
-
+	trade := types.StockTrade{
+		Ticker:    ticker,
+		Date:      closeDate,
+		Code:      types.Sell,
+		Price:     sellPrice,
+		Amount:    sellPrice.Mul(quantityToClose).Sub(fees),
+		Quantity:  quantityToClose,
+		Fees:      fees,
+		AccountID: accountID,
+	}
+	if side == types.Short {
+		trade.Code = types.BuyToCover
+		trade.Amount = sellPrice.Mul(quantityToClose).Neg().Sub(fees)
+	}
+
+	// The lot field holds either a lot method or "lot:<id>" for a specific lot.
+	lot := r.FormValue("lot")
+	if lotID, ok := strings.CutPrefix(lot, "lot:"); ok {
+		err = db.QueryRow(`
+			SELECT open_trade_id FROM stock_lots
+			WHERE id = ? AND user_id = ? AND account_id = ? AND ticker = ? AND side = ? AND quantity > 0
+		`, lotID, userID, accountID, ticker, side).Scan(&trade.LotTradeID)
 		if err != nil {
-			http.Error(w, "Failed to close position: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Lot not found", http.StatusBadRequest)
 			return
 		}
+		trade.LotMethod = types.SpecificLot
+	} else if validLotMethod(types.LotMethod(lot)) {
+		trade.LotMethod = types.LotMethod(lot)
 	}
 
-	remainingQuantity := currentQuantity - quantityToClose
-	if remainingQuantity > 0 {
-		_, err = db.Exec(`
-			UPDATE stock_positions
-			SET quantity = ?, updated_at = CURRENT_TIMESTAMP
-			WHERE id = ?
-		`, remainingQuantity, positionID)
-	} else {
-		_, err = db.Exec(`DELETE FROM stock_positions WHERE id = ?`, positionID)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	defer tx.Rollback()
 
+	entry, err := recordStockTrade(tx, userID, &trade, SourceManual)
+	if err == nil {
+		_, err = applyLedgerEntry(tx, userID, entry)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		http.Error(w, "Failed to update position: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to close position: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -841,9 +1009,9 @@ func HandleCloseOptionPosition(w http.ResponseWriter, r *http.Request) {
 	positionID := chi.URLParam(r, "id")
 	outcome := r.FormValue("outcome")
 
-	quantityToClose, _ := strconv.ParseFloat(r.FormValue("quantity"), 64)
-	sellPrice, _ := strconv.ParseFloat(r.FormValue("sellPrice"), 64)
-	sharePrice, _ := strconv.ParseFloat(r.FormValue("sharePrice"), 64)
+	quantityToClose, _ := types.ParseDecimal(r.FormValue("quantity"))
+	sellPrice, _ := types.ParseDecimal(r.FormValue("sellPrice"))
+	sharePrice, _ := types.ParseDecimal(r.FormValue("sharePrice"))
 	closeDate := r.FormValue("closeDate")
 	if closeDate == "" {
 		closeDate = time.Now().Format("2006-01-02")
@@ -855,147 +1023,69 @@ func HandleCloseOptionPosition(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var id, accountID int
 	var ticker string
-	var price, premium, strike, collateral, currentQuantity float64
-	var expDate, purchaseDate string
+	var strike, currentQuantity, multiplier types.Decimal
+	var expDate string
 	var optionType types.OptionType
 
 	err := db.QueryRow(`
-		SELECT ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date
+		SELECT id, ticker, strike, exp_date, type, quantity, multiplier, account_id
 		FROM option_positions
 		WHERE id = ? AND user_id = ?
-	`, positionID, userID).Scan(&ticker, &price, &premium, &strike, &expDate, &optionType, &collateral, &currentQuantity, &purchaseDate)
+	`, positionID, userID).Scan(&id, &ticker, &strike, &expDate, &optionType, &currentQuantity, &multiplier, &accountID)
 
 	if err != nil {
 		http.Error(w, "Position not found", http.StatusNotFound)
 		return
 	}
 
-	if quantityToClose <= 0 || quantityToClose > currentQuantity {
+	if quantityToClose.Sign() <= 0 || quantityToClose.Cmp(currentQuantity) > 0 {
 		http.Error(w, "Invalid quantity to close", http.StatusBadRequest)
 		return
 	}
 
-	switch outcome {
-	case "expired":
-		sellPrice = 0
-		closeDate = expDate
-
-	case "called_away":
-		sellPrice = 0
-
-		sharesToSell := quantityToClose * 100
-		var stockID int
-		var stockQuantity, stockCostBasis float64
-		var stockOpenDate string
-		err := db.QueryRow(`
-			SELECT id, quantity, cost_basis, open_date
-			FROM stock_positions
-			WHERE user_id = ? AND ticker = ?
-		`, userID, ticker).Scan(&stockID, &stockQuantity, &stockCostBasis, &stockOpenDate)
-
-		if err == nil && stockQuantity >= sharesToSell {
-			stockProfitLoss := (sharePrice - stockCostBasis) * sharesToSell
-
-			_, err = db.Exec(`
-				INSERT INTO closed_stocks (user_id, ticker, open_date, close_date, quantity, cost_basis, sell_price, profit_loss)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-			`, userID, ticker, stockOpenDate, closeDate, sharesToSell, stockCostBasis, sharePrice, stockProfitLoss)
-
-			if err != nil {
-				http.Error(w, "Failed to close stock position: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			newQuantity := stockQuantity - sharesToSell
-			if newQuantity > 0 {
-				_, err = db.Exec(`
-					UPDATE stock_positions
-					SET quantity = ?, updated_at = CURRENT_TIMESTAMP
-					WHERE id = ?
-				`, newQuantity, stockID)
-			} else {
-				_, err = db.Exec(`DELETE FROM stock_positions WHERE id = ?`, stockID)
-			}
-
-			if err != nil {
-				http.Error(w, "Failed to update stock position: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
-
-	case "assigned":
-		sellPrice = 0
-
-		sharesToAdd := quantityToClose * 100
-
-		var existingID int
-		var existingQuantity, existingCostBasis float64
-		err := db.QueryRow(`
-			SELECT id, quantity, cost_basis
-			FROM stock_positions
-			WHERE user_id = ? AND ticker = ?
-		`, userID, ticker).Scan(&existingID, &existingQuantity, &existingCostBasis)
-
-		if err == nil {
-			totalQuantity := existingQuantity + sharesToAdd
-			totalCost := (existingCostBasis * existingQuantity) + (strike * sharesToAdd)
-			newCostBasis := totalCost / totalQuantity
-
-			_, err = db.Exec(`
-				UPDATE stock_positions
-				SET quantity = ?, cost_basis = ?, updated_at = CURRENT_TIMESTAMP
-				WHERE id = ?
-			`, totalQuantity, newCostBasis, existingID)
-		} else {
-			_, err = db.Exec(`
-				INSERT INTO stock_positions (user_id, ticker, quantity, cost_basis, open_date)
-				VALUES (?, ?, ?, ?, ?)
-			`, userID, ticker, sharesToAdd, strike, closeDate)
-		}
-
-		if err != nil {
-			http.Error(w, "Failed to add stock position: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-	case "closed":
+	contract := optionContract{Ticker: ticker, Strike: strike, ExpDate: expDate, PositionType: optionType, Multiplier: multiplier}
+	if outcome == OutcomeAssigned {
+		sharePrice = strike
 	}
-
-	var profitLoss float64
-	switch optionType {
-	case types.Call, types.Put:
-		profitLoss = (sellPrice - premium) * quantityToClose
-	case types.CSP, types.CC:
-		profitLoss = (premium - sellPrice) * quantityToClose
+	closeTrade, shareTrade := outcomeTrades(contract, outcome, quantityToClose, sellPrice, sharePrice, closeDate)
+	closeTrade.Fees = formFees(r)
+	closeTrade.Amount = closeTrade.Amount.Sub(closeTrade.Fees)
+	closeTrade.AccountID = accountID
+	if shareTrade != nil {
+		shareTrade.AccountID = accountID
 	}
 
-	collateralForClosed := (collateral / currentQuantity) * quantityToClose
-
-	_, err = db.Exec(`
-		INSERT INTO closed_options (user_id, ticker, price, premium, strike, exp_date, type, collateral, quantity, purchase_date, close_date, sell_price, profit_loss)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, userID, ticker, price, premium, strike, expDate, optionType, collateralForClosed, quantityToClose, purchaseDate, closeDate, sellPrice, profitLoss)
-
+	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, "Failed to close position: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	remainingQuantity := currentQuantity - quantityToClose
-	if remainingQuantity > 0 {
-		remainingCollateral := collateral - collateralForClosed
-		_, err = db.Exec(`
-			UPDATE option_positions
-			SET quantity = ?, collateral = ?, updated_at = CURRENT_TIMESTAMP
-			WHERE id = ?
-		`, remainingQuantity, remainingCollateral, positionID)
-	} else {
-		_, err = db.Exec(`DELETE FROM option_positions WHERE id = ?`, positionID)
+	_, err = recordOptionTrade(tx, userID, &closeTrade, SourceManual)
+	if err == nil {
+		_, err = closeOptionPosition(tx, userID, id, closeTrade, outcome)
+	}
+	if err == nil {
+		err = postOptionTradeCash(tx, userID, closeTrade)
+	}
+	if err == nil && shareTrade != nil {
+		if outcome == OutcomeAssigned {
+			shareTrade.OptionTradeID = closeTrade.ID
+		}
+		var entry ledgerEntry
+		entry, err = recordStockTrade(tx, userID, shareTrade, SourceManual)
+		if err == nil {
+			_, err = applyLedgerEntry(tx, userID, entry)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
 	}
-
 	if err != nil {
-		http.Error(w, "Failed to update position: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to close position: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -1013,7 +1103,7 @@ func HandleEditStockPosition(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var ticker string
-	var quantity, costBasis float64
+	var quantity, costBasis types.Decimal
 	var openDate string
 
 	err := db.QueryRow(`
@@ -1073,14 +1163,14 @@ func HandleEditOptionPosition(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var ticker string
-	var price, premium, strike, collateral float64
+	var price, premium, strike, collateral, multiplier types.Decimal
 	var expDate, purchaseDate, optionType string
 
 	err := db.QueryRow(`
-		SELECT ticker, price, premium, strike, exp_date, type, collateral, purchase_date
+		SELECT ticker, price, premium, strike, exp_date, type, collateral, multiplier, purchase_date
 		FROM option_positions
 		WHERE id = ? AND user_id = ?
-	`, positionID, userID).Scan(&ticker, &price, &premium, &strike, &expDate, &optionType, &collateral, &purchaseDate)
+	`, positionID, userID).Scan(&ticker, &price, &premium, &strike, &expDate, &optionType, &collateral, &multiplier, &purchaseDate)
 
 	if err != nil {
 		http.Error(w, "Position not found", http.StatusNotFound)
@@ -1101,10 +1191,10 @@ func HandleEditOptionPosition(w http.ResponseWriter, r *http.Request) {
 					<div class="form-group">
 						<label>Type</label>
 						<select name="optionType" required>
-							<option value="call" %s>Call</option>
-							<option value="put" %s>Put</option>
-							<option value="csp" %s>CSP</option>
-							<option value="cc" %s>CC</option>
+							<option value="Call" %s>Call</option>
+							<option value="Put" %s>Put</option>
+							<option value="CSP" %s>CSP</option>
+							<option value="CC" %s>CC</option>
 						</select>
 					</div>
 					<div class="form-group">
@@ -1119,6 +1209,10 @@ func HandleEditOptionPosition(w http.ResponseWriter, r *http.Request) {
 						<label>Price</label>
 						<input type="number" name="price" step="0.01" value="%.2f" required />
 					</div>
+					<div class="form-group">
+						<label>Contract Multiplier</label>
+						<input type="number" name="multiplier" step="any" min="0" value="%s" required />
+					</div>
 					<div class="form-group">
 						<label>Expiration Date</label>
 						<input type="date" name="expDate" value="%s" required />
@@ -1135,9 +1229,9 @@ func HandleEditOptionPosition(w http.ResponseWriter, r *http.Request) {
 			</div>
 		</div>
 	`, ticker, positionID, ticker,
-		selected(optionType, "call"), selected(optionType, "put"),
-		selected(optionType, "csp"), selected(optionType, "cc"),
-		strike, premium, price, expDate, purchaseDate)
+		selected(optionType, string(types.Call)), selected(optionType, string(types.Put)),
+		selected(optionType, string(types.CSP)), selected(optionType, string(types.CC)),
+		strike, premium, price, multiplier, expDate, purchaseDate)
 
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(modalHTML))
@@ -1157,16 +1251,73 @@ func HandleUpdateStockPosition(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ticker := strings.ToUpper(r.FormValue("ticker"))
-	quantity, _ := strconv.ParseFloat(r.FormValue("quantity"), 64)
-	costBasis, _ := strconv.ParseFloat(r.FormValue("costBasis"), 64)
+	quantity, _ := types.ParseDecimal(r.FormValue("quantity"))
+	costBasis, _ := types.ParseDecimal(r.FormValue("costBasis"))
 	openDate := r.FormValue("openDate")
 
-	_, err := db.Exec(`
-		UPDATE stock_positions
-		SET ticker = ?, quantity = ?, cost_basis = ?, open_date = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ? AND user_id = ?
-	`, ticker, quantity, costBasis, openDate, positionID, userID)
+	var oldTicker string
+	var side types.PositionSide
+	var accountID int
+	err := db.QueryRow("SELECT ticker, side, account_id FROM stock_positions WHERE id = ? AND user_id = ?", positionID, userID).Scan(&oldTicker, &side, &accountID)
+	if err != nil {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
+	}
 
+	lotTradeID, err := newTradeID()
+	if err != nil {
+		http.Error(w, "Failed to update position", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Renaming onto a ticker already held in the account would leave two
+	// positions sharing one set of lots.
+	if ticker != oldTicker {
+		var taken bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM stock_positions WHERE user_id = ? AND account_id = ? AND ticker = ?)", userID, accountID, ticker).Scan(&taken)
+		if err != nil {
+			http.Error(w, "Failed to update position", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			http.Error(w, fmt.Sprintf("%s already has a position in this account; edit that one instead", ticker), http.StatusConflict)
+			return
+		}
+	}
+
+	// An edited position no longer matches its lots, so they are replaced
+	// by a single lot holding the edited values and the fees already paid.
+	var fees types.Decimal
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(fees), 0) FROM stock_lots
+		WHERE user_id = ? AND account_id = ? AND ticker = ? AND side = ? AND quantity > 0
+	`, userID, accountID, oldTicker, side).Scan(&fees)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE stock_positions
+			SET ticker = ?, quantity = ?, cost_basis = ?, open_date = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND user_id = ?
+		`, ticker, quantity, costBasis, openDate, positionID, userID)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM stock_lots WHERE user_id = ? AND account_id = ? AND ticker = ? AND side = ? AND quantity > 0", userID, accountID, oldTicker, side)
+	}
+	if err == nil {
+		_, err = tx.Exec(`
+			INSERT INTO stock_lots (user_id, account_id, ticker, side, open_date, quantity, cost_basis, open_trade_id, fees)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, userID, accountID, ticker, side, openDate, quantity, costBasis, lotTradeID, fees)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		http.Error(w, "Failed to update position", http.StatusInternalServerError)
 		return
@@ -1182,27 +1333,41 @@ func HandleUpdateOptionPosition(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	positionID := chi.URLParam(r, "id")
+	positionID, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	userID, ok := GetOrCreateUserID(r)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	var quantity types.Decimal
+	var accountID int
+	err := db.QueryRow("SELECT quantity, account_id FROM option_positions WHERE id = ? AND user_id = ?", positionID, userID).Scan(&quantity, &accountID)
+	if err != nil {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
+	}
+
 	ticker := strings.ToUpper(r.FormValue("ticker"))
-	optionType := r.FormValue("optionType")
-	strike, _ := strconv.ParseFloat(r.FormValue("strike"), 64)
-	premium, _ := strconv.ParseFloat(r.FormValue("premium"), 64)
-	price, _ := strconv.ParseFloat(r.FormValue("price"), 64)
-	collateral := 0.0
+	optionType := types.OptionType(r.FormValue("optionType"))
+	strike, _ := types.ParseDecimal(r.FormValue("strike"))
+	premium, _ := types.ParseDecimal(r.FormValue("premium"))
+	price, _ := types.ParseDecimal(r.FormValue("price"))
+	multiplier := formMultiplier(r)
 	expDate := r.FormValue("expDate")
 	purchaseDate := r.FormValue("purchaseDate")
 
-	_, err := db.Exec(`
+	collateral, err := optionCollateral(db, userID, accountID, positionID, ticker, optionType, strike, quantity, multiplier)
+	if err != nil {
+		http.Error(w, "Failed to update position", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = db.Exec(`
 		UPDATE option_positions
-		SET ticker = ?, type = ?, strike = ?, premium = ?, price = ?, collateral = ?, exp_date = ?, purchase_date = ?, updated_at = CURRENT_TIMESTAMP
+		SET ticker = ?, type = ?, strike = ?, premium = ?, price = ?, collateral = ?, multiplier = ?, exp_date = ?, purchase_date = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ? AND user_id = ?
-	`, ticker, optionType, strike, premium, price, collateral, expDate, purchaseDate, positionID, userID)
+	`, ticker, optionType, strike, premium, price, collateral, multiplier, expDate, purchaseDate, positionID, userID)
 
 	if err != nil {
 		http.Error(w, "Failed to update position", http.StatusInternalServerError)
@@ -1213,6 +1378,35 @@ func HandleUpdateOptionPosition(w http.ResponseWriter, r *http.Request) {
 	components.ModalClose().Render(r.Context(), w)
 }
 
+// closeLotOptions lists the lot methods, with the user's default selected,
+// followed by each open lot of the ticker so a specific lot can be closed.
+func closeLotOptions(userID, accountID int, ticker string, side types.PositionSide) (string, error) {
+	method, err := userLotMethod(db, userID)
+	if err != nil {
+		return "", err
+	}
+	lots, err := openStockLots(db, userID, accountID, ticker, side)
+	if err != nil {
+		return "", err
+	}
+
+	options := ""
+	for _, m := range lotMethods {
+		options += fmt.Sprintf(`<option value="%s" %s>%s</option>`, m, selected(string(method), string(m)), lotMethodLabel(m))
+	}
+	for _, lot := range lots {
+		options += fmt.Sprintf(`<option value="lot:%d">Lot opened %s: %.2f @ $%.2f</option>`, lot.ID, FormatDate(lot.OpenDate), lot.Quantity, lot.CostBasis)
+	}
+	return options, nil
+}
+
+func closeLabel(side types.PositionSide) string {
+	if side == types.Short {
+		return "Cover"
+	}
+	return "Close"
+}
+
 func selected(current, value string) string {
 	if current == value {
 		return "selected"
@@ -1229,7 +1423,23 @@ func HandleDeleteStockPosition(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.Exec("DELETE FROM stock_positions WHERE id = ? AND user_id = ?", positionID, userID)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM stock_lots
+		WHERE user_id = ? AND quantity > 0 AND (account_id, ticker) = (SELECT account_id, ticker FROM stock_positions WHERE id = ? AND user_id = ?)
+	`, userID, positionID, userID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM stock_positions WHERE id = ? AND user_id = ?", positionID, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		http.Error(w, "Failed to delete position", http.StatusInternalServerError)
 		return
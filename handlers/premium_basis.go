@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"backend/pnl"
+	"backend/types"
+	"backend/views/components"
+	"database/sql"
+	"net/http"
+)
+
+// creditAssignedPut moves the premium of an assigned put into the basis of
+// the lot its shares opened, in proportion to the shares in the lot.
+func creditAssignedPut(q dbtx, userID int, trade types.StockTrade, lotID int, quantity types.Decimal) error {
+	if trade.OptionTradeID == "" || trade.Quantity.Sign() <= 0 {
+		return nil
+	}
+
+	var closedID int
+	var premium, sellPrice, contracts, multiplier types.Decimal
+	err := q.QueryRow(`
+		SELECT id, premium, sell_price, quantity, multiplier
+		FROM closed_options
+		WHERE user_id = ? AND close_trade_id = ? AND type = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, userID, trade.OptionTradeID, types.CSP).Scan(&closedID, &premium, &sellPrice, &contracts, &multiplier)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	credit := pnl.ShortOptionClose(premium, sellPrice, contracts, multiplier, types.Decimal{}).MulDiv(quantity, trade.Quantity)
+	if _, err := q.Exec("UPDATE stock_lots SET premium_adjustment = premium_adjustment + ? WHERE id = ?", credit, lotID); err != nil {
+		return err
+	}
+	_, err = q.Exec("UPDATE closed_options SET premium_to_basis = premium_to_basis + ? WHERE id = ?", credit, closedID)
+	return err
+}
+
+// creditCoveredCall spreads the P/L of a closed covered call across the
+// shares it covered, shares of the ticker's oldest long lots first. A call
+// bought back at a loss raises their adjusted basis.
+func creditCoveredCall(q dbtx, userID, accountID int, ticker string, closedID int, profitLoss, shares types.Decimal) error {
+	lots, err := openStockLots(q, userID, accountID, ticker, types.Long)
+	if err != nil || len(lots) == 0 {
+		return err
+	}
+
+	var held types.Decimal
+	for _, lot := range lots {
+		held = held.Add(lot.Quantity)
+	}
+	covered := types.MinDecimal(shares, held)
+
+	// Each lot takes the difference between the credit of the shares
+	// covered up to and including it and before it, so the lots add up to
+	// the call's P/L exactly.
+	var credited types.Decimal
+	for _, lot := range lots {
+		if credited.Cmp(covered) >= 0 {
+			break
+		}
+		quantity := types.MinDecimal(lot.Quantity, covered.Sub(credited))
+		credit := profitLoss.MulDiv(credited.Add(quantity), covered).Sub(profitLoss.MulDiv(credited, covered))
+		credited = credited.Add(quantity)
+		_, err := q.Exec("UPDATE stock_lots SET premium_adjustment = premium_adjustment + ? WHERE id = ?", credit, lot.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = q.Exec("UPDATE closed_options SET premium_to_basis = ? WHERE id = ?", profitLoss, closedID)
+	return err
+}
+
+// userAdjustedBasis reports whether the user wants premium-adjusted basis
+// shown alongside broker basis.
+func userAdjustedBasis(q dbtx, userID int) bool {
+	var enabled bool
+	q.QueryRow("SELECT adjusted_basis FROM user_settings WHERE user_id = ?", userID).Scan(&enabled)
+	return enabled
+}
+
+func setUserAdjustedBasis(q dbtx, userID int, enabled bool) error {
+	_, err := q.Exec(`
+		INSERT INTO user_settings (user_id, adjusted_basis) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET adjusted_basis = excluded.adjusted_basis, updated_at = CURRENT_TIMESTAMP
+	`, userID, enabled)
+	return err
+}
+
+func HandleGetAdjustedBasis(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.AdjustedBasisSetting(userAdjustedBasis(db, userID)).Render(r.Context(), w)
+}
+
+func HandleUpdateAdjustedBasis(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	enabled := r.FormValue("adjustedBasis") != ""
+	if err := setUserAdjustedBasis(db, userID, enabled); err != nil {
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "positionAdded, historyUpdated")
+	w.Header().Set("Content-Type", "text/html")
+	components.AdjustedBasisSetting(enabled).Render(r.Context(), w)
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// derivedTables are rebuilt from the ledger on replay.
+var derivedTables = []string{"stock_lots", "stock_positions", "closed_stocks", "option_strategies", "wheel_campaigns", "option_rolls", "option_positions", "closed_options", "corporate_action_adjustments", "cash_transactions"}
+
+// ledgerTradeIDs selects every trade id a derived row can carry when the
+// ledger made it: stock and option trades, which include the share trades
+// of option events, and applied corporate actions. It takes the user id
+// three times.
+const ledgerTradeIDs = `
+	SELECT id FROM stock_trades WHERE user_id = ?
+	UNION SELECT id FROM option_trades WHERE user_id = ?
+	UNION SELECT 'action-' || id FROM corporate_actions WHERE user_id = ? AND applied = 1`
+
+// ErrLedgerGap is returned when a replay would be saved while positions or
+// history exist that no ledger entry made, such as a position edited by
+// hand, and dropping them wasn't asked for.
+var ErrLedgerGap = errors.New("positions or history not in the ledger would be lost")
+
+// ledgerGapFilters picks out the rows of each table that no ledger entry
+// made. Each takes the user id three times.
+var ledgerGapFilters = []struct{ table, filter string }{
+	{"stock_lots", ` AND open_trade_id NOT IN (` + ledgerTradeIDs + `)`},
+	{"closed_stocks", ` AND close_trade_id NOT IN (` + ledgerTradeIDs + `)`},
+	{"option_positions", ` AND quantity > 0 AND open_trade_id NOT IN (` + ledgerTradeIDs + `)`},
+	{"closed_options", ` AND close_trade_id NOT IN (` + ledgerTradeIDs + `)`},
+}
+
+// ledgerGaps describes the open lots and positions and the history rows
+// that no ledger entry made. A rebuild drops them.
+func ledgerGaps(q dbtx, userID int) ([]string, error) {
+	var gaps []string
+	for _, f := range ledgerGapFilters {
+		lines, err := describeRows(q, snapshotQueries[f.table]+f.filter, userID, userID, userID, userID)
+		if err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, lines...)
+	}
+	return gaps, nil
+}
+
+// snapshotAccount names the account of a row outside the Default account.
+const snapshotAccount = `COALESCE((SELECT ' in ' || name FROM accounts WHERE accounts.id = account_id), '')`
+
+var snapshotQueries = map[string]string{
+	"stock_lots":        `SELECT ticker || ' ' || side || ` + snapshotAccount + `, quantity, cost_basis, open_date, '', 0, 0 FROM stock_lots WHERE user_id = ? AND quantity > 0`,
+	"stock_positions":   `SELECT ticker || ' ' || side || ` + snapshotAccount + `, quantity, cost_basis, open_date, '', 0, 0 FROM stock_positions WHERE user_id = ?`,
+	"closed_stocks":     `SELECT ticker || ' ' || side || ` + snapshotAccount + `, quantity, cost_basis, open_date, close_date, sell_price, profit_loss FROM closed_stocks WHERE user_id = ?`,
+	"option_strategies": `SELECT ticker || ' strategy ' || exp_date, 0, 0, open_date, '', 0, 0 FROM option_strategies WHERE user_id = ?`,
+	"wheel_campaigns":   `SELECT ticker || ' wheel' || ` + snapshotAccount + `, 0, 0, start_date, end_date, 0, 0 FROM wheel_campaigns WHERE user_id = ?`,
+	"option_rolls":      `SELECT ticker || ' roll chain', 0, 0, start_date, '', 0, 0 FROM option_rolls WHERE user_id = ?`,
+	"option_positions": `SELECT ticker || ' ' || type || ' ' || printf('%.2f', strike / 1000000.0) || ' ' || exp_date || ` + snapshotAccount + `, quantity, premium, purchase_date, '', 0, 0
+		FROM option_positions WHERE user_id = ?`,
+	"closed_options": `SELECT ticker || ' ' || type || ' ' || printf('%.2f', strike / 1000000.0) || ' ' || exp_date || ` + snapshotAccount + `, quantity, premium, purchase_date, close_date, sell_price, profit_loss
+		FROM closed_options WHERE user_id = ?`,
+	"corporate_action_adjustments": `SELECT a.ticker || ' ' || a.record || ' ' || a.field || ' ' || a.old_value || ' -> ' || a.new_value, 0, 0, c.effective_date, '', 0, 0
+		FROM corporate_action_adjustments a JOIN corporate_actions c ON c.id = a.action_id WHERE a.user_id = ?`,
+	"cash_transactions": `SELECT kind || ' ' || description || ` + snapshotAccount + `, 0, amount, date, '', 0, 0 FROM cash_transactions WHERE user_id = ?`,
+}
+
+// snapshotTable describes every row of a derived table as a line of text so
+// two snapshots can be compared as multisets.
+func snapshotTable(q dbtx, userID int, table string) ([]string, error) {
+	return describeRows(q, snapshotQueries[table], userID)
+}
+
+// describeRows runs a snapshot query and describes each row it returns.
+func describeRows(q dbtx, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var label, openDate, closeDate string
+		var quantity, basis, closePrice, profitLoss types.Decimal
+		if err := rows.Scan(&label, &quantity, &basis, &openDate, &closeDate, &closePrice, &profitLoss); err != nil {
+			return nil, err
+		}
+
+		line := fmt.Sprintf("%s x%.4g @ $%.2f opened %s", label, quantity, basis, FormatDate(NormalizeDateToISO(openDate)))
+		if closeDate != "" {
+			line += fmt.Sprintf(", closed %s @ $%.2f, P/L $%.2f", FormatDate(NormalizeDateToISO(closeDate)), closePrice, profitLoss)
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+func diffSnapshots(table string, before, after []string) components.ReplayTableDiff {
+	diff := components.ReplayTableDiff{Table: table}
+
+	remaining := map[string]int{}
+	for _, line := range before {
+		remaining[line]++
+	}
+	for _, line := range after {
+		if remaining[line] > 0 {
+			remaining[line]--
+			diff.Unchanged++
+		} else {
+			diff.Added = append(diff.Added, line)
+		}
+	}
+	for _, line := range before {
+		if remaining[line] > 0 {
+			remaining[line]--
+			diff.Removed = append(diff.Removed, line)
+		}
+	}
+	return diff
+}
+
+func loadLedger(q dbtx, userID int) ([]ledgerEntry, error) {
+	var entries []ledgerEntry
+
+	stockRows, err := q.Query(`
+		SELECT seq, id, ticker, date, code, price, amount, quantity, fees, lot_method, lot_trade_id, option_trade_id, account_id
+		FROM stock_trades
+		WHERE user_id = ?
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer stockRows.Close()
+
+	for stockRows.Next() {
+		entry := ledgerEntry{Stock: &types.StockTrade{}}
+		t := entry.Stock
+		if err := stockRows.Scan(&entry.Seq, &t.ID, &t.Ticker, &t.Date, &t.Code, &t.Price, &t.Amount, &t.Quantity, &t.Fees, &t.LotMethod, &t.LotTradeID, &t.OptionTradeID, &t.AccountID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := stockRows.Err(); err != nil {
+		return nil, err
+	}
+
+	optionRows, err := q.Query(`
+		SELECT seq, id, ticker, date, code, price, amount, quantity, strike, exp_date, option_type, premium, multiplier, fees, strategy_key, roll_key, position_trade_id, account_id
+		FROM option_trades
+		WHERE user_id = ?
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer optionRows.Close()
+
+	for optionRows.Next() {
+		entry := ledgerEntry{Option: &types.OptionTrade{}}
+		t := entry.Option
+		if err := optionRows.Scan(&entry.Seq, &t.ID, &t.Ticker, &t.Date, &t.Code, &t.Price, &t.Amount, &t.Quantity, &t.Strike, &t.ExpDate, &t.OptionType, &t.Premium, &t.Multiplier, &t.Fees, &t.StrategyKey, &t.RollKey, &t.PositionTradeID, &t.AccountID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := optionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	actions, err := corporateActions(q, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := range actions {
+		entries = append(entries, ledgerEntry{Action: &actions[i]})
+	}
+
+	transfers, err := accountTransfers(q, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range transfers {
+		entries = append(entries, ledgerEntry{Transfer: &transfers[i]})
+	}
+
+	// Cash flows don't change positions but post to the account's cash.
+	cashRows, err := q.Query(`
+		SELECT id, date, type, ticker, amount, description, account_id
+		FROM cash_flows
+		WHERE user_id = ?
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer cashRows.Close()
+
+	for cashRows.Next() {
+		entry := ledgerEntry{Cash: &types.CashFlow{}}
+		f := entry.Cash
+		if err := cashRows.Scan(&f.ID, &f.Date, &f.Type, &f.Ticker, &f.Amount, &f.Description, &f.AccountID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := cashRows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortLedgerEntries(entries)
+	return entries, nil
+}
+
+// ReplayUser clears a user's positions and history and rebuilds them from
+// the trade ledger in date order. With dryRun set the rebuild is rolled back
+// and only the diff is returned. Unless dropGaps is set it won't save a
+// rebuild that would delete rows the ledger doesn't cover; the error wraps
+// ErrLedgerGap.
+func ReplayUser(userID int, dryRun, dropGaps bool) ([]components.ReplayTableDiff, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if !dryRun && !dropGaps {
+		gaps, err := ledgerGaps(tx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(gaps) > 0 {
+			return nil, fmt.Errorf("%w: %d rows", ErrLedgerGap, len(gaps))
+		}
+	}
+
+	diffs, err := replayLedger(tx, userID)
+	if err != nil || dryRun {
+		return diffs, err
+	}
+	return diffs, tx.Commit()
+}
+
+// replayLedger clears the user's derived tables inside q, rebuilds them
+// from the ledger and returns the diff.
+func replayLedger(q dbtx, userID int) ([]components.ReplayTableDiff, error) {
+	before := map[string][]string{}
+	for _, table := range derivedTables {
+		lines, err := snapshotTable(q, userID, table)
+		if err != nil {
+			return nil, err
+		}
+		before[table] = lines
+
+		if _, err := q.Exec(fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", table), userID); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := loadLedger(q, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if _, err := applyLedgerEntry(q, userID, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	var diffs []components.ReplayTableDiff
+	for _, table := range derivedTables {
+		after, err := snapshotTable(q, userID, table)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diffSnapshots(table, before[table], after))
+	}
+	return diffs, nil
+}
+
+// HandleReplayLedger previews a rebuild from the ledger and saves it only
+// once the preview is confirmed.
+func HandleReplayLedger(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rebuildWith(w, r, userID, "Rebuild from Ledger", "/api/ledger/replay", "positionAdded, historyUpdated", nil)
+}
+
+// rebuildWith makes change, if any, and rebuilds the user's positions and
+// history from the ledger in one transaction. Unless the request confirms
+// it, the transaction is rolled back and the rebuild is shown as a preview
+// whose confirm button posts back to confirmURL. Rows the ledger doesn't
+// cover are listed in the preview and only dropped when the confirmation
+// sets dropGaps. A change that finds nothing to change returns
+// sql.ErrNoRows.
+func rebuildWith(w http.ResponseWriter, r *http.Request, userID int, title, confirmURL, trigger string, change func(q dbtx) error) {
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Rows the change itself stops covering, like those of a reverted
+	// action, are meant to go.
+	gaps, err := ledgerGaps(tx, userID)
+	if err != nil {
+		http.Error(w, "Failed to check the ledger", http.StatusInternalServerError)
+		return
+	}
+
+	if change != nil {
+		err := change(tx)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save changes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	confirmed := r.FormValue("confirm") != ""
+	if confirmed && len(gaps) > 0 && r.FormValue("dropGaps") == "" {
+		http.Error(w, fmt.Sprintf("Failed to rebuild from ledger: %v: %d rows", ErrLedgerGap, len(gaps)), http.StatusConflict)
+		return
+	}
+	diffs, err := replayLedger(tx, userID)
+	if err != nil {
+		http.Error(w, "Failed to rebuild from ledger: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if !confirmed {
+		components.ReplayPreviewModal(title, diffs, confirmURL, gaps).Render(r.Context(), w)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to save rebuild", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", trigger)
+	components.ReplayResultModal(diffs).Render(r.Context(), w)
+}
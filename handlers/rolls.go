@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// rollChainFor returns the roll chain an opening trade continues, or 0 when
+// it is not the open of a roll. The first roll out of a position starts a
+// chain holding the leg it closed.
+func rollChainFor(q dbtx, userID int, trade types.OptionTrade) (int, error) {
+	if trade.RollKey == "" || (trade.Code != types.BTO && trade.Code != types.STO) {
+		return 0, nil
+	}
+
+	var rollID int
+	var startDate sql.NullString
+	err := q.QueryRow(`
+		SELECT COALESCE(MAX(c.roll_id), 0), MIN(c.purchase_date)
+		FROM closed_options c
+		JOIN option_trades t ON t.id = c.close_trade_id
+		WHERE c.user_id = ? AND t.user_id = ? AND t.roll_key = ? AND t.code IN (?, ?)
+	`, userID, userID, trade.RollKey, types.BTC, types.STC).Scan(&rollID, &startDate)
+	if err != nil || rollID != 0 || !startDate.Valid {
+		return rollID, err
+	}
+
+	result, err := q.Exec("INSERT INTO option_rolls (user_id, ticker, start_date) VALUES (?, ?, ?)", userID, trade.Ticker, startDate.String)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = q.Exec(`
+		UPDATE closed_options SET roll_id = ?
+		WHERE user_id = ? AND close_trade_id IN (
+			SELECT id FROM option_trades WHERE user_id = ? AND roll_key = ? AND code IN (?, ?)
+		)
+	`, newID, userID, userID, trade.RollKey, types.BTC, types.STC)
+	return int(newID), err
+}
+
+// detectRolls pairs each closing trade with an opening trade in a different
+// strike or expiry of the same ticker, right and side made the same day,
+// giving both a roll key. Strategy legs are left alone. The open of each
+// roll is moved after its close so the close applies first.
+func detectRolls(trades []types.OptionTrade) {
+	paired := map[int]bool{}
+	for i, c := range trades {
+		if (c.Code != types.BTC && c.Code != types.STC) || c.StrategyKey != "" || c.RollKey != "" {
+			continue
+		}
+		openCode := types.STO
+		if c.Code == types.STC {
+			openCode = types.BTO
+		}
+
+		match := -1
+		for j, o := range trades {
+			if paired[j] || o.Code != openCode || o.StrategyKey != "" || o.RollKey != "" ||
+				o.Ticker != c.Ticker || o.OptionType != c.OptionType || NormalizeDateToISO(o.Date) != NormalizeDateToISO(c.Date) {
+				continue
+			}
+			if o.Strike == c.Strike && NormalizeDateToISO(o.ExpDate) == NormalizeDateToISO(c.ExpDate) {
+				continue
+			}
+			if match == -1 || (o.Quantity == c.Quantity && trades[match].Quantity != c.Quantity) {
+				match = j
+			}
+		}
+		if match == -1 {
+			continue
+		}
+
+		o := trades[match]
+		key := fmt.Sprintf("roll|%s|%s|%s %.2f %s|%.2f %s", c.Ticker, NormalizeDateToISO(c.Date), c.OptionType,
+			c.Strike, NormalizeDateToISO(c.ExpDate), o.Strike, NormalizeDateToISO(o.ExpDate))
+		trades[i].RollKey = key
+		trades[match].RollKey = key
+		paired[match] = true
+	}
+
+	ordered := make([]types.OptionTrade, 0, len(trades))
+	waiting := map[string]types.OptionTrade{}
+	closed := map[string]bool{}
+	for _, t := range trades {
+		switch {
+		case t.RollKey == "":
+			ordered = append(ordered, t)
+		case t.Code == types.BTC || t.Code == types.STC:
+			ordered = append(ordered, t)
+			closed[t.RollKey] = true
+			if open, ok := waiting[t.RollKey]; ok {
+				ordered = append(ordered, open)
+			}
+		case closed[t.RollKey]:
+			ordered = append(ordered, t)
+		default:
+			waiting[t.RollKey] = t
+		}
+	}
+	copy(trades, ordered)
+}
+
+// rollLegs lists every leg of a roll chain, closed and open, oldest first.
+func rollLegs(q dbtx, userID, rollID int) ([]types.RollLeg, error) {
+	rows, err := q.Query(`
+		SELECT type, strike, exp_date, quantity, multiplier, purchase_date, premium, close_date, sell_price
+		FROM closed_options WHERE user_id = ? AND roll_id = ?
+		UNION ALL
+		SELECT type, strike, exp_date, quantity, multiplier, purchase_date, premium, '', 0
+		FROM option_positions WHERE user_id = ? AND roll_id = ? AND quantity > 0
+	`, userID, rollID, userID, rollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var legs []types.RollLeg
+	for rows.Next() {
+		var leg types.RollLeg
+		var multiplier types.Decimal
+		if err := rows.Scan(&leg.Type, &leg.Strike, &leg.ExpDate, &leg.Quantity, &multiplier, &leg.OpenDate, &leg.Premium, &leg.CloseDate, &leg.ClosePrice); err != nil {
+			return nil, err
+		}
+		leg.NetCredit = leg.Premium.Sub(leg.ClosePrice).Mul(leg.Quantity).Mul(multiplier)
+		if !leg.Type.Short() {
+			leg.NetCredit = leg.NetCredit.Neg()
+		}
+		legs = append(legs, leg)
+	}
+
+	sort.SliceStable(legs, func(i, j int) bool {
+		return NormalizeDateToISO(legs[i].OpenDate) < NormalizeDateToISO(legs[j].OpenDate)
+	})
+	return legs, rows.Err()
+}
+
+// annotateRolls fills in the roll count and net credit of positions that
+// belong to a roll chain. Each contract the chain moved out of is a roll.
+func annotateRolls(q dbtx, userID int, positions []types.OptionPos) error {
+	for i := range positions {
+		pos := &positions[i]
+		if pos.RollID == 0 {
+			continue
+		}
+
+		legs, err := rollLegs(q, userID, pos.RollID)
+		if err != nil {
+			return err
+		}
+		contracts := map[string]bool{}
+		for _, leg := range legs {
+			contracts[fmt.Sprintf("%s %.4f %s", leg.Type, leg.Strike, NormalizeDateToISO(leg.ExpDate))] = true
+			pos.RollNet = pos.RollNet.Add(leg.NetCredit)
+		}
+		pos.Rolls = len(contracts) - 1
+	}
+	return nil
+}
+
+func HandleRollOptionModal(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var pos types.OptionPos
+	err := db.QueryRow(`
+		SELECT id, ticker, premium, strike, exp_date, type, quantity
+		FROM option_positions
+		WHERE id = ? AND user_id = ?
+	`, chi.URLParam(r, "id"), userID).Scan(&pos.ID, &pos.Ticker, &pos.Premium, &pos.Strike, &pos.ExpDate, &pos.Type, &pos.Quantity)
+	if err != nil {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.RollOptionModal(pos, time.Now().Format("2006-01-02"), FormatDate).Render(r.Context(), w)
+}
+
+// HandleRollOption closes an option position and opens its replacement in
+// a new strike or expiry as one roll.
+func HandleRollOption(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var pos types.OptionPos
+	err := db.QueryRow(`
+		SELECT id, ticker, strike, exp_date, type, quantity, multiplier, open_trade_id, account_id
+		FROM option_positions
+		WHERE id = ? AND user_id = ?
+	`, chi.URLParam(r, "id"), userID).Scan(&pos.ID, &pos.Ticker, &pos.Strike, &pos.ExpDate, &pos.Type, &pos.Quantity, &pos.Multiplier, &pos.OpenTradeID, &pos.AccountID)
+	if err != nil {
+		http.Error(w, "Position not found", http.StatusNotFound)
+		return
+	}
+
+	quantity, _ := types.ParseDecimal(r.FormValue("quantity"))
+	closePrice, _ := types.ParseDecimal(r.FormValue("closePrice"))
+	newStrike, _ := types.ParseDecimal(r.FormValue("newStrike"))
+	newPremium, _ := types.ParseDecimal(r.FormValue("newPremium"))
+	newExpDate := r.FormValue("newExpDate")
+	rollDate := r.FormValue("rollDate")
+	if rollDate == "" {
+		rollDate = time.Now().Format("2006-01-02")
+	}
+
+	if quantity.Sign() <= 0 || quantity.Cmp(pos.Quantity) > 0 {
+		http.Error(w, "Invalid quantity to roll", http.StatusBadRequest)
+		return
+	}
+	if newStrike.Sign() <= 0 || newExpDate == "" {
+		http.Error(w, "The new leg needs a strike and an expiration date", http.StatusBadRequest)
+		return
+	}
+	if newStrike == pos.Strike && NormalizeDateToISO(newExpDate) == NormalizeDateToISO(pos.ExpDate) {
+		http.Error(w, "A roll needs a new strike or expiration date", http.StatusBadRequest)
+		return
+	}
+
+	key, err := newTradeID()
+	if err != nil {
+		http.Error(w, "Failed to roll position: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	contract := optionContract{Ticker: pos.Ticker, Strike: pos.Strike, ExpDate: pos.ExpDate, PositionType: pos.Type, Multiplier: pos.Multiplier}
+	closeTrade, _ := outcomeTrades(contract, OutcomeClosed, quantity, closePrice, types.Decimal{}, rollDate)
+	closeTrade.RollKey = key
+	closeTrade.PositionTradeID = pos.OpenTradeID
+	closeTrade.AccountID = pos.AccountID
+	openTrade := openingOptionTrade(pos.Ticker, rollDate, pos.Type, newStrike, newPremium, quantity, pos.Multiplier, newExpDate)
+	openTrade.RollKey = key
+	openTrade.AccountID = pos.AccountID
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, trade := range []*types.OptionTrade{&closeTrade, &openTrade} {
+		var entry ledgerEntry
+		entry, err = recordOptionTrade(tx, userID, trade, SourceManual)
+		if err == nil {
+			_, err = applyLedgerEntry(tx, userID, entry)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Failed to roll position: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "positionAdded, positionClosed")
+	components.ModalClose().Render(r.Context(), w)
+}
+
+func HandleRollChain(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rollID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	var ticker string
+	if err := db.QueryRow("SELECT ticker FROM option_rolls WHERE id = ? AND user_id = ?", rollID, userID).Scan(&ticker); err != nil {
+		http.Error(w, "Roll chain not found", http.StatusNotFound)
+		return
+	}
+
+	legs, err := rollLegs(db, userID, rollID)
+	if err != nil {
+		http.Error(w, "Failed to fetch roll chain", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.RollChainModal(ticker, legs, FormatDate).Render(r.Context(), w)
+}
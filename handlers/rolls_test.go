@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"backend/types"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// rollTrade is one execution on an F option, for roll detection.
+func rollTrade(t *testing.T, code types.TradeCode, optionType types.OptionType, date, strike, expDate, quantity string) types.OptionTrade {
+	t.Helper()
+	return types.OptionTrade{
+		Ticker:     "F",
+		Date:       date,
+		Code:       code,
+		OptionType: optionType,
+		Strike:     decimal(t, strike),
+		ExpDate:    expDate,
+		Quantity:   decimal(t, quantity),
+		Multiplier: types.StandardMultiplier,
+	}
+}
+
+func TestDetectRolls(t *testing.T) {
+	btc := func(date, strike, expDate, quantity string) types.OptionTrade {
+		return rollTrade(t, types.BTC, types.Put, date, strike, expDate, quantity)
+	}
+	sto := func(date, strike, expDate, quantity string) types.OptionTrade {
+		return rollTrade(t, types.STO, types.Put, date, strike, expDate, quantity)
+	}
+	leg := func(trade types.OptionTrade) types.OptionTrade {
+		trade.StrategyKey = "spread"
+		return trade
+	}
+	const down = "roll|F|2025-01-10|Put 10.00 2025-01-17|9.00 2025-02-21"
+
+	tests := []struct {
+		name   string
+		trades []types.OptionTrade
+		// keys is the roll key of each trade after detection, in the order
+		// detection leaves them.
+		keys []string
+		// order is the strike of each trade after detection.
+		order []string
+	}{
+		{
+			name:   "close and open the same day",
+			trades: []types.OptionTrade{btc("2025-01-10", "10", "2025-01-17", "1"), sto("2025-01-10", "9", "2025-02-21", "1")},
+			keys:   []string{down, down},
+			order:  []string{"10", "9"},
+		},
+		{
+			name:   "open listed before its close",
+			trades: []types.OptionTrade{sto("2025-01-10", "9", "2025-02-21", "1"), btc("2025-01-10", "10", "2025-01-17", "1")},
+			keys:   []string{down, down},
+			order:  []string{"10", "9"},
+		},
+		{
+			name:   "out in time at the same strike",
+			trades: []types.OptionTrade{btc("2025-01-10", "10", "2025-01-17", "1"), sto("2025-01-10", "10", "2025-02-21", "1")},
+			keys:   []string{"roll|F|2025-01-10|Put 10.00 2025-01-17|10.00 2025-02-21", "roll|F|2025-01-10|Put 10.00 2025-01-17|10.00 2025-02-21"},
+			order:  []string{"10", "10"},
+		},
+		{
+			name:   "reopening the same contract",
+			trades: []types.OptionTrade{btc("2025-01-10", "10", "2025-01-17", "1"), sto("2025-01-10", "10", "2025-01-17", "1")},
+			keys:   []string{"", ""},
+			order:  []string{"10", "10"},
+		},
+		{
+			name:   "open on another day",
+			trades: []types.OptionTrade{btc("2025-01-10", "10", "2025-01-17", "1"), sto("2025-01-13", "9", "2025-02-21", "1")},
+			keys:   []string{"", ""},
+			order:  []string{"10", "9"},
+		},
+		{
+			name: "open of the other right",
+			trades: []types.OptionTrade{
+				btc("2025-01-10", "10", "2025-01-17", "1"),
+				rollTrade(t, types.STO, types.Call, "2025-01-10", "12", "2025-02-21", "1"),
+			},
+			keys:  []string{"", ""},
+			order: []string{"10", "12"},
+		},
+		{
+			name:   "strategy legs",
+			trades: []types.OptionTrade{leg(btc("2025-01-10", "10", "2025-01-17", "1")), leg(sto("2025-01-10", "9", "2025-02-21", "1"))},
+			keys:   []string{"", ""},
+			order:  []string{"10", "9"},
+		},
+		{
+			name: "matching quantity preferred",
+			trades: []types.OptionTrade{
+				sto("2025-01-10", "8", "2025-02-21", "1"),
+				sto("2025-01-10", "9", "2025-02-21", "2"),
+				btc("2025-01-10", "10", "2025-01-17", "2"),
+			},
+			keys:  []string{"", "roll|F|2025-01-10|Put 10.00 2025-01-17|9.00 2025-02-21", "roll|F|2025-01-10|Put 10.00 2025-01-17|9.00 2025-02-21"},
+			order: []string{"8", "10", "9"},
+		},
+		{
+			name: "buying back a long call",
+			trades: []types.OptionTrade{
+				rollTrade(t, types.STC, types.Call, "2025-01-10", "10", "2025-01-17", "1"),
+				rollTrade(t, types.BTO, types.Call, "2025-01-10", "11", "2025-01-17", "1"),
+			},
+			keys:  []string{"roll|F|2025-01-10|Call 10.00 2025-01-17|11.00 2025-01-17", "roll|F|2025-01-10|Call 10.00 2025-01-17|11.00 2025-01-17"},
+			order: []string{"10", "11"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detectRolls(tt.trades)
+			var keys, order []string
+			for _, trade := range tt.trades {
+				keys = append(keys, trade.RollKey)
+				order = append(order, trade.Strike.String())
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+				t.Errorf("roll keys = %q, want %q", keys, tt.keys)
+			}
+			if strings.Join(order, ",") != strings.Join(tt.order, ",") {
+				t.Errorf("strikes in order = %v, want %v", order, tt.order)
+			}
+		})
+	}
+}
+
+// Rolling a position twice builds one chain holding every leg, and the
+// roll closes the position it was started from rather than an older one
+// on the same contract.
+func TestRollChain(t *testing.T) {
+	newTestDB(t)
+	userID, h := testUser(t, "roller")
+
+	applyOption(t, userID, openingOptionTrade("F", "2025-01-02", types.CSP, decimal(t, "10"), decimal(t, "0.5"), types.DecimalFromInt(2), types.StandardMultiplier, "2025-01-17"))
+	rolled := applyOption(t, userID, openingOptionTrade("F", "2025-01-03", types.CSP, decimal(t, "10"), decimal(t, "0.6"), types.DecimalFromInt(1), types.StandardMultiplier, "2025-01-17"))
+
+	var rolledID string
+	if err := db.QueryRow("SELECT id FROM option_positions WHERE user_id = ? AND open_trade_id = ?", userID, rolled.ID).Scan(&rolledID); err != nil {
+		t.Fatal(err)
+	}
+	postForm(t, h, "/api/positions/roll-option/"+rolledID, url.Values{
+		"quantity": {"1"}, "closePrice": {"0.2"}, "newStrike": {"9"}, "newPremium": {"0.4"}, "newExpDate": {"2025-02-21"}, "rollDate": {"2025-01-10"},
+	})
+
+	var untouched types.Decimal
+	if err := db.QueryRow("SELECT quantity FROM option_positions WHERE user_id = ? AND purchase_date = '2025-01-02'", userID).Scan(&untouched); err != nil {
+		t.Fatal(err)
+	}
+	if untouched.String() != "2" {
+		t.Errorf("older position has %s contracts after the roll, want 2", untouched)
+	}
+
+	var secondID string
+	if err := db.QueryRow("SELECT id FROM option_positions WHERE user_id = ? AND strike = ? AND quantity > 0", userID, decimal(t, "9")).Scan(&secondID); err != nil {
+		t.Fatal(err)
+	}
+	postForm(t, h, "/api/positions/roll-option/"+secondID, url.Values{
+		"quantity": {"1"}, "closePrice": {"0.1"}, "newStrike": {"8"}, "newPremium": {"0.35"}, "newExpDate": {"2025-03-21"}, "rollDate": {"2025-02-14"},
+	})
+
+	var chains int
+	if err := db.QueryRow("SELECT COUNT(*) FROM option_rolls WHERE user_id = ?", userID).Scan(&chains); err != nil {
+		t.Fatal(err)
+	}
+	if chains != 1 {
+		t.Fatalf("%d roll chains, want 1", chains)
+	}
+
+	var pos types.OptionPos
+	if err := db.QueryRow("SELECT id, roll_id FROM option_positions WHERE user_id = ? AND strike = ? AND quantity > 0", userID, decimal(t, "8")).Scan(&pos.ID, &pos.RollID); err != nil {
+		t.Fatal(err)
+	}
+	legs, err := rollLegs(db, userID, pos.RollID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened []string
+	for _, leg := range legs {
+		opened = append(opened, leg.OpenDate+" "+leg.Strike.String())
+	}
+	if want := "2025-01-03 10,2025-01-10 9,2025-02-14 8"; strings.Join(opened, ",") != want {
+		t.Errorf("chain legs = %v, want %s", opened, want)
+	}
+
+	positions := []types.OptionPos{pos}
+	if err := annotateRolls(db, userID, positions); err != nil {
+		t.Fatal(err)
+	}
+	// 40 kept on the first leg, 30 on the second and 35 open on the third.
+	if positions[0].Rolls != 2 || positions[0].RollNet.StringFixed(2) != "105.00" {
+		t.Errorf("rolls = %d, net = %s; want 2 and 105.00", positions[0].Rolls, positions[0].RollNet.StringFixed(2))
+	}
+}
@@ -0,0 +1,349 @@
+package handlers
+
+// SchemaSQL creates every table the app uses. Each statement is idempotent
+// so it runs on every start; columns added since a table was first created
+// are added by the migrations in main.
+const SchemaSQL = `
+CREATE TABLE IF NOT EXISTS data_migrations (
+    name TEXT PRIMARY KEY,
+    applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT UNIQUE NOT NULL,
+    password TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS stock_trades (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    date TEXT NOT NULL,
+    code TEXT NOT NULL,
+    price INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    quantity INTEGER NOT NULL,
+    seq INTEGER NOT NULL DEFAULT 0,
+    source TEXT NOT NULL DEFAULT 'import',
+    fingerprint TEXT NOT NULL DEFAULT '',
+    lot_method TEXT NOT NULL DEFAULT '',
+    lot_trade_id TEXT NOT NULL DEFAULT '',
+    option_trade_id TEXT NOT NULL DEFAULT '',
+    fees INTEGER NOT NULL DEFAULT 0,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS option_trades (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    date TEXT NOT NULL,
+    code TEXT NOT NULL,
+    price INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    quantity INTEGER NOT NULL,
+    strike INTEGER NOT NULL,
+    exp_date TEXT NOT NULL,
+    option_type TEXT NOT NULL,
+    premium INTEGER NOT NULL,
+    multiplier INTEGER NOT NULL DEFAULT 100000000,
+    seq INTEGER NOT NULL DEFAULT 0,
+    source TEXT NOT NULL DEFAULT 'import',
+    fingerprint TEXT NOT NULL DEFAULT '',
+    strategy_key TEXT NOT NULL DEFAULT '',
+    roll_key TEXT NOT NULL DEFAULT '',
+    position_trade_id TEXT NOT NULL DEFAULT '',
+    fees INTEGER NOT NULL DEFAULT 0,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS stock_positions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    open_date TEXT NOT NULL,
+    ticker TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    cost_basis INTEGER NOT NULL,
+    side TEXT NOT NULL DEFAULT 'long',
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, account_id, ticker, open_date)
+);
+
+CREATE TABLE IF NOT EXISTS stock_lots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    open_date TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    cost_basis INTEGER NOT NULL,
+    side TEXT NOT NULL DEFAULT 'long',
+    open_trade_id TEXT NOT NULL DEFAULT '',
+    wash_adjustment INTEGER NOT NULL DEFAULT 0,
+    wash_quantity INTEGER NOT NULL DEFAULT 0,
+    campaign_id INTEGER NOT NULL DEFAULT 0,
+    premium_adjustment INTEGER NOT NULL DEFAULT 0,
+    fees INTEGER NOT NULL DEFAULT 0,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS closed_stocks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    open_date TEXT NOT NULL,
+    close_date TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    cost_basis INTEGER NOT NULL,
+    sell_price INTEGER NOT NULL,
+    profit_loss INTEGER NOT NULL,
+    close_trade_id TEXT NOT NULL DEFAULT '',
+    lot_id INTEGER NOT NULL DEFAULT 0,
+    side TEXT NOT NULL DEFAULT 'long',
+    wash_disallowed INTEGER NOT NULL DEFAULT 0,
+    wash_quantity INTEGER NOT NULL DEFAULT 0,
+    wash_adjustment INTEGER NOT NULL DEFAULT 0,
+    campaign_id INTEGER NOT NULL DEFAULT 0,
+    premium_adjustment INTEGER NOT NULL DEFAULT 0,
+    fees INTEGER NOT NULL DEFAULT 0,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS option_positions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    price INTEGER NOT NULL,
+    premium INTEGER NOT NULL,
+    strike INTEGER NOT NULL,
+    exp_date TEXT NOT NULL,
+    type TEXT NOT NULL,
+    collateral INTEGER NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 1000000,
+    purchase_date TEXT NOT NULL,
+    open_trade_id TEXT NOT NULL DEFAULT '',
+    wash_adjustment INTEGER NOT NULL DEFAULT 0,
+    wash_quantity INTEGER NOT NULL DEFAULT 0,
+    strategy_id INTEGER NOT NULL DEFAULT 0,
+    campaign_id INTEGER NOT NULL DEFAULT 0,
+    roll_id INTEGER NOT NULL DEFAULT 0,
+    multiplier INTEGER NOT NULL DEFAULT 100000000,
+    fees INTEGER NOT NULL DEFAULT 0,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS closed_options (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    price INTEGER NOT NULL,
+    premium INTEGER NOT NULL,
+    strike INTEGER NOT NULL,
+    exp_date TEXT NOT NULL,
+    type TEXT NOT NULL,
+    collateral INTEGER NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 1000000,
+    purchase_date TEXT NOT NULL,
+    close_date TEXT NOT NULL,
+    sell_price INTEGER NOT NULL,
+    profit_loss INTEGER NOT NULL,
+    open_trade_id TEXT NOT NULL DEFAULT '',
+    close_trade_id TEXT NOT NULL DEFAULT '',
+    wash_disallowed INTEGER NOT NULL DEFAULT 0,
+    wash_quantity INTEGER NOT NULL DEFAULT 0,
+    wash_adjustment INTEGER NOT NULL DEFAULT 0,
+    strategy_id INTEGER NOT NULL DEFAULT 0,
+    campaign_id INTEGER NOT NULL DEFAULT 0,
+    roll_id INTEGER NOT NULL DEFAULT 0,
+    multiplier INTEGER NOT NULL DEFAULT 100000000,
+    premium_to_basis INTEGER NOT NULL DEFAULT 0,
+    fees INTEGER NOT NULL DEFAULT 0,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS option_strategies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    strategy_key TEXT NOT NULL,
+    ticker TEXT NOT NULL,
+    open_date TEXT NOT NULL,
+    exp_date TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, strategy_key)
+);
+
+CREATE TABLE IF NOT EXISTS wheel_campaigns (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    start_date TEXT NOT NULL,
+    end_date TEXT NOT NULL DEFAULT '',
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS option_rolls (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    start_date TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS corporate_actions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    type TEXT NOT NULL,
+    effective_date TEXT NOT NULL,
+    old_shares INTEGER NOT NULL DEFAULT 1000000,
+    new_shares INTEGER NOT NULL DEFAULT 1000000,
+    new_ticker TEXT NOT NULL DEFAULT '',
+    cash_per_share INTEGER NOT NULL DEFAULT 0,
+    applied INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, ticker, type, effective_date)
+);
+
+CREATE TABLE IF NOT EXISTS corporate_action_adjustments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    action_id INTEGER NOT NULL,
+    record TEXT NOT NULL,
+    record_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    field TEXT NOT NULL,
+    old_value TEXT NOT NULL,
+    new_value TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cash_flows (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    type TEXT NOT NULL,
+    ticker TEXT NOT NULL DEFAULT '',
+    amount INTEGER NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL DEFAULT 'import',
+    fingerprint TEXT NOT NULL DEFAULT '',
+    account_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cash_transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    date TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    amount INTEGER NOT NULL,
+    trade_id TEXT NOT NULL DEFAULT '',
+    cash_flow_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS account_transfers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    ticker TEXT NOT NULL DEFAULT '',
+    from_account INTEGER NOT NULL,
+    to_account INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id INTEGER PRIMARY KEY,
+    lot_method TEXT NOT NULL DEFAULT 'fifo',
+    adjusted_basis INTEGER NOT NULL DEFAULT 0,
+    account_view INTEGER NOT NULL DEFAULT -1,
+    utilization_limit INTEGER NOT NULL DEFAULT 100,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS csv_mapping_profiles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    header TEXT NOT NULL,
+    columns TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_trades_user_id ON stock_trades(user_id);
+CREATE INDEX IF NOT EXISTS idx_stock_trades_ticker ON stock_trades(ticker);
+CREATE INDEX IF NOT EXISTS idx_option_trades_user_id ON option_trades(user_id);
+CREATE INDEX IF NOT EXISTS idx_option_trades_ticker ON option_trades(ticker);
+CREATE INDEX IF NOT EXISTS idx_stock_positions_user_id ON stock_positions(user_id);
+CREATE INDEX IF NOT EXISTS idx_stock_positions_ticker ON stock_positions(ticker);
+CREATE INDEX IF NOT EXISTS idx_stock_lots_user_ticker ON stock_lots(user_id, ticker);
+CREATE INDEX IF NOT EXISTS idx_closed_stocks_user_id ON closed_stocks(user_id);
+CREATE INDEX IF NOT EXISTS idx_closed_options_user_id ON closed_options(user_id);
+CREATE INDEX IF NOT EXISTS idx_cash_flows_user_id ON cash_flows(user_id, date);
+CREATE INDEX IF NOT EXISTS idx_cash_transactions_user_id ON cash_transactions(user_id, date);
+CREATE INDEX IF NOT EXISTS idx_corporate_action_adjustments_action ON corporate_action_adjustments(action_id);
+`
+
+// IndexSQL creates the indexes on migrated columns. It runs after the
+// migrations so older databases have the columns by the time the index is
+// created.
+const IndexSQL = `
+CREATE INDEX IF NOT EXISTS idx_stock_trades_seq ON stock_trades(user_id, seq);
+CREATE INDEX IF NOT EXISTS idx_option_trades_seq ON option_trades(user_id, seq);
+DROP INDEX IF EXISTS idx_stock_trades_fingerprint;
+DROP INDEX IF EXISTS idx_option_trades_fingerprint;
+DROP INDEX IF EXISTS idx_cash_flows_fingerprint;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_trades_account_fingerprint ON stock_trades(user_id, account_id, fingerprint) WHERE fingerprint != '';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_option_trades_account_fingerprint ON option_trades(user_id, account_id, fingerprint) WHERE fingerprint != '';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cash_flows_account_fingerprint ON cash_flows(user_id, account_id, fingerprint) WHERE fingerprint != '';
+CREATE INDEX IF NOT EXISTS idx_option_positions_strategy ON option_positions(strategy_id);
+CREATE INDEX IF NOT EXISTS idx_closed_options_strategy ON closed_options(strategy_id);
+CREATE INDEX IF NOT EXISTS idx_wheel_campaigns_user_ticker ON wheel_campaigns(user_id, ticker);
+CREATE INDEX IF NOT EXISTS idx_option_trades_roll_key ON option_trades(user_id, roll_key) WHERE roll_key != '';
+CREATE INDEX IF NOT EXISTS idx_closed_options_roll ON closed_options(roll_id);
+CREATE INDEX IF NOT EXISTS idx_stock_lots_account ON stock_lots(user_id, account_id, ticker);
+CREATE INDEX IF NOT EXISTS idx_option_positions_account ON option_positions(user_id, account_id);
+`
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"net/http"
+)
+
+func renderLotMethodSetting(w http.ResponseWriter, r *http.Request, method types.LotMethod) {
+	var options []components.LotMethodOption
+	for _, m := range lotMethods {
+		options = append(options, components.LotMethodOption{Value: string(m), Label: lotMethodLabel(m)})
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.LotMethodSetting(string(method), options).Render(r.Context(), w)
+}
+
+func HandleGetLotMethod(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	method, err := userLotMethod(db, userID)
+	if err != nil {
+		http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+	renderLotMethodSetting(w, r, method)
+}
+
+func HandleUpdateLotMethod(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	method := types.LotMethod(r.FormValue("lotMethod"))
+	if !validLotMethod(method) {
+		http.Error(w, "Invalid lot method", http.StatusBadRequest)
+		return
+	}
+
+	if err := setUserLotMethod(db, userID, method); err != nil {
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+	renderLotMethodSetting(w, r, method)
+}
@@ -0,0 +1,534 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// strategyIDForKey returns the id of the user's strategy with this key, or
+// 0 when the key is empty or no legs have opened under it yet.
+func strategyIDForKey(q dbtx, userID int, key string) (int, error) {
+	if key == "" {
+		return 0, nil
+	}
+
+	var id int
+	err := q.QueryRow("SELECT id FROM option_strategies WHERE user_id = ? AND strategy_key = ?", userID, key).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// openStrategy returns the strategy an opening trade belongs to, creating
+// it for the first leg. Trades without a strategy key return 0.
+func openStrategy(q dbtx, userID int, trade types.OptionTrade) (int, error) {
+	id, err := strategyIDForKey(q, userID, trade.StrategyKey)
+	if err != nil || id != 0 || trade.StrategyKey == "" {
+		return id, err
+	}
+
+	result, err := q.Exec(`
+		INSERT INTO option_strategies (user_id, strategy_key, ticker, open_date, exp_date)
+		VALUES (?, ?, ?, ?, ?)
+	`, userID, trade.StrategyKey, trade.Ticker, trade.Date, NormalizeDateToISO(trade.ExpDate))
+	if err != nil {
+		return 0, err
+	}
+	newID, err := result.LastInsertId()
+	return int(newID), err
+}
+
+// detectStrategies gives opening trades that share an account, ticker,
+// trade date and expiry a common strategy key when they cover more than one
+// contract, so an imported spread or iron condor is grouped. Several fills of the
+// same contract stay a single position.
+func detectStrategies(trades []types.OptionTrade) {
+	groups := map[string][]int{}
+	var order []string
+	for i, t := range trades {
+		if (t.Code != types.BTO && t.Code != types.STO) || t.StrategyKey != "" {
+			continue
+		}
+		key := strings.Join([]string{strconv.Itoa(t.AccountID), t.Ticker, NormalizeDateToISO(t.Date), NormalizeDateToISO(t.ExpDate)}, "|")
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	for _, key := range order {
+		contracts := map[string]bool{}
+		for _, i := range groups[key] {
+			contracts[fmt.Sprintf("%s %s %.4f", trades[i].Code, trades[i].OptionType, trades[i].Strike)] = true
+		}
+		if len(contracts) < 2 {
+			continue
+		}
+		for _, i := range groups[key] {
+			trades[i].StrategyKey = key
+		}
+	}
+}
+
+// strategyLeg is one contract of a strategy, with fills of the same
+// contract merged. shares is the contracts times their multiplier and cost
+// is the premium paid or collected for them, so merged fills keep their
+// exact total even when their multipliers differ.
+type strategyLeg struct {
+	long   bool
+	call   bool
+	strike types.Decimal
+	cost   types.Decimal
+	shares types.Decimal
+}
+
+func newStrategyLeg(positionType types.OptionType, strike, premium, quantity, multiplier types.Decimal) strategyLeg {
+	shares := quantity.Mul(multiplier)
+	return strategyLeg{
+		long:   positionType == types.Call || positionType == types.Put,
+		call:   positionType == types.Call || positionType == types.CC,
+		strike: strike,
+		cost:   premium.Mul(shares),
+		shares: shares,
+	}
+}
+
+// payoff is the leg's P/L in dollars if the underlying is at price on
+// expiry.
+func (leg strategyLeg) payoff(price types.Decimal) types.Decimal {
+	intrinsic := types.MaxDecimal(price.Sub(leg.strike), types.Decimal{})
+	if !leg.call {
+		intrinsic = types.MaxDecimal(leg.strike.Sub(price), types.Decimal{})
+	}
+	profitLoss := intrinsic.Mul(leg.shares).Sub(leg.cost)
+	if !leg.long {
+		return profitLoss.Neg()
+	}
+	return profitLoss
+}
+
+// mergeLegs combines legs in the same contract and sorts the result by
+// strike.
+func mergeLegs(legs []strategyLeg) []strategyLeg {
+	var merged []strategyLeg
+	for _, leg := range legs {
+		found := false
+		for i := range merged {
+			m := &merged[i]
+			if m.long == leg.long && m.call == leg.call && m.strike == leg.strike {
+				m.cost = m.cost.Add(leg.cost)
+				m.shares = m.shares.Add(leg.shares)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, leg)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].strike.Cmp(merged[j].strike) < 0 })
+	return merged
+}
+
+// netPremium is the premium collected opening the legs, negative for a
+// debit.
+func netPremium(legs []strategyLeg) types.Decimal {
+	var total types.Decimal
+	for _, leg := range legs {
+		amount := leg.cost
+		if leg.long {
+			amount = amount.Neg()
+		}
+		total = total.Add(amount)
+	}
+	return total
+}
+
+// strategyKind names common shapes; anything else is Custom.
+func strategyKind(legs []strategyLeg) string {
+	legs = mergeLegs(legs)
+
+	switch len(legs) {
+	case 1:
+		return "Single Leg"
+	case 2:
+		a, b := legs[0], legs[1]
+		switch {
+		case a.call == b.call && a.long != b.long:
+			right := "Put"
+			if a.call {
+				right = "Call"
+			}
+			if netPremium(legs).Sign() > 0 {
+				return right + " Credit Spread"
+			}
+			return right + " Debit Spread"
+		case a.call != b.call && a.long == b.long:
+			name := "Strangle"
+			if a.strike == b.strike {
+				name = "Straddle"
+			}
+			if a.long {
+				return "Long " + name
+			}
+			return "Short " + name
+		}
+	case 4:
+		// Legs are sorted by strike: long put, short put, short call, long
+		// call, with the short strikes equal for a butterfly.
+		p1, p2, c1, c2 := legs[0], legs[1], legs[2], legs[3]
+		if !p1.call && !p2.call && c1.call && c2.call && p1.long && !p2.long && !c1.long && c2.long && p2.strike.Cmp(c1.strike) < 0 {
+			return "Iron Condor"
+		}
+		if p1.long && !p1.call && c2.long && c2.call && p2.strike == c1.strike && !p2.long && !c1.long && p2.call != c1.call {
+			return "Iron Butterfly"
+		}
+	}
+	return "Custom"
+}
+
+// analyzeStrategy fills in the strategy's kind, net premium and risk at
+// expiry from its open legs. The payoff is linear between strikes, so it
+// is checked at zero and at each strike, and its slope above the highest
+// strike decides whether profit or loss is unlimited.
+func analyzeStrategy(strategy *types.OptionStrategy) {
+	var legs []strategyLeg
+	var legCollateral types.Decimal
+	for _, pos := range strategy.Legs {
+		legs = append(legs, newStrategyLeg(pos.Type, pos.Strike, pos.Premium, pos.Quantity, pos.Multiplier))
+		legCollateral = legCollateral.Add(pos.Collateral)
+	}
+	legs = mergeLegs(legs)
+
+	strategy.Kind = strategyKind(legs)
+	strategy.NetPremium = netPremium(legs)
+
+	prices := []types.Decimal{{}}
+	for _, leg := range legs {
+		if leg.strike != prices[len(prices)-1] {
+			prices = append(prices, leg.strike)
+		}
+	}
+
+	values := make([]types.Decimal, len(prices))
+	for i, price := range prices {
+		for _, leg := range legs {
+			values[i] = values[i].Add(leg.payoff(price))
+		}
+	}
+
+	var slope types.Decimal
+	for _, leg := range legs {
+		if !leg.call {
+			continue
+		}
+		if leg.long {
+			slope = slope.Add(leg.shares)
+		} else {
+			slope = slope.Sub(leg.shares)
+		}
+	}
+
+	best, worst := values[0], values[0]
+	for _, value := range values {
+		best = types.MaxDecimal(best, value)
+		worst = types.MinDecimal(worst, value)
+	}
+	strategy.MaxProfit = types.MaxDecimal(best, types.Decimal{})
+	strategy.MaxLoss = types.MaxDecimal(worst.Neg(), types.Decimal{})
+	strategy.UnlimitedProfit = slope.Sign() > 0
+	strategy.UnlimitedLoss = slope.Sign() < 0
+	if strategy.UnlimitedProfit {
+		strategy.MaxProfit = types.Decimal{}
+	}
+	if strategy.UnlimitedLoss {
+		strategy.MaxLoss = types.Decimal{}
+	}
+
+	strategy.Breakevens = nil
+	for i := 1; i < len(values); i++ {
+		switch {
+		case values[i-1].Sign()*values[i].Sign() < 0:
+			// The payoff crosses zero between the two prices
+			step := prices[i].Sub(prices[i-1]).MulDiv(values[i-1], values[i-1].Sub(values[i]))
+			strategy.Breakevens = append(strategy.Breakevens, prices[i-1].Add(step))
+		case values[i].IsZero() && !values[i-1].IsZero():
+			strategy.Breakevens = append(strategy.Breakevens, prices[i])
+		}
+	}
+	if last := values[len(values)-1]; last.Sign()*slope.Sign() < 0 {
+		strategy.Breakevens = append(strategy.Breakevens, prices[len(prices)-1].Sub(last.Div(slope)))
+	}
+
+	// A defined-risk strategy ties up its maximum loss; otherwise each leg
+	// needs its own collateral.
+	strategy.Collateral = legCollateral
+	if !strategy.UnlimitedLoss {
+		strategy.Collateral = strategy.MaxLoss
+	}
+}
+
+// openStrategies loads the user's strategies in the scoped account that
+// still have open legs, newest first. A non-zero strategyID loads just that
+// strategy.
+func openStrategies(q dbtx, userID int, scope accountScope, strategyID int) ([]types.OptionStrategy, error) {
+	query := `
+		SELECT s.id, s.ticker, s.open_date, s.exp_date,
+		       p.id, p.ticker, p.price, p.premium, p.strike, p.exp_date, p.type, p.collateral, p.quantity, p.purchase_date, p.strategy_id, p.multiplier, p.open_trade_id, p.account_id
+		FROM option_strategies s
+		JOIN option_positions p ON p.strategy_id = s.id
+		WHERE s.user_id = ? AND p.quantity > 0` + scope.filter("p.account_id")
+	args := scope.args(userID)
+	if strategyID != 0 {
+		query += ` AND s.id = ?`
+		args = append(args, strategyID)
+	}
+	query += ` ORDER BY s.open_date DESC, s.id DESC, p.strike ASC, p.id ASC`
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var strategies []types.OptionStrategy
+	for rows.Next() {
+		var s types.OptionStrategy
+		var leg types.OptionPos
+		if err := rows.Scan(&s.ID, &s.Ticker, &s.OpenDate, &s.ExpDate,
+			&leg.ID, &leg.Ticker, &leg.Price, &leg.Premium, &leg.Strike, &leg.ExpDate, &leg.Type, &leg.Collateral, &leg.Quantity, &leg.PurchaseDate, &leg.StrategyID, &leg.Multiplier, &leg.OpenTradeID, &leg.AccountID); err != nil {
+			return nil, err
+		}
+		leg.Account = scope.label(leg.AccountID)
+		if n := len(strategies); n == 0 || strategies[n-1].ID != s.ID {
+			strategies = append(strategies, s)
+		}
+		current := &strategies[len(strategies)-1]
+		current.Legs = append(current.Legs, leg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range strategies {
+		analyzeStrategy(&strategies[i])
+	}
+	return strategies, nil
+}
+
+// closedStrategies loads strategies in the scoped account with no open legs
+// left, most recently closed first, with the combined P/L of their legs.
+func closedStrategies(q dbtx, userID int, scope accountScope) ([]types.ClosedStrategy, error) {
+	rows, err := q.Query(`
+		SELECT s.id, s.ticker, s.open_date, s.exp_date, c.type, c.strike, c.premium, c.quantity, c.multiplier, c.close_date, c.profit_loss
+		FROM option_strategies s
+		JOIN closed_options c ON c.strategy_id = s.id
+		WHERE s.user_id = ? AND NOT EXISTS (
+			SELECT 1 FROM option_positions p WHERE p.strategy_id = s.id AND p.quantity > 0
+		)`+scope.filter("c.account_id")+`
+		ORDER BY s.id ASC, c.id ASC
+	`, scope.args(userID)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var strategies []types.ClosedStrategy
+	var legs [][]strategyLeg
+	for rows.Next() {
+		var s types.ClosedStrategy
+		var positionType types.OptionType
+		var strike, premium, quantity, multiplier, profitLoss types.Decimal
+		var closeDate string
+		if err := rows.Scan(&s.ID, &s.Ticker, &s.OpenDate, &s.ExpDate, &positionType, &strike, &premium, &quantity, &multiplier, &closeDate, &profitLoss); err != nil {
+			return nil, err
+		}
+		if n := len(strategies); n == 0 || strategies[n-1].ID != s.ID {
+			strategies = append(strategies, s)
+			legs = append(legs, nil)
+		}
+		current := &strategies[len(strategies)-1]
+		current.ProfitLoss = current.ProfitLoss.Add(profitLoss)
+		if NormalizeDateToISO(closeDate) > current.CloseDate {
+			current.CloseDate = NormalizeDateToISO(closeDate)
+		}
+		legs[len(legs)-1] = append(legs[len(legs)-1], newStrategyLeg(positionType, strike, premium, quantity, multiplier))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range strategies {
+		merged := mergeLegs(legs[i])
+		strategies[i].Kind = strategyKind(merged)
+		strategies[i].Legs = len(merged)
+		strategies[i].NetPremium = netPremium(merged)
+	}
+	sort.SliceStable(strategies, func(i, j int) bool { return strategies[i].CloseDate > strategies[j].CloseDate })
+	return strategies, nil
+}
+
+// strategyLegTrades builds the opening trades for the legs entered in the
+// Add Position modal, all sharing a new strategy key.
+func strategyLegTrades(r *http.Request, ticker, openDate string, quantity types.Decimal) ([]types.OptionTrade, error) {
+	key, err := newTradeID()
+	if err != nil {
+		return nil, err
+	}
+
+	legTypes := r.Form["legType"]
+	strikes := r.Form["legStrike"]
+	premiums := r.Form["legPremium"]
+
+	var trades []types.OptionTrade
+	for i, legType := range legTypes {
+		if legType == "" || i >= len(strikes) || i >= len(premiums) {
+			continue
+		}
+		strike, err := types.ParseDecimal(strikes[i])
+		if err != nil || strike.Sign() <= 0 {
+			return nil, fmt.Errorf("leg %d needs a strike", i+1)
+		}
+		premium, _ := types.ParseDecimal(premiums[i])
+
+		trade := openingOptionTrade(ticker, openDate, types.OptionType(legType), strike, premium, quantity, formMultiplier(r), r.FormValue("expDate"))
+		trade.StrategyKey = key
+		trades = append(trades, trade)
+	}
+	if len(trades) < 2 {
+		return nil, fmt.Errorf("a strategy needs at least two legs")
+	}
+	return trades, nil
+}
+
+func HandleGetStrategies(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	strategies, err := openStrategies(db, userID, requestAccountScope(r, userID), 0)
+	if err != nil {
+		http.Error(w, "Failed to fetch strategies", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.StrategiesTable(strategies, FormatDate).Render(r.Context(), w)
+}
+
+func HandleCloseStrategyModal(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	strategyID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	strategies, err := openStrategies(db, userID, accountScope{View: types.AllAccounts}, strategyID)
+	if err != nil || len(strategies) == 0 {
+		http.Error(w, "Strategy not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.CloseStrategyModal(strategies[0], time.Now().Format("2006-01-02"), FormatDate).Render(r.Context(), w)
+}
+
+// HandleCloseStrategy closes every open leg of a strategy at the prices
+// entered for each leg, in one transaction.
+func HandleCloseStrategy(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	closeDate := r.FormValue("closeDate")
+	if closeDate == "" {
+		closeDate = time.Now().Format("2006-01-02")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	strategyID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	strategies, err := openStrategies(tx, userID, accountScope{View: types.AllAccounts}, strategyID)
+	if err != nil || len(strategies) == 0 {
+		http.Error(w, "Strategy not found", http.StatusNotFound)
+		return
+	}
+
+	var key string
+	if err := tx.QueryRow("SELECT strategy_key FROM option_strategies WHERE id = ?", strategyID).Scan(&key); err != nil {
+		http.Error(w, "Strategy not found", http.StatusNotFound)
+		return
+	}
+
+	for _, leg := range strategies[0].Legs {
+		price, err := types.ParseDecimal(r.FormValue(fmt.Sprintf("price-%d", leg.ID)))
+		if err != nil || price.Sign() < 0 {
+			http.Error(w, fmt.Sprintf("Invalid close price for the %s $%.2f leg", leg.Type, leg.Strike), http.StatusBadRequest)
+			return
+		}
+		contract := optionContract{Ticker: leg.Ticker, Strike: leg.Strike, ExpDate: leg.ExpDate, PositionType: leg.Type, Multiplier: leg.Multiplier}
+		closeTrade, _ := outcomeTrades(contract, OutcomeClosed, leg.Quantity, price, types.Decimal{}, closeDate)
+		closeTrade.StrategyKey = key
+		closeTrade.PositionTradeID = leg.OpenTradeID
+		closeTrade.AccountID = leg.AccountID
+
+		entry, err := recordOptionTrade(tx, userID, &closeTrade, SourceManual)
+		if err == nil {
+			_, err = applyLedgerEntry(tx, userID, entry)
+		}
+		if err != nil {
+			http.Error(w, "Failed to close strategy: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to close strategy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "positionClosed")
+	components.ModalClose().Render(r.Context(), w)
+}
+
+func HandleGetClosedStrategies(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	strategies, err := closedStrategies(db, userID, requestAccountScope(r, userID))
+	if err != nil {
+		http.Error(w, "Failed to fetch closed strategies", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.ClosedStrategiesTable(strategies, FormatDate).Render(r.Context(), w)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"backend/types"
+	"fmt"
+	"testing"
+)
+
+func TestDetectStrategies(t *testing.T) {
+	opening := func(code types.TradeCode, optionType types.OptionType, ticker, date, strike string, accountID int) types.OptionTrade {
+		return types.OptionTrade{
+			Ticker:     ticker,
+			Date:       date,
+			Code:       code,
+			OptionType: optionType,
+			Strike:     decimal(t, strike),
+			ExpDate:    "2025-02-21",
+			AccountID:  accountID,
+		}
+	}
+	trades := []types.OptionTrade{
+		opening(types.STO, types.Put, "SPY", "2025-01-02", "500", 1),
+		opening(types.BTO, types.Put, "SPY", "01/02/2025", "495", 1),
+		// The same spread in another account is its own strategy.
+		opening(types.STO, types.Put, "SPY", "2025-01-02", "500", 2),
+		opening(types.BTO, types.Put, "SPY", "2025-01-02", "495", 2),
+		// Fills of one contract stay a single position.
+		opening(types.STO, types.Call, "QQQ", "2025-01-02", "600", 1),
+		opening(types.STO, types.Call, "QQQ", "2025-01-02", "600", 1),
+		// Closes are never grouped.
+		opening(types.BTC, types.Put, "SPY", "2025-01-02", "490", 1),
+		// Legs opened on different days aren't one strategy.
+		opening(types.BTO, types.Call, "AAPL", "2025-01-02", "100", 1),
+		opening(types.STO, types.Call, "AAPL", "2025-01-03", "110", 1),
+		opening(types.BTO, types.Put, "SPY", "2025-01-02", "480", 1),
+	}
+	trades[9].StrategyKey = "entered"
+
+	detectStrategies(trades)
+
+	want := []string{
+		"1|SPY|2025-01-02|2025-02-21",
+		"1|SPY|2025-01-02|2025-02-21",
+		"2|SPY|2025-01-02|2025-02-21",
+		"2|SPY|2025-01-02|2025-02-21",
+		"", "", "", "", "",
+		"entered",
+	}
+	for i, trade := range trades {
+		if trade.StrategyKey != want[i] {
+			t.Errorf("trade %d has strategy key %q, want %q", i, trade.StrategyKey, want[i])
+		}
+	}
+}
+
+func TestAnalyzeStrategy(t *testing.T) {
+	type leg struct {
+		positionType                          types.OptionType
+		strike, premium, quantity, collateral string
+	}
+	tests := []struct {
+		name               string
+		legs               []leg
+		kind               string
+		net                string
+		maxProfit, maxLoss string
+		unlimited          string
+		breakevens         []string
+		collateral         string
+	}{
+		{
+			name:       "put credit spread",
+			legs:       []leg{{types.CSP, "500", "5", "2", "100000"}, {types.Put, "495", "3", "2", "0"}},
+			kind:       "Put Credit Spread",
+			net:        "400",
+			maxProfit:  "400",
+			maxLoss:    "600",
+			breakevens: []string{"498"},
+			collateral: "600",
+		},
+		{
+			name:       "call debit spread",
+			legs:       []leg{{types.Call, "100", "5", "1", "0"}, {types.CC, "110", "2", "1", "0"}},
+			kind:       "Call Debit Spread",
+			net:        "-300",
+			maxProfit:  "700",
+			maxLoss:    "300",
+			breakevens: []string{"103"},
+			collateral: "300",
+		},
+		{
+			name: "iron condor",
+			legs: []leg{
+				{types.Put, "90", "1", "1", "0"}, {types.CSP, "95", "2", "1", "9500"},
+				{types.CC, "105", "2", "1", "0"}, {types.Call, "110", "1", "1", "0"},
+			},
+			kind:       "Iron Condor",
+			net:        "200",
+			maxProfit:  "200",
+			maxLoss:    "300",
+			breakevens: []string{"93", "107"},
+			collateral: "300",
+		},
+		{
+			name:       "short strangle",
+			legs:       []leg{{types.CSP, "95", "2", "1", "9500"}, {types.CC, "105", "2", "1", "0"}},
+			kind:       "Short Strangle",
+			net:        "400",
+			maxProfit:  "400",
+			unlimited:  "loss",
+			breakevens: []string{"91", "109"},
+			collateral: "9500",
+		},
+		{
+			name:       "long call",
+			legs:       []leg{{types.Call, "100", "3", "1", "0"}},
+			kind:       "Single Leg",
+			net:        "-300",
+			maxLoss:    "300",
+			unlimited:  "profit",
+			breakevens: []string{"103"},
+			collateral: "300",
+		},
+		{
+			name:       "fills of one contract merge",
+			legs:       []leg{{types.CSP, "50", "1", "1", "5000"}, {types.CSP, "50", "1.5", "1", "5000"}, {types.Put, "45", "0.5", "2", "0"}},
+			kind:       "Put Credit Spread",
+			net:        "150",
+			maxProfit:  "150",
+			maxLoss:    "850",
+			breakevens: []string{"49.25"},
+			collateral: "850",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var strategy types.OptionStrategy
+			for _, l := range tt.legs {
+				strategy.Legs = append(strategy.Legs, types.OptionPos{
+					Type:       l.positionType,
+					Strike:     decimal(t, l.strike),
+					Premium:    decimal(t, l.premium),
+					Quantity:   decimal(t, l.quantity),
+					Multiplier: types.StandardMultiplier,
+					Collateral: decimal(t, l.collateral),
+				})
+			}
+			analyzeStrategy(&strategy)
+
+			zero := func(s string) types.Decimal {
+				if s == "" {
+					return types.Decimal{}
+				}
+				return decimal(t, s)
+			}
+			if strategy.Kind != tt.kind {
+				t.Errorf("kind %q, want %q", strategy.Kind, tt.kind)
+			}
+			if strategy.NetPremium != decimal(t, tt.net) {
+				t.Errorf("net premium %s, want %s", strategy.NetPremium, tt.net)
+			}
+			if strategy.MaxProfit != zero(tt.maxProfit) || strategy.MaxLoss != zero(tt.maxLoss) {
+				t.Errorf("max profit %s and loss %s, want %s and %s", strategy.MaxProfit, strategy.MaxLoss, tt.maxProfit, tt.maxLoss)
+			}
+			if strategy.UnlimitedProfit != (tt.unlimited == "profit") || strategy.UnlimitedLoss != (tt.unlimited == "loss") {
+				t.Errorf("unlimited profit %v, loss %v, want unlimited %q", strategy.UnlimitedProfit, strategy.UnlimitedLoss, tt.unlimited)
+			}
+			if fmt.Sprint(strategy.Breakevens) != fmt.Sprint(tt.breakevens) {
+				t.Errorf("breakevens %v, want %v", strategy.Breakevens, tt.breakevens)
+			}
+			if strategy.Collateral != decimal(t, tt.collateral) {
+				t.Errorf("collateral %s, want %s", strategy.Collateral, tt.collateral)
+			}
+		})
+	}
+}
@@ -81,3 +81,16 @@ func NormalizeDateToMMDDYY(dateStr string) string {
 
 	return t.Format("01/02/06")
 }
+
+func NormalizeDateToISO(dateStr string) string {
+	if dateStr == "" {
+		return ""
+	}
+
+	t, err := ParseDateToTime(dateStr)
+	if err != nil {
+		return dateStr
+	}
+
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"backend/types"
+	"fmt"
+	"strings"
+)
+
+// washSecurity describes what counts as substantially identical for wash
+// sales: long shares of the same ticker, or the same option contract.
+// Losses live in closedTable and replacement purchases in openTable.
+type washSecurity struct {
+	closedTable string
+	openTable   string
+	openDate    string
+	where       string
+	args        []interface{}
+}
+
+func stockWashSecurity(ticker string) washSecurity {
+	return washSecurity{
+		closedTable: "closed_stocks",
+		openTable:   "stock_lots",
+		openDate:    "open_date",
+		where:       "ticker = ? AND side = ?",
+		args:        []interface{}{ticker, types.Long},
+	}
+}
+
+func optionWashSecurity(ticker string, positionType types.OptionType, strike types.Decimal, expDate string) washSecurity {
+	return washSecurity{
+		closedTable: "closed_options",
+		openTable:   "option_positions",
+		openDate:    "purchase_date",
+		where:       "ticker = ? AND type = ? AND strike = ? AND exp_date = ?",
+		args:        []interface{}{ticker, positionType, strike, expDate},
+	}
+}
+
+// Only bought options are purchases; writing a contract can't replace one.
+func optionWashApplies(positionType types.OptionType) bool {
+	return positionType == types.Call || positionType == types.Put
+}
+
+// washMatch is a closed loss or open purchase with quantity units still
+// unmatched. For a loss, amount is the loss still allowed across all of its
+// units.
+type washMatch struct {
+	id       int
+	quantity types.Decimal
+	amount   types.Decimal
+	units    types.Decimal
+}
+
+// washLoss looks for purchases of the same security within 30 days of a
+// loss that just closed and disallows the loss against them. Only shares or
+// contracts still open after the sale count, so the lots or positions the
+// sale itself closed (soldIDs) are never replacements. Purchases applied
+// later are matched by washPurchase instead.
+func washLoss(q dbtx, userID int, sec washSecurity, closedID int, soldIDs []int) error {
+	var quantity, matched, adjustedPL types.Decimal
+	var closeDate string
+	err := q.QueryRow(fmt.Sprintf(`
+		SELECT quantity, wash_quantity, profit_loss - wash_adjustment, close_date
+		FROM %s WHERE id = ?
+	`, sec.closedTable), closedID).Scan(&quantity, &matched, &adjustedPL, &closeDate)
+	if err != nil || adjustedPL.Sign() >= 0 {
+		return err
+	}
+
+	args := append([]interface{}{userID}, sec.args...)
+	for _, id := range soldIDs {
+		args = append(args, id)
+	}
+	args = append(args, closeDate, closeDate)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(soldIDs)), ", ")
+	replacements, err := washCandidates(q, fmt.Sprintf(`
+		SELECT id, quantity - wash_quantity, 0, quantity
+		FROM %[1]s
+		WHERE user_id = ? AND %[2]s AND id NOT IN (%[4]s) AND quantity > wash_quantity
+		  AND %[3]s >= date(?, '-30 days') AND %[3]s <= date(?, '+30 days')
+		ORDER BY %[3]s ASC, id ASC
+	`, sec.openTable, sec.where, sec.openDate, placeholders), args...)
+	if err != nil {
+		return err
+	}
+
+	remaining := quantity.Sub(matched)
+	for _, replacement := range replacements {
+		if remaining.Sign() <= 0 {
+			break
+		}
+		units := types.MinDecimal(remaining, replacement.quantity)
+		remaining = remaining.Sub(units)
+		if err := recordWashSale(q, sec, closedID, replacement.id, units, adjustedPL.Neg().MulDiv(units, quantity)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// washPurchase matches a new purchase against losses on the same security
+// closed within 30 days of it.
+func washPurchase(q dbtx, userID int, sec washSecurity, openID int, openDate string, quantity types.Decimal) error {
+	args := append([]interface{}{userID}, sec.args...)
+	args = append(args, openDate, openDate)
+	losses, err := washCandidates(q, fmt.Sprintf(`
+		SELECT id, quantity - wash_quantity, wash_adjustment - profit_loss, quantity
+		FROM %s
+		WHERE user_id = ? AND %s AND profit_loss - wash_adjustment < 0 AND quantity > wash_quantity
+		  AND close_date >= date(?, '-30 days') AND close_date <= date(?, '+30 days')
+		ORDER BY close_date ASC, id ASC
+	`, sec.closedTable, sec.where), args...)
+	if err != nil {
+		return err
+	}
+
+	remaining := quantity
+	for _, loss := range losses {
+		if remaining.Sign() <= 0 {
+			break
+		}
+		units := types.MinDecimal(remaining, loss.quantity)
+		remaining = remaining.Sub(units)
+		if err := recordWashSale(q, sec, loss.id, openID, units, loss.amount.MulDiv(units, loss.units)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func washCandidates(q dbtx, query string, args ...interface{}) ([]washMatch, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []washMatch
+	for rows.Next() {
+		var m washMatch
+		if err := rows.Scan(&m.id, &m.quantity, &m.amount, &m.units); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// recordWashSale disallows part of a loss and adds it to the basis of the
+// replacement purchase.
+func recordWashSale(q dbtx, sec washSecurity, closedID, openID int, units, disallowed types.Decimal) error {
+	_, err := q.Exec(fmt.Sprintf(`
+		UPDATE %s
+		SET wash_quantity = wash_quantity + ?, wash_disallowed = wash_disallowed + ?
+		WHERE id = ?
+	`, sec.closedTable), units, disallowed, closedID)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(fmt.Sprintf(`
+		UPDATE %s
+		SET wash_quantity = wash_quantity + ?, wash_adjustment = wash_adjustment + ?
+		WHERE id = ?
+	`, sec.openTable), units, disallowed, openID)
+	return err
+}
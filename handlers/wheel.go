@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"backend/types"
+	"backend/views/components"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// openWheelCampaign returns the ticker's running wheel campaign in an
+// account, or 0.
+func openWheelCampaign(q dbtx, userID, accountID int, ticker string) (int, error) {
+	var id int
+	err := q.QueryRow(`
+		SELECT id FROM wheel_campaigns
+		WHERE user_id = ? AND account_id = ? AND ticker = ? AND end_date = ''
+		ORDER BY id DESC
+		LIMIT 1
+	`, userID, accountID, ticker).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// wheelCampaignFor returns the campaign a newly written option joins. A
+// CSP on a ticker with no running campaign starts one; a CC only joins a
+// campaign that is already running.
+func wheelCampaignFor(q dbtx, userID, accountID int, ticker string, positionType types.OptionType, date string) (int, error) {
+	if positionType != types.CSP && positionType != types.CC {
+		return 0, nil
+	}
+
+	id, err := openWheelCampaign(q, userID, accountID, ticker)
+	if err != nil || id != 0 || positionType != types.CSP {
+		return id, err
+	}
+
+	result, err := q.Exec("INSERT INTO wheel_campaigns (user_id, account_id, ticker, start_date) VALUES (?, ?, ?, ?)", userID, accountID, ticker, date)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := result.LastInsertId()
+	return int(newID), err
+}
+
+// settleWheelCampaign ends a campaign once its shares are gone and nothing
+// is left open. Puts that expire before any assignment keep the campaign
+// running so the next put sold continues it.
+func settleWheelCampaign(q dbtx, userID, campaignID int, date string) error {
+	if campaignID == 0 {
+		return nil
+	}
+
+	var open, sold int
+	err := q.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM option_positions WHERE campaign_id = ? AND quantity > 0)
+		     + (SELECT COUNT(*) FROM stock_lots WHERE campaign_id = ? AND quantity > 0),
+		       (SELECT COUNT(*) FROM closed_stocks WHERE campaign_id = ?)
+	`, campaignID, campaignID, campaignID).Scan(&open, &sold)
+	if err != nil || open > 0 || sold == 0 {
+		return err
+	}
+
+	_, err = q.Exec("UPDATE wheel_campaigns SET end_date = ? WHERE id = ? AND user_id = ?", date, campaignID, userID)
+	return err
+}
+
+// wheelCampaigns loads the user's campaigns in the scoped account, newest
+// first. A non-zero campaignID loads just that campaign.
+func wheelCampaigns(q dbtx, userID int, scope accountScope, campaignID int) ([]types.WheelCampaign, error) {
+	query := `
+		SELECT w.id, w.ticker, w.start_date, w.end_date, w.account_id,
+		       COALESCE((SELECT SUM(profit_loss) FROM closed_options WHERE campaign_id = w.id), 0)
+		     + COALESCE((SELECT SUM(profit_loss) FROM closed_stocks WHERE campaign_id = w.id), 0),
+		       COALESCE((SELECT SUM(quantity) FROM stock_lots WHERE campaign_id = w.id AND quantity > 0), 0)
+		FROM wheel_campaigns w
+		WHERE w.user_id = ?` + scope.filter("w.account_id")
+	args := scope.args(userID)
+	if campaignID != 0 {
+		query += ` AND w.id = ?`
+		args = append(args, campaignID)
+	}
+	query += ` ORDER BY w.start_date DESC, w.id DESC`
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var campaigns []types.WheelCampaign
+	for rows.Next() {
+		var c types.WheelCampaign
+		var accountID int
+		if err := rows.Scan(&c.ID, &c.Ticker, &c.StartDate, &c.EndDate, &accountID, &c.ProfitLoss, &c.SharesHeld); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.Account = scope.label(accountID)
+		campaigns = append(campaigns, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	today := time.Now()
+	for i := range campaigns {
+		if err := campaignCapital(q, &campaigns[i]); err != nil {
+			return nil, err
+		}
+		campaigns[i].Days = campaignDays(campaigns[i], today)
+		if c := campaigns[i]; c.Capital.Sign() > 0 {
+			campaigns[i].AnnualizedReturn = c.ProfitLoss.Float() / c.Capital.Float() * 365 / float64(c.Days) * 100
+		}
+	}
+	return campaigns, nil
+}
+
+// campaignCapital fills in the premium a campaign has collected, what its
+// shares effectively cost and the capital it tied up: the larger of the
+// biggest put's assignment value and the cost of every share it bought.
+func campaignCapital(q dbtx, c *types.WheelCampaign) error {
+	rows, err := q.Query(`
+		SELECT 'option', type, premium, 0, strike, quantity, multiplier FROM option_positions WHERE campaign_id = ?
+		UNION ALL
+		SELECT 'option', type, premium, sell_price, strike, quantity, multiplier FROM closed_options WHERE campaign_id = ?
+		UNION ALL
+		SELECT 'held', '', cost_basis, 0, 0, quantity, 0 FROM stock_lots WHERE campaign_id = ? AND quantity > 0
+		UNION ALL
+		SELECT 'sold', '', cost_basis, 0, 0, quantity, 0 FROM closed_stocks WHERE campaign_id = ?
+	`, c.ID, c.ID, c.ID, c.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var heldCost, soldCost, putNotional types.Decimal
+	for rows.Next() {
+		var kind string
+		var positionType types.OptionType
+		var price, closePrice, strike, quantity, multiplier types.Decimal
+		if err := rows.Scan(&kind, &positionType, &price, &closePrice, &strike, &quantity, &multiplier); err != nil {
+			return err
+		}
+		switch kind {
+		case "option":
+			c.PremiumCollected = c.PremiumCollected.Add(price.Sub(closePrice).Mul(quantity).Mul(multiplier))
+			if positionType == types.CSP {
+				putNotional = types.MaxDecimal(putNotional, strike.Mul(quantity).Mul(multiplier))
+			}
+		case "held":
+			heldCost = heldCost.Add(price.Mul(quantity))
+		case "sold":
+			soldCost = soldCost.Add(price.Mul(quantity))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Premium lowers what the shares still held effectively cost.
+	if c.SharesHeld.Sign() > 0 {
+		c.EffectiveCostBasis = heldCost.Sub(c.PremiumCollected).Div(c.SharesHeld)
+	}
+	c.Capital = types.MaxDecimal(putNotional, heldCost.Add(soldCost))
+	return nil
+}
+
+// campaignDays counts calendar days from the start of a campaign to its
+// end, or to today while it runs, counting a same-day campaign as one day.
+func campaignDays(c types.WheelCampaign, today time.Time) int {
+	start, err := ParseDateToTime(c.StartDate)
+	if err != nil {
+		return 1
+	}
+	end := today
+	if !c.Open() {
+		if parsed, err := ParseDateToTime(c.EndDate); err == nil {
+			end = parsed
+		}
+	}
+	return max(int(end.Sub(start).Hours()/24), 1)
+}
+
+// wheelEvents lists the trades linked to a campaign in date order: each
+// option written and closed, and each lot of shares bought and sold.
+func wheelEvents(q dbtx, campaignID int) ([]types.WheelEvent, error) {
+	rows, err := q.Query(`
+		SELECT 'sold', purchase_date, type, strike, premium, SUM(quantity), multiplier
+		FROM (
+			SELECT purchase_date, type, strike, premium, quantity, multiplier, open_trade_id FROM option_positions WHERE campaign_id = ?
+			UNION ALL
+			SELECT purchase_date, type, strike, premium, quantity, multiplier, open_trade_id FROM closed_options WHERE campaign_id = ?
+		)
+		GROUP BY open_trade_id, purchase_date, type, strike, premium, multiplier
+		UNION ALL
+		SELECT 'closed', close_date, type, strike, sell_price, quantity, multiplier
+		FROM closed_options WHERE campaign_id = ?
+		UNION ALL
+		SELECT 'bought', l.open_date, '', 0, l.cost_basis, l.quantity + COALESCE(SUM(c.quantity), 0), 0
+		FROM stock_lots l LEFT JOIN closed_stocks c ON c.lot_id = l.id
+		WHERE l.campaign_id = ?
+		GROUP BY l.id
+		UNION ALL
+		SELECT 'sold shares', close_date, '', 0, sell_price, quantity, 0
+		FROM closed_stocks WHERE campaign_id = ?
+	`, campaignID, campaignID, campaignID, campaignID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []types.WheelEvent
+	for rows.Next() {
+		var kind, date string
+		var positionType types.OptionType
+		var strike, price, quantity, multiplier types.Decimal
+		if err := rows.Scan(&kind, &date, &positionType, &strike, &price, &quantity, &multiplier); err != nil {
+			return nil, err
+		}
+
+		e := types.WheelEvent{Date: date}
+		switch kind {
+		case "sold":
+			e.Description = fmt.Sprintf("Sold %s %s $%.2f @ $%.2f", quantity, positionType, strike, price)
+			e.Amount = price.Mul(quantity).Mul(multiplier)
+		case "closed":
+			e.Description = fmt.Sprintf("Closed %s %s $%.2f @ $%.2f", quantity, positionType, strike, price)
+			e.Amount = price.Mul(quantity).Mul(multiplier).Neg()
+		case "bought":
+			e.Description = fmt.Sprintf("Bought %s shares @ $%.2f", quantity, price)
+			e.Amount = price.Mul(quantity).Neg()
+		default:
+			e.Description = fmt.Sprintf("Sold %s shares @ $%.2f", quantity, price)
+			e.Amount = price.Mul(quantity)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return NormalizeDateToISO(events[i].Date) < NormalizeDateToISO(events[j].Date)
+	})
+	return events, nil
+}
+
+func HandleWheel(w http.ResponseWriter, r *http.Request) {
+	components.AppLayout("Wheel - DATATRADER", "wheel", components.WheelPage()).Render(r.Context(), w)
+}
+
+func HandleGetWheelCampaigns(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	campaigns, err := wheelCampaigns(db, userID, requestAccountScope(r, userID), 0)
+	if err != nil {
+		http.Error(w, "Failed to fetch wheel campaigns", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.WheelCampaignsTable(campaigns, FormatDate).Render(r.Context(), w)
+}
+
+func HandleWheelCampaignDetails(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetOrCreateUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	campaignID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	campaigns, err := wheelCampaigns(db, userID, accountScope{View: types.AllAccounts}, campaignID)
+	if err != nil || len(campaigns) == 0 {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return
+	}
+
+	events, err := wheelEvents(db, campaignID)
+	if err != nil {
+		http.Error(w, "Failed to fetch campaign trades", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	components.WheelCampaignModal(campaigns[0], events, FormatDate).Render(r.Context(), w)
+}
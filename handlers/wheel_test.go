@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"backend/types"
+	"testing"
+)
+
+// optionEvent is an expiry or assignment of contracts on one option.
+func optionEvent(t *testing.T, code types.TradeCode, optionType types.OptionType, date, strike, expDate string) types.OptionTrade {
+	t.Helper()
+	return types.OptionTrade{
+		Ticker:     "F",
+		Date:       date,
+		Code:       code,
+		OptionType: optionType,
+		Strike:     decimal(t, strike),
+		ExpDate:    expDate,
+		Quantity:   types.DecimalFromInt(1),
+		Multiplier: types.StandardMultiplier,
+	}
+}
+
+// A campaign runs from the first put sold, through an expiry, an
+// assignment and a covered call, until the shares are called away.
+func TestWheelCampaign(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "wheel")
+	one := types.DecimalFromInt(1)
+
+	steps := []struct {
+		trade types.OptionTrade
+		open  bool
+	}{
+		{openingOptionTrade("F", "2025-01-02", types.CSP, decimal(t, "12"), decimal(t, "0.5"), one, types.StandardMultiplier, "2025-01-17"), true},
+		// An expired put keeps the campaign running for the next one.
+		{optionEvent(t, types.OEXP, types.Put, "2025-01-17", "12", "2025-01-17"), true},
+		{openingOptionTrade("F", "2025-01-21", types.CSP, decimal(t, "11"), decimal(t, "0.4"), one, types.StandardMultiplier, "2025-02-21"), true},
+		{optionEvent(t, types.OASGN, types.Put, "2025-02-21", "11", "2025-02-21"), true},
+		{openingOptionTrade("F", "2025-02-24", types.CC, decimal(t, "12"), decimal(t, "0.3"), one, types.StandardMultiplier, "2025-03-21"), true},
+		{optionEvent(t, types.OASGN, types.Call, "2025-03-21", "12", "2025-03-21"), false},
+	}
+	for i, step := range steps {
+		applyOption(t, userID, step.trade)
+
+		campaigns, err := wheelCampaigns(db, userID, accountScope{View: types.AllAccounts}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(campaigns) != 1 {
+			t.Fatalf("step %d: %d campaigns, want 1", i, len(campaigns))
+		}
+		if campaigns[0].Open() != step.open {
+			t.Errorf("step %d: campaign open = %v, want %v", i, campaigns[0].Open(), step.open)
+		}
+	}
+
+	campaigns, err := wheelCampaigns(db, userID, accountScope{View: types.AllAccounts}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := campaigns[0]
+	if c.StartDate != "2025-01-02" || c.EndDate != "2025-03-21" {
+		t.Errorf("campaign ran %s to %s, want 2025-01-02 to 2025-03-21", c.StartDate, c.EndDate)
+	}
+	tests := []struct {
+		name      string
+		got, want types.Decimal
+	}{
+		{"premium collected", c.PremiumCollected, decimal(t, "120")},
+		// $120 of premium and $100 on the shares.
+		{"P/L", c.ProfitLoss, decimal(t, "220")},
+		// The $1,200 put was the most the campaign tied up.
+		{"capital", c.Capital, decimal(t, "1200")},
+		{"shares held", c.SharesHeld, types.Decimal{}},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+	if c.Days != 78 {
+		t.Errorf("campaign lasted %d days, want 78", c.Days)
+	}
+}
+
+// Covered calls on shares bought outside a wheel don't start a campaign,
+// and each account runs its own.
+func TestWheelCampaignStarts(t *testing.T) {
+	newTestDB(t)
+	userID, _ := testUser(t, "wheels")
+	one := types.DecimalFromInt(1)
+
+	applyStock(t, userID, types.StockTrade{Ticker: "F", Date: "2025-01-02", Code: types.Buy, Price: decimal(t, "11"), Quantity: types.DecimalFromInt(100)})
+	applyOption(t, userID, openingOptionTrade("F", "2025-01-03", types.CC, decimal(t, "12"), decimal(t, "0.3"), one, types.StandardMultiplier, "2025-02-21"))
+
+	for _, accountID := range []int{0, 2} {
+		put := openingOptionTrade("F", "2025-01-06", types.CSP, decimal(t, "10"), decimal(t, "0.2"), one, types.StandardMultiplier, "2025-02-21")
+		put.AccountID = accountID
+		applyOption(t, userID, put)
+	}
+
+	var campaigns, calls int
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(DISTINCT account_id) FROM wheel_campaigns WHERE user_id = ?),
+		       (SELECT COUNT(*) FROM option_positions WHERE user_id = ? AND type = ? AND campaign_id != 0)
+	`, userID, userID, types.CC).Scan(&campaigns, &calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if campaigns != 2 {
+		t.Errorf("campaigns run in %d accounts, want 2", campaigns)
+	}
+	if calls != 0 {
+		t.Errorf("%d covered calls joined a campaign, want 0", calls)
+	}
+}
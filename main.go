@@ -21,6 +21,15 @@ func main() {
 	defer db.Close()
 
 	handlers.SetDB(db)
+	backfillLegacyLedger()
+	backfillCashTransactions()
+
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	middleware.StartSessionCleanup()
 
@@ -49,13 +58,22 @@ func main() {
 		r.Get("/", handlers.HandleHome)
 		r.Get("/positions.html", handlers.HandlePositions)
 		r.Get("/history.html", handlers.HandleHistory)
+		r.Get("/wheel.html", handlers.HandleWheel)
+		r.Get("/corporate-actions.html", handlers.HandleCorporateActions)
 
 		r.Get("/modal/add-position.html", handlers.HandleModalAddPosition)
 		r.Get("/modal/add-position-fields.html", handlers.HandleModalAddPositionFields)
 		r.Get("/modal/import-csv.html", handlers.HandleModalImportCSV)
+		r.Get("/modal/add-cash-flow.html", handlers.HandleModalAddCashFlow)
+		r.Get("/modal/add-corporate-action.html", handlers.HandleModalAddCorporateAction)
+		r.Get("/modal/import-corporate-actions.html", handlers.HandleModalImportCorporateActions)
+		r.Get("/modal/accounts.html", handlers.HandleModalAccounts)
 		r.Get("/modal/close", handlers.HandleModalClose)
 
 		r.Get("/api/stats", handlers.HandleStats)
+		r.Get("/api/performance", handlers.HandlePerformance)
+		r.Get("/api/capital", handlers.HandleCapital)
+		r.Post("/api/capital/check", handlers.HandleCapitalCheck)
 		r.Post("/api/positions/add", handlers.HandleAddPosition)
 		r.Get("/api/positions/stocks", handlers.HandleGetStockPositions)
 		r.Get("/api/positions/options", handlers.HandleGetOptionPositions)
@@ -75,6 +93,13 @@ func main() {
 		r.Post("/api/positions/close-stock/{id}", handlers.HandleCloseStockPosition)
 		r.Post("/api/positions/close-option/{id}", handlers.HandleCloseOptionPosition)
 
+		r.Get("/api/positions/strategies", handlers.HandleGetStrategies)
+		r.Get("/api/positions/close-strategy-modal/{id}", handlers.HandleCloseStrategyModal)
+		r.Post("/api/positions/close-strategy/{id}", handlers.HandleCloseStrategy)
+		r.Get("/api/positions/roll-option-modal/{id}", handlers.HandleRollOptionModal)
+		r.Post("/api/positions/roll-option/{id}", handlers.HandleRollOption)
+		r.Get("/api/positions/roll-chain/{id}", handlers.HandleRollChain)
+
 		r.Get("/api/history/stocks", handlers.HandleGetClosedStocks)
 		r.Get("/api/history/options", handlers.HandleGetClosedOptions)
 		r.Get("/api/history/filter", handlers.HandleHistoryFilter)
@@ -87,7 +112,48 @@ func main() {
 		r.Delete("/api/history/stock/{id}", handlers.HandleDeleteClosedStock)
 		r.Delete("/api/history/option/{id}", handlers.HandleDeleteClosedOption)
 
+		r.Get("/api/history/fills/stock/{id}", handlers.HandleClosedStockFills)
+		r.Get("/api/history/fills/option/{id}", handlers.HandleClosedOptionFills)
+
+		r.Get("/api/history/returns", handlers.HandleTickerReturns)
+		r.Get("/api/history/strategies", handlers.HandleGetClosedStrategies)
+
+		r.Get("/api/wheel/campaigns", handlers.HandleGetWheelCampaigns)
+		r.Get("/api/wheel/campaigns/{id}", handlers.HandleWheelCampaignDetails)
+
+		r.Get("/api/cash-flows", handlers.HandleGetCashFlows)
+		r.Post("/api/cash-flows", handlers.HandleAddCashFlow)
+		r.Delete("/api/cash-flows/{id}", handlers.HandleDeleteCashFlow)
+
+		r.Get("/api/corporate-actions", handlers.HandleGetCorporateActions)
+		r.Post("/api/corporate-actions", handlers.HandleAddCorporateAction)
+		r.Post("/api/corporate-actions/import", handlers.HandleImportCorporateActions)
+		r.Post("/api/corporate-actions/{id}/apply", handlers.HandleApplyCorporateAction)
+		r.Post("/api/corporate-actions/{id}/revert", handlers.HandleRevertCorporateAction)
+		r.Get("/api/corporate-actions/{id}/adjustments", handlers.HandleCorporateActionAdjustments)
+		r.Delete("/api/corporate-actions/{id}", handlers.HandleDeleteCorporateAction)
+
+		r.Get("/api/accounts/selector", handlers.HandleAccountSelector)
+		r.Post("/api/accounts", handlers.HandleAddAccount)
+		r.Delete("/api/accounts/{id}", handlers.HandleDeleteAccount)
+		r.Get("/api/accounts/move-modal/{kind}/{id}", handlers.HandleMoveToAccountModal)
+		r.Post("/api/accounts/transfer", handlers.HandleTransferPositions)
+
 		r.Post("/api/import-csv", handlers.HandleImportCSV)
+		r.Post("/api/import-csv/preview", handlers.HandleImportPreview)
+		r.Post("/api/import-csv/confirm", handlers.HandleImportConfirm)
+		r.Post("/api/import-csv/discard", handlers.HandleImportDiscard)
+		r.Get("/api/import-csv/report/{token}", handlers.HandleImportReport)
+		r.Post("/api/import-csv/mapping", handlers.HandleImportMapping)
+		r.Delete("/api/import-profiles/{id}", handlers.HandleDeleteMappingProfile)
+		r.Post("/api/ledger/replay", handlers.HandleReplayLedger)
+
+		r.Get("/api/settings/lot-method", handlers.HandleGetLotMethod)
+		r.Post("/api/settings/lot-method", handlers.HandleUpdateLotMethod)
+		r.Get("/api/settings/adjusted-basis", handlers.HandleGetAdjustedBasis)
+		r.Post("/api/settings/adjusted-basis", handlers.HandleUpdateAdjustedBasis)
+		r.Post("/api/settings/utilization-limit", handlers.HandleUpdateUtilizationLimit)
+		r.Post("/api/settings/account-view", handlers.HandleUpdateAccountView)
 	})
 
 	port := os.Getenv("PORT")
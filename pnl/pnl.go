@@ -0,0 +1,102 @@
+// Package pnl computes the realized profit or loss of each way a position
+// closes, so manual closes, imports, replays and history edits agree on the
+// same trade. Every result is net of the fees passed in.
+package pnl
+
+import "backend/types"
+
+// Outcome is how an option position closed.
+type Outcome string
+
+const (
+	Closed     Outcome = "closed"
+	Expired    Outcome = "expired"
+	Assigned   Outcome = "assigned"
+	CalledAway Outcome = "called_away"
+	Exercised  Outcome = "exercised"
+)
+
+// StockSale is the P/L of closing shares of a lot at price: what they sold
+// for over what they cost for a long lot, and the reverse for a short one.
+func StockSale(side types.PositionSide, costBasis, price, shares, fees types.Decimal) types.Decimal {
+	gain := price.Sub(costBasis).Mul(shares)
+	if side == types.Short {
+		gain = gain.Neg()
+	}
+	return gain.Sub(fees)
+}
+
+// StockSaleTotal is StockSale for shares whose total cost and proceeds are
+// already known, so partial sales of a lot can split them without rounding
+// each share's gain.
+func StockSaleTotal(side types.PositionSide, cost, proceeds, fees types.Decimal) types.Decimal {
+	gain := proceeds.Sub(cost)
+	if side == types.Short {
+		gain = gain.Neg()
+	}
+	return gain.Sub(fees)
+}
+
+// LongOptionClose is the P/L of selling bought contracts at price.
+// Premium and price are per share; multiplier is shares per contract.
+func LongOptionClose(premium, price, contracts, multiplier, fees types.Decimal) types.Decimal {
+	return price.Sub(premium).Mul(contracts).Mul(multiplier).Sub(fees)
+}
+
+// ShortOptionClose is the P/L of buying back written contracts at price.
+func ShortOptionClose(premium, price, contracts, multiplier, fees types.Decimal) types.Decimal {
+	return premium.Sub(price).Mul(contracts).Mul(multiplier).Sub(fees)
+}
+
+// Written reports whether a position type is a written option.
+func Written(positionType types.OptionType) bool {
+	return positionType == types.CSP || positionType == types.CC
+}
+
+// OptionClose is the P/L of closing contracts of a position at price, long
+// or short by its type.
+func OptionClose(positionType types.OptionType, premium, price, contracts, multiplier, fees types.Decimal) types.Decimal {
+	if Written(positionType) {
+		return ShortOptionClose(premium, price, contracts, multiplier, fees)
+	}
+	return LongOptionClose(premium, price, contracts, multiplier, fees)
+}
+
+// Expiry is the P/L of contracts expiring worthless: a writer keeps the
+// premium and a buyer loses it.
+func Expiry(positionType types.OptionType, premium, contracts, multiplier, fees types.Decimal) types.Decimal {
+	return OptionClose(positionType, premium, types.Decimal{}, contracts, multiplier, fees)
+}
+
+// Assignment is the P/L of written contracts being assigned, including a
+// covered call being called away. The writer keeps the premium; the shares
+// bought or delivered at the strike are a separate stock trade, so a
+// called-away lot's P/L is a StockSale at the strike.
+func Assignment(premium, contracts, multiplier, fees types.Decimal) types.Decimal {
+	return ShortOptionClose(premium, types.Decimal{}, contracts, multiplier, fees)
+}
+
+// Exercise is the P/L of bought contracts being exercised: the premium is
+// spent and the shares move at the strike in a separate stock trade.
+func Exercise(premium, contracts, multiplier, fees types.Decimal) types.Decimal {
+	return LongOptionClose(premium, types.Decimal{}, contracts, multiplier, fees)
+}
+
+// Option is the P/L of contracts of a position closing with an outcome.
+// Price is only used when the contracts are closed by a trade; the other
+// outcomes settle the option at zero.
+func Option(outcome Outcome, positionType types.OptionType, premium, price, contracts, multiplier, fees types.Decimal) types.Decimal {
+	switch outcome {
+	case Expired:
+		return Expiry(positionType, premium, contracts, multiplier, fees)
+	case Assigned, CalledAway:
+		if Written(positionType) {
+			return Assignment(premium, contracts, multiplier, fees)
+		}
+	case Exercised:
+		if !Written(positionType) {
+			return Exercise(premium, contracts, multiplier, fees)
+		}
+	}
+	return OptionClose(positionType, premium, price, contracts, multiplier, fees)
+}
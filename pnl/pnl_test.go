@@ -0,0 +1,150 @@
+package pnl
+
+import (
+	"backend/types"
+	"testing"
+)
+
+func dec(t *testing.T, s string) types.Decimal {
+	t.Helper()
+	d, err := types.ParseDecimal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestStockSale(t *testing.T) {
+	tests := []struct {
+		name                           string
+		side                           types.PositionSide
+		costBasis, price, shares, fees string
+		want                           string
+	}{
+		{"long gain", types.Long, "100", "110", "10", "1", "99"},
+		{"long loss", types.Long, "100", "90", "10", "0", "-100"},
+		{"short gain", types.Short, "100", "90", "10", "1", "99"},
+		{"short loss", types.Short, "50", "60", "5", "0", "-50"},
+		{"fractional shares", types.Long, "10.005", "10.01", "3", "0", "0.015"},
+		{"fees only", types.Long, "20", "20", "7", "0.35", "-0.35"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StockSale(tt.side, dec(t, tt.costBasis), dec(t, tt.price), dec(t, tt.shares), dec(t, tt.fees))
+			if want := dec(t, tt.want); got != want {
+				t.Errorf("StockSale = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestStockSaleTotal(t *testing.T) {
+	tests := []struct {
+		name                       string
+		side                       types.PositionSide
+		cost, proceeds, fees, want string
+	}{
+		{"long gain", types.Long, "1000", "1100", "1", "99"},
+		{"short gain", types.Short, "1000", "900", "1", "99"},
+		{"short loss", types.Short, "250", "300", "0.5", "-50.5"},
+		{"split lot", types.Long, "1099.999989", "1320", "0", "220.000011"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StockSaleTotal(tt.side, dec(t, tt.cost), dec(t, tt.proceeds), dec(t, tt.fees))
+			if want := dec(t, tt.want); got != want {
+				t.Errorf("StockSaleTotal = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestOptionCloses(t *testing.T) {
+	tests := []struct {
+		name                                        string
+		close                                       func(premium, price, contracts, multiplier, fees types.Decimal) types.Decimal
+		premium, price, contracts, multiplier, fees string
+		want                                        string
+	}{
+		{"long gain", LongOptionClose, "2", "3.5", "2", "100", "1.3", "298.7"},
+		{"long loss", LongOptionClose, "2", "0.25", "1", "100", "0", "-175"},
+		{"short gain", ShortOptionClose, "2", "0.5", "1", "100", "0.65", "149.35"},
+		{"short loss", ShortOptionClose, "1", "4", "2", "100", "0", "-600"},
+		{"mini contract", LongOptionClose, "1", "4", "3", "10", "0", "90"},
+		{"adjusted contract", ShortOptionClose, "2", "1", "1", "150", "0", "150"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.close(dec(t, tt.premium), dec(t, tt.price), dec(t, tt.contracts), dec(t, tt.multiplier), dec(t, tt.fees))
+			if want := dec(t, tt.want); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSettlements(t *testing.T) {
+	tests := []struct {
+		name string
+		got  func(t *testing.T) types.Decimal
+		want string
+	}{
+		{"written put expires", func(t *testing.T) types.Decimal {
+			return Expiry(types.CSP, dec(t, "1.25"), dec(t, "3"), dec(t, "100"), dec(t, "0"))
+		}, "375"},
+		{"bought call expires", func(t *testing.T) types.Decimal {
+			return Expiry(types.Call, dec(t, "1.25"), dec(t, "3"), dec(t, "100"), dec(t, "0"))
+		}, "-375"},
+		{"covered call expires with fees", func(t *testing.T) types.Decimal {
+			return Expiry(types.CC, dec(t, "0.8"), dec(t, "1"), dec(t, "100"), dec(t, "0.65"))
+		}, "79.35"},
+		{"assignment keeps premium", func(t *testing.T) types.Decimal {
+			return Assignment(dec(t, "2"), dec(t, "1"), dec(t, "100"), dec(t, "0.5"))
+		}, "199.5"},
+		{"assignment of adjusted contracts", func(t *testing.T) types.Decimal {
+			return Assignment(dec(t, "2"), dec(t, "2"), dec(t, "150"), dec(t, "0"))
+		}, "600"},
+		{"exercise spends premium", func(t *testing.T) types.Decimal {
+			return Exercise(dec(t, "2"), dec(t, "1"), dec(t, "100"), dec(t, "0"))
+		}, "-200"},
+		{"exercise with fees", func(t *testing.T) types.Decimal {
+			return Exercise(dec(t, "3"), dec(t, "1"), dec(t, "100"), dec(t, "0.65"))
+		}, "-300.65"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := tt.got(t), dec(t, tt.want); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestOption(t *testing.T) {
+	tests := []struct {
+		name                                        string
+		outcome                                     Outcome
+		positionType                                types.OptionType
+		premium, price, contracts, multiplier, fees string
+		want                                        string
+	}{
+		{"closed written put", Closed, types.CSP, "2", "1", "1", "100", "0", "100"},
+		{"closed bought call with fees", Closed, types.Call, "2", "3", "2", "10", "1", "19"},
+		{"expired ignores price", Expired, types.CC, "1.5", "9", "1", "150", "0", "225"},
+		{"expired bought put", Expired, types.Put, "1.5", "9", "2", "100", "0.5", "-300.5"},
+		{"assigned written put", Assigned, types.CSP, "2", "5", "2", "100", "1.3", "398.7"},
+		{"called away", CalledAway, types.CC, "1", "5", "1", "100", "0", "100"},
+		{"exercised bought put", Exercised, types.Put, "3", "7", "1", "100", "0.65", "-300.65"},
+		{"assigned bought call closes at price", Assigned, types.Call, "2", "5", "1", "100", "0", "300"},
+		{"exercised written put closes at price", Exercised, types.CSP, "2", "0.5", "1", "100", "0", "150"},
+		{"partial contracts", Closed, types.CSP, "2.37", "0.12", "0.5", "100", "0.33", "112.17"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Option(tt.outcome, tt.positionType, dec(t, tt.premium), dec(t, tt.price), dec(t, tt.contracts), dec(t, tt.multiplier), dec(t, tt.fees))
+			if want := dec(t, tt.want); got != want {
+				t.Errorf("Option = %s, want %s", got, want)
+			}
+		})
+	}
+}
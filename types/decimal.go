@@ -0,0 +1,296 @@
+package types
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Decimal is a fixed-point number with six decimal places, used for every
+// price, quantity and amount of money so that sums, averages and partial
+// closes stay exact to the cent however many trades they cover. It is
+// stored in SQLite as an integer count of millionths.
+type Decimal struct {
+	micros int64
+}
+
+// DecimalPlaces is the number of decimal places a Decimal holds.
+const DecimalPlaces = 6
+
+const decimalScale = 1000000
+
+var bigScale = big.NewInt(decimalScale)
+
+// NewDecimal converts a float, rounding to the nearest millionth.
+func NewDecimal(f float64) Decimal {
+	return Decimal{int64(math.Round(f * decimalScale))}
+}
+
+// DecimalFromInt converts a whole number.
+func DecimalFromInt(n int64) Decimal {
+	return Decimal{n * decimalScale}
+}
+
+// DecimalFromMicros is the Decimal holding n millionths.
+func DecimalFromMicros(n int64) Decimal {
+	return Decimal{n}
+}
+
+// decimalSyntax matches plain decimal numbers with an optional exponent.
+// big.Rat on its own would also take fractions like "1/3" and hex.
+var decimalSyntax = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d{1,3})?$`)
+
+// ParseDecimal reads a number such as "-1234.5", "0.000125" or "1e-3"
+// exactly, rounding anything past six decimal places half away from zero.
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if !decimalSyntax.MatchString(s) {
+		return Decimal{}, fmt.Errorf("invalid number %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid number %q", s)
+	}
+	num := new(big.Int).Mul(r.Num(), bigScale)
+	micros := roundQuo(num, r.Denom())
+	if !micros.IsInt64() {
+		return Decimal{}, fmt.Errorf("number %q is out of range", s)
+	}
+	return Decimal{micros.Int64()}, nil
+}
+
+// roundQuo divides, rounding half away from zero.
+func roundQuo(num, den *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(r, 1)).Cmp(new(big.Int).Abs(den)) >= 0 {
+		if (num.Sign() < 0) != (den.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+func (d Decimal) Micros() int64 {
+	return d.micros
+}
+
+// Float converts to a float64 for ratios and display. Money should not be
+// calculated with the result.
+func (d Decimal) Float() float64 {
+	return float64(d.micros) / decimalScale
+}
+
+func (d Decimal) Add(o Decimal) Decimal { return Decimal{d.micros + o.micros} }
+func (d Decimal) Sub(o Decimal) Decimal { return Decimal{d.micros - o.micros} }
+func (d Decimal) Neg() Decimal          { return Decimal{-d.micros} }
+
+func (d Decimal) Abs() Decimal {
+	if d.micros < 0 {
+		return d.Neg()
+	}
+	return d
+}
+
+// MulInt multiplies by a whole number, such as the 100 shares of a
+// contract.
+func (d Decimal) MulInt(n int64) Decimal {
+	return Decimal{d.micros * n}
+}
+
+// Mul multiplies, rounding the product to the nearest millionth.
+func (d Decimal) Mul(o Decimal) Decimal {
+	return d.MulDiv(o, DecimalFromInt(1))
+}
+
+// Div divides, rounding to the nearest millionth. Dividing by zero gives
+// zero.
+func (d Decimal) Div(o Decimal) Decimal {
+	return d.MulDiv(DecimalFromInt(1), o)
+}
+
+// MulDiv is d * num / den rounded once, for allocating an amount in
+// proportion, such as the cost of the shares closed out of a lot. A zero
+// den gives zero, and a result too large for a Decimal saturates at the
+// largest one of its sign rather than wrapping around.
+func (d Decimal) MulDiv(num, den Decimal) Decimal {
+	if den.micros == 0 {
+		return Decimal{}
+	}
+	n := new(big.Int).Mul(big.NewInt(d.micros), big.NewInt(num.micros))
+	q := roundQuo(n, big.NewInt(den.micros))
+	if !q.IsInt64() {
+		if q.Sign() < 0 {
+			return Decimal{math.MinInt64}
+		}
+		return Decimal{math.MaxInt64}
+	}
+	return Decimal{q.Int64()}
+}
+
+// Round rounds half away from zero to the given number of decimal places.
+func (d Decimal) Round(places int) Decimal {
+	if places >= DecimalPlaces {
+		return d
+	}
+	unit := int64(math.Pow10(DecimalPlaces - max(places, 0)))
+	q, r := d.micros/unit, d.micros%unit
+	if 2*abs64(r) >= unit {
+		if d.micros < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return Decimal{q * unit}
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Sign is -1, 0 or 1.
+func (d Decimal) Sign() int {
+	switch {
+	case d.micros < 0:
+		return -1
+	case d.micros > 0:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) IsZero() bool { return d.micros == 0 }
+
+// Cmp is -1, 0 or 1 as d is less than, equal to or greater than o.
+func (d Decimal) Cmp(o Decimal) int {
+	return d.Sub(o).Sign()
+}
+
+func MinDecimal(a, b Decimal) Decimal {
+	if a.Cmp(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+func MaxDecimal(a, b Decimal) Decimal {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// String is the exact value without trailing zeros, e.g. "12.5".
+func (d Decimal) String() string {
+	s := d.StringFixed(DecimalPlaces)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// StringFixed rounds to the given number of decimal places and always
+// shows them, e.g. "12.50".
+func (d Decimal) StringFixed(places int) string {
+	r := d.Round(places)
+	sign := ""
+	if r.micros < 0 {
+		sign = "-"
+	}
+	whole := abs64(r.micros) / decimalScale
+	frac := fmt.Sprintf("%06d", abs64(r.micros)%decimalScale)
+	if places <= 0 {
+		return sign + strconv.FormatInt(whole, 10)
+	}
+	if places > DecimalPlaces {
+		frac += strings.Repeat("0", places-DecimalPlaces)
+	}
+	return sign + strconv.FormatInt(whole, 10) + "." + frac[:places]
+}
+
+// Format lets a Decimal be printed with the float verbs, so "%.2f" rounds
+// exactly rather than through a float64.
+func (d Decimal) Format(f fmt.State, verb rune) {
+	var s string
+	switch verb {
+	case 'f', 'F':
+		places, ok := f.Precision()
+		if !ok {
+			places = DecimalPlaces
+		}
+		s = d.StringFixed(places)
+		if f.Flag('+') && !strings.HasPrefix(s, "-") {
+			s = "+" + s
+		}
+	case 'v', 's':
+		s = d.String()
+	default:
+		fmt.Fprintf(f, fmt.FormatString(f, verb), d.Float())
+		return
+	}
+
+	if width, ok := f.Width(); ok && len(s) < width {
+		padding := strings.Repeat(" ", width-len(s))
+		if f.Flag('-') {
+			s += padding
+		} else {
+			s = padding + s
+		}
+	}
+	f.Write([]byte(s))
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseDecimal(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Value stores the Decimal as an integer count of millionths.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.micros, nil
+}
+
+// Scan reads a count of millionths. Aggregates such as AVG come back as
+// floats and are rounded to the nearest millionth.
+func (d *Decimal) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Decimal{}
+	case int64:
+		*d = Decimal{v}
+	case float64:
+		*d = Decimal{int64(math.Round(v))}
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			*d = Decimal{n}
+			return nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("cannot scan %q into Decimal", v)
+		}
+		*d = Decimal{int64(math.Round(f))}
+	default:
+		return fmt.Errorf("cannot scan %T into Decimal", src)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package types
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func dec(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := ParseDecimal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		in     string
+		micros int64
+	}{
+		{"0", 0},
+		{"-1234.5", -1234500000},
+		{" 12.34 ", 12340000},
+		{"0.000125", 125},
+		{"1e-3", 1000},
+		{"2.5E2", 250000000},
+		{"+.5", 500000},
+		{"7.", 7000000},
+		// Past six places, halves round away from zero.
+		{"0.0000005", 1},
+		{"-0.0000005", -1},
+		{"0.0000004999", 0},
+		{"33.3333335", 33333334},
+		{"-2.1234565", -2123457},
+	}
+	for _, tt := range tests {
+		got, err := ParseDecimal(tt.in)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q): %v", tt.in, err)
+			continue
+		}
+		if got.Micros() != tt.micros {
+			t.Errorf("ParseDecimal(%q) = %d micros, want %d", tt.in, got.Micros(), tt.micros)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "$5", "1,000", "1e20", "1/3", "-2/3", "0x10", "1_000", "0b1", ".", "1e", "1e99999"} {
+		if _, err := ParseDecimal(in); err == nil {
+			t.Errorf("ParseDecimal(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"1.5", "2", "3"},
+		{"33.333333", "3", "99.999999"},
+		{"0.001", "0.001", "0.000001"},
+		// 0.0000005 rounds up, -0.0000005 down.
+		{"0.001", "0.0005", "0.000001"},
+		{"-0.001", "0.0005", "-0.000001"},
+		{"0.001", "0.0004", "0"},
+		{"199.96", "-100", "-19996"},
+	}
+	for _, tt := range tests {
+		if got := dec(t, tt.a).Mul(dec(t, tt.b)); got != dec(t, tt.want) {
+			t.Errorf("%s * %s = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMulDiv(t *testing.T) {
+	tests := []struct {
+		d, num, den, want string
+	}{
+		{"9.99", "1", "3", "3.33"},
+		{"10", "1", "3", "3.333333"},
+		{"20", "1", "3", "6.666667"},
+		{"-20", "1", "3", "-6.666667"},
+		{"1", "2", "0", "0"},
+		// Rounded once, not after the multiply.
+		{"0.000001", "1", "2", "0.000001"},
+		{"123456789.123456", "7", "7", "123456789.123456"},
+	}
+	for _, tt := range tests {
+		if got := dec(t, tt.d).MulDiv(dec(t, tt.num), dec(t, tt.den)); got != dec(t, tt.want) {
+			t.Errorf("%s * %s / %s = %s, want %s", tt.d, tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestMulDivSaturates(t *testing.T) {
+	huge := DecimalFromMicros(math.MaxInt64 / 2)
+	if got := huge.MulDiv(DecimalFromInt(4), DecimalFromInt(1)); got.Micros() != math.MaxInt64 {
+		t.Errorf("overflow gave %d micros, want the largest Decimal", got.Micros())
+	}
+	if got := huge.Neg().Mul(DecimalFromInt(4)); got.Micros() != math.MinInt64 {
+		t.Errorf("negative overflow gave %d micros, want the smallest Decimal", got.Micros())
+	}
+	if got := huge.Div(DecimalFromMicros(1)); got.Micros() != math.MaxInt64 {
+		t.Errorf("division overflow gave %d micros, want the largest Decimal", got.Micros())
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.004999", 2, "1"},
+		{"-1.005", 2, "-1.01"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"0.123456", 6, "0.123456"},
+		{"0.123456", 9, "0.123456"},
+		{"149.995", -1, "150"},
+	}
+	for _, tt := range tests {
+		if got := dec(t, tt.in).Round(tt.places); got != dec(t, tt.want) {
+			t.Errorf("Round(%s, %d) = %s, want %s", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestStringFixed(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"12.5", 2, "12.50"},
+		{"-0.004", 2, "0.00"},
+		{"-0.005", 2, "-0.01"},
+		{"1234567.891", 0, "1234568"},
+	}
+	for _, tt := range tests {
+		if got := dec(t, tt.in).StringFixed(tt.places); got != tt.want {
+			t.Errorf("StringFixed(%s, %d) = %q, want %q", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+// Money columns hold integer millionths, so SUM comes back as an integer,
+// AVG and TOTAL as floats and an empty SUM as NULL.
+func TestScanAggregates(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE amounts (amount INTEGER NOT NULL, kind TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, amount := range []string{"0.1", "0.2", "-0.05", "1234.567891"} {
+		if _, err := db.Exec("INSERT INTO amounts (amount, kind) VALUES (?, 'a')", dec(t, amount)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT SUM(amount) FROM amounts", "1234.817891"},
+		{"SELECT SUM(amount) FROM amounts WHERE kind = 'b'", "0"},
+		{"SELECT COALESCE(SUM(amount), 0) FROM amounts WHERE kind = 'b'", "0"},
+		{"SELECT TOTAL(amount) FROM amounts", "1234.817891"},
+		{"SELECT AVG(amount) FROM amounts", "308.704473"},
+		// A float sum rounds to the nearest millionth.
+		{"SELECT SUM(amount * 0.5) FROM amounts", "617.408946"},
+		{"SELECT CAST(SUM(amount) AS TEXT) FROM amounts", "1234.817891"},
+	}
+	for _, tt := range tests {
+		var got Decimal
+		if err := db.QueryRow(tt.query).Scan(&got); err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if got != dec(t, tt.want) {
+			t.Errorf("%s = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
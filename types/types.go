@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type TradeCode string
 type OptionType string
 
@@ -16,36 +18,290 @@ const (
 	CC   OptionType = "CC"
 )
 
+// Short reports whether options of this type are written rather than
+// bought.
+func (ot OptionType) Short() bool {
+	return ot == CC || ot == CSP
+}
+
+// Option events reported by brokers. They close a position at zero, and
+// assignments and exercises also deliver shares at the strike.
+const (
+	OEXP  TradeCode = "OEXP"
+	OASGN TradeCode = "OASGN"
+	OEXCS TradeCode = "OEXCS"
+)
+
+// Short sales reported by brokers. A plain Sell with no shares held also
+// opens a short, and a plain Buy covers an open short first.
+const (
+	SellShort  TradeCode = "Sell Short"
+	BuyToCover TradeCode = "Buy to Cover"
+)
+
+// PositionSide is whether stock is held long or sold short.
+type PositionSide string
+
+const (
+	Long  PositionSide = "long"
+	Short PositionSide = "short"
+)
+
+// Sells reports whether the trade sells shares, closing a long or opening
+// a short.
+func (code TradeCode) Sells() bool {
+	return code == Sell || code == SellShort
+}
+
 type StockTrade struct {
 	ID       string    `json:"id"`
 	Ticker   string    `json:"ticker"`
 	Date     string    `json:"date"`
 	Code     TradeCode `json:"code"`
-	Price    float64   `json:"price"`
-	Amount   float64   `json:"amount"`
-	Quantity float64   `json:"quantity"`
+	Price    Decimal   `json:"price"`
+	Amount   Decimal   `json:"amount"`
+	Quantity Decimal   `json:"quantity"`
+
+	// Fees is the commission and regulatory fees paid on the execution,
+	// always positive. They are already included in Amount.
+	Fees Decimal `json:"fees"`
+
+	// LotMethod and LotTradeID pick the lots a sale closes. LotTradeID is
+	// the opening trade of the lot chosen with SpecificLot.
+	LotMethod  LotMethod `json:"lot_method,omitempty"`
+	LotTradeID string    `json:"lot_trade_id,omitempty"`
+
+	// OptionTradeID is the option trade that delivered shares on
+	// assignment, whose premium lowers their adjusted basis.
+	OptionTradeID string `json:"option_trade_id,omitempty"`
+
+	AccountID int `json:"account_id"`
+
+	Row         int    `json:"row,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 type OptionTrade struct {
 	ID       string    `json:"id"`
 	Ticker   string    `json:"ticker"`
 	Date     string    `json:"date"`
 	Code     TradeCode `json:"code"`
-	Price    float64   `json:"price"`
-	Amount   float64   `json:"amount"`
-	Quantity float64   `json:"quantity"`
+	Price    Decimal   `json:"price"`
+	Amount   Decimal   `json:"amount"`
+	Quantity Decimal   `json:"quantity"`
 
-	Strike     float64    `json:"strike"`
+	Strike     Decimal    `json:"strike"`
 	ExpDate    string     `json:"exp_date"`
 	OptionType OptionType `json:"option_type"`
-	Premium    float64    `json:"premium"`
+	Premium    Decimal    `json:"premium"`
+
+	// Multiplier is the number of shares one contract delivers. Zero means
+	// the standard 100; see ContractMultiplier.
+	Multiplier Decimal `json:"multiplier"`
+
+	// Fees is the commission and per-contract fees paid on the execution.
+	Fees Decimal `json:"fees"`
+
+	// StrategyKey groups the legs of a multi-leg strategy. Legs opened
+	// with the same key share one strategy.
+	StrategyKey string `json:"strategy_key,omitempty"`
+
+	// RollKey pairs the close and the open of a roll. The open continues
+	// the roll chain of the position the close ended.
+	RollKey string `json:"roll_key,omitempty"`
+
+	// PositionTradeID is the opening trade of the position a close was
+	// entered against, which it closes before any other.
+	PositionTradeID string `json:"position_trade_id,omitempty"`
+
+	AccountID int `json:"account_id"`
+
+	Row         int    `json:"row,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// StandardMultiplier is the number of shares a listed equity option
+// contract delivers.
+var StandardMultiplier = DecimalFromInt(100)
+
+// ContractMultiplier is the trade's multiplier, or the standard one when
+// the broker didn't report it.
+func (t OptionTrade) ContractMultiplier() Decimal {
+	if t.Multiplier.Sign() <= 0 {
+		return StandardMultiplier
+	}
+	return t.Multiplier
+}
+
+type CashFlowType string
+
+const (
+	Dividend   CashFlowType = "dividend"
+	Interest   CashFlowType = "interest"
+	Fee        CashFlowType = "fee"
+	Deposit    CashFlowType = "deposit"
+	Withdrawal CashFlowType = "withdrawal"
+	// StartingBalance is the cash an account held before its first
+	// recorded trade or deposit.
+	StartingBalance CashFlowType = "starting_balance"
+)
+
+// External reports whether the flow moves money into or out of the account
+// rather than earning or costing it, which is what returns are measured
+// against.
+func (t CashFlowType) External() bool {
+	return t == Deposit || t == Withdrawal || t == StartingBalance
+}
+
+// CashFlow is money moving in or out of the account without a trade.
+// Amount is signed: fees and withdrawals are negative.
+type CashFlow struct {
+	ID          int          `json:"id"`
+	Date        string       `json:"date"`
+	Type        CashFlowType `json:"type"`
+	Ticker      string       `json:"ticker"`
+	Amount      Decimal      `json:"amount"`
+	Description string       `json:"description"`
+	AccountID   int          `json:"account_id"`
+	Account     string       `json:"account,omitempty"`
+
+	Row         int    `json:"row,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// DefaultAccount holds everything recorded before the user set up accounts
+// and anything recorded without picking one. AllAccounts is the account
+// view that consolidates every account.
+const (
+	DefaultAccount     = 0
+	AllAccounts        = -1
+	DefaultAccountName = "Default"
+)
+
+// Account is one of a user's brokerage accounts, such as a taxable account
+// or an IRA. Trades, cash flows and the positions they open belong to one
+// account.
+type Account struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// AccountTransfer moves open positions from one account to another on
+// Date, keeping their basis and open dates. An empty Ticker moves every
+// open position in the account.
+type AccountTransfer struct {
+	ID          int    `json:"id"`
+	Date        string `json:"date"`
+	Ticker      string `json:"ticker"`
+	FromAccount int    `json:"from_account"`
+	ToAccount   int    `json:"to_account"`
+}
+
+type CorporateActionType string
+
+const (
+	Split        CorporateActionType = "split"
+	TickerChange CorporateActionType = "ticker_change"
+	CashMerger   CorporateActionType = "cash_merger"
+)
+
+// CorporateAction changes the shares and options held in a ticker as of
+// its effective date. A split gives NewShares for every OldShares, so a
+// reverse split has fewer new shares than old. Applied actions are
+// replayed with the ledger.
+type CorporateAction struct {
+	ID            int                 `json:"id"`
+	Ticker        string              `json:"ticker"`
+	Type          CorporateActionType `json:"type"`
+	EffectiveDate string              `json:"effective_date"`
+	OldShares     Decimal             `json:"old_shares"`
+	NewShares     Decimal             `json:"new_shares"`
+	NewTicker     string              `json:"new_ticker"`
+	CashPerShare  Decimal             `json:"cash_per_share"`
+	Applied       bool                `json:"applied"`
+
+	Row int `json:"row,omitempty"`
+}
+
+// Ratio is the number of new shares per old share of a split.
+func (ca CorporateAction) Ratio() float64 {
+	if ca.OldShares.IsZero() {
+		return 1
+	}
+	return ca.NewShares.Float() / ca.OldShares.Float()
+}
+
+// CorporateAdjustment records one value a corporate action changed, so the
+// original can be audited after the action is applied.
+type CorporateAdjustment struct {
+	Record   string `json:"record"`
+	Ticker   string `json:"ticker"`
+	Field    string `json:"field"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
 }
 
+// LotMethod decides which tax lots a stock sale consumes.
+type LotMethod string
+
+const (
+	FIFO        LotMethod = "fifo"
+	LIFO        LotMethod = "lifo"
+	HIFO        LotMethod = "hifo"
+	AverageCost LotMethod = "average"
+	SpecificLot LotMethod = "specific"
+)
+
+// StockLot is the part of a single buy that is still held.
+type StockLot struct {
+	ID          int          `json:"id"`
+	Ticker      string       `json:"ticker"`
+	Side        PositionSide `json:"side"`
+	OpenDate    string       `json:"open_date"`
+	Quantity    Decimal      `json:"quantity"`
+	CostBasis   Decimal      `json:"cost_basis"`
+	OpenTradeID string       `json:"open_trade_id"`
+	AccountID   int          `json:"account_id"`
+
+	// WashAdjustment is loss disallowed by wash sales and added to the
+	// basis of the lot, covering WashQuantity of its shares.
+	WashAdjustment Decimal `json:"wash_adjustment"`
+	WashQuantity   Decimal `json:"wash_quantity"`
+
+	CampaignID int `json:"campaign_id"`
+
+	// PremiumAdjustment is option premium credited against the lot's cost
+	// from the put it was assigned from and calls sold against it.
+	PremiumAdjustment Decimal `json:"premium_adjustment"`
+
+	// Fees is what was paid to open the shares still in the lot. It is
+	// charged to the P/L of the sales that close them.
+	Fees Decimal `json:"fees"`
+}
+
+// StockPos is stock held in one ticker. Quantity is always positive; short
+// positions have Side set to Short and CostBasis is the average sale price.
 type StockPos struct {
-	ID        int     `json:"id"`
-	OpenDate  string  `json:"open_date"`
-	Ticker    string  `json:"ticker"`
-	Quantity  float64 `json:"quantity"`
-	CostBasis float64 `json:"cost_basis"`
+	ID        int          `json:"id"`
+	OpenDate  string       `json:"open_date"`
+	Ticker    string       `json:"ticker"`
+	Quantity  Decimal      `json:"quantity"`
+	CostBasis Decimal      `json:"cost_basis"`
+	Side      PositionSide `json:"side"`
+
+	// AdjustedBasis is CostBasis less option premium credited to the lots.
+	AdjustedBasis Decimal `json:"adjusted_basis"`
+
+	// Account names the account holding the position when positions
+	// from more than one account are listed together.
+	Account string `json:"account,omitempty"`
+}
+
+// SignedQuantity is negative for short positions.
+func (pos StockPos) SignedQuantity() Decimal {
+	if pos.Side == Short {
+		return pos.Quantity.Neg()
+	}
+	return pos.Quantity
 }
 
 type User struct {
@@ -64,50 +320,214 @@ type ClosedStock struct {
 	Ticker     string  `json:"ticker"`
 	OpenDate   string  `json:"open_date"`
 	CloseDate  string  `json:"close_date"`
-	Quantity   float64 `json:"quantity"`
-	CostBasis  float64 `json:"cost_basis"`
-	SellPrice  float64 `json:"sell_price"`
-	ProfitLoss float64 `json:"profit_loss"`
+	Quantity   Decimal `json:"quantity"`
+	CostBasis  Decimal `json:"cost_basis"`
+	SellPrice  Decimal `json:"sell_price"`
+	ProfitLoss Decimal `json:"profit_loss"`
+	LotID      int     `json:"lot_id"`
+
+	// Side is Short when the row covers a short sale: CostBasis is then
+	// the sale price and SellPrice the price paid to cover.
+	Side PositionSide `json:"side"`
+
+	WashDisallowed Decimal `json:"wash_disallowed"`
+	WashAdjustment Decimal `json:"wash_adjustment"`
+
+	PremiumAdjustment Decimal `json:"premium_adjustment"`
+
+	// Fees paid to open and close the shares, already taken out of
+	// ProfitLoss.
+	Fees Decimal `json:"fees"`
+
+	Account string `json:"account,omitempty"`
 }
 
 type OptionPos struct {
 	ID           int        `json:"id"`
 	Ticker       string     `json:"ticker"`
-	Price        float64    `json:"price"`
-	Premium      float64    `json:"premium"`
-	Strike       float64    `json:"strike"`
+	Price        Decimal    `json:"price"`
+	Premium      Decimal    `json:"premium"`
+	Strike       Decimal    `json:"strike"`
 	ExpDate      string     `json:"exp_date"`
 	Type         OptionType `json:"type"`
-	Collateral   float64    `json:"collateral"`
-	Quantity     float64    `json:"quantity"`
+	Collateral   Decimal    `json:"collateral"`
+	Quantity     Decimal    `json:"quantity"`
 	PurchaseDate string     `json:"purchase_date"`
+	StrategyID   int        `json:"strategy_id"`
+
+	// Multiplier is the number of shares one contract delivers, 100 unless
+	// a corporate action adjusted the contract.
+	Multiplier Decimal `json:"multiplier"`
+
+	// RollID is the roll chain the position belongs to, or 0. Rolls counts
+	// the earlier legs in the chain and RollNet is the net credit, or
+	// debit when negative, across every leg including this one.
+	RollID  int     `json:"roll_id"`
+	Rolls   int     `json:"rolls"`
+	RollNet Decimal `json:"roll_net"`
+
+	OpenTradeID string `json:"open_trade_id"`
+	AccountID   int    `json:"account_id"`
+	Account     string `json:"account,omitempty"`
+}
+
+// OptionStrategy is a group of option legs on one ticker and expiry that
+// is tracked and closed as a unit, such as a spread or an iron condor.
+// NetPremium is positive for a credit. MaxProfit and MaxLoss are positive
+// dollar amounts at expiry, left at zero when UnlimitedProfit or
+// UnlimitedLoss is set.
+type OptionStrategy struct {
+	ID              int         `json:"id"`
+	Ticker          string      `json:"ticker"`
+	Kind            string      `json:"kind"`
+	OpenDate        string      `json:"open_date"`
+	ExpDate         string      `json:"exp_date"`
+	Legs            []OptionPos `json:"legs"`
+	NetPremium      Decimal     `json:"net_premium"`
+	MaxProfit       Decimal     `json:"max_profit"`
+	MaxLoss         Decimal     `json:"max_loss"`
+	UnlimitedProfit bool        `json:"unlimited_profit"`
+	UnlimitedLoss   bool        `json:"unlimited_loss"`
+	Breakevens      []Decimal   `json:"breakevens"`
+	Collateral      Decimal     `json:"collateral"`
+}
+
+// ClosedStrategy is a strategy with no open legs left. ProfitLoss is the
+// combined P/L of every leg.
+type ClosedStrategy struct {
+	ID         int     `json:"id"`
+	Ticker     string  `json:"ticker"`
+	Kind       string  `json:"kind"`
+	OpenDate   string  `json:"open_date"`
+	CloseDate  string  `json:"close_date"`
+	ExpDate    string  `json:"exp_date"`
+	Legs       int     `json:"legs"`
+	NetPremium Decimal `json:"net_premium"`
+	ProfitLoss Decimal `json:"profit_loss"`
+}
+
+// WheelCampaign links the puts sold on a ticker, the shares assigned from
+// them and the calls sold against those shares, until the shares are
+// called away or sold. PremiumCollected is net of buybacks and ProfitLoss
+// is realized P/L across options and shares.
+type WheelCampaign struct {
+	ID                 int     `json:"id"`
+	Ticker             string  `json:"ticker"`
+	StartDate          string  `json:"start_date"`
+	EndDate            string  `json:"end_date"`
+	Account            string  `json:"account,omitempty"`
+	PremiumCollected   Decimal `json:"premium_collected"`
+	SharesHeld         Decimal `json:"shares_held"`
+	EffectiveCostBasis Decimal `json:"effective_cost_basis"`
+	Capital            Decimal `json:"capital"`
+	ProfitLoss         Decimal `json:"profit_loss"`
+	Days               int     `json:"days"`
+	AnnualizedReturn   float64 `json:"annualized_return"`
+}
+
+func (c WheelCampaign) Open() bool {
+	return c.EndDate == ""
+}
+
+// WheelEvent is one trade in a campaign. Amount is the cash it moved.
+type WheelEvent struct {
+	Date        string  `json:"date"`
+	Description string  `json:"description"`
+	Amount      Decimal `json:"amount"`
+}
+
+// RollLeg is one position in a roll chain. Legs still open have no close
+// date. NetCredit is the cash the leg brought in, negative for a debit.
+type RollLeg struct {
+	Type       OptionType `json:"type"`
+	Strike     Decimal    `json:"strike"`
+	ExpDate    string     `json:"exp_date"`
+	Quantity   Decimal    `json:"quantity"`
+	OpenDate   string     `json:"open_date"`
+	Premium    Decimal    `json:"premium"`
+	CloseDate  string     `json:"close_date"`
+	ClosePrice Decimal    `json:"close_price"`
+	NetCredit  Decimal    `json:"net_credit"`
 }
 
 type ClosedOption struct {
 	ID           int        `json:"id"`
 	Ticker       string     `json:"ticker"`
-	Price        float64    `json:"price"`
-	Premium      float64    `json:"premium"`
-	Strike       float64    `json:"strike"`
+	Price        Decimal    `json:"price"`
+	Premium      Decimal    `json:"premium"`
+	Strike       Decimal    `json:"strike"`
 	ExpDate      string     `json:"exp_date"`
 	Type         OptionType `json:"type"`
-	Collateral   float64    `json:"collateral"`
-	Quantity     float64    `json:"quantity"`
+	Collateral   Decimal    `json:"collateral"`
+	Quantity     Decimal    `json:"quantity"`
 	PurchaseDate string     `json:"purchase_date"`
 	CloseDate    string     `json:"close_date"`
-	SellPrice    float64    `json:"sell_price"`
-	ProfitLoss   float64    `json:"profit_loss"`
+	SellPrice    Decimal    `json:"sell_price"`
+	ProfitLoss   Decimal    `json:"profit_loss"`
+	StrategyID   int        `json:"strategy_id"`
+
+	WashDisallowed Decimal `json:"wash_disallowed"`
+	WashAdjustment Decimal `json:"wash_adjustment"`
+
+	// PremiumToBasis is the part of the P/L credited to the basis of
+	// shares instead, for assigned puts and covered calls.
+	PremiumToBasis Decimal `json:"premium_to_basis"`
+
+	// Fees paid to open and close the contracts, already taken out of
+	// ProfitLoss.
+	Fees Decimal `json:"fees"`
+
+	Multiplier Decimal `json:"multiplier"`
+
+	Account string `json:"account,omitempty"`
+}
+
+// IsLongTerm reports whether the lot was held for more than a year.
+func (cs ClosedStock) IsLongTerm() bool {
+	open, err := time.Parse("2006-01-02", cs.OpenDate)
+	if err != nil {
+		return false
+	}
+	closed, err := time.Parse("2006-01-02", cs.CloseDate)
+	if err != nil {
+		return false
+	}
+	return closed.After(open.AddDate(1, 0, 0))
+}
+
+// AdjustedPL is the P/L after wash sales: disallowed loss is added back and
+// loss carried in from earlier wash sales is taken off.
+func (cs ClosedStock) AdjustedPL() Decimal {
+	return cs.ProfitLoss.Add(cs.WashDisallowed).Sub(cs.WashAdjustment)
+}
+func (co ClosedOption) AdjustedPL() Decimal {
+	return co.ProfitLoss.Add(co.WashDisallowed).Sub(co.WashAdjustment)
+}
+
+// PremiumAdjustedPL is the P/L with option premium counted in the share
+// basis rather than as option income.
+func (cs ClosedStock) PremiumAdjustedPL() Decimal {
+	return cs.ProfitLoss.Add(cs.PremiumAdjustment)
+}
+func (co ClosedOption) PremiumAdjustedPL() Decimal {
+	return co.ProfitLoss.Sub(co.PremiumToBasis)
 }
 
 func (cs ClosedStock) CalculateROR() float64 {
-	return cs.ProfitLoss / cs.CostBasis
+	return cs.ProfitLoss.Float() / cs.CostBasis.Float()
 }
+
+// PremiumPaid is the premium across every contract closed.
+func (co ClosedOption) PremiumPaid() Decimal {
+	return co.Premium.Mul(co.Quantity).Mul(co.Multiplier)
+}
+
 func (co ClosedOption) CalculateROR() float64 {
 	switch co.Type {
 	case Call, Put:
-		return co.ProfitLoss / co.Premium
+		return co.ProfitLoss.Float() / co.PremiumPaid().Float()
 	case CC, CSP:
-		return co.ProfitLoss / co.Collateral
+		return co.ProfitLoss.Float() / co.Collateral.Float()
 	default:
 		return 0
 	}
@@ -116,8 +536,8 @@ func (co ClosedOption) RORPercent() float64 {
 	return co.CalculateROR() * 100
 }
 func (stock ClosedStock) PlPercent() float64 {
-	return (stock.ProfitLoss / (stock.CostBasis * stock.Quantity)) * 100
+	return (stock.ProfitLoss.Float() / stock.CostBasis.Mul(stock.Quantity).Float()) * 100
 }
 func (option ClosedOption) PlPercent() float64 {
-	return (option.ProfitLoss / (option.Premium + option.Collateral)) * 100
+	return (option.ProfitLoss.Float() / option.PremiumPaid().Add(option.Collateral).Float()) * 100
 }
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"backend/types"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BrokerFormat reads the CSV export of one broker. Detect is given the
+// normalized header columns and ParseRow turns a data row into a trade or
+// cash flow. A row it can't use is reported with an error, and an empty
+// ParsedRow without an error (a section header or totals line) is ignored.
+type BrokerFormat interface {
+	Name() string
+	Detect(header CSVHeader) bool
+	ParseRow(row CSVRow, fingerprints Fingerprinter) (ParsedRow, error)
+}
+
+// ParsedRow holds whichever of a stock trade, option trade or cash flow a
+// source row produced.
+type ParsedRow struct {
+	Stock  *types.StockTrade
+	Option *types.OptionTrade
+	Cash   *types.CashFlow
+}
+
+// CSVHeader maps normalized column names to their position.
+type CSVHeader map[string]int
+
+func NewCSVHeader(columns []string) CSVHeader {
+	header := CSVHeader{}
+	for i, column := range columns {
+		name := normalizeColumnName(column)
+		if _, exists := header[name]; !exists {
+			header[name] = i
+		}
+	}
+	return header
+}
+
+func normalizeColumnName(column string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Trim(column, "\"\ufeff")))
+}
+
+func (h CSVHeader) Has(columns ...string) bool {
+	for _, column := range columns {
+		if _, exists := h[normalizeColumnName(column)]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+type CSVRow struct {
+	Fields []string
+	Header CSVHeader
+}
+
+// Get returns the trimmed value of a column, or "" if the row doesn't have it.
+func (r CSVRow) Get(column string) string {
+	i, exists := r.Header[normalizeColumnName(column)]
+	if !exists || i >= len(r.Fields) {
+		return ""
+	}
+	return strings.TrimSpace(strings.Trim(r.Fields[i], "\""))
+}
+
+func (r CSVRow) blank() bool {
+	for _, field := range r.Fields {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+var brokerFormats []BrokerFormat
+
+// RegisterBrokerFormat adds a format to the registry. Formats are tried in
+// registration order, so more specific layouts should be registered first.
+func RegisterBrokerFormat(format BrokerFormat) {
+	brokerFormats = append(brokerFormats, format)
+}
+
+func init() {
+	RegisterBrokerFormat(robinhoodFormat{})
+	RegisterBrokerFormat(schwabFormat{})
+	RegisterBrokerFormat(ibkrFlexFormat{})
+}
+
+func BrokerFormatNames() []string {
+	names := make([]string, len(brokerFormats))
+	for i, format := range brokerFormats {
+		names[i] = format.Name()
+	}
+	return names
+}
+
+// maxHeaderSearchRows bounds how far into the file we look for a header,
+// since some brokers put an account title above it.
+const maxHeaderSearchRows = 5
+
+// DetectBrokerFormat finds the header row and the format that recognises it.
+func DetectBrokerFormat(rows [][]string) (BrokerFormat, int, bool) {
+	return detectFormat(rows, brokerFormats)
+}
+
+func detectFormat(rows [][]string, formats []BrokerFormat) (BrokerFormat, int, bool) {
+	for i := 0; i < len(rows) && i < maxHeaderSearchRows; i++ {
+		header := NewCSVHeader(rows[i])
+		for _, format := range formats {
+			if format.Detect(header) {
+				return format, i, true
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// ErrUnrecognizedFormat is returned when no format recognises the header.
+var ErrUnrecognizedFormat = errors.New("unrecognized CSV format")
+
+// ParseBrokerageCSV parses an export with the first format that recognises
+// its header. Extra formats, such as a user's saved column mappings, are
+// tried before the built-in ones.
+func ParseBrokerageCSV(csvContent string, extra ...BrokerFormat) (*ImportedTrades, error) {
+	rows := Parse(csvContent)
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("CSV is empty")
+	}
+
+	format, headerIndex, ok := detectFormat(rows, append(extra, brokerFormats...))
+	if !ok {
+		return nil, fmt.Errorf("%w, supported formats are %s", ErrUnrecognizedFormat, strings.Join(BrokerFormatNames(), ", "))
+	}
+	if headerIndex == len(rows)-1 {
+		return nil, fmt.Errorf("CSV only contains header row")
+	}
+
+	return parseBrokerRows(format, NewCSVHeader(rows[headerIndex]), rows, headerIndex+1), nil
+}
+
+func parseBrokerRows(format BrokerFormat, header CSVHeader, rows [][]string, start int) *ImportedTrades {
+	result := &ImportedTrades{
+		Format:       format.Name(),
+		StockTrades:  []types.StockTrade{},
+		OptionTrades: []types.OptionTrade{},
+	}
+
+	fingerprints := Fingerprinter{}
+
+	for i := start; i < len(rows); i++ {
+		row := CSVRow{Fields: rows[i], Header: header}
+		rowNumber := i + 1
+		if row.blank() {
+			continue
+		}
+
+		parsed, err := format.ParseRow(row, fingerprints)
+		if err != nil {
+			result.Skipped = append(result.Skipped, SkippedRow{Row: rowNumber, Reason: err.Error()})
+			continue
+		}
+		result.add(parsed, rowNumber)
+	}
+
+	return result
+}
+
+// impliedFees is the commission a broker took out of a trade's cash amount
+// when the export has no fees column: what a purchase cost beyond its price
+// times quantity, or what a sale's proceeds fell short of it. The gross is
+// rounded to the cent first so a rounded amount isn't mistaken for a fee.
+func impliedFees(pays bool, gross, amount types.Decimal) types.Decimal {
+	if amount.IsZero() {
+		return types.Decimal{}
+	}
+	fees := amount.Abs().Sub(gross.Round(2))
+	if !pays {
+		fees = fees.Neg()
+	}
+	return types.MaxDecimal(fees, types.Decimal{})
+}
+
+// add appends a parsed row to the result, skipping trades without a
+// positive quantity and cash flows without an amount. Trades whose format
+// reported no fees get the fees implied by their amount.
+func (result *ImportedTrades) add(parsed ParsedRow, rowNumber int) {
+	switch {
+	case parsed.Stock != nil:
+		if parsed.Stock.Quantity.Sign() <= 0 {
+			result.Skipped = append(result.Skipped, SkippedRow{Row: rowNumber, Reason: "quantity must be positive"})
+			return
+		}
+		if parsed.Stock.Fees.IsZero() {
+			pays := parsed.Stock.Code == types.Buy || parsed.Stock.Code == types.BuyToCover
+			parsed.Stock.Fees = impliedFees(pays, parsed.Stock.Price.Mul(parsed.Stock.Quantity), parsed.Stock.Amount)
+		}
+		parsed.Stock.Row = rowNumber
+		result.StockTrades = append(result.StockTrades, *parsed.Stock)
+	case parsed.Option != nil:
+		if parsed.Option.Quantity.Sign() <= 0 {
+			result.Skipped = append(result.Skipped, SkippedRow{Row: rowNumber, Reason: "quantity must be positive"})
+			return
+		}
+		if parsed.Option.Fees.IsZero() {
+			pays := parsed.Option.Code == types.BTO || parsed.Option.Code == types.BTC
+			parsed.Option.Fees = impliedFees(pays, parsed.Option.Price.Mul(parsed.Option.Quantity).Mul(parsed.Option.ContractMultiplier()), parsed.Option.Amount)
+		}
+		parsed.Option.Row = rowNumber
+		result.OptionTrades = append(result.OptionTrades, *parsed.Option)
+	case parsed.Cash != nil:
+		if parsed.Cash.Amount.IsZero() {
+			result.Skipped = append(result.Skipped, SkippedRow{Row: rowNumber, Reason: "cash amount is zero"})
+			return
+		}
+		parsed.Cash.Row = rowNumber
+		result.CashFlows = append(result.CashFlows, *parsed.Cash)
+	}
+}
@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"backend/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wantTrade is one parsed trade, with numbers written as decimals and the
+// contract as "strike type expiry" for options. Stock trades are listed
+// before option trades, each in file order.
+type wantTrade struct {
+	row                   int
+	code                  types.TradeCode
+	ticker, contract      string
+	quantity, price, fees string
+	fingerprint           string
+}
+
+type wantCash struct {
+	row      int
+	cashType types.CashFlowType
+	amount   string
+}
+
+type wantSkipped struct {
+	row    int
+	reason string
+}
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("..", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func parsedTrades(result *ImportedTrades) []wantTrade {
+	var trades []wantTrade
+	for _, trade := range result.StockTrades {
+		trades = append(trades, wantTrade{
+			row: trade.Row, code: trade.Code, ticker: trade.Ticker,
+			quantity: trade.Quantity.String(), price: trade.Price.String(), fees: trade.Fees.String(),
+			fingerprint: trade.Fingerprint,
+		})
+	}
+	for _, trade := range result.OptionTrades {
+		trades = append(trades, wantTrade{
+			row: trade.Row, code: trade.Code, ticker: trade.Ticker,
+			contract: fmt.Sprintf("%s %s %s", trade.Strike, trade.OptionType, trade.ExpDate),
+			quantity: trade.Quantity.String(), price: trade.Price.String(), fees: trade.Fees.String(),
+			fingerprint: trade.Fingerprint,
+		})
+	}
+	return trades
+}
+
+func TestParseBrokerageCSVFixtures(t *testing.T) {
+	tests := []struct {
+		file    string
+		format  string
+		trades  []wantTrade
+		cash    []wantCash
+		skipped []wantSkipped
+	}{
+		{
+			file:   "brokers/robinhood.csv",
+			format: "Robinhood",
+			trades: []wantTrade{
+				{3, types.Sell, "AAPL", "", "5", "250", "0", "cc4c824d68d34af8fc9dbd4311f31a4fa658d68c590a34b9a0794d300bec3986"},
+				{5, types.Buy, "AAPL", "", "10", "200", "0", "d59fa0fbefbf38824e0facdeb9216d89b492ded817b07bceb5ced0591dad7b57"},
+				// The amounts are net of fees Robinhood doesn't report.
+				{2, types.BTC, "AAPL", "240 Put 10/24/2025", "1", "0.5", "0.04", "e5504dc3e7b663b39d596294981e4418e5ca67499ceae80e4f1a3571a1552a68"},
+				{4, types.STO, "AAPL", "240 Put 10/24/2025", "1", "2", "0.04", "afd62564be43607eb0ef743b213c31c33138610cd7cff43b16fdfa8a9a5d6c7b"},
+				{6, types.BTO, "MSFT", "500 Call 11/21/2025", "2", "3", "0", "a3fc84cf20bddab43bdb71433a32edfc1139af6a4e569eccadca1a25850ad9d7"},
+			},
+		},
+		{
+			file:   "brokers/schwab.csv",
+			format: "Schwab",
+			trades: []wantTrade{
+				{4, types.Sell, "AAPL", "", "5", "250", "0", "2f06709820e96274496dd3db3ab0bcb7178adf5faac178f8141737efa7de64b2"},
+				{7, types.Buy, "AAPL", "", "10", "200", "0", "8109a41ba3325c6c51a83515632625d09ec618a9cf1b44838f22086477d939f1"},
+				{3, types.BTC, "AAPL", "240 Put 10/24/2025", "1", "0.5", "0.66", "1706e465db108450e370aff7d159727b141ed3e69d45966856f6d390ebb40de5"},
+				{5, types.STO, "AAPL", "240 Put 10/24/2025", "1", "2", "0.66", "b77f76e2e977e73973478e45efd999a0bd639ca51a70b19cefe017237aa46b06"},
+			},
+			cash: []wantCash{{6, types.Dividend, "2.6"}},
+		},
+		{
+			file:   "brokers/ibkr_flex.csv",
+			format: "Interactive Brokers Flex",
+			trades: []wantTrade{
+				{2, types.Buy, "AAPL", "", "10", "200", "1", "69af17d5af85e8df9d22d83ac9dd677d817c84cbf348f703fdf70f85d3cbe1f3"},
+				{4, types.Sell, "AAPL", "", "5", "250", "1", "241932ab2d653aaef654f27b1f6de70f1c98ee3bf3d92f48a1090f24e4e86253"},
+				{3, types.STO, "AAPL", "240 Put 2025-10-24", "1", "2", "0.65", "8dbd7ac0922fc35c1055ac4329b567f438251ff5c4be8bb4dda4a68ff16dfa83"},
+				{5, types.BTC, "AAPL", "240 Put 2025-10-24", "1", "0.5", "0.65", "6f557245495345918670ea62c2181f0048c716186489908f2a7e5a3763fd9f8e"},
+			},
+			skipped: []wantSkipped{{6, `unsupported asset class "CASH"`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			content := readFixture(t, tt.file)
+			result, err := ParseBrokerageCSV(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Format != tt.format {
+				t.Errorf("format = %q, want %q", result.Format, tt.format)
+			}
+
+			trades := parsedTrades(result)
+			if len(trades) != len(tt.trades) {
+				t.Fatalf("parsed %d trades, want %d: %+v", len(trades), len(tt.trades), trades)
+			}
+			for i, want := range tt.trades {
+				if trades[i] != want {
+					t.Errorf("trade %d:\n got %+v\nwant %+v", i, trades[i], want)
+				}
+			}
+
+			if len(result.CashFlows) != len(tt.cash) {
+				t.Fatalf("parsed %d cash flows, want %d: %+v", len(result.CashFlows), len(tt.cash), result.CashFlows)
+			}
+			for i, want := range tt.cash {
+				got := result.CashFlows[i]
+				if got.Row != want.row || got.Type != want.cashType || got.Amount.String() != want.amount {
+					t.Errorf("cash flow %d = row %d %s %s, want row %d %s %s", i, got.Row, got.Type, got.Amount, want.row, want.cashType, want.amount)
+				}
+			}
+
+			if len(result.Skipped) != len(tt.skipped) {
+				t.Fatalf("skipped %+v, want %+v", result.Skipped, tt.skipped)
+			}
+			for i, want := range tt.skipped {
+				got := result.Skipped[i]
+				if got.Row != want.row || !strings.Contains(got.Reason, want.reason) {
+					t.Errorf("skipped %d = %+v, want row %d %q", i, got, want.row, want.reason)
+				}
+			}
+
+			// Re-importing the same file must produce the same fingerprints
+			// so duplicates are caught.
+			again, err := ParseBrokerageCSV(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, trade := range parsedTrades(again) {
+				if trade.fingerprint != trades[i].fingerprint {
+					t.Errorf("trade %d fingerprint changed between parses", i)
+				}
+			}
+		})
+	}
+}
+
+// Identical rows in one file are separate executions and must not share a
+// fingerprint.
+func TestFingerprintsOfRepeatedRows(t *testing.T) {
+	content := readFixture(t, "brokers/robinhood.csv")
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	result, err := ParseBrokerageCSV(content + lines[1] + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for _, trade := range parsedTrades(result) {
+		if seen[trade.fingerprint] {
+			t.Errorf("row %d repeats fingerprint %s", trade.row, trade.fingerprint)
+		}
+		seen[trade.fingerprint] = true
+	}
+	if len(seen) != 6 {
+		t.Errorf("got %d fingerprints, want 6", len(seen))
+	}
+}
+
+// A number that doesn't parse skips its row with the reason instead of
+// importing the row with a zero.
+func TestInvalidNumbersAreSkipped(t *testing.T) {
+	tests := []struct {
+		file     string
+		old, new string
+		row      int
+		reason   string
+	}{
+		{"brokers/robinhood.csv", `"5","$250.00"`, `"5","$25O.00"`, 3, `invalid number "25O.00"`},
+		{"brokers/schwab.csv", `"5","$250.00"`, `"5 shares","$250.00"`, 4, `invalid number "5 shares"`},
+		{"brokers/ibkr_flex.csv", `"-5","250","1250"`, `"-5","250","1.250,00"`, 4, `invalid number "1.250,00"`},
+		{"ofx/statement.ofx", "<UNITPRICE>250", "<UNITPRICE>250 USD", 3, `invalid UNITPRICE "250 USD"`},
+	}
+	parse := func(content string) (*ImportedTrades, error) {
+		if IsOFX(content) {
+			return ParseOFX(content)
+		}
+		return ParseBrokerageCSV(content)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			content := readFixture(t, tt.file)
+			if strings.Count(content, tt.old) != 1 {
+				t.Fatalf("fixture should contain %q once", tt.old)
+			}
+			clean, err := parse(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := parse(strings.Replace(content, tt.old, tt.new, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, trade := range parsedTrades(result) {
+				if trade.row == tt.row {
+					t.Errorf("row %d was imported as %+v", tt.row, trade)
+				}
+			}
+			if got, want := len(parsedTrades(result)), len(parsedTrades(clean))-1; got != want {
+				t.Errorf("parsed %d trades, want %d", got, want)
+			}
+			found := false
+			for _, skipped := range result.Skipped {
+				if skipped.Row == tt.row && strings.Contains(skipped.Reason, tt.reason) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("skipped %+v, want row %d %q", result.Skipped, tt.row, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"backend/types"
+	"fmt"
+	"strings"
+)
+
+// Fields a user can map a CSV column to.
+const (
+	FieldDate        = "date"
+	FieldTicker      = "ticker"
+	FieldCode        = "code"
+	FieldQuantity    = "quantity"
+	FieldPrice       = "price"
+	FieldAmount      = "amount"
+	FieldFees        = "fees"
+	FieldDescription = "description"
+	FieldStrike      = "strike"
+	FieldExpiry      = "expiry"
+	FieldOptionType  = "option_type"
+	FieldMultiplier  = "multiplier"
+)
+
+type MappingField struct {
+	Key      string
+	Label    string
+	Required bool
+}
+
+var MappingFields = []MappingField{
+	{Key: FieldDate, Label: "Date", Required: true},
+	{Key: FieldTicker, Label: "Ticker", Required: true},
+	{Key: FieldCode, Label: "Trans Code", Required: true},
+	{Key: FieldQuantity, Label: "Quantity", Required: true},
+	{Key: FieldPrice, Label: "Price", Required: true},
+	{Key: FieldAmount, Label: "Amount"},
+	{Key: FieldFees, Label: "Fees"},
+	{Key: FieldDescription, Label: "Description"},
+	{Key: FieldStrike, Label: "Strike"},
+	{Key: FieldExpiry, Label: "Expiry"},
+	{Key: FieldOptionType, Label: "Option Type"},
+	{Key: FieldMultiplier, Label: "Multiplier"},
+}
+
+// ColumnMapping maps a DataTrader field to a normalized header column.
+type ColumnMapping map[string]string
+
+// MappedFormat is a user's saved column mapping. It only recognises files
+// whose header has exactly the columns it was created from.
+type MappedFormat struct {
+	ProfileName string
+	Header      []string
+	Columns     ColumnMapping
+}
+
+func (f MappedFormat) Name() string { return f.ProfileName }
+
+func (f MappedFormat) Detect(header CSVHeader) bool {
+	return len(header) == len(NewCSVHeader(f.Header)) && header.Has(f.Header...)
+}
+
+// Validate reports the first required field that isn't mapped.
+func (f MappedFormat) Validate() error {
+	for _, field := range MappingFields {
+		if _, mapped := f.Columns[field.Key]; field.Required && !mapped {
+			return fmt.Errorf("%s must be mapped to a column", field.Label)
+		}
+	}
+	return nil
+}
+
+var mappedTradeCodes = map[string]types.TradeCode{
+	"buy":           types.Buy,
+	"bought":        types.Buy,
+	"sell":          types.Sell,
+	"sold":          types.Sell,
+	"sell short":    types.SellShort,
+	"short":         types.SellShort,
+	"ss":            types.SellShort,
+	"buy to cover":  types.BuyToCover,
+	"cover":         types.BuyToCover,
+	"bc":            types.BuyToCover,
+	"bto":           types.BTO,
+	"buy to open":   types.BTO,
+	"sto":           types.STO,
+	"sell to open":  types.STO,
+	"btc":           types.BTC,
+	"buy to close":  types.BTC,
+	"stc":           types.STC,
+	"sell to close": types.STC,
+	"oexp":          types.OEXP,
+	"expired":       types.OEXP,
+	"oasgn":         types.OASGN,
+	"assigned":      types.OASGN,
+	"oexcs":         types.OEXCS,
+	"exercised":     types.OEXCS,
+}
+
+// mappedCashCodes turn a row into a cash flow instead of a trade. Transfers
+// are classified by the sign of the amount.
+var mappedCashCodes = map[string]types.CashFlowType{
+	"dividend":   types.Dividend,
+	"div":        types.Dividend,
+	"interest":   types.Interest,
+	"int":        types.Interest,
+	"fee":        types.Fee,
+	"deposit":    types.Deposit,
+	"withdrawal": types.Deposit,
+	"transfer":   types.Deposit,
+}
+
+func (f MappedFormat) get(row CSVRow, field string) string {
+	column, mapped := f.Columns[field]
+	if !mapped {
+		return ""
+	}
+	return row.Get(column)
+}
+
+// number parses an optional numeric field, treating an empty value as zero.
+func (f MappedFormat) number(row CSVRow, field string) (types.Decimal, error) {
+	return CleanCurrencyString(f.get(row, field))
+}
+
+func (f MappedFormat) ParseRow(row CSVRow, fingerprints Fingerprinter) (ParsedRow, error) {
+	ticker := strings.ToUpper(f.get(row, FieldTicker))
+	rawCode := f.get(row, FieldCode)
+
+	var date string
+	if fields := strings.Fields(f.get(row, FieldDate)); len(fields) > 0 {
+		date = fields[0]
+	}
+
+	var numbers rowNumbers
+	quantity := numbers.keep(f.number(row, FieldQuantity)).Abs()
+	price := numbers.keep(f.number(row, FieldPrice))
+	amount := numbers.keep(f.number(row, FieldAmount))
+	fees := numbers.keep(f.number(row, FieldFees)).Abs()
+	if numbers.err != nil {
+		return ParsedRow{}, numbers.err
+	}
+
+	// Fees and multipliers are left out so rows imported before they could
+	// be mapped are still recognised.
+	values := []string{"mapped"}
+	for _, field := range MappingFields {
+		if field.Key != FieldFees && field.Key != FieldMultiplier {
+			values = append(values, f.get(row, field.Key))
+		}
+	}
+	fingerprint := fingerprints.Fingerprint(values...)
+
+	if cashType, ok := mappedCashCodes[strings.ToLower(rawCode)]; ok {
+		if cashType == types.Deposit {
+			cashType = TransferType(amount)
+		}
+		return ParsedRow{Cash: &types.CashFlow{
+			Date:        date,
+			Type:        cashType,
+			Ticker:      ticker,
+			Amount:      amount,
+			Description: f.get(row, FieldDescription),
+			Fingerprint: fingerprint,
+		}}, nil
+	}
+
+	if ticker == "" {
+		return ParsedRow{}, fmt.Errorf("no ticker")
+	}
+
+	code, ok := mappedTradeCodes[strings.ToLower(rawCode)]
+	if !ok {
+		return ParsedRow{}, fmt.Errorf("unsupported transaction code %q", rawCode)
+	}
+
+	switch code {
+	case types.Buy, types.Sell, types.SellShort, types.BuyToCover:
+		return ParsedRow{Stock: &types.StockTrade{
+			Ticker:      ticker,
+			Date:        date,
+			Code:        code,
+			Price:       price,
+			Amount:      amount,
+			Quantity:    quantity,
+			Fees:        fees,
+			Fingerprint: fingerprint,
+		}}, nil
+	}
+
+	strike := numbers.keep(f.number(row, FieldStrike))
+	multiplier := numbers.keep(f.number(row, FieldMultiplier))
+	if numbers.err != nil {
+		return ParsedRow{}, numbers.err
+	}
+
+	trade := types.OptionTrade{
+		Ticker:      ticker,
+		Date:        date,
+		Code:        code,
+		Price:       price,
+		Amount:      amount,
+		Quantity:    quantity,
+		Strike:      strike,
+		ExpDate:     f.get(row, FieldExpiry),
+		Premium:     price,
+		Multiplier:  multiplier,
+		Fees:        fees,
+		Fingerprint: fingerprint,
+	}
+
+	switch strings.ToLower(f.get(row, FieldOptionType)) {
+	case "call", "c":
+		trade.OptionType = types.Call
+	case "put", "p":
+		trade.OptionType = types.Put
+	}
+
+	if trade.Strike.IsZero() || trade.ExpDate == "" || trade.OptionType == "" {
+		// Fall back to a description such as "AAPL 10/24/2025 Put $240.00"
+		if err := parseOptionDetailsFromDescription(&trade, f.get(row, FieldDescription)); err != nil {
+			return ParsedRow{}, err
+		}
+	}
+	if trade.Strike.IsZero() || trade.ExpDate == "" || trade.OptionType == "" {
+		return ParsedRow{}, fmt.Errorf("missing strike, expiry or option type")
+	}
+
+	return ParsedRow{Option: &trade}, nil
+}
+
+// GuessColumnMapping pre-selects columns whose name matches a field.
+func GuessColumnMapping(header []string) ColumnMapping {
+	aliases := map[string][]string{
+		FieldDate:        {"date", "trade date", "activity date", "transaction date"},
+		FieldTicker:      {"ticker", "symbol", "instrument"},
+		FieldCode:        {"code", "trans code", "action", "side", "type"},
+		FieldQuantity:    {"quantity", "qty", "shares", "contracts"},
+		FieldPrice:       {"price", "fill price", "trade price"},
+		FieldAmount:      {"amount", "net amount", "total"},
+		FieldFees:        {"fees", "fee", "commission", "commissions", "fees & comm"},
+		FieldDescription: {"description"},
+		FieldStrike:      {"strike"},
+		FieldExpiry:      {"expiry", "expiration", "exp date", "expiration date"},
+		FieldOptionType:  {"option type", "put/call", "call/put"},
+		FieldMultiplier:  {"multiplier", "contract multiplier", "shares per contract"},
+	}
+
+	columns := NewCSVHeader(header)
+	mapping := ColumnMapping{}
+	for field, names := range aliases {
+		for _, name := range names {
+			if columns.Has(name) {
+				mapping[field] = name
+				break
+			}
+		}
+	}
+	return mapping
+}
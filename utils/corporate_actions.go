@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"backend/types"
+	"fmt"
+	"strings"
+)
+
+// corporateActionTypes maps the action names accepted in a corporate
+// actions CSV to their type.
+var corporateActionTypes = map[string]types.CorporateActionType{
+	"split":         types.Split,
+	"forward split": types.Split,
+	"reverse split": types.Split,
+	"ticker change": types.TickerChange,
+	"symbol change": types.TickerChange,
+	"cash merger":   types.CashMerger,
+	"merger":        types.CashMerger,
+}
+
+// ParseSplitRatio reads a split ratio written as new shares for old, such
+// as "4:1", "3-for-2" or "1 for 10".
+func ParseSplitRatio(ratio string) (types.Decimal, types.Decimal, error) {
+	normalized := strings.ToLower(strings.TrimSpace(ratio))
+	for _, separator := range []string{"-for-", " for ", "for", "/"} {
+		normalized = strings.ReplaceAll(normalized, separator, ":")
+	}
+
+	parts := strings.Split(normalized, ":")
+	if len(parts) != 2 {
+		return types.Decimal{}, types.Decimal{}, fmt.Errorf("invalid split ratio %q", ratio)
+	}
+	newShares, err1 := types.ParseDecimal(parts[0])
+	oldShares, err2 := types.ParseDecimal(parts[1])
+	if err1 != nil || err2 != nil || newShares.Sign() <= 0 || oldShares.Sign() <= 0 {
+		return types.Decimal{}, types.Decimal{}, fmt.Errorf("invalid split ratio %q", ratio)
+	}
+	return newShares, oldShares, nil
+}
+
+// ParseCorporateActionsCSV reads corporate actions from a CSV with the
+// columns Ticker, Type and Effective Date, plus Ratio for splits, New
+// Ticker for ticker changes and Cash Per Share for cash mergers. Rows that
+// can't be used are skipped with a reason.
+func ParseCorporateActionsCSV(csvContent string) ([]types.CorporateAction, []SkippedRow, error) {
+	rows := Parse(csvContent)
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("CSV is empty")
+	}
+
+	header := NewCSVHeader(rows[0])
+	if !header.Has("ticker", "type", "effective date") {
+		return nil, nil, fmt.Errorf("corporate actions CSV needs Ticker, Type and Effective Date columns")
+	}
+
+	var actions []types.CorporateAction
+	var skipped []SkippedRow
+	for i := 1; i < len(rows); i++ {
+		row := CSVRow{Fields: rows[i], Header: header}
+		if row.blank() {
+			continue
+		}
+
+		action, err := parseCorporateActionRow(row)
+		if err != nil {
+			skipped = append(skipped, SkippedRow{Row: i + 1, Reason: err.Error()})
+			continue
+		}
+		action.Row = i + 1
+		actions = append(actions, action)
+	}
+	return actions, skipped, nil
+}
+
+func parseCorporateActionRow(row CSVRow) (types.CorporateAction, error) {
+	action := types.CorporateAction{
+		Ticker:        strings.ToUpper(row.Get("ticker")),
+		EffectiveDate: row.Get("effective date"),
+		OldShares:     types.DecimalFromInt(1),
+		NewShares:     types.DecimalFromInt(1),
+	}
+	if action.Ticker == "" || action.EffectiveDate == "" {
+		return action, fmt.Errorf("missing ticker or effective date")
+	}
+
+	kind := strings.ToLower(strings.ReplaceAll(row.Get("type"), "_", " "))
+	actionType, ok := corporateActionTypes[kind]
+	if !ok {
+		return action, fmt.Errorf("unknown corporate action %q", row.Get("type"))
+	}
+	action.Type = actionType
+
+	switch actionType {
+	case types.Split:
+		newShares, oldShares, err := ParseSplitRatio(row.Get("ratio"))
+		if err != nil {
+			return action, err
+		}
+		action.NewShares, action.OldShares = newShares, oldShares
+	case types.TickerChange:
+		action.NewTicker = strings.ToUpper(row.Get("new ticker"))
+		if action.NewTicker == "" {
+			return action, fmt.Errorf("ticker change needs a new ticker")
+		}
+	case types.CashMerger:
+		cashPerShare, err := CleanCurrencyString(row.Get("cash per share"))
+		if err != nil {
+			return action, err
+		}
+		action.CashPerShare = cashPerShare
+		if action.CashPerShare.Sign() <= 0 {
+			return action, fmt.Errorf("cash merger needs a cash amount per share")
+		}
+	}
+	return action, nil
+}
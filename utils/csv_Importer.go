@@ -2,34 +2,58 @@ package utils
 
 import (
 	"backend/types"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 )
 
-func ParseFloat(input string) float64 {
-	ret, err := strconv.ParseFloat(input, 64)
+// ParseDecimal parses a number from an import. An empty value is zero, as
+// brokers leave out the price and amount of rows such as assignments.
+func ParseDecimal(input string) (types.Decimal, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return types.Decimal{}, nil
+	}
+	ret, err := types.ParseDecimal(input)
 	if err != nil {
-		fmt.Println("Error:", err)
+		return types.Decimal{}, fmt.Errorf("invalid number %q", input)
 	}
-	return ret
+	return ret, nil
 }
 
-func CleanCurrencyString(s string) float64 {
-	s = strings.Trim(s, "\"")
+// CleanCurrencyString parses an amount such as "$1,234.50", with
+// parentheses for a negative amount. An empty value is zero.
+func CleanCurrencyString(s string) (types.Decimal, error) {
+	s = strings.TrimSpace(strings.Trim(s, "\""))
 	s = strings.ReplaceAll(s, "$", "")
 	s = strings.ReplaceAll(s, ",", "")
 	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
 		s = "-" + strings.Trim(s, "()")
 	}
-	return ParseFloat(s)
+	return ParseDecimal(s)
+}
+
+// rowNumbers collects the numeric fields of one source row and keeps the
+// first that fails to parse, so the row is skipped with that reason instead
+// of being imported with a zero.
+type rowNumbers struct {
+	err error
 }
 
-func MakeStockTradeFromBrokerageFormat(tc types.TradeCode, data []string) types.StockTrade {
-	price := CleanCurrencyString(data[7])
-	amount := CleanCurrencyString(data[8])
-	quantity := ParseFloat(data[6])
+func (n *rowNumbers) keep(value types.Decimal, err error) types.Decimal {
+	if err != nil && n.err == nil {
+		n.err = err
+	}
+	return value
+}
+
+func MakeStockTradeFromBrokerageFormat(tc types.TradeCode, data []string) (types.StockTrade, error) {
+	var numbers rowNumbers
+	price := numbers.keep(CleanCurrencyString(data[7]))
+	amount := numbers.keep(CleanCurrencyString(data[8]))
+	quantity := numbers.keep(ParseDecimal(data[6]))
 	ticker := strings.TrimSpace(data[3])
 
 	return types.StockTrade{
@@ -39,13 +63,15 @@ func MakeStockTradeFromBrokerageFormat(tc types.TradeCode, data []string) types.
 		Price:    price,
 		Amount:   amount,
 		Quantity: quantity,
-	}
+	}, numbers.err
 }
 
-func MakeOptionTradeFromBrokerageFormat(tc types.TradeCode, data []string) types.OptionTrade {
-	price := CleanCurrencyString(data[7])
-	amount := CleanCurrencyString(data[8])
-	quantity := ParseFloat(data[6])
+func MakeOptionTradeFromBrokerageFormat(tc types.TradeCode, data []string) (types.OptionTrade, error) {
+	var numbers rowNumbers
+	price := numbers.keep(CleanCurrencyString(data[7]))
+	amount := numbers.keep(CleanCurrencyString(data[8]))
+	// Short contracts can be listed as "1S"
+	quantity := numbers.keep(ParseDecimal(strings.TrimSuffix(strings.TrimSpace(data[6]), "S")))
 	ticker := strings.TrimSpace(data[3])
 	description := ""
 	if len(data) > 4 {
@@ -60,19 +86,23 @@ func MakeOptionTradeFromBrokerageFormat(tc types.TradeCode, data []string) types
 		Amount:   amount,
 		Quantity: quantity,
 	}
+	if numbers.err != nil {
+		return trade, numbers.err
+	}
 
-	parseOptionDetailsFromDescription(&trade, description)
-
-	return trade
+	err := parseOptionDetailsFromDescription(&trade, description)
+	return trade, err
 }
 
-func parseOptionDetailsFromDescription(trade *types.OptionTrade, description string) {
+func parseOptionDetailsFromDescription(trade *types.OptionTrade, description string) error {
 	if description == "" {
 		trade.Premium = trade.Price
-		return
+		return nil
 	}
 
-	re := regexp.MustCompile(`^([A-Z]+)\s+(\d{1,2}/\d{1,2}/\d{4})\s+(Call|Put)\s+\$?([\d,.]+)`)
+	// Event rows read "Option Expiration for AAPL 10/24/2025 Put $240.00",
+	// so the contract isn't always at the start of the description
+	re := regexp.MustCompile(`([A-Z]+)\s+(\d{1,2}/\d{1,2}/\d{4})\s+(Call|Put)\s+\$?([\d,.]+)`)
 	matches := re.FindStringSubmatch(description)
 
 	if len(matches) >= 5 {
@@ -87,7 +117,11 @@ func parseOptionDetailsFromDescription(trade *types.OptionTrade, description str
 		}
 
 		strikeStr := strings.ReplaceAll(matches[4], ",", "")
-		trade.Strike = ParseFloat(strikeStr)
+		strike, err := ParseDecimal(strikeStr)
+		if err != nil {
+			return err
+		}
+		trade.Strike = strike
 
 		trade.Premium = trade.Price
 	} else {
@@ -100,71 +134,127 @@ func parseOptionDetailsFromDescription(trade *types.OptionTrade, description str
 			trade.OptionType = types.Put
 		}
 	}
+	return nil
+}
+
+// Fingerprinter identifies source rows so a re-imported row can be
+// recognised. Identical rows within one file are numbered so genuine
+// repeated fills still get distinct fingerprints.
+type Fingerprinter map[string]int
+
+func (f Fingerprinter) Fingerprint(fields ...string) string {
+	normalized := make([]string, len(fields))
+	for i, field := range fields {
+		normalized[i] = strings.TrimSpace(strings.Trim(field, "\""))
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(normalized, "|")))
+	fingerprint := hex.EncodeToString(sum[:])
+
+	f[fingerprint]++
+	if n := f[fingerprint]; n > 1 {
+		fingerprint = fmt.Sprintf("%s#%d", fingerprint, n)
+	}
+	return fingerprint
+}
+
+// brokerageRowFingerprint uses date, ticker, code, quantity, price, amount
+// and description.
+func brokerageRowFingerprint(f Fingerprinter, row []string) string {
+	return f.Fingerprint(row[0], row[3], row[5], row[6], row[7], row[8], row[4])
+}
+
+// SkippedRow is a source row the importer could not turn into a trade.
+type SkippedRow struct {
+	Row    int
+	Reason string
 }
 
 type ImportedTrades struct {
+	Format       string
 	StockTrades  []types.StockTrade
 	OptionTrades []types.OptionTrade
+	CashFlows    []types.CashFlow
+	Skipped      []SkippedRow
 }
 
-func ParseBrokerageCSV(csvContent string) (*ImportedTrades, error) {
-	rows := Parse(csvContent)
-
-	if len(rows) == 0 {
-		return nil, fmt.Errorf("CSV is empty")
-	}
-	if len(rows) < 2 {
-		return nil, fmt.Errorf("CSV only contains header row")
+// TransferType classifies a deposit or withdrawal by the sign of its amount.
+func TransferType(amount types.Decimal) types.CashFlowType {
+	if amount.Sign() < 0 {
+		return types.Withdrawal
 	}
+	return types.Deposit
+}
+
+// robinhoodCashCodes are the non-trade rows Robinhood reports. ACH transfers
+// are classified by the sign of their amount.
+var robinhoodCashCodes = map[string]types.CashFlowType{
+	"CDIV": types.Dividend,
+	"MDIV": types.Dividend,
+	"DTAX": types.Fee,
+	"INT":  types.Interest,
+	"SLIP": types.Interest,
+	"GOLD": types.Fee,
+	"AFEE": types.Fee,
+	"DFEE": types.Fee,
+	"ACH":  types.Deposit,
+}
+
+// robinhoodFormat reads the Robinhood activity export: Activity Date,
+// Process Date, Settle Date, Instrument, Description, Trans Code, Quantity,
+// Price, Amount.
+type robinhoodFormat struct{}
 
-	result := &ImportedTrades{
-		StockTrades:  []types.StockTrade{},
-		OptionTrades: []types.OptionTrade{},
+func (robinhoodFormat) Name() string { return "Robinhood" }
+
+func (robinhoodFormat) Detect(header CSVHeader) bool {
+	return header.Has("activity date", "instrument", "trans code")
+}
+
+func (robinhoodFormat) ParseRow(row CSVRow, fingerprints Fingerprinter) (ParsedRow, error) {
+	data := row.Fields
+	if len(data) < 9 {
+		return ParsedRow{}, fmt.Errorf("expected at least 9 columns")
 	}
+	ticker := strings.Trim(data[3], "\" ")
+	transCode := strings.Trim(data[5], "\" ")
 
-	for i := 1; i < len(rows); i++ {
-		row := rows[i]
-		if len(row) < 9 {
-			continue
-		}
-		ticker := strings.Trim(row[3], "\" ")
-		if ticker == "" {
-			continue
+	if cashType, ok := robinhoodCashCodes[transCode]; ok {
+		amount, err := CleanCurrencyString(data[8])
+		if err != nil {
+			return ParsedRow{}, err
 		}
-		transCode := strings.Trim(row[5], "\" ")
-		switch transCode {
-		case "Buy":
-			record := MakeStockTradeFromBrokerageFormat(types.Buy, row)
-			if record.Quantity > 0 {
-				result.StockTrades = append(result.StockTrades, record)
-			}
-		case "Sell":
-			record := MakeStockTradeFromBrokerageFormat(types.Sell, row)
-			if record.Quantity > 0 {
-				result.StockTrades = append(result.StockTrades, record)
-			}
-		case "BTC":
-			record := MakeOptionTradeFromBrokerageFormat(types.BTC, row)
-			if record.Quantity > 0 {
-				result.OptionTrades = append(result.OptionTrades, record)
-			}
-		case "BTO":
-			record := MakeOptionTradeFromBrokerageFormat(types.BTO, row)
-			if record.Quantity > 0 {
-				result.OptionTrades = append(result.OptionTrades, record)
-			}
-		case "STO":
-			record := MakeOptionTradeFromBrokerageFormat(types.STO, row)
-			if record.Quantity > 0 {
-				result.OptionTrades = append(result.OptionTrades, record)
-			}
-		case "STC":
-			record := MakeOptionTradeFromBrokerageFormat(types.STC, row)
-			if record.Quantity > 0 {
-				result.OptionTrades = append(result.OptionTrades, record)
-			}
+		if transCode == "ACH" {
+			cashType = TransferType(amount)
 		}
+		return ParsedRow{Cash: &types.CashFlow{
+			Date:        data[0],
+			Type:        cashType,
+			Ticker:      ticker,
+			Amount:      amount,
+			Description: strings.TrimSpace(data[4]),
+			Fingerprint: brokerageRowFingerprint(fingerprints, data),
+		}}, nil
 	}
 
-	return result, nil
+	if ticker == "" {
+		return ParsedRow{}, fmt.Errorf("no instrument")
+	}
+	switch transCode {
+	case "Buy", "Sell":
+		record, err := MakeStockTradeFromBrokerageFormat(types.TradeCode(transCode), data)
+		if err != nil {
+			return ParsedRow{}, err
+		}
+		record.Fingerprint = brokerageRowFingerprint(fingerprints, data)
+		return ParsedRow{Stock: &record}, nil
+	case "BTC", "BTO", "STO", "STC", "OEXP", "OASGN", "OEXCS":
+		record, err := MakeOptionTradeFromBrokerageFormat(types.TradeCode(transCode), data)
+		if err != nil {
+			return ParsedRow{}, err
+		}
+		record.Fingerprint = brokerageRowFingerprint(fingerprints, data)
+		return ParsedRow{Option: &record}, nil
+	}
+	return ParsedRow{}, fmt.Errorf("unsupported transaction code %q", transCode)
 }
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"backend/types"
+	"fmt"
+	"strings"
+)
+
+// ibkrFlexFormat reads the Trades section of an Interactive Brokers Flex
+// query exported as CSV. Columns are looked up by name, so the query can
+// include extra fields in any order.
+type ibkrFlexFormat struct{}
+
+func (ibkrFlexFormat) Name() string { return "Interactive Brokers Flex" }
+
+func (ibkrFlexFormat) Detect(header CSVHeader) bool {
+	return header.Has("assetclass", "symbol", "tradedate", "quantity", "tradeprice", "buy/sell")
+}
+
+// flexDate converts Flex dates such as "20251024" or "20251024;093000" to
+// ISO. Dates that are already delimited are returned unchanged.
+func flexDate(value string) string {
+	value, _, _ = strings.Cut(value, ";")
+	if len(value) == 8 && !strings.ContainsAny(value, "-/") {
+		return value[0:4] + "-" + value[4:6] + "-" + value[6:8]
+	}
+	return value
+}
+
+func (ibkrFlexFormat) ParseRow(row CSVRow, fingerprints Fingerprinter) (ParsedRow, error) {
+	if strings.EqualFold(row.Get("symbol"), "symbol") {
+		// Multi-account queries repeat the header for every section
+		return ParsedRow{}, nil
+	}
+
+	assetClass := row.Get("assetclass")
+	symbol := row.Get("symbol")
+	if symbol == "" {
+		return ParsedRow{}, fmt.Errorf("no symbol")
+	}
+
+	date := flexDate(row.Get("tradedate"))
+	var numbers rowNumbers
+	quantity := numbers.keep(ParseDecimal(row.Get("quantity"))).Abs()
+	price := numbers.keep(ParseDecimal(row.Get("tradeprice")))
+
+	amount := numbers.keep(ParseDecimal(row.Get("proceeds")))
+	if netCash := row.Get("netcash"); netCash != "" {
+		amount = numbers.keep(ParseDecimal(netCash))
+	}
+	// Commissions are reported as negative amounts
+	fees := numbers.keep(ParseDecimal(row.Get("ibcommission"))).Abs()
+	if numbers.err != nil {
+		return ParsedRow{}, numbers.err
+	}
+
+	var fingerprint string
+	if tradeID := row.Get("tradeid"); tradeID != "" {
+		fingerprint = fingerprints.Fingerprint("ibkr", tradeID)
+	} else {
+		fingerprint = fingerprints.Fingerprint("ibkr", row.Get("tradedate"), symbol, row.Get("buy/sell"), row.Get("quantity"), row.Get("tradeprice"), row.Get("proceeds"))
+	}
+
+	buy := strings.EqualFold(row.Get("buy/sell"), "BUY")
+	indicator := strings.ToUpper(row.Get("open/closeindicator"))
+
+	switch assetClass {
+	case "STK":
+		// Only a buy that just closes is a cover; "C;O" flips the
+		// position and is left to the ledger.
+		var code types.TradeCode
+		switch {
+		case buy && indicator == "C":
+			code = types.BuyToCover
+		case buy:
+			code = types.Buy
+		case indicator == "O":
+			code = types.SellShort
+		default:
+			code = types.Sell
+		}
+		return ParsedRow{Stock: &types.StockTrade{
+			Ticker:      symbol,
+			Date:        date,
+			Code:        code,
+			Price:       price,
+			Amount:      amount,
+			Quantity:    quantity,
+			Fees:        fees,
+			Fingerprint: fingerprint,
+		}}, nil
+
+	case "OPT":
+		var code types.TradeCode
+		opening := strings.HasPrefix(indicator, "O")
+		switch {
+		case buy && opening:
+			code = types.BTO
+		case buy:
+			code = types.BTC
+		case opening:
+			code = types.STO
+		default:
+			code = types.STC
+		}
+
+		optionType := types.Call
+		if strings.EqualFold(row.Get("put/call"), "P") {
+			optionType = types.Put
+		}
+
+		ticker := row.Get("underlyingsymbol")
+		if ticker == "" {
+			ticker = strings.Fields(symbol)[0]
+		}
+
+		strike := numbers.keep(ParseDecimal(row.Get("strike")))
+		multiplier := numbers.keep(ParseDecimal(row.Get("multiplier")))
+		if numbers.err != nil {
+			return ParsedRow{}, numbers.err
+		}
+
+		return ParsedRow{Option: &types.OptionTrade{
+			Ticker:      ticker,
+			Date:        date,
+			Code:        code,
+			Price:       price,
+			Amount:      amount,
+			Quantity:    quantity,
+			Strike:      strike,
+			ExpDate:     flexDate(row.Get("expiry")),
+			OptionType:  optionType,
+			Premium:     price,
+			Multiplier:  multiplier,
+			Fees:        fees,
+			Fingerprint: fingerprint,
+		}}, nil
+	}
+
+	return ParsedRow{}, fmt.Errorf("unsupported asset class %q", assetClass)
+}
@@ -0,0 +1,409 @@
+package utils
+
+import (
+	"backend/types"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ofxNode is an element of an OFX document. SGML statements (OFX 1.x) leave
+// leaf elements unclosed, so a node either has a Value or Children.
+type ofxNode struct {
+	Name     string
+	Value    string
+	Children []*ofxNode
+}
+
+// Find returns the first descendant with the given name.
+func (n *ofxNode) Find(name string) *ofxNode {
+	if n == nil {
+		return nil
+	}
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+		if found := child.Find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the first descendant with the given name.
+func (n *ofxNode) Get(name string) string {
+	if found := n.Find(name); found != nil {
+		return found.Value
+	}
+	return ""
+}
+
+// Number parses an optional numeric element, treating a missing one as zero.
+func (n *ofxNode) Number(name string) (types.Decimal, error) {
+	value := n.Get(name)
+	number, err := ParseDecimal(value)
+	if err != nil {
+		return types.Decimal{}, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return number, nil
+}
+
+// IsOFX reports whether an upload is an OFX or QFX statement rather than a
+// CSV.
+func IsOFX(content string) bool {
+	head := strings.ToUpper(content)
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	return strings.Contains(head, "OFXHEADER") || strings.Contains(head, "<OFX>")
+}
+
+var ofxTag = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)[^>]*>`)
+
+// parseOFXDocument builds the element tree for both the SGML and XML
+// variants. A tag followed by text is a leaf; a closing tag pops back to
+// the matching open element, which tolerates SGML's missing end tags.
+func parseOFXDocument(content string) (*ofxNode, error) {
+	start := strings.Index(strings.ToUpper(content), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("no <OFX> element found")
+	}
+	content = content[start:]
+
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	matches := ofxTag.FindAllStringSubmatchIndex(content, -1)
+
+	for i, match := range matches {
+		closing := content[match[2]:match[3]] == "/"
+		name := strings.ToUpper(content[match[4]:match[5]])
+
+		if closing {
+			for j := len(stack) - 1; j > 0; j-- {
+				if stack[j].Name == name {
+					stack = stack[:j]
+					break
+				}
+			}
+			continue
+		}
+
+		textEnd := len(content)
+		if i+1 < len(matches) {
+			textEnd = matches[i+1][0]
+		}
+		text := strings.TrimSpace(content[match[1]:textEnd])
+
+		node := &ofxNode{Name: name}
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, node)
+
+		if text != "" {
+			node.Value = unescapeOFX(text)
+		} else {
+			stack = append(stack, node)
+		}
+	}
+
+	ofx := root.Find("OFX")
+	if ofx == nil {
+		return nil, fmt.Errorf("no <OFX> element found")
+	}
+	return ofx, nil
+}
+
+func unescapeOFX(value string) string {
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&", "&nbsp;", " ").Replace(value)
+}
+
+// ofxDate converts "20251001120000.000[-5:EST]" to ISO.
+func ofxDate(value string) string {
+	if len(value) < 8 {
+		return value
+	}
+	return value[0:4] + "-" + value[4:6] + "-" + value[6:8]
+}
+
+// ofxSecurity is an entry of the statement's SECLIST.
+type ofxSecurity struct {
+	Ticker     string
+	Underlying string
+	OptionType types.OptionType
+	Strike     types.Decimal
+	Expiry     string
+	Multiplier types.Decimal
+}
+
+// occSymbol matches OCC option symbols such as "AAPL  251024P00240000".
+var occSymbol = regexp.MustCompile(`^([A-Z.]+)\s*(\d{6})([CP])(\d{8})$`)
+
+func ofxSecurities(ofx *ofxNode) (map[string]ofxSecurity, error) {
+	securities := map[string]ofxSecurity{}
+	list := ofx.Find("SECLIST")
+	if list == nil {
+		return securities, nil
+	}
+
+	for _, info := range list.Children {
+		secInfo := info.Find("SECINFO")
+		if secInfo == nil {
+			continue
+		}
+		security := ofxSecurity{Ticker: strings.TrimSpace(secInfo.Get("TICKER"))}
+
+		if info.Name == "OPTINFO" {
+			switch strings.ToUpper(info.Get("OPTTYPE")) {
+			case "PUT":
+				security.OptionType = types.Put
+			case "CALL":
+				security.OptionType = types.Call
+			}
+			var numbers rowNumbers
+			security.Strike = numbers.keep(info.Number("STRIKEPRICE"))
+			security.Expiry = ofxDate(info.Get("DTEXPIRE"))
+			security.Multiplier = numbers.keep(info.Number("SHPERCTRCT"))
+			if numbers.err != nil {
+				return nil, fmt.Errorf("security %s: %w", security.Ticker, numbers.err)
+			}
+
+			// The underlying is the SECID that follows SECINFO, if any
+			for _, child := range info.Children {
+				if child.Name == "SECID" {
+					security.Underlying = child.Get("UNIQUEID")
+				}
+			}
+			if m := occSymbol.FindStringSubmatch(security.Ticker); m != nil {
+				security.Underlying = m[1]
+			}
+		}
+
+		securities[secInfo.Find("SECID").Get("UNIQUEID")] = security
+	}
+
+	// Replace CUSIP references to an underlying with its ticker
+	for id, security := range securities {
+		if underlying, ok := securities[security.Underlying]; ok {
+			security.Underlying = underlying.Ticker
+			securities[id] = security
+		}
+	}
+	return securities, nil
+}
+
+var ofxOptionCodes = map[string]types.TradeCode{
+	"BUYTOOPEN":   types.BTO,
+	"BUYTOCLOSE":  types.BTC,
+	"SELLTOOPEN":  types.STO,
+	"SELLTOCLOSE": types.STC,
+}
+
+// ParseOFX reads the investment transactions of an OFX or QFX statement.
+// Transactions are numbered in statement order in place of row numbers.
+func ParseOFX(content string) (*ImportedTrades, error) {
+	ofx, err := parseOFXDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	list := ofx.Find("INVTRANLIST")
+	if list == nil {
+		return nil, fmt.Errorf("statement has no investment transactions")
+	}
+
+	securities, err := ofxSecurities(ofx)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := Fingerprinter{}
+	result := &ImportedTrades{
+		Format:       "OFX",
+		StockTrades:  []types.StockTrade{},
+		OptionTrades: []types.OptionTrade{},
+	}
+
+	transactions := 0
+	for _, txn := range list.Children {
+		if txn.Value != "" {
+			// DTSTART and DTEND
+			continue
+		}
+		transactions++
+
+		parsed, err := parseOFXTransaction(txn, securities, fingerprints)
+		if err != nil {
+			result.Skipped = append(result.Skipped, SkippedRow{Row: transactions, Reason: err.Error()})
+			continue
+		}
+		result.add(parsed, transactions)
+	}
+
+	return result, nil
+}
+
+func parseOFXTransaction(txn *ofxNode, securities map[string]ofxSecurity, fingerprints Fingerprinter) (ParsedRow, error) {
+	if txn.Name == "INVBANKTRAN" {
+		return parseOFXBankTransaction(txn.Find("STMTTRN"), fingerprints)
+	}
+
+	fitID := txn.Get("FITID")
+	date := ofxDate(txn.Get("DTTRADE"))
+	securityID := txn.Find("SECID").Get("UNIQUEID")
+	security, known := securities[securityID]
+	var numbers rowNumbers
+	units := numbers.keep(txn.Number("UNITS"))
+	quantity := units.Abs()
+	price := numbers.keep(txn.Number("UNITPRICE"))
+	amount := numbers.keep(txn.Number("TOTAL"))
+	fees := numbers.keep(txn.Number("COMMISSION")).Add(numbers.keep(txn.Number("FEES"))).Abs()
+	multiplier := numbers.keep(txn.Number("SHPERCTRCT"))
+	if numbers.err != nil {
+		return ParsedRow{}, numbers.err
+	}
+
+	var fingerprint string
+	if fitID != "" {
+		fingerprint = fingerprints.Fingerprint("ofx", fitID)
+	} else {
+		fingerprint = fingerprints.Fingerprint("ofx", txn.Name, date, securityID, txn.Get("UNITS"), txn.Get("UNITPRICE"), txn.Get("TOTAL"))
+	}
+
+	switch txn.Name {
+	case "INCOME", "INVEXPENSE":
+		cashType := types.Fee
+		if txn.Name == "INCOME" {
+			cashType = types.Dividend
+			if strings.EqualFold(txn.Get("INCOMETYPE"), "INTEREST") {
+				cashType = types.Interest
+			}
+		} else {
+			amount = amount.Abs().Neg()
+		}
+		return ParsedRow{Cash: &types.CashFlow{
+			Date:        date,
+			Type:        cashType,
+			Ticker:      security.Ticker,
+			Amount:      amount,
+			Description: txn.Get("MEMO"),
+			Fingerprint: fingerprint,
+		}}, nil
+
+	case "BUYSTOCK", "SELLSTOCK":
+		if !known || security.Ticker == "" {
+			return ParsedRow{}, fmt.Errorf("unknown security %s", securityID)
+		}
+		code := types.Buy
+		switch {
+		case txn.Get("BUYTYPE") == "BUYTOCOVER":
+			code = types.BuyToCover
+		case txn.Get("SELLTYPE") == "SELLSHORT":
+			code = types.SellShort
+		case txn.Name == "SELLSTOCK":
+			code = types.Sell
+		}
+		return ParsedRow{Stock: &types.StockTrade{
+			Ticker:      security.Ticker,
+			Date:        date,
+			Code:        code,
+			Price:       price,
+			Amount:      amount,
+			Quantity:    quantity,
+			Fees:        fees,
+			Fingerprint: fingerprint,
+		}}, nil
+
+	case "BUYOPT", "SELLOPT", "CLOSUREOPT":
+		if !known || security.Underlying == "" || security.OptionType == "" {
+			return ParsedRow{}, fmt.Errorf("unknown option %s", securityID)
+		}
+
+		var code types.TradeCode
+		if txn.Name == "CLOSUREOPT" {
+			// OFX reports the shares from an assignment or exercise as a
+			// separate stock trade, so only expiries are imported as events
+			// and other closures just close the contracts at zero.
+			price, amount = types.Decimal{}, types.Decimal{}
+			switch {
+			case strings.EqualFold(txn.Get("OPTACTION"), "EXPIRE"):
+				code = types.OEXP
+			case units.Sign() > 0:
+				code = types.BTC
+			default:
+				code = types.STC
+			}
+		} else {
+			action := txn.Get("OPTBUYTYPE") + txn.Get("OPTSELLTYPE")
+			var ok bool
+			if code, ok = ofxOptionCodes[strings.ToUpper(action)]; !ok {
+				return ParsedRow{}, fmt.Errorf("unsupported option action %q", action)
+			}
+		}
+
+		// Transactions may carry their own shares per contract
+		if multiplier.IsZero() {
+			multiplier = security.Multiplier
+		}
+
+		return ParsedRow{Option: &types.OptionTrade{
+			Ticker:      security.Underlying,
+			Date:        date,
+			Code:        code,
+			Price:       price,
+			Amount:      amount,
+			Quantity:    quantity,
+			Strike:      security.Strike,
+			ExpDate:     security.Expiry,
+			OptionType:  security.OptionType,
+			Premium:     price,
+			Multiplier:  multiplier,
+			Fees:        fees,
+			Fingerprint: fingerprint,
+		}}, nil
+	}
+
+	return ParsedRow{}, fmt.Errorf("unsupported transaction %s", txn.Name)
+}
+
+// ofxBankTypes classifies the cash side of an investment account. Other
+// transaction types are transfers in or out.
+var ofxBankTypes = map[string]types.CashFlowType{
+	"INT":    types.Interest,
+	"DIV":    types.Dividend,
+	"FEE":    types.Fee,
+	"SRVCHG": types.Fee,
+}
+
+func parseOFXBankTransaction(txn *ofxNode, fingerprints Fingerprinter) (ParsedRow, error) {
+	if txn == nil {
+		return ParsedRow{}, fmt.Errorf("bank transaction has no details")
+	}
+
+	amount, err := txn.Number("TRNAMT")
+	if err != nil {
+		return ParsedRow{}, err
+	}
+	cashType, ok := ofxBankTypes[strings.ToUpper(txn.Get("TRNTYPE"))]
+	if !ok {
+		cashType = TransferType(amount)
+	}
+
+	description := txn.Get("NAME")
+	if memo := txn.Get("MEMO"); memo != "" {
+		description = strings.TrimSpace(description + " " + memo)
+	}
+
+	var fingerprint string
+	if fitID := txn.Get("FITID"); fitID != "" {
+		fingerprint = fingerprints.Fingerprint("ofx", fitID)
+	} else {
+		fingerprint = fingerprints.Fingerprint("ofx", "INVBANKTRAN", txn.Get("DTPOSTED"), txn.Get("TRNAMT"), description)
+	}
+
+	return ParsedRow{Cash: &types.CashFlow{
+		Date:        ofxDate(txn.Get("DTPOSTED")),
+		Type:        cashType,
+		Amount:      amount,
+		Description: description,
+		Fingerprint: fingerprint,
+	}}, nil
+}
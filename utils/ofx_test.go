@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"backend/types"
+	"strings"
+	"testing"
+)
+
+func TestIsOFX(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"SGML statement", readFixture(t, "ofx/statement.ofx"), true},
+		{"XML statement", readFixture(t, "ofx/statement.qfx"), true},
+		{"bare OFX element", "<ofx><signonmsgsrsv1>", true},
+		{"broker CSV", readFixture(t, "brokers/robinhood.csv"), false},
+		{"header past the first kilobyte", strings.Repeat(" ", 2048) + "<OFX>", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsOFX(tt.content); got != tt.want {
+				t.Errorf("IsOFX = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOFXFixtures(t *testing.T) {
+	tests := []struct {
+		file        string
+		trades      []wantTrade
+		multipliers map[int]string
+		cash        []wantCash
+		skipped     []wantSkipped
+	}{
+		{
+			file: "ofx/statement.ofx",
+			trades: []wantTrade{
+				{1, types.Buy, "AAPL", "", "10", "200", "1", ""},
+				{3, types.Sell, "AAPL", "", "5", "250", "1", ""},
+				// The put is named by its OCC symbol.
+				{2, types.STO, "AAPL", "240 Put 2025-10-24", "1", "2", "0.65", ""},
+				{4, types.BTC, "AAPL", "240 Put 2025-10-24", "1", "0.5", "0.65", ""},
+				// The call's underlying is only given by CUSIP.
+				{5, types.BTO, "MSFT", "500 Call 2025-11-21", "2", "3", "1.3", ""},
+				{6, types.OEXP, "MSFT", "500 Call 2025-11-21", "2", "0", "0", ""},
+			},
+			multipliers: map[int]string{2: "100", 4: "100", 5: "100", 6: "100"},
+			cash: []wantCash{
+				{7, types.Deposit, "5000"},
+				{8, types.Interest, "1.25"},
+			},
+		},
+		{
+			file: "ofx/statement.qfx",
+			trades: []wantTrade{
+				{1, types.Buy, "SPY", "", "100", "500", "0.01", ""},
+				{3, types.SellShort, "TSLA", "", "5", "320", "0", ""},
+				{4, types.BuyToCover, "TSLA", "", "5", "300", "1", ""},
+				{2, types.STO, "SPY", "520 Call 2025-12-19", "1", "4.5", "0.5", ""},
+				// Assignments close the contracts at zero; the shares come
+				// as their own stock trade.
+				{5, types.BTC, "SPY", "520 Call 2025-12-19", "1", "0", "0", ""},
+			},
+			multipliers: map[int]string{2: "10", 5: "10"},
+			cash: []wantCash{
+				{7, types.Withdrawal, "-200"},
+				{8, types.Fee, "-15"},
+			},
+			skipped: []wantSkipped{{6, "unsupported transaction REINVEST"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			content := readFixture(t, tt.file)
+			result, err := ParseOFX(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Format != "OFX" {
+				t.Errorf("format = %q, want OFX", result.Format)
+			}
+
+			trades := parsedTrades(result)
+			if len(trades) != len(tt.trades) {
+				t.Fatalf("parsed %d trades, want %d: %+v", len(trades), len(tt.trades), trades)
+			}
+			seen := map[string]bool{}
+			for i, want := range tt.trades {
+				got := trades[i]
+				if got.fingerprint == "" || seen[got.fingerprint] {
+					t.Errorf("trade %d fingerprint %q is missing or repeated", i, got.fingerprint)
+				}
+				seen[got.fingerprint] = true
+
+				got.fingerprint = ""
+				if got != want {
+					t.Errorf("trade %d:\n got %+v\nwant %+v", i, got, want)
+				}
+			}
+			for _, trade := range result.OptionTrades {
+				if got, want := trade.Multiplier.String(), tt.multipliers[trade.Row]; got != want {
+					t.Errorf("transaction %d multiplier = %s, want %s", trade.Row, got, want)
+				}
+			}
+
+			if len(result.CashFlows) != len(tt.cash) {
+				t.Fatalf("parsed %d cash flows, want %d: %+v", len(result.CashFlows), len(tt.cash), result.CashFlows)
+			}
+			for i, want := range tt.cash {
+				got := result.CashFlows[i]
+				if got.Row != want.row || got.Type != want.cashType || got.Amount.String() != want.amount {
+					t.Errorf("cash flow %d = row %d %s %s, want row %d %s %s", i, got.Row, got.Type, got.Amount, want.row, want.cashType, want.amount)
+				}
+			}
+
+			if len(result.Skipped) != len(tt.skipped) {
+				t.Fatalf("skipped %+v, want %+v", result.Skipped, tt.skipped)
+			}
+			for i, want := range tt.skipped {
+				got := result.Skipped[i]
+				if got.Row != want.row || !strings.Contains(got.Reason, want.reason) {
+					t.Errorf("skipped %d = %+v, want row %d %q", i, got, want.row, want.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestOFXSecurities(t *testing.T) {
+	ofx, err := parseOFXDocument(readFixture(t, "ofx/statement.ofx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	securities, err := ofxSecurities(ofx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id   string
+		want ofxSecurity
+	}{
+		{"037833100", ofxSecurity{Ticker: "AAPL"}},
+		{"AAPL251024P240", ofxSecurity{
+			Ticker: "AAPL  251024P00240000", Underlying: "AAPL", OptionType: types.Put,
+			Strike: types.DecimalFromInt(240), Expiry: "2025-10-24", Multiplier: types.DecimalFromInt(100),
+		}},
+		{"MSFT251121C500", ofxSecurity{
+			Ticker: "MSFT NOV 21 2025 500 C", Underlying: "MSFT", OptionType: types.Call,
+			Strike: types.DecimalFromInt(500), Expiry: "2025-11-21", Multiplier: types.DecimalFromInt(100),
+		}},
+	}
+	for _, tt := range tests {
+		if got := securities[tt.id]; got != tt.want {
+			t.Errorf("security %s = %+v, want %+v", tt.id, got, tt.want)
+		}
+	}
+}
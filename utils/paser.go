@@ -1,7 +1,7 @@
 package utils
 
 import (
-	"fmt"
+	"backend/types"
 	"os"
 	"strings"
 )
@@ -110,21 +110,10 @@ func Parse(input string) [][]string {
 	return result
 }
 
-func parseStrikePrice(s string) (float64, error) {
+func parseStrikePrice(s string) (types.Decimal, error) {
 	s = strings.TrimSpace(s)
 	s = strings.TrimPrefix(s, "$")
 	s = strings.ReplaceAll(s, ",", "")
 
-	if value, err := parseFloat64(s); err == nil {
-		return value, nil
-	} else {
-		return 0, err
-	}
-}
-
-func parseFloat64(s string) (float64, error) {
-	s = strings.TrimSpace(s)
-	var result float64
-	_, err := fmt.Sscanf(s, "%f", &result)
-	return result, err
+	return types.ParseDecimal(s)
 }
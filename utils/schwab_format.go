@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"backend/types"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// schwabFormat reads the Schwab transaction history export: Date, Action,
+// Symbol, Description, Quantity, Price, Fees & Comm, Amount. Option symbols
+// look like "AAPL 10/24/2025 240.00 P".
+type schwabFormat struct{}
+
+var schwabOptionSymbol = regexp.MustCompile(`^([A-Z.]+)\s+(\d{1,2}/\d{1,2}/\d{4})\s+([\d,.]+)\s+([CP])$`)
+
+var schwabStockActions = map[string]types.TradeCode{
+	"buy":          types.Buy,
+	"sell":         types.Sell,
+	"sell short":   types.SellShort,
+	"buy to cover": types.BuyToCover,
+}
+
+var schwabOptionActions = map[string]types.TradeCode{
+	"buy to open":          types.BTO,
+	"buy to close":         types.BTC,
+	"sell to open":         types.STO,
+	"sell to close":        types.STC,
+	"expired":              types.OEXP,
+	"assigned":             types.OASGN,
+	"exchange or exercise": types.OEXCS,
+}
+
+// schwabCashActions are classified by amount when listed as a deposit,
+// since Schwab uses the same action for money in and out.
+var schwabCashActions = map[string]types.CashFlowType{
+	"cash dividend":      types.Dividend,
+	"qualified dividend": types.Dividend,
+	"non-qualified div":  types.Dividend,
+	"special dividend":   types.Dividend,
+	"credit interest":    types.Interest,
+	"bank interest":      types.Interest,
+	"margin interest":    types.Interest,
+	"adr mgmt fee":       types.Fee,
+	"service fee":        types.Fee,
+	"foreign tax paid":   types.Fee,
+	"moneylink transfer": types.Deposit,
+	"moneylink deposit":  types.Deposit,
+	"wire received":      types.Deposit,
+	"wire sent":          types.Deposit,
+	"funds received":     types.Deposit,
+	"journal":            types.Deposit,
+}
+
+func (schwabFormat) Name() string { return "Schwab" }
+
+func (schwabFormat) Detect(header CSVHeader) bool {
+	return header.Has("date", "action", "symbol", "quantity", "price", "amount")
+}
+
+func (schwabFormat) ParseRow(row CSVRow, fingerprints Fingerprinter) (ParsedRow, error) {
+	dateFields := strings.Fields(row.Get("date"))
+	if len(dateFields) == 0 || strings.EqualFold(row.Get("date"), "Transactions Total") {
+		return ParsedRow{}, nil
+	}
+	// Backdated rows read "10/15/2025 as of 10/14/2025"
+	date := dateFields[0]
+
+	action := row.Get("action")
+	symbol := row.Get("symbol")
+	var numbers rowNumbers
+	quantity := numbers.keep(CleanCurrencyString(row.Get("quantity"))).Abs()
+	price := numbers.keep(CleanCurrencyString(row.Get("price")))
+	amount := numbers.keep(CleanCurrencyString(row.Get("amount")))
+	fees := numbers.keep(CleanCurrencyString(row.Get("fees & comm"))).Abs()
+
+	stockCode, isStock := schwabStockActions[strings.ToLower(action)]
+	optionCode, isOption := schwabOptionActions[strings.ToLower(action)]
+	cashType, isCash := schwabCashActions[strings.ToLower(action)]
+	if !isStock && !isOption && !isCash {
+		return ParsedRow{}, fmt.Errorf("unsupported action %q", action)
+	}
+	if numbers.err != nil {
+		return ParsedRow{}, numbers.err
+	}
+
+	fingerprint := fingerprints.Fingerprint("schwab", row.Get("date"), action, symbol, row.Get("quantity"), row.Get("price"), row.Get("amount"), row.Get("description"))
+
+	if isCash {
+		if cashType == types.Deposit {
+			cashType = TransferType(amount)
+		}
+		return ParsedRow{Cash: &types.CashFlow{
+			Date:        date,
+			Type:        cashType,
+			Ticker:      symbol,
+			Amount:      amount,
+			Description: row.Get("description"),
+			Fingerprint: fingerprint,
+		}}, nil
+	}
+
+	if symbol == "" {
+		return ParsedRow{}, fmt.Errorf("no symbol")
+	}
+
+	if isStock {
+		return ParsedRow{Stock: &types.StockTrade{
+			Ticker:      symbol,
+			Date:        date,
+			Code:        stockCode,
+			Price:       price,
+			Amount:      amount,
+			Quantity:    quantity,
+			Fees:        fees,
+			Fingerprint: fingerprint,
+		}}, nil
+	}
+
+	matches := schwabOptionSymbol.FindStringSubmatch(symbol)
+	if matches == nil {
+		return ParsedRow{}, fmt.Errorf("unrecognized option symbol %q", symbol)
+	}
+
+	optionType := types.Call
+	if matches[4] == "P" {
+		optionType = types.Put
+	}
+	strike, err := CleanCurrencyString(matches[3])
+	if err != nil {
+		return ParsedRow{}, err
+	}
+
+	return ParsedRow{Option: &types.OptionTrade{
+		Ticker:      matches[1],
+		Date:        date,
+		Code:        optionCode,
+		Price:       price,
+		Amount:      amount,
+		Quantity:    quantity,
+		Strike:      strike,
+		ExpDate:     matches[2],
+		OptionType:  optionType,
+		Premium:     price,
+		Fees:        fees,
+		Fingerprint: fingerprint,
+	}}, nil
+}
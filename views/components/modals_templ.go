@@ -8,6 +8,11 @@ package components
 import "github.com/a-h/templ"
 import templruntime "github.com/a-h/templ/runtime"
 
+import (
+	"backend/types"
+	"strings"
+)
+
 func ModalClose() templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
@@ -33,7 +38,7 @@ func ModalClose() templ.Component {
 	})
 }
 
-func AddPositionModal() templ.Component {
+func AddPositionModal(accounts []types.Account, account int) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -54,7 +59,19 @@ func AddPositionModal() templ.Component {
 			templ_7745c5c3_Var2 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div id=\"positionModal\" class=\"modal\"><div class=\"modal-content\"><div class=\"modal-header\"><h3>Add Position</h3><button class=\"close-btn\" hx-get=\"/modal/close\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\">&times;</button></div><form id=\"positionForm\" class=\"modal-form\" hx-post=\"/api/positions/add\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\"><div class=\"form-group\"><label>Position Type</label> <select id=\"positionType\" name=\"positionType\" required hx-get=\"/modal/add-position-fields.html\" hx-target=\"#conditional-fields\" hx-swap=\"innerHTML\" hx-trigger=\"load, change\"><option value=\"stock\">Stock</option> <option value=\"option\">Option</option></select></div><div class=\"form-group\"><label>Ticker</label> <input type=\"text\" id=\"ticker\" name=\"ticker\" required placeholder=\"e.g., AAPL\" style=\"text-transform: uppercase\" oninput=\"this.value = this.value.toUpperCase()\"></div><div id=\"conditional-fields\"></div><div class=\"form-group\"><label>Open Date</label> <input type=\"date\" id=\"openDate\" name=\"openDate\"></div><div class=\"form-actions\"><button type=\"submit\" class=\"btn btn-primary\">Add Position</button> <button type=\"button\" class=\"btn btn-secondary\" hx-get=\"/modal/close\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\">Cancel</button></div></form></div></div>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div id=\"positionModal\" class=\"modal\"><div class=\"modal-content\"><div class=\"modal-header\"><h3>Add Position</h3><button class=\"close-btn\" hx-get=\"/modal/close\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\">&times;</button></div><form id=\"positionForm\" class=\"modal-form\" hx-post=\"/api/positions/add\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\"><div class=\"form-group\"><label>Position Type</label> <select id=\"positionType\" name=\"positionType\" required hx-get=\"/modal/add-position-fields.html\" hx-target=\"#conditional-fields\" hx-swap=\"innerHTML\" hx-trigger=\"load, change\"><option value=\"stock\">Stock</option> <option value=\"option\">Option</option> <option value=\"strategy\">Option Strategy</option></select></div><div class=\"form-group\"><label>Ticker</label> <input type=\"text\" id=\"ticker\" name=\"ticker\" required placeholder=\"e.g., AAPL\" style=\"text-transform: uppercase\" oninput=\"this.value = this.value.toUpperCase()\"></div><div id=\"conditional-fields\"></div><div class=\"form-group\"><label>Open Date</label> <input type=\"date\" id=\"openDate\" name=\"openDate\"></div>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = AccountSelect(accounts, account).Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = CapitalCheck(CapitalCheckData{}).Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "<div class=\"form-actions\"><button type=\"submit\" class=\"btn btn-primary\">Add Position</button> <button type=\"button\" class=\"btn btn-secondary\" hx-get=\"/modal/close\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\">Cancel</button></div></form></div></div>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -83,7 +100,11 @@ func AddPositionStockFields() templ.Component {
 			templ_7745c5c3_Var3 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "<div class=\"form-group\"><label>Quantity</label> <input type=\"number\" id=\"quantity\" name=\"quantity\" required step=\"0.01\" placeholder=\"100\"></div><div class=\"form-group\"><label>Cost Basis / Price</label> <input type=\"number\" id=\"costBasis\" name=\"costBasis\" required step=\"0.01\" placeholder=\"150.00\"></div>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "<div class=\"form-group\"><label>Side</label> <select id=\"side\" name=\"side\" required><option value=\"long\">Long (buy)</option> <option value=\"short\">Short (sell short)</option></select></div><div class=\"form-group\"><label>Quantity</label> <input type=\"number\" id=\"quantity\" name=\"quantity\" required step=\"0.01\" placeholder=\"100\"></div><div class=\"form-group\"><label>Cost Basis / Sale Price</label> <input type=\"number\" id=\"costBasis\" name=\"costBasis\" required step=\"0.01\" placeholder=\"150.00\"></div>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = FeesField().Render(ctx, templ_7745c5c3_Buffer)
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -91,7 +112,8 @@ func AddPositionStockFields() templ.Component {
 	})
 }
 
-func AddPositionOptionFields() templ.Component {
+// FeesField is the commission and fees paid on a manually entered trade.
+func FeesField() templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -112,7 +134,7 @@ func AddPositionOptionFields() templ.Component {
 			templ_7745c5c3_Var4 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "<div class=\"form-group\"><label>Option Type</label> <select id=\"optionType\" name=\"optionType\" required><option value=\"Call\">Call</option> <option value=\"Put\">Put</option> <option value=\"CSP\">Cash Secured Put (CSP)</option> <option value=\"CC\">Covered Call (CC)</option></select></div><div class=\"form-group\"><label>Strike Price</label> <input type=\"number\" id=\"strike\" name=\"strike\" step=\"0.01\" placeholder=\"155.00\" required></div><div class=\"form-group\"><label>Premium (per contract)</label> <input type=\"number\" id=\"premium\" name=\"premium\" step=\"0.01\" placeholder=\"5.00\" required></div><div class=\"form-group\"><label>Number of Contracts</label> <input type=\"number\" id=\"quantity\" name=\"quantity\" step=\"1\" placeholder=\"1\" value=\"1\" required></div><div class=\"form-group\"><label>Expiration Date</label> <input type=\"date\" id=\"expDate\" name=\"expDate\" required></div>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "<div class=\"form-group\"><label>Fees &amp; Commissions</label> <input type=\"number\" id=\"fees\" name=\"fees\" step=\"0.01\" min=\"0\" placeholder=\"0.00\"></div>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -120,7 +142,9 @@ func AddPositionOptionFields() templ.Component {
 	})
 }
 
-func ImportCSVModal() templ.Component {
+// MultiplierField is the shares each contract delivers, left at 100 for
+// standard contracts.
+func MultiplierField() templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -141,7 +165,98 @@ func ImportCSVModal() templ.Component {
 			templ_7745c5c3_Var5 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "<div class=\"modal\"><div class=\"modal-content\"><div class=\"modal-header\"><h3>Import Trades from CSV</h3><button class=\"close-btn\" hx-get=\"/modal/close\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\">&times;</button></div><form hx-post=\"/api/import-csv\" hx-encoding=\"multipart/form-data\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\"><div class=\"form-group\"><label>CSV File</label> <input type=\"file\" name=\"csvFile\" accept=\".csv\" required><p style=\"font-size: 0.875rem; color: var(--text-secondary); margin-top: 0.5rem;\">Upload your brokerage CSV file with trade history</p></div><div class=\"form-actions\"><button type=\"submit\" class=\"btn btn-primary\">Import</button> <button type=\"button\" class=\"btn btn-secondary\" hx-get=\"/modal/close\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\">Cancel</button></div></form></div></div>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "<div class=\"form-group\"><label>Contract Multiplier</label> <input type=\"number\" id=\"multiplier\" name=\"multiplier\" step=\"any\" min=\"0\" placeholder=\"100\" value=\"100\"></div>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		return nil
+	})
+}
+
+func AddPositionOptionFields() templ.Component {
+	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
+		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
+		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
+			return templ_7745c5c3_CtxErr
+		}
+		templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
+		if !templ_7745c5c3_IsBuffer {
+			defer func() {
+				templ_7745c5c3_BufErr := templruntime.ReleaseBuffer(templ_7745c5c3_Buffer)
+				if templ_7745c5c3_Err == nil {
+					templ_7745c5c3_Err = templ_7745c5c3_BufErr
+				}
+			}()
+		}
+		ctx = templ.InitializeContext(ctx)
+		templ_7745c5c3_Var6 := templ.GetChildren(ctx)
+		if templ_7745c5c3_Var6 == nil {
+			templ_7745c5c3_Var6 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "<div class=\"form-group\"><label>Option Type</label> <select id=\"optionType\" name=\"optionType\" required><option value=\"Call\">Call</option> <option value=\"Put\">Put</option> <option value=\"CSP\">Cash Secured Put (CSP)</option> <option value=\"CC\">Covered Call (CC)</option></select></div><div class=\"form-group\"><label>Strike Price</label> <input type=\"number\" id=\"strike\" name=\"strike\" step=\"0.01\" placeholder=\"155.00\" required></div><div class=\"form-group\"><label>Premium (per contract)</label> <input type=\"number\" id=\"premium\" name=\"premium\" step=\"0.01\" placeholder=\"5.00\" required></div><div class=\"form-group\"><label>Number of Contracts</label> <input type=\"number\" id=\"quantity\" name=\"quantity\" step=\"1\" placeholder=\"1\" value=\"1\" required></div><div class=\"form-group\"><label>Expiration Date</label> <input type=\"date\" id=\"expDate\" name=\"expDate\" required></div>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = MultiplierField().Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = FeesField().Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		return nil
+	})
+}
+
+func ImportCSVModal(formats []string, profiles []MappingProfile, accounts []types.Account, account int) templ.Component {
+	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
+		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
+		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
+			return templ_7745c5c3_CtxErr
+		}
+		templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
+		if !templ_7745c5c3_IsBuffer {
+			defer func() {
+				templ_7745c5c3_BufErr := templruntime.ReleaseBuffer(templ_7745c5c3_Buffer)
+				if templ_7745c5c3_Err == nil {
+					templ_7745c5c3_Err = templ_7745c5c3_BufErr
+				}
+			}()
+		}
+		ctx = templ.InitializeContext(ctx)
+		templ_7745c5c3_Var7 := templ.GetChildren(ctx)
+		if templ_7745c5c3_Var7 == nil {
+			templ_7745c5c3_Var7 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "<div class=\"modal\"><div class=\"modal-content\"><div class=\"modal-header\"><h3>Import Trades</h3><button class=\"close-btn\" hx-get=\"/modal/close\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\">&times;</button></div><form hx-post=\"/api/import-csv\" hx-encoding=\"multipart/form-data\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\"><div class=\"form-group\"><label>CSV or OFX File</label> <input type=\"file\" name=\"csvFile\" accept=\".csv,.ofx,.qfx\" required><p style=\"font-size: 0.875rem; color: var(--text-secondary); margin-top: 0.5rem;\">Upload your brokerage CSV or OFX/QFX statement with trade history. Supported formats: ")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var8 string
+		templ_7745c5c3_Var8, templ_7745c5c3_Err = templ.JoinStringErrs(strings.Join(formats, ", "))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/components/modals.templ`, Line: 232, Col: 121}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var8))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 8, ". Other files can be mapped column by column.</p></div>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = AccountSelect(accounts, account).Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = MappingProfileList(profiles).Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 9, "<div class=\"form-actions\"><button type=\"submit\" class=\"btn btn-primary\">Preview Import</button> <button type=\"button\" class=\"btn btn-secondary\" hx-get=\"/modal/close\" hx-target=\"#modal-container\" hx-swap=\"innerHTML\">Cancel</button></div></form></div></div>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}